@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/user/perlcov/internal/runner"
+)
+
+// cleanPaths lists the artifacts --clean removes: the coverage database,
+// every <cover-dir>_* directory (isolated per-test databases and shards),
+// and cacheFile, the timing cache. Siblings are matched by name prefix
+// rather than a glob, so a cover dir containing *, ? or [ can't match
+// unrelated directories.
+func cleanPaths(coverDir, cacheFile string) ([]string, error) {
+	paths := []string{coverDir}
+	parent, prefix := filepath.Dir(coverDir), filepath.Base(coverDir)+"_"
+	entries, err := os.ReadDir(parent)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			paths = append(paths, filepath.Join(parent, entry.Name()))
+		}
+	}
+	return append(paths, cacheFile), nil
+}
+
+// clean removes the artifacts left behind by previous runs
+func clean(cfg *Config) error {
+	paths, err := cleanPaths(cfg.CoverDir, cfg.path(runner.CacheFile))
+	if err != nil {
+		return fmt.Errorf("failed to list coverage directories: %w", err)
+	}
+	if cfg.MergedDB != "" {
+		paths = append(paths, cfg.MergedDB)
+	}
+
+	removed := 0
+	for _, path := range paths {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		removed++
+		if cfg.Verbose {
+			fmt.Printf("Removed %s\n", path)
+		}
+	}
+	cfg.logf("Cleaned %d coverage artifact(s)\n", removed)
+	return nil
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	// The cover dir's name holds glob metacharacters: as a glob,
+	// "cover[1]_*" would match cover1_keep and miss the real siblings
+	root := t.TempDir()
+	for _, dir := range []string{"cover[1]/runs", "cover[1]_0_abc", "cover[1]_shard0", "cover1_keep", "cover[1]x_keep", "other"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"cover[1]_notes.txt", ".perlcov-timings.json"} {
+		if err := os.WriteFile(filepath.Join(root, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &Config{Root: root, CoverDir: filepath.Join(root, "cover[1]"), Quiet: true}
+	if err := clean(cfg); err != nil {
+		t.Fatalf("clean() error: %v", err)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	sort.Strings(left)
+	want := []string{"cover1_keep", "cover[1]_notes.txt", "cover[1]x_keep", "other"}
+	if !reflect.DeepEqual(left, want) {
+		t.Errorf("after clean() %s holds %q, want %q", root, left, want)
+	}
+}
+
+func TestCleanMissingCoverDir(t *testing.T) {
+	root := t.TempDir()
+	cfg := &Config{Root: root, CoverDir: filepath.Join(root, "missing", "cover_db"), Quiet: true}
+	if err := clean(cfg); err != nil {
+		t.Errorf("clean() error: %v, want nothing to remove", err)
+	}
+}
@@ -1,41 +1,179 @@
 package cli
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/user/perlcov/internal/coverage"
+	"github.com/user/perlcov/internal/ignore"
 	"github.com/user/perlcov/internal/runner"
+	"github.com/user/perlcov/pkg/perlcov"
 )
 
+// minPathWidth is the smallest --max-width, which still leaves grouped
+// directory labels a few characters after their " (N files)" suffix
+const minPathWidth = 16
+
 // Config holds the CLI configuration
 type Config struct {
-	IncludePaths  []string
-	Jobs          int
-	HTML          bool
-	CoverDir      string
-	NoRerunFailed bool
-	Verbose       bool
-	TestPaths     []string
-	SourceDirs    []string
-	OutputDir     string
-	ShowVersion   bool
-	IgnoreDirs    []string
-	NoSelect      bool
-	Normalize     string // Comma-separated normalization modes
-	JSONMerge     bool   // Use JSON export + Go merging instead of Perl merging
-	PerlPath      string // Path to perl executable
-	NoCover       bool   // Disable coverage collection (for debugging test runs)
-	ShowOutput    bool   // Show test output during execution
+	IncludePaths     []string
+	Jobs             int
+	HTML             bool
+	CoverDir         string
+	MergedDB         string // Where the merged database is written (default: CoverDir)
+	Root             string // Project directory perlcov runs in; other relative paths resolve against it
+	NoRerunFailed    bool
+	IgnoreCoverFails bool // Don't fail the run for tests that pass when rerun without Devel::Cover
+	Verbose          bool
+	TestPaths        []string
+	TestGlobs        []string // Globs test files must match, relative to each test path (default: perlcov.DefaultTestGlob)
+	SourceDirs       []string
+	OutputDir        string
+	ShowVersion      bool
+	IgnoreDirs       []string
+	NoSelect         bool
+	SelectMap        string   // File mapping test patterns to the modules to -select
+	Normalize        string   // Comma-separated normalization modes
+	JSONMerge        bool     // Use JSON export + Go merging instead of Perl merging
+	DBFormat         string   // Format tests write coverage in: json, storable or sereal
+	PerlPath         string   // Path to perl executable
+	SkipVersionCheck bool     // Don't check that Devel::Cover is installed before running tests
+	NoCover          bool     // Disable coverage collection (for debugging test runs)
+	ShowOutput       bool     // Show test output during execution
+	ShowWarnings     bool     // Print what each test wrote to stderr, even if it passed
+	Slowest          int      // Print the N slowest test files after the run (0: don't)
+	WarnEmpty        bool     // List passing tests whose coverage database recorded nothing
+	JUnit            string   // Path to write test results as JUnit XML
+	Criteria         string   // Comma-separated Devel::Cover criteria to collect (default: runner.DefaultCriteria)
+	StatementsOnly   bool     // Shortcut for Criteria "statement"
+	XSCoverage       bool     // Add gcov's C coverage of XS code to the report
+	XSDir            string   // Directory searched for .gcda files and XS sources
+	Formats          []string // Report formats, each a comma-separated list of name[:file] (default: text)
+	Retries          int      // Number of times to retry failing tests
+	Harness          string   // Test harness: perl or prove
+	Pod              bool     // Collect POD coverage
+	Time             bool     // Collect time per statement and show slowest files
+	Filter           string   // Only run tests whose path matches this regex
+	Exclude          string   // Skip tests whose path matches this regex
+	Since            string   // Only run tests affected by files changed since this git ref
+	Order            string   // Test dispatch order: alpha, size, random, failed-first
+	Seed             int64    // Seed for --order random (0 picks one)
+	SerialGroup      string   // Regex whose first capture group names tests that must not run concurrently
+	MaxMemory        int      // Memory budget in MB for the running tests (0: no limit)
+	Bail             float64  // Stop once more than this percentage of finished tests died inside Devel::Cover (0: never)
+	NoTimingCache    bool     // Don't read or write the test timing cache
+	PerTest          bool     // Write per-test coverage attribution to per-test.json
+	HTMLNative       bool     // Generate HTML report in Go without the cover command
+	Baseline         string   // Baseline action: save or compare
+	BaselineFile     string   // Path of the saved baseline report
+	FailOnRegress    bool     // Fail if coverage dropped against the baseline
+	History          string   // JSON lines file each run's summary is appended to
+	HistoryReport    bool     // Print the coverage trend from History
+	Badge            string   // Path to write a coverage badge SVG to
+	BadgeMetric      string   // Metric the badge shows (default: statement)
+	SummaryFormat    string   // Go template evaluated against coverage.CoverageSummary
+	FailUnder        float64  // Minimum percentage of the Gate metric, or weighted score with Score (0 disables)
+	Gate             string   // Metric FailUnder checks (default: statement)
+	SummaryMetrics   string   // Comma-separated metrics on the final summary line (default: statement,branch)
+	Score            string   // Weights of a combined score: metric:weight,...
+	Imports          []string // External coverage databases to merge into the report
+	ImportArchives   []string // .tar.gz or .zip artifacts holding coverage databases to merge
+	NoRun            bool     // Don't run tests; report on imported coverage only
+	DryRun           bool     // Print the command for each test instead of running it
+	Accumulate       bool     // Add to the existing coverage database instead of clearing it
+	ForceUnlock      bool     // Remove stale .lock files from the coverage database
+	Shard            string   // Run only this slice of the tests: <index>/<total>
+	ShardTimings     string   // Timing cache shared by all shards to balance them by duration
+	Quiet            bool     // Print only the coverage table and summary
+	SummaryOnly      bool     // Parse and print only the totals, skipping per-line data
+	LogLevel         string   // Lowest level of diagnostics logged to stderr (default: warn, or debug with --verbose)
+	NoColor          bool     // Never color the coverage table
+	GitHubAnnotate   bool     // Print GitHub Actions warnings for uncovered lines (default: on when $GITHUB_ACTIONS is true)
+	AnnotationLimit  int      // Most annotations GitHubAnnotate prints (0 for no limit)
+	ColorCutoffs     string   // Coloring thresholds: <high>,<medium>
+	Sort             string   // Report file order: path, statement, branch, uncovered
+	Top              int      // Show only the N first files of the sorted report
+	FullPaths        bool     // Don't truncate paths in the report table
+	MaxWidth         int      // Truncate report paths longer than this (0: the default 58, or none with FullPaths)
+	Precision        int      // Decimals shown in percentages
+	Round            string   // How shown percentages are rounded: nearest or floor
+	GroupBy          string   // Roll up the report by directory: dir[:depth]
+	GroupFiles       bool     // List each group's files under it
+	Strict           bool     // Fail if any run file could not be parsed
+	Clean            bool     // Remove coverage artifacts and exit
+	CountEmpty       bool     // Count files without statements as covered files
+	LocalLib         string   // local::lib root whose lib/perl5 is added to @INC
+	NoAutoInc        bool     // Don't add lib, blib or local/lib/perl5 to @INC automatically
+	ProjectType      string   // Project layout: auto (detected) or one of runner.ValidProjectTypes
+	Env              []string // Extra KEY=VALUE environment variables for tests
+	CoverIgnore      []string // Extra Devel::Cover -ignore regexes
+	CoverSelect      []string // Extra Devel::Cover -select regexes
+	NoDefaultIgnore  bool     // Drop the built-in ^t/ and \.t$ -ignore regexes
+	IncludeTests     bool     // Cover test helpers under TestLib
+	TestLib          string   // Test helper directory covered by IncludeTests
+	ExcludeMarker    string   // Drop source files whose head matches this regex
+	Uncoverable      string   // Regex for uncovered lines to leave out of statement coverage
+	UncoverableFile  string   // Devel::Cover .uncoverable file of points to leave out
+	IgnoreSubs       []string // Regexes for subroutine names to leave out of subroutine coverage
+	PathStyle        string   // Report paths: rel (to the working directory) or abs
+	MapPaths         []string // Report path rewrites: <from>=<to>, e.g. blib/lib=lib
+	FailUntested     bool     // Fail if a .pm file under SourceDirs has no coverage
+
+	filterRe       *regexp.Regexp
+	serialRe       *regexp.Regexp
+	excludeRe      *regexp.Regexp
+	markerRe       *regexp.Regexp
+	pathMappings   []coverage.PathMapping
+	uncoverRe      *regexp.Regexp // nil when disabled
+	ignoreSubRes   []*regexp.Regexp
+	selectMap      *runner.SelectMap
+	criteria       []string // nil unless --criteria is given
+	summaryTmpl    *template.Template
+	shardIndex     int
+	shardTotal     int // 0 when not sharding
+	thresholds     coverage.Thresholds
+	percent        coverage.PercentFormat
+	scoreWeights   map[string]float64 // nil unless --score is given
+	summaryMetrics []string
+	groupDepth     int // 0 when not grouping
+	formats        []formatTarget
+	logger         *slog.Logger
+	projectType    string // ProjectType, detected when auto
+	coverCmd       string // Devel::Cover's cover script, for --html
+}
+
+// formatTarget is one --format entry: a registered format and the file it
+// is written to ("" for stdout)
+type formatTarget struct {
+	name string
+	path string
 }
 
 // Version information
 const Version = "0.1.2"
 
+// Build metadata, set at build time with e.g.
+//
+//	go build -ldflags "-X github.com/user/perlcov/internal/cli.Commit=$(git rev-parse --short HEAD) -X github.com/user/perlcov/internal/cli.BuildDate=$(date -u +%Y-%m-%d)"
+//
+// When unset, the VCS information Go embeds in the binary is used instead.
+var (
+	Commit    string
+	BuildDate string
+)
+
 // multiString implements flag.Value for multiple -I flags
 type multiString []string
 
@@ -72,29 +210,126 @@ func printFlagDefaults(fs *flag.FlagSet) {
 func Run(args []string) error {
 	cfg := &Config{}
 
-	fs := flag.NewFlagSet("perlcov", flag.ExitOnError)
+	// ContinueOnError so bad flags map to ExitInternalError rather than the
+	// flag package's exit status 2, which means low coverage here
+	fs := flag.NewFlagSet("perlcov", flag.ContinueOnError)
 
 	var includePaths multiString
 	var ignoreDirs multiString
 	var sourceDirs multiString
+	var imports multiString
+	var importArchives multiString
+	var env multiString
+	var testGlobs multiString
+	var formatFlags multiString
+	var coverIgnore multiString
+	var coverSelect multiString
+	var mapPaths multiString
+	var ignoreSubs multiString
 
 	fs.Var(&includePaths, "I", "Add directory to @INC (can be specified multiple times)")
 	fs.IntVar(&cfg.Jobs, "j", runtime.NumCPU(), "Number of parallel test jobs")
 	fs.BoolVar(&cfg.HTML, "html", false, "Generate HTML coverage report (warning: slow)")
+	fs.BoolVar(&cfg.HTMLNative, "html-native", false, "Generate HTML coverage report natively (fast, no 'cover' command needed)")
 	fs.StringVar(&cfg.CoverDir, "cover-dir", "cover_db", "Directory for coverage database")
+	fs.StringVar(&cfg.MergedDB, "merged-db", "", "Write the merged coverage database here instead of --cover-dir, which then only places the per-test databases (default: --cover-dir)")
+	fs.StringVar(&cfg.Root, "root", "", "Project directory to run in, like running perlcov from there; all other paths are relative to it (default: current directory)")
 	fs.BoolVar(&cfg.NoRerunFailed, "no-rerun-failed", false, "Disable rerunning failed tests without Devel::Cover")
+	fs.BoolVar(&cfg.IgnoreCoverFails, "ignore-coverage-failures", false, "Don't fail the run for tests that only fail under Devel::Cover (they pass when rerun without it)")
 	fs.BoolVar(&cfg.Verbose, "v", false, "Verbose output")
 	fs.BoolVar(&cfg.Verbose, "verbose", false, "Verbose output")
+	fs.BoolVar(&cfg.Quiet, "q", false, "Quiet: print only the coverage table and summary")
+	fs.BoolVar(&cfg.Quiet, "quiet", false, "Quiet: print only the coverage table and summary")
+	fs.BoolVar(&cfg.SummaryOnly, "summary-only", false, "Print only the total coverage, skipping per-file and per-line data (faster on large databases)")
+	fs.StringVar(&cfg.LogLevel, "log-level", "", "Log diagnostics at this level and above to stderr: debug, info, warn or error (default: warn, or debug with --verbose)")
 	fs.StringVar(&cfg.OutputDir, "o", "", "Output directory for reports (default: current directory)")
 	fs.BoolVar(&cfg.ShowVersion, "version", false, "Show version information")
-	fs.Var(&ignoreDirs, "ignore", "Directories to ignore for coverage (can be specified multiple times)")
+	fs.Var(&ignoreDirs, "ignore", "Paths or gitignore-style patterns to ignore for tests and coverage (can be specified multiple times, added to .perlcovignore)")
 	fs.Var(&sourceDirs, "source", "Source directories to measure coverage (default: lib)")
 	fs.BoolVar(&cfg.NoSelect, "no-select", false, "Disable -select optimization (for benchmarking)")
+	fs.Var(&coverIgnore, "cover-ignore", "Devel::Cover -ignore regex for files to leave out of coverage (can be specified multiple times)")
+	fs.Var(&coverSelect, "cover-select", "Devel::Cover -select regex for files to always cover (can be specified multiple times)")
+	fs.BoolVar(&cfg.NoDefaultIgnore, "no-default-ignore", false, "Don't pass the built-in -ignore regexes for test files (^t/ and \\.t$) to Devel::Cover")
+	fs.BoolVar(&cfg.IncludeTests, "include-tests", false, "Measure coverage of test helpers under --test-lib too, instead of ignoring everything in t/")
+	fs.StringVar(&cfg.TestLib, "test-lib", "t/lib", "Test helper directory covered by --include-tests")
+	fs.StringVar(&cfg.SelectMap, "select-map", "", "File mapping test file patterns to the modules to -select for them (\"<pattern> <Module> ...\" per line)")
 	fs.StringVar(&cfg.Normalize, "normalize", "", "Normalize coverage metrics (comma-separated modes: conditions-to-branches, subroutines-to-statements, sonarqube, simple)")
 	fs.BoolVar(&cfg.JSONMerge, "json-merge", false, "Export coverage to JSON and merge in Go (faster for large test suites)")
+	fs.StringVar(&cfg.DBFormat, "db-format", "", "Format tests write coverage in: "+strings.Join(runner.ValidDBFormats, ", ")+" (default: Devel::Cover's choice)")
 	fs.StringVar(&cfg.PerlPath, "perl-path", "", "Path to perl executable (default: perl from PATH, or $PERL_PATH)")
+	fs.BoolVar(&cfg.SkipVersionCheck, "skip-version-check", false, "Don't check that Devel::Cover is installed before running tests (for CI images that already did)")
 	fs.BoolVar(&cfg.NoCover, "no-cover", false, "Disable coverage collection (for debugging test runs)")
 	fs.BoolVar(&cfg.ShowOutput, "show-output", false, "Show test output during execution")
+	fs.BoolVar(&cfg.ShowWarnings, "show-warnings", false, "Print a Warnings section with each test's stderr, including passing tests")
+	fs.BoolVar(&cfg.WarnEmpty, "warn-empty-coverage", false, "List passing tests that produced no coverage data (e.g. they forked or exec'd away)")
+	fs.IntVar(&cfg.Retries, "retries", 0, "Retry failing tests up to N times before marking them failed")
+	fs.Float64Var(&cfg.Bail, "bail", 0, "Stop with an error once more than this percentage of finished tests died inside Devel::Cover (checked from the 5th test on)")
+	fs.StringVar(&cfg.Harness, "harness", runner.HarnessPerl, "Test harness: perl (run tests directly) or prove (run through prove with HARNESS_PERL_SWITCHES)")
+	fs.BoolVar(&cfg.XSCoverage, "xs-coverage", false, "Add C coverage of XS code, collected with gcov from .gcda files under --xs-dir (build with --coverage)")
+	fs.StringVar(&cfg.XSDir, "xs-dir", ".", "Directory searched for .gcda files and XS sources for --xs-coverage")
+	fs.StringVar(&cfg.Criteria, "criteria", "", "Comma-separated coverage criteria to collect: "+strings.Join(runner.ValidCriteria, ", ")+" (default: "+strings.Join(runner.DefaultCriteria, ",")+")")
+	fs.BoolVar(&cfg.StatementsOnly, "statements-only", false, "Collect only statement coverage (same as --criteria statement), the fastest instrumentation")
+	fs.BoolVar(&cfg.Pod, "pod", false, "Collect POD coverage (requires Pod::Coverage)")
+	fs.BoolVar(&cfg.Time, "time", false, "Collect time spent per statement and print the slowest files")
+	fs.IntVar(&cfg.Slowest, "slowest", 0, "Print the N test files that took longest to run")
+	fs.StringVar(&cfg.Filter, "filter", "", "Only run test files whose path matches this regex")
+	fs.StringVar(&cfg.Exclude, "exclude", "", "Skip test files whose path matches this regex")
+	fs.StringVar(&cfg.Since, "since", "", "Only run changed test files and the tests of source files changed since this git ref (all tests when a change can't be mapped)")
+	fs.Var(&testGlobs, "test-glob", "Glob test files must match, relative to each test path (can be specified multiple times, default: "+perlcov.DefaultTestGlob+")")
+	fs.StringVar(&cfg.Order, "order", "", "Test dispatch order: alpha, size (largest first), random, failed-first (default: discovery order)")
+	fs.IntVar(&cfg.MaxMemory, "max-memory", 0, "Hold back new tests while the running ones use more than this many MB of resident memory (Linux only)")
+	fs.StringVar(&cfg.SerialGroup, "serial-group", "", "Regex matched against test paths; tests with the same first capture group run one at a time on a single worker")
+	fs.Int64Var(&cfg.Seed, "seed", 0, "Seed for --order random (default: time-based, printed for reproducibility)")
+	fs.BoolVar(&cfg.NoTimingCache, "no-timing-cache", false, "Don't read or write "+runner.CacheFile+" (disables longest-first scheduling)")
+	fs.BoolVar(&cfg.PerTest, "per-test", false, "Write which source files each test covered to per-test.json in the output directory")
+	fs.Var(&imports, "import", "Merge an externally produced coverage database into the report (can be specified multiple times)")
+	fs.Var(&importArchives, "import-archive", "Merge the coverage database in a .tar.gz or .zip artifact into the report, like --import (can be specified multiple times)")
+	fs.BoolVar(&cfg.NoRun, "no-run", false, "Don't run any tests; report on --import databases only")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "Print the perl command line for each test, one per line, and exit without running anything")
+	fs.BoolVar(&cfg.Accumulate, "accumulate", false, "Merge this run's coverage into the existing coverage database instead of clearing it first")
+	fs.BoolVar(&cfg.ForceUnlock, "force-unlock", false, fmt.Sprintf("Remove .lock files older than %s left in the coverage database by a crashed run", coverage.StaleLockAge))
+	fs.StringVar(&cfg.Shard, "shard", "", "Run only shard <index>/<total> of the tests (0-based index), e.g. 0/4")
+	fs.StringVar(&cfg.ShardTimings, "shard-timings", "", "Balance --shard by the durations in this timing cache, which every shard must share (default: round-robin)")
+	fs.BoolVar(&cfg.FailUntested, "fail-on-untested", false, "Exit with code 2 if a .pm file under --source was never loaded or had no statement run")
+	fs.Float64Var(&cfg.FailUnder, "fail-under", 0, "Exit with code 2 if the --gate metric (or the --score) is below this percentage")
+	fs.StringVar(&cfg.Gate, "gate", "statement", "Metric --fail-under checks: "+strings.Join(coverage.ScoreMetrics, ", "))
+	fs.StringVar(&cfg.SummaryMetrics, "summary-metrics", "statement,branch", "Comma-separated metrics on the final Coverage: summary line: "+strings.Join(coverage.ScoreMetrics, ", "))
+	fs.StringVar(&cfg.Score, "score", "", "Print a weighted blend of the coverage metrics, e.g. 'statement:0.5,branch:0.3,subroutine:0.2', and check it with --fail-under")
+	fs.StringVar(&cfg.Baseline, "baseline", "", "Save the coverage report as a baseline (save) or diff against a saved one (compare)")
+	fs.StringVar(&cfg.BaselineFile, "baseline-file", ".perlcov-baseline.json", "Baseline file used by --baseline")
+	fs.BoolVar(&cfg.FailOnRegress, "fail-on-regression", false, "Exit with an error if --baseline compare finds a coverage drop")
+	fs.StringVar(&cfg.History, "history", "", "Append this run's coverage summary (with the git commit) as a JSON line to this file")
+	fs.BoolVar(&cfg.HistoryReport, "history-report", false, "Print the coverage trend recorded in the --history file")
+	fs.StringVar(&cfg.Badge, "badge", "", "Write a shields.io-style coverage badge SVG to this file, colored by --color-thresholds")
+	fs.StringVar(&cfg.BadgeMetric, "badge-metric", "statement", "Metric the --badge shows: "+strings.Join(coverage.ScoreMetrics, ", "))
+	fs.StringVar(&cfg.SummaryFormat, "summary-format", "", "Go template for a single summary line printed last, e.g. '{{.Statement}} {{.Branch}}'")
+	fs.StringVar(&cfg.Sort, "sort", coverage.SortPath, "Report file order: path, statement (worst first), branch (worst first), uncovered (most uncovered lines first)")
+	fs.IntVar(&cfg.Top, "top", 0, "Show only the N worst-covered files (sorted by --sort, default statement); totals still cover all files")
+	fs.BoolVar(&cfg.FullPaths, "full-paths", false, "Show full paths in the report; the path column widens to fit the longest")
+	fs.IntVar(&cfg.Precision, "precision", coverage.DefaultPrecision, "Decimals shown in coverage percentages (0-4)")
+	fs.StringVar(&cfg.Round, "round", coverage.RoundNearest, "Rounding of shown percentages: nearest, or floor so a value never shows as a threshold it is below")
+	fs.IntVar(&cfg.MaxWidth, "max-width", 0, fmt.Sprintf("Truncate report paths longer than N characters, keeping the end (default %d, none with --full-paths)", coverage.DefaultPathWidth))
+	fs.StringVar(&cfg.GroupBy, "group-by", "", "Roll up the report by directory: dir[:depth] (default depth 2, e.g. lib/App/)")
+	fs.BoolVar(&cfg.GroupFiles, "group-files", false, "With --group-by, list each group's files under it")
+	fs.BoolVar(&cfg.Clean, "clean", false, "Remove the coverage directory, its isolated <cover-dir>_* directories, any --merged-db and "+runner.CacheFile+", then exit")
+	fs.StringVar(&cfg.LocalLib, "local-lib", "", "local::lib or Carton directory whose lib/perl5 is added to @INC (default: ./local if present)")
+	fs.BoolVar(&cfg.NoAutoInc, "no-auto-inc", false, "Don't add lib, blib or local/lib/perl5 to @INC automatically; only -I and --local-lib paths are used")
+	fs.StringVar(&cfg.ProjectType, "project-type", runner.ProjectAuto, "Project layout, for the default --source and the build output to ignore: "+strings.Join(runner.ValidProjectTypes, ", ")+" (auto detects dist.ini, minil.toml, Build.PL, Makefile.PL or cpanfile)")
+	fs.StringVar(&cfg.ExcludeMarker, "exclude-marker", "", fmt.Sprintf("Leave out source files with a line matching this regex in their first %d lines, e.g. 'GENERATED FILE'", coverage.MarkerLines))
+	fs.StringVar(&cfg.Uncoverable, "uncoverable-marker", coverage.DefaultUncoverableMarker, "Leave uncovered lines matching this regex out of statement coverage ('' disables)")
+	fs.StringVar(&cfg.UncoverableFile, "uncoverable-file", "", "Leave the points listed in this Devel::Cover .uncoverable file out of coverage")
+	fs.Var(&ignoreSubs, "ignore-sub", "Leave subroutines whose name matches this regex out of subroutine coverage, e.g. generated accessors (can be specified multiple times)")
+	fs.StringVar(&cfg.PathStyle, "path-style", coverage.PathRel, "Report file paths: rel (relative to the working directory) or abs")
+	fs.Var(&mapPaths, "map-path", "Report coverage of files under <from> as under <to>, e.g. blib/lib=lib (can be specified multiple times)")
+	fs.Var(&env, "env", "Set KEY=VALUE in the environment of every test (can be specified multiple times)")
+	fs.BoolVar(&cfg.CountEmpty, "count-empty-files", false, "Count files without statements as covered in the summary file counts (default: leave them out)")
+	fs.BoolVar(&cfg.Strict, "strict", false, "Fail if any coverage run file could not be parsed instead of leaving it out")
+	fs.BoolVar(&cfg.NoColor, "no-color", false, "Don't color the coverage table (also honors $NO_COLOR)")
+	fs.BoolVar(&cfg.GitHubAnnotate, "github-annotations", false, "Print a GitHub Actions ::warning for each uncovered line (default: on when $GITHUB_ACTIONS is true)")
+	fs.IntVar(&cfg.AnnotationLimit, "annotation-limit", coverage.DefaultAnnotationLimit, "Most --github-annotations to print (0 for no limit)")
+	fs.StringVar(&cfg.ColorCutoffs, "color-thresholds", "90,70", "Coverage percentages for green and yellow: <high>,<medium>")
+	fs.StringVar(&cfg.JUnit, "junit", "", "Write test results as JUnit XML to this path")
+	fs.Var(&formatFlags, "format", "Report format: "+strings.Join(coverage.FormatNames(), ", ")+"; written to its default file in the output directory, or to name:file (text:- for stdout). Comma-separate or repeat for several (default: text)")
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, `perlcov - Fast Perl test coverage tool
@@ -112,21 +347,99 @@ Examples:
   perlcov                           # Run all tests in t/**/*.t
   perlcov -j 4                      # Run tests with 4 parallel jobs
   perlcov -I lib -I local/lib       # Add include paths
+  perlcov --local-lib vendor        # Use dependencies installed in vendor/lib/perl5
+  perlcov --project-type plain      # Don't skip build output such as blib/ or .build/
+  perlcov --map-path blib/lib=lib   # Report modules tests loaded from blib under lib/
+  perlcov --exclude-marker 'GENERATED FILE'   # Leave out generated modules
+  perlcov --uncoverable-file .uncoverable     # Honor points added with cover -add_uncoverable_point
+  perlcov --ignore-sub '^_build_' --ignore-sub '^(has|clear)_'   # Don't count generated subs
+  perlcov --env TZ=UTC              # Set an environment variable for every test
+  perlcov --root ~/src/My-Dist      # Run against another project directory
+  perlcov --test-glob '**/*.t' --test-glob '**/*.test' xt/   # Also run .test files
   perlcov --html                    # Generate HTML report (slow)
+  perlcov --html-native             # Generate HTML report without 'cover' (fast)
   perlcov --no-rerun-failed         # Don't rerun failed tests without coverage
+  perlcov --ignore-coverage-failures   # Pass if failures only happen under Devel::Cover
+  perlcov --quiet                   # Print only the coverage table and summary
+  perlcov --summary-only --json-merge   # Just the totals, e.g. in a pre-commit hook
+  perlcov --log-level debug         # Log -select/-ignore choices and merging to stderr
   perlcov --no-select               # Disable -select optimization (for benchmarking)
   perlcov --no-cover                # Run tests without coverage (for debugging)
   perlcov --show-output             # Show test output during execution
+  perlcov --show-warnings           # List what passing tests wrote to stderr
+  perlcov --slowest 10              # List the 10 slowest test files after the run
+  perlcov --warn-empty-coverage     # List passing tests that recorded no coverage
   perlcov --json-merge              # Use JSON export + Go merging (faster)
+  perlcov --json-merge --db-format json   # Write JSON up front, skipping the conversion
   perlcov --normalize=conditions-to-branches   # Merge conditions into branches
   perlcov --normalize=sonarqube     # Use SonarQube-style coverage metrics
   perlcov --normalize=simple        # Show only statement coverage
   perlcov --perl-path=/usr/bin/perl # Use specific perl executable
+  perlcov --skip-version-check      # Don't spawn perl to check Devel::Cover first
+  perlcov --format json             # Write full report to coverage.json
+  perlcov --format sonar-generic    # Write SonarQube generic coverage to sonar-coverage.xml
+  perlcov --format clover           # Write Clover XML to clover.xml (Bamboo, Bitbucket)
+  perlcov --format cobertura        # Write Cobertura XML with condition coverage to cobertura.xml
+  perlcov --format text --format clover:build/clover.xml   # Table and Clover XML in one run
+  perlcov --junit junit.xml         # Also write test results as JUnit XML
+  perlcov --sort statement          # Worst-covered files first
+  perlcov --github-annotations --annotation-limit 20   # Annotate uncovered lines in a PR
+  perlcov --top 20                  # Show only the 20 worst-covered files
+  perlcov --precision 2 --round floor   # Two decimals, never rounded up past a threshold
+  perlcov --full-paths              # Don't shorten long paths to ...<tail>
+  perlcov --group-by dir:3          # Coverage per directory, e.g. lib/App/Model/
+  perlcov --color-thresholds 80,50  # Green from 80%%, yellow from 50%%, red below
+  perlcov --badge coverage.svg      # Write a coverage badge for the README
+  perlcov --summary-format '{{.Statement}} {{.Branch}}'   # Print a parseable summary line
+  perlcov --per-test                # Write test -> covered files matrix to per-test.json
+  perlcov --clean                   # Remove cover_db, cover_db_* and the timing cache
+  perlcov --cover-dir /tmp/pc/cover_db --merged-db out/cover_db   # Scratch in /tmp, result in out/
+  perlcov --strict                  # Fail instead of skipping corrupt coverage run files
+  perlcov --shard 0/4               # Run the first quarter of the tests into cover_db_shard0
+  perlcov --no-run --import a/cover_db --import b/cover_db   # Merge CI shards
+  perlcov --no-run --import-archive shard0.tar.gz --import-archive shard1.zip   # Merge CI artifacts
+  perlcov --accumulate --filter '^t/unit/'   # Add to the existing cover_db
+  perlcov --dry-run --filter Auth   # Show the perl commands for the Auth tests
+  perlcov --select-map .perlcov-select   # Select several modules for integration tests
+  perlcov --no-default-ignore --cover-ignore '^tests/'   # Tests live in tests/, not t/
+  perlcov --include-tests           # Also cover the test helpers in t/lib
+  perlcov --fail-under 80           # Exit 2 if statement coverage is below 80%%
+  perlcov --score statement:0.5,branch:0.3,subroutine:0.2 --fail-under 75   # Gate on a weighted score
+  perlcov --gate subroutine --fail-under 100 --summary-metrics subroutine   # Every sub must be called
+  perlcov --baseline save           # Save coverage to .perlcov-baseline.json
+  perlcov --baseline compare --fail-on-regression   # Diff against it, fail on drops
+  perlcov --history .perlcov-history.jsonl --history-report   # Track coverage over time
+  perlcov --retries 2               # Retry flaky tests up to 2 more times
+  perlcov --bail 50                 # Give up once over half the tests die inside Devel::Cover
+  perlcov --harness prove           # Run tests through prove (honors .proverc)
+  perlcov --criteria statement,subroutine   # Skip branch/condition instrumentation
+  perlcov --statements-only         # Line coverage only, fastest
+  perlcov --pod                     # Also collect POD coverage
+  perlcov --xs-coverage             # Also report gcov coverage of XS code
+  perlcov --time                    # Show the source files with the most time spent
+  perlcov --filter 'Auth|Session'   # Run only tests whose path matches a regex
+  perlcov --since origin/main       # Run only the tests for files changed since origin/main
+  perlcov --order failed-first      # Run previously failed tests first
+  perlcov --order random --seed 42  # Reproducible random order
+  perlcov -j 8 --serial-group '^t/(db)/'   # t/db tests share a database; run them one at a time
+  perlcov -j 16 --max-memory 6000   # Up to 16 tests at once while they fit in 6 GB
   perlcov t/unit/                   # Run tests in specific directory
   perlcov t/foo.t t/bar.t           # Run specific test files
 
+Ignore File:
+  A .perlcovignore file in the current directory lists gitignore-style
+  patterns (*, **, and ! negation) for test files and source files to
+  exclude. --ignore flags are applied after the file's patterns.
+
+Exit Codes:
+  0  Tests passed and coverage requirements were met
+  1  One or more tests failed
+  2  Coverage below --fail-under, or regressed with --fail-on-regression
+  3  Internal or tooling error (e.g. Devel::Cover missing, invalid options)
+
 Environment Variables:
   PERL_PATH                         Path to perl executable (overridden by --perl-path)
+  NO_COLOR                          Disable colored output when set to any value
 
 Note: This tool requires Devel::Cover to be installed.
       Install with: cpan Devel::Cover
@@ -137,17 +450,50 @@ Note: This tool requires Devel::Cover to be installed.
 	}
 
 	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
 		return err
 	}
 
-	if cfg.ShowVersion {
-		fmt.Printf("perlcov version %s\n", Version)
-		return nil
-	}
-
 	cfg.IncludePaths = includePaths
 	cfg.IgnoreDirs = ignoreDirs
 	cfg.SourceDirs = sourceDirs
+	cfg.Imports = imports
+	cfg.ImportArchives = importArchives
+	cfg.Env = env
+	cfg.CoverIgnore = coverIgnore
+	cfg.CoverSelect = coverSelect
+	cfg.MapPaths = mapPaths
+	cfg.IgnoreSubs = ignoreSubs
+	cfg.TestGlobs = testGlobs
+	cfg.Formats = formatFlags
+
+	// Everything below resolves relative paths against the project root:
+	// the library resolves its options against Root, and the files the
+	// CLI reads and writes itself are resolved here
+	if cfg.Root != "" {
+		root, err := filepath.Abs(cfg.Root)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			return fmt.Errorf("--root %s is not a directory", cfg.Root)
+		}
+		cfg.Root = root
+	}
+	if cfg.OutputDir == "" {
+		cfg.OutputDir = "."
+	}
+	for _, p := range []*string{&cfg.CoverDir, &cfg.MergedDB, &cfg.OutputDir, &cfg.SelectMap, &cfg.UncoverableFile,
+		&cfg.JUnit, &cfg.BaselineFile, &cfg.History, &cfg.Badge, &cfg.ShardTimings} {
+		*p = cfg.path(*p)
+	}
+	for _, list := range [][]string{cfg.Imports, cfg.ImportArchives} {
+		for i := range list {
+			list[i] = cfg.path(list[i])
+		}
+	}
 
 	// Use PERL_PATH env var as fallback if --perl-path not specified
 	if cfg.PerlPath == "" {
@@ -158,9 +504,23 @@ Note: This tool requires Devel::Cover to be installed.
 		}
 	}
 
+	if cfg.ShowVersion {
+		printVersion(cfg.PerlPath)
+		return nil
+	}
+
+	if !contains(runner.ValidProjectTypes, cfg.ProjectType) {
+		return fmt.Errorf("unknown --project-type: %s (valid: %s)", cfg.ProjectType, strings.Join(runner.ValidProjectTypes, ", "))
+	}
+	cfg.projectType = cfg.ProjectType
+	if cfg.projectType == runner.ProjectAuto {
+		cfg.projectType = runner.DetectProjectType(cfg.path("."))
+	}
+	layout := runner.LayoutFor(cfg.path("."), cfg.projectType)
 	if len(cfg.SourceDirs) == 0 {
-		cfg.SourceDirs = []string{"lib"}
+		cfg.SourceDirs = layout.SourceDirs
 	}
+	cfg.IgnoreDirs = append(cfg.IgnoreDirs, layout.Ignore...)
 
 	// Remaining args are test paths
 	cfg.TestPaths = fs.Args()
@@ -168,171 +528,1254 @@ Note: This tool requires Devel::Cover to be installed.
 		cfg.TestPaths = []string{"t"}
 	}
 
-	if cfg.OutputDir == "" {
-		cfg.OutputDir = "."
+	if cfg.Clean {
+		return clean(cfg)
 	}
 
-	return runCoverage(cfg)
-}
+	switch cfg.Harness {
+	case runner.HarnessPerl, runner.HarnessProve:
+	default:
+		return fmt.Errorf("unknown --harness value: %s (valid: perl, prove)", cfg.Harness)
+	}
 
-func runCoverage(cfg *Config) error {
-	// Check for Devel::Cover (skip if --no-cover)
-	if !cfg.NoCover {
-		if err := runner.CheckDevelCover(cfg.PerlPath); err != nil {
+	if cfg.Filter != "" {
+		re, err := regexp.Compile(cfg.Filter)
+		if err != nil {
+			return fmt.Errorf("invalid --filter regex: %w", err)
+		}
+		cfg.filterRe = re
+	}
+	if cfg.Exclude != "" {
+		re, err := regexp.Compile(cfg.Exclude)
+		if err != nil {
+			return fmt.Errorf("invalid --exclude regex: %w", err)
+		}
+		cfg.excludeRe = re
+	}
+
+	if len(cfg.TestGlobs) == 0 {
+		cfg.TestGlobs = []string{perlcov.DefaultTestGlob}
+	}
+	for _, glob := range cfg.TestGlobs {
+		if _, err := ignore.CompileGlob(filepath.ToSlash(glob)); err != nil {
+			return fmt.Errorf("invalid --test-glob: %w", err)
+		}
+	}
+
+	if cfg.ExcludeMarker != "" {
+		re, err := regexp.Compile(cfg.ExcludeMarker)
+		if err != nil {
+			return fmt.Errorf("invalid --exclude-marker regex: %w", err)
+		}
+		cfg.markerRe = re
+	}
+
+	if cfg.SelectMap != "" {
+		if cfg.NoSelect {
+			return fmt.Errorf("--select-map and --no-select cannot be used together")
+		}
+		m, err := perlcov.LoadSelectMap(cfg.SelectMap, cfg.Root)
+		if err != nil {
+			return fmt.Errorf("invalid --select-map: %w", err)
+		}
+		cfg.selectMap = m
+	}
+
+	for _, opt := range []struct {
+		flag    string
+		regexes []string
+	}{{"--cover-ignore", cfg.CoverIgnore}, {"--cover-select", cfg.CoverSelect}} {
+		for _, re := range opt.regexes {
+			if err := checkCoverRegex(re); err != nil {
+				return fmt.Errorf("invalid %s %q: %w", opt.flag, re, err)
+			}
+		}
+		if len(opt.regexes) > 0 && cfg.NoCover {
+			return fmt.Errorf("%s has no effect with --no-cover", opt.flag)
+		}
+	}
+
+	if cfg.Uncoverable != "" {
+		re, err := regexp.Compile(cfg.Uncoverable)
+		if err != nil {
+			return fmt.Errorf("invalid --uncoverable-marker regex: %w", err)
+		}
+		cfg.uncoverRe = re
+	}
+	if cfg.UncoverableFile != "" {
+		if cfg.NoCover {
+			return fmt.Errorf("--uncoverable-file has no effect with --no-cover")
+		}
+		if _, err := os.Stat(cfg.UncoverableFile); err != nil {
+			return fmt.Errorf("--uncoverable-file: %w", err)
+		}
+	}
+	for _, expr := range cfg.IgnoreSubs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid --ignore-sub regex: %w", err)
+		}
+		cfg.ignoreSubRes = append(cfg.ignoreSubRes, re)
+	}
+	if len(cfg.ignoreSubRes) > 0 && cfg.NoCover {
+		return fmt.Errorf("--ignore-sub has no effect with --no-cover")
+	}
+
+	if cfg.Order != "" && !contains(runner.ValidOrders, cfg.Order) {
+		return fmt.Errorf("unknown --order value: %s (valid: %s)", cfg.Order, strings.Join(runner.ValidOrders, ", "))
+	}
+	if cfg.SerialGroup != "" {
+		re, err := regexp.Compile(cfg.SerialGroup)
+		if err != nil {
+			return fmt.Errorf("invalid --serial-group regex: %w", err)
+		}
+		cfg.serialRe = re
+	}
+	if cfg.MaxMemory < 0 {
+		return fmt.Errorf("--max-memory must be non-negative, got %d", cfg.MaxMemory)
+	}
+	if cfg.MaxMemory > 0 && !runner.MemoryThrottleSupported() {
+		fmt.Fprintf(os.Stderr, "Warning: --max-memory needs /proc to measure test memory; running up to -j %d tests regardless\n", cfg.Jobs)
+	}
+	if cfg.Order == runner.OrderRandom && cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+
+	if cfg.Shard != "" {
+		var err error
+		if cfg.shardIndex, cfg.shardTotal, err = parseShard(cfg.Shard); err != nil {
 			return err
 		}
+		if cfg.shardTotal < 1 || cfg.shardIndex < 0 || cfg.shardIndex >= cfg.shardTotal {
+			return fmt.Errorf("invalid --shard %s: index must be in 0..%d", cfg.Shard, cfg.shardTotal-1)
+		}
+		// Give each shard its own database so shards can be imported together
+		if !flagSet(fs, "cover-dir") {
+			cfg.CoverDir = fmt.Sprintf("%s_shard%d", cfg.CoverDir, cfg.shardIndex)
+		}
+	}
+	if cfg.ShardTimings != "" && cfg.Shard == "" {
+		return fmt.Errorf("--shard-timings has no effect without --shard")
+	}
+	if cfg.MergedDB != "" && cfg.NoCover {
+		return fmt.Errorf("--merged-db has no effect with --no-cover")
+	}
+	if cfg.MergedDB == "" {
+		cfg.MergedDB = cfg.CoverDir
+	}
+
+	for _, kv := range cfg.Env {
+		if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+			return fmt.Errorf("invalid --env value: %s (want KEY=VALUE)", kv)
+		}
+	}
+
+	if !contains(coverage.ValidPathStyles, cfg.PathStyle) {
+		return fmt.Errorf("unknown --path-style value: %s (valid: %s)", cfg.PathStyle, strings.Join(coverage.ValidPathStyles, ", "))
+	}
+	for _, spec := range cfg.MapPaths {
+		m, err := coverage.ParsePathMapping(spec)
+		if err != nil {
+			return fmt.Errorf("invalid --map-path: %w", err)
+		}
+		cfg.pathMappings = append(cfg.pathMappings, m)
+	}
+	if len(cfg.pathMappings) > 0 && cfg.NoCover {
+		return fmt.Errorf("--map-path has no effect with --no-cover")
+	}
+
+	if !contains(coverage.ValidSorts, cfg.Sort) {
+		return fmt.Errorf("unknown --sort value: %s (valid: %s)", cfg.Sort, strings.Join(coverage.ValidSorts, ", "))
+	}
+
+	if flagSet(fs, "test-lib") && !cfg.IncludeTests {
+		return fmt.Errorf("--test-lib requires --include-tests")
+	}
+	if cfg.IncludeTests {
+		if cfg.NoCover {
+			return fmt.Errorf("--include-tests has no effect with --no-cover")
+		}
+		if cfg.TestLib == "" || strings.Contains(cfg.TestLib, ",") {
+			return fmt.Errorf("invalid --test-lib %q: must be a directory without commas", cfg.TestLib)
+		}
+	}
+
+	// Find cover before the tests run rather than failing after them
+	if cfg.HTML && !cfg.NoCover && !cfg.DryRun {
+		cover, err := coverage.FindCoverCommand(cfg.PerlPath)
+		if err != nil {
+			return fmt.Errorf("%v; it is required for --html (install Devel::Cover, or use --html-native)", err)
+		}
+		cfg.coverCmd = cover
+	}
+
+	if flagSet(fs, "xs-dir") && !cfg.XSCoverage {
+		return fmt.Errorf("--xs-dir requires --xs-coverage")
+	}
+	if cfg.XSCoverage && cfg.NoCover {
+		return fmt.Errorf("--xs-coverage and --no-cover cannot be used together")
 	}
 
-	// Discover test files
-	testFiles, err := discoverTests(cfg.TestPaths)
+	if !flagSet(fs, "github-annotations") && os.Getenv("GITHUB_ACTIONS") == "true" && !cfg.NoCover && !cfg.SummaryOnly {
+		cfg.GitHubAnnotate = true
+	}
+	if cfg.GitHubAnnotate && cfg.NoCover {
+		return fmt.Errorf("--github-annotations and --no-cover cannot be used together")
+	}
+	if cfg.AnnotationLimit < 0 {
+		return fmt.Errorf("--annotation-limit must be non-negative, got %d", cfg.AnnotationLimit)
+	}
+
+	if cfg.Top < 0 {
+		return fmt.Errorf("--top must be non-negative, got %d", cfg.Top)
+	}
+	if cfg.Slowest < 0 {
+		return fmt.Errorf("--slowest must be non-negative, got %d", cfg.Slowest)
+	}
+	if cfg.MaxWidth != 0 && cfg.MaxWidth < minPathWidth {
+		return fmt.Errorf("--max-width must be at least %d, got %d", minPathWidth, cfg.MaxWidth)
+	}
+	if cfg.Precision < 0 || cfg.Precision > 4 {
+		return fmt.Errorf("--precision must be between 0 and 4, got %d", cfg.Precision)
+	}
+	if !contains(coverage.ValidRoundings, cfg.Round) {
+		return fmt.Errorf("unknown --round value: %s (valid: %s)", cfg.Round, strings.Join(coverage.ValidRoundings, ", "))
+	}
+	cfg.percent = coverage.PercentFormat{Precision: cfg.Precision, Round: cfg.Round}
+	// The worst files only come first when sorting by coverage
+	if cfg.Top > 0 && !flagSet(fs, "sort") {
+		cfg.Sort = coverage.SortStatement
+	}
+
+	if cfg.GroupBy != "" {
+		depth, err := parseGroupBy(cfg.GroupBy)
+		if err != nil {
+			return err
+		}
+		cfg.groupDepth = depth
+	} else if cfg.GroupFiles {
+		return fmt.Errorf("--group-files requires --group-by")
+	}
+
+	thresholds, err := coverage.ParseThresholds(cfg.ColorCutoffs)
 	if err != nil {
-		return fmt.Errorf("failed to discover tests: %w", err)
+		return fmt.Errorf("invalid --color-thresholds: %w", err)
 	}
+	cfg.thresholds = thresholds
 
-	if len(testFiles) == 0 {
-		return fmt.Errorf("no test files found")
+	if cfg.Quiet && cfg.Verbose {
+		return fmt.Errorf("--quiet and --verbose cannot be used together")
 	}
 
-	fmt.Printf("Found %d test files\n", len(testFiles))
-	if cfg.NoCover {
-		fmt.Println("Coverage collection disabled (--no-cover)")
+	logLevel := cfg.LogLevel
+	if logLevel == "" {
+		logLevel = "warn"
+		if cfg.Verbose {
+			logLevel = "debug"
+		}
 	}
+	level, err := parseLogLevel(logLevel)
+	if err != nil {
+		return fmt.Errorf("invalid --log-level: %w", err)
+	}
+	cfg.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	cfg.logger.Info("project layout", "type", cfg.projectType, "detected", cfg.ProjectType == runner.ProjectAuto,
+		"source", strings.Join(cfg.SourceDirs, ","))
 
-	// Clean previous coverage data (both main dir and any isolated dirs) - skip if --no-cover
-	if !cfg.NoCover {
-		if err := os.RemoveAll(cfg.CoverDir); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to clean coverage directory: %w", err)
+	if cfg.NoRun && len(cfg.Imports) == 0 && len(cfg.ImportArchives) == 0 && !cfg.Accumulate {
+		return fmt.Errorf("--no-run requires at least one --import or --import-archive (or --accumulate to report on the existing database)")
+	}
+	if cfg.NoRun && cfg.NoCover {
+		return fmt.Errorf("--no-run and --no-cover together leave nothing to do")
+	}
+
+	// A mistyped source directory silently measures nothing, so catch it
+	// before running. Imported databases may come from another checkout.
+	if !cfg.NoRun {
+		missing := missingDirs(cfg.path("."), cfg.SourceDirs)
+		switch {
+		case len(missing) > 0 && flagSet(fs, "source"):
+			return fmt.Errorf("--source directory not found: %s", strings.Join(missing, ", "))
+		case len(missing) > 0 && !cfg.NoCover:
+			fmt.Fprintf(os.Stderr, "Warning: source directory %s not found; pass --source to measure another\n", strings.Join(missing, ", "))
+		}
+	}
+	for _, pattern := range ignoreDirs {
+		if ignoreMatchesNothing(cfg.path("."), pattern) {
+			fmt.Fprintf(os.Stderr, "Warning: --ignore %s matches nothing: no such path\n", pattern)
+		}
+	}
+	if cfg.JUnit != "" && cfg.NoRun {
+		return fmt.Errorf("--junit needs tests to run; it can't be combined with --no-run")
+	}
+	if cfg.DBFormat != "" {
+		if !contains(runner.ValidDBFormats, cfg.DBFormat) {
+			return fmt.Errorf("unknown --db-format value: %s (valid: %s)", cfg.DBFormat, strings.Join(runner.ValidDBFormats, ", "))
+		}
+		if cfg.NoCover || cfg.NoRun {
+			return fmt.Errorf("--db-format only applies when tests run with coverage")
+		}
+	}
+	if cfg.Slowest > 0 && cfg.NoRun {
+		return fmt.Errorf("--slowest needs tests to run; it can't be combined with --no-run")
+	}
+	if cfg.Since != "" && cfg.NoRun {
+		return fmt.Errorf("--since selects tests to run; it can't be combined with --no-run")
+	}
+	if cfg.FailUntested && cfg.partialRun() {
+		return fmt.Errorf("--fail-on-untested needs the whole suite; it can't be combined with --shard, --filter, --exclude or --since")
+	}
+	if cfg.DryRun && cfg.NoRun {
+		return fmt.Errorf("--dry-run and --no-run together leave nothing to do")
+	}
+	if cfg.Accumulate && cfg.NoCover {
+		return fmt.Errorf("--accumulate and --no-cover together leave nothing to do")
+	}
+	for _, dir := range cfg.Imports {
+		if filepath.Clean(dir) == filepath.Clean(cfg.MergedDB) {
+			return fmt.Errorf("--import %s is the merged database (--cover-dir or --merged-db), which already holds the report's coverage", dir)
+		}
+		if err := coverage.ValidateCoverageDB(dir); err != nil {
+			return fmt.Errorf("invalid --import: %w", err)
+		}
+	}
+	for _, archive := range cfg.ImportArchives {
+		if info, err := os.Stat(archive); err != nil || info.IsDir() {
+			return fmt.Errorf("invalid --import-archive: %s is not a file", archive)
 		}
-		// Also clean any leftover isolated coverage directories from previous runs
-		for i := 0; i < len(testFiles); i++ {
-			isolatedDir := fmt.Sprintf("%s_%d", cfg.CoverDir, i)
-			os.RemoveAll(isolatedDir) // Ignore errors
+	}
+
+	if cfg.StatementsOnly {
+		switch {
+		case cfg.Criteria != "":
+			return fmt.Errorf("--statements-only and --criteria cannot be used together")
+		case cfg.Pod || cfg.Time:
+			return fmt.Errorf("--statements-only cannot be combined with --pod or --time")
 		}
+		cfg.Criteria = "statement"
 	}
 
-	// Run tests
-	r := runner.New(cfg.IncludePaths, cfg.CoverDir, cfg.Jobs, cfg.Verbose, cfg.SourceDirs, cfg.NoSelect, cfg.JSONMerge, cfg.PerlPath, cfg.ShowOutput)
+	if cfg.Criteria != "" {
+		criteria, err := parseCriteria(cfg.Criteria)
+		if err != nil {
+			return err
+		}
+		cfg.criteria = criteria
+	}
 
-	var results []runner.TestResult
-	if cfg.NoCover {
-		// Run tests without coverage
-		results = r.RunTestsWithoutCoverage(testFiles)
-	} else {
-		// Run tests with coverage (each test gets its own isolated coverage directory)
-		results = r.RunTests(testFiles)
-
-		// Collect isolated coverage directories from test results
-		var isolatedDirs []string
-		for _, result := range results {
-			if result.CoverDir != "" {
-				isolatedDirs = append(isolatedDirs, result.CoverDir)
+	if cfg.Score != "" {
+		weights, err := coverage.ParseScoreWeights(cfg.Score)
+		if err != nil {
+			return fmt.Errorf("invalid --score: %w", err)
+		}
+		if cfg.NoCover {
+			return fmt.Errorf("--score has no effect with --no-cover")
+		}
+		// An uncollected metric would read as 0% and drag the score down
+		criteria := buildCriteria(cfg)
+		for metric, w := range weights {
+			if w > 0 && !contains(criteria, metric) {
+				return fmt.Errorf("--score weights %s coverage, which isn't collected (see --criteria and --pod)", metric)
 			}
 		}
+		cfg.scoreWeights = weights
+	}
+
+	if !contains(coverage.ScoreMetrics, cfg.Gate) {
+		return fmt.Errorf("unknown --gate metric: %s (valid: %s)", cfg.Gate, strings.Join(coverage.ScoreMetrics, ", "))
+	}
+	if flagSet(fs, "gate") {
+		switch {
+		case cfg.Score != "":
+			return fmt.Errorf("--gate and --score both choose what --fail-under checks; use one")
+		case !flagSet(fs, "fail-under"):
+			return fmt.Errorf("--gate requires --fail-under")
+		}
+	}
+	if cfg.FailUnder > 0 && cfg.Score == "" && !cfg.NoCover && !contains(buildCriteria(cfg), cfg.Gate) {
+		return fmt.Errorf("--fail-under checks %s coverage, which isn't collected (see --criteria and --pod)", cfg.Gate)
+	}
+	for _, metric := range strings.Split(cfg.SummaryMetrics, ",") {
+		metric = strings.TrimSpace(metric)
+		if !contains(coverage.ScoreMetrics, metric) {
+			return fmt.Errorf("unknown --summary-metrics metric: %q (valid: %s)", metric, strings.Join(coverage.ScoreMetrics, ", "))
+		}
+		if !contains(cfg.summaryMetrics, metric) {
+			cfg.summaryMetrics = append(cfg.summaryMetrics, metric)
+		}
+	}
+
+	if cfg.FailUnder < 0 || cfg.FailUnder > 100 {
+		return fmt.Errorf("--fail-under must be between 0 and 100, got %g", cfg.FailUnder)
+	}
+
+	if cfg.Retries < 0 {
+		return fmt.Errorf("--retries must be non-negative, got %d", cfg.Retries)
+	}
+	if cfg.Bail < 0 || cfg.Bail >= 100 {
+		return fmt.Errorf("--bail must be a percentage from 0 to below 100, got %g", cfg.Bail)
+	}
+	if cfg.Bail > 0 && cfg.NoCover {
+		return fmt.Errorf("--bail has no effect with --no-cover")
+	}
+
+	if len(cfg.Formats) == 0 {
+		cfg.Formats = []string{"text"}
+	}
+	formats, err := parseFormats(cfg.Formats, cfg.OutputDir)
+	if err != nil {
+		return fmt.Errorf("invalid --format: %w", err)
+	}
+	for i := range formats {
+		formats[i].path = cfg.path(formats[i].path)
+	}
+	cfg.formats = formats
 
-		// Merge isolated coverage directories into the final cover_db
-		if len(isolatedDirs) > 0 {
-			if cfg.Verbose {
-				fmt.Printf("Merging %d coverage directories...\n", len(isolatedDirs))
+	// Everything that needs per-line data conflicts with --summary-only
+	if cfg.SummaryOnly {
+		if cfg.NoCover {
+			return fmt.Errorf("--summary-only has no effect with --no-cover")
+		}
+		for _, opt := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--verbose", cfg.Verbose},
+			{"--html", cfg.HTML},
+			{"--html-native", cfg.HTMLNative},
+			{"--github-annotations", cfg.GitHubAnnotate},
+			{"--uncoverable-file", cfg.UncoverableFile != ""},
+			{"--ignore-sub", len(cfg.IgnoreSubs) > 0},
+		} {
+			if opt.set {
+				return fmt.Errorf("--summary-only and %s cannot be used together", opt.flag)
 			}
-			if err := coverage.MergeCoverageDBs(isolatedDirs, cfg.CoverDir); err != nil {
-				return fmt.Errorf("failed to merge coverage directories: %w", err)
+		}
+		for _, t := range cfg.formats {
+			if t.name != "text" {
+				return fmt.Errorf("--summary-only only supports --format text, got %s", t.name)
 			}
 		}
 	}
 
-	// Print test results
-	printTestResults(results)
+	if cfg.SummaryFormat != "" {
+		tmpl, err := template.New("summary").Parse(cfg.SummaryFormat)
+		if err != nil {
+			return fmt.Errorf("invalid --summary-format template: %w", err)
+		}
+		cfg.summaryTmpl = tmpl
+	}
+
+	switch cfg.Baseline {
+	case "", "save", "compare":
+	default:
+		return fmt.Errorf("unknown --baseline value: %s (valid: save, compare)", cfg.Baseline)
+	}
+	if cfg.IgnoreCoverFails && (cfg.NoRerunFailed || cfg.NoCover) {
+		return fmt.Errorf("--ignore-coverage-failures needs failed tests rerun without coverage, which --no-rerun-failed and --no-cover disable")
+	}
+
+	if cfg.FailOnRegress && cfg.Baseline != "compare" {
+		return fmt.Errorf("--fail-on-regression requires --baseline compare")
+	}
+
+	if cfg.HistoryReport && cfg.History == "" {
+		return fmt.Errorf("--history-report requires --history")
+	}
+	if cfg.History != "" && cfg.NoCover {
+		return fmt.Errorf("--history records coverage and cannot be used with --no-cover")
+	}
+
+	if !contains(coverage.ScoreMetrics, cfg.BadgeMetric) {
+		return fmt.Errorf("unknown --badge-metric: %s (valid: %s)", cfg.BadgeMetric, strings.Join(coverage.ScoreMetrics, ", "))
+	}
+	if cfg.Badge != "" {
+		if cfg.NoCover {
+			return fmt.Errorf("--badge shows coverage and cannot be used with --no-cover")
+		}
+		if !contains(buildCriteria(cfg), cfg.BadgeMetric) {
+			return fmt.Errorf("--badge shows %s coverage, which isn't collected (see --criteria and --pod)", cfg.BadgeMetric)
+		}
+	} else if flagSet(fs, "badge-metric") {
+		return fmt.Errorf("--badge-metric requires --badge")
+	}
+
+	return runCoverage(cfg)
+}
 
-	// Handle failed tests - rerun by default to detect Devel::Cover-related failures
-	// Skip rerun logic if --no-cover since there's no coverage to debug
+func runCoverage(cfg *Config) error {
+	opts := libraryOptions(cfg)
+	if cfg.DryRun {
+		return nothingAffected(cfg, perlcov.DryRun(opts, os.Stdout))
+	}
+
+	started := time.Now()
+	report, results, err := perlcov.RunCoverage(opts)
+	if err != nil {
+		return nothingAffected(cfg, err)
+	}
+	if !cfg.NoRun && cfg.JUnit != "" {
+		if err := writeJUnitReport(results, started, cfg.JUnit); err != nil {
+			return fmt.Errorf("failed to write JUnit report: %w", err)
+		}
+		cfg.logf("JUnit report written: %s\n", cfg.JUnit)
+	}
 	failedTests := getFailedTests(results)
-	if len(failedTests) > 0 && !cfg.NoRerunFailed && !cfg.NoCover {
-		fmt.Println("\n--- Rerunning failed tests without Devel::Cover ---")
-		rerunResults := r.RunTestsWithoutCoverage(failedTests)
-		printRerunResults(results, rerunResults)
+	if len(failedTests) > 0 && opts.RerunFailed && !cfg.NoCover && !cfg.Quiet {
+		printRerunResults(results)
 	}
 
-	// Parse and display coverage (skip if --no-cover)
-	var report *coverage.Report
+	// Display coverage (skip if --no-cover)
+	var regressed bool
+	var untested []string
 	if !cfg.NoCover {
 		fmt.Println("\n--- Coverage Report ---")
-		report, err = coverage.ParseCoverageDB(cfg.CoverDir, cfg.JSONMerge, cfg.PerlPath)
-		if err != nil {
-			return fmt.Errorf("failed to parse coverage: %w", err)
+		if cfg.Verbose || cfg.Strict {
+			for _, s := range report.Skipped {
+				fmt.Fprintf(os.Stderr, "Warning: skipped run file %s: %s\n", s.Path, s.Reason)
+			}
+		}
+		if cfg.Verbose {
+			for _, c := range report.Conflicts {
+				fmt.Fprintf(os.Stderr, "Warning: runs instrumented %d versions of %s; kept the newest and dropped %d run(s) of older ones\n",
+					c.Versions, c.File, c.Dropped)
+			}
+		}
+		if cfg.Strict && len(report.Skipped) > 0 {
+			return exitErrorf(ExitInternalError, "%d of %d run files could not be parsed (--strict)",
+				len(report.Skipped), report.RunFiles)
+		}
+		if err := handleStaleLocks(cfg, report.StaleLocks); err != nil {
+			return err
 		}
 
-		// Apply normalization if specified
-		if cfg.Normalize != "" {
-			normConfig, err := coverage.ParseNormalizationModes(cfg.Normalize)
+		printOpts := coverage.PrintOptions{
+			Verbose:    cfg.Verbose,
+			Color:      useColor(cfg),
+			Thresholds: cfg.thresholds,
+			Sort:       cfg.Sort,
+			Top:        cfg.Top,
+			FullPaths:  cfg.FullPaths,
+			MaxWidth:   cfg.MaxWidth,
+			Percent:    cfg.percent,
+		}
+		if cfg.groupDepth > 0 {
+			groups := coverage.GroupByDir(report, cfg.groupDepth)
+			coverage.PrintGroupedReport(report, groups, cfg.GroupFiles, printOpts)
+		} else {
+			coverage.PrintReport(report, printOpts)
+		}
+
+		if cfg.Time {
+			coverage.PrintSlowestFiles(report, 10, printOpts)
+		}
+		if reason := emptyCoverageReason(cfg, report, results); reason != "" {
+			fmt.Fprintf(os.Stderr, "\nWarning: no coverage collected: %s\n", reason)
+		}
+
+		// Modules no test loaded never show up in the coverage database.
+		// A partial run leaves out the modules only the other tests load.
+		if !cfg.partialRun() {
+			untested, err = perlcov.UntestedFiles(report, opts)
 			if err != nil {
-				return fmt.Errorf("invalid --normalize value: %w", err)
+				return fmt.Errorf("failed to find untested files: %w", err)
+			}
+			if !cfg.Quiet {
+				coverage.PrintUntestedFiles(untested)
 			}
-			report.Normalize(normConfig)
 		}
 
-		coverage.PrintReport(report, cfg.Verbose)
+		if cfg.GitHubAnnotate {
+			if _, err := coverage.WriteGitHubAnnotations(report, cfg.AnnotationLimit, os.Stdout); err != nil {
+				return fmt.Errorf("failed to write GitHub annotations: %w", err)
+			}
+		}
+
+		for _, t := range cfg.formats {
+			if t.name == "text" && t.path == "" {
+				continue // the table printed above
+			}
+			if err := writeFormat(report, t, printOpts); err != nil {
+				return fmt.Errorf("failed to write %s report: %w", t.name, err)
+			}
+			if t.path != "" {
+				cfg.logf("\n%s report written: %s\n", t.name, t.path)
+			}
+		}
 
 		// Generate HTML if requested
 		if cfg.HTML {
-			fmt.Println("\n⚠️  WARNING: HTML report generation using 'cover' can be very slow")
-			fmt.Println("   For large codebases, this may take several minutes...")
-			if err := coverage.GenerateHTML(cfg.CoverDir, cfg.OutputDir); err != nil {
+			cfg.logf("\n⚠️  WARNING: HTML report generation using 'cover' can be very slow\n")
+			cfg.logf("   For large codebases, this may take several minutes...\n")
+			if err := coverage.GenerateHTML(cfg.coverCmd, cfg.MergedDB); err != nil {
+				return fmt.Errorf("failed to generate HTML report: %w", err)
+			}
+			htmlPath := filepath.Join(cfg.MergedDB, "coverage.html")
+			cfg.logf("\n📊 HTML report generated: %s\n", htmlPath)
+		}
+
+		if cfg.HTMLNative {
+			htmlDir := filepath.Join(cfg.OutputDir, "perlcov-html")
+			if err := coverage.GenerateNativeHTML(report, htmlDir); err != nil {
 				return fmt.Errorf("failed to generate HTML report: %w", err)
 			}
-			htmlPath := filepath.Join(cfg.OutputDir, cfg.CoverDir, "coverage.html")
-			fmt.Printf("\n📊 HTML report generated: %s\n", htmlPath)
+			cfg.logf("\n📊 HTML report generated: %s\n", filepath.Join(htmlDir, "index.html"))
+		}
+
+		switch cfg.Baseline {
+		case "save":
+			if err := writeJSONReport(report, cfg.BaselineFile); err != nil {
+				return fmt.Errorf("failed to save baseline: %w", err)
+			}
+			cfg.logf("\nBaseline saved: %s\n", cfg.BaselineFile)
+		case "compare":
+			regressed, err = compareBaseline(report, cfg.BaselineFile, cfg.percent)
+			if err != nil {
+				return err
+			}
+		}
+
+		if cfg.History != "" {
+			// Part of the suite covers less than the whole, which would
+			// read as a drop in the trend
+			if cfg.partialRun() {
+				cfg.logf("\nHistory not appended: only part of the suite ran\n")
+			} else {
+				entry := coverage.NewHistoryEntry(report, time.Now(), gitCommit(cfg.path(".")))
+				if err := coverage.AppendHistory(cfg.History, entry); err != nil {
+					return fmt.Errorf("failed to append to history: %w", err)
+				}
+				cfg.logf("\nHistory appended: %s\n", cfg.History)
+			}
+			if cfg.HistoryReport {
+				if err := printHistory(cfg.History, cfg.percent); err != nil {
+					return err
+				}
+			}
+		}
+
+		if cfg.Badge != "" {
+			if err := writeBadge(cfg, report); err != nil {
+				return fmt.Errorf("failed to write badge: %w", err)
+			}
+			cfg.logf("Badge written: %s\n", cfg.Badge)
 		}
 	}
 
 	// Summary
 	passCount := len(results) - len(failedTests)
 	fmt.Printf("\n=== Summary ===\n")
-	fmt.Printf("Tests: %d passed, %d failed, %d total\n", passCount, len(failedTests), len(results))
-	if !cfg.NoCover && report != nil {
-		fmt.Printf("Coverage: %.1f%% statement, %.1f%% branch\n",
-			report.Summary.Statement, report.Summary.Branch)
+	if !cfg.NoRun {
+		fmt.Printf("Tests: %d passed, %d failed, %d total\n", passCount, len(failedTests), len(results))
+	}
+	if retried := countPassedOnRetry(results); retried > 0 {
+		fmt.Printf("Flaky: %d test(s) passed on retry\n", retried)
+	}
+	coverageOnly := countCoverageOnlyFailures(results)
+	if coverageOnly > 0 {
+		fmt.Printf("Coverage-only: %d failed test(s) pass without Devel::Cover\n", coverageOnly)
+	}
+	if toolingErrors := countCoverageToolingErrors(results); toolingErrors > 0 {
+		fmt.Printf("Tooling errors: %d failed test(s) died inside Devel::Cover\n", toolingErrors)
+	}
+	if !cfg.NoCover && report != nil && len(report.Files) == 0 {
+		fmt.Println("Coverage: none collected")
+	} else if !cfg.NoCover && report != nil {
+		var parts []string
+		collected := buildCriteria(cfg)
+		for _, metric := range cfg.summaryMetrics {
+			if !contains(collected, metric) || !report.Collected(metric) {
+				continue
+			}
+			pct, _ := coverage.SummaryMetric(report.Summary, metric)
+			parts = append(parts, cfg.percent.Percent(pct)+" "+metric)
+		}
+		if len(parts) > 0 {
+			fmt.Printf("Coverage: %s\n", strings.Join(parts, ", "))
+		}
+		if cfg.scoreWeights != nil {
+			fmt.Printf("Weighted score: %s\n", cfg.percent.Percent(coverage.WeightedScore(report.Summary, cfg.scoreWeights)))
+		}
+		if len(untested) > 0 {
+			fmt.Printf("Untested: %d source file(s) with no coverage\n", len(untested))
+		}
+		if len(report.Skipped) > 0 {
+			fmt.Printf("warning: %d of %d run files could not be parsed", len(report.Skipped), report.RunFiles)
+			if !cfg.Verbose {
+				fmt.Print(" (use -v to list them)")
+			}
+			fmt.Println()
+		}
+		if cfg.summaryTmpl != nil {
+			if err := printSummaryLine(cfg.summaryTmpl, report.Summary); err != nil {
+				return fmt.Errorf("failed to render --summary-format: %w", err)
+			}
+		}
 	}
 
-	if len(failedTests) > 0 {
-		return fmt.Errorf("%d test(s) failed", len(failedTests))
+	if cfg.IgnoreCoverFails && len(failedTests) > 0 && len(failedTests) == coverageOnly {
+		cfg.logf("Ignoring %d coverage-only failure(s) (--ignore-coverage-failures)\n", coverageOnly)
+	} else if len(failedTests) > 0 {
+		return exitErrorf(ExitTestsFailed, "%d test(s) failed", len(failedTests))
+	}
+	// The unrounded value is gated; the message rounds it down so it never
+	// reads as reaching the threshold it missed
+	if report != nil && cfg.FailUnder > 0 {
+		shown := coverage.PercentFormat{Precision: cfg.Precision, Round: coverage.RoundFloor}
+		if cfg.scoreWeights != nil {
+			if score := coverage.WeightedScore(report.Summary, cfg.scoreWeights); score < cfg.FailUnder {
+				return exitErrorf(ExitCoverageLow, "weighted score %s is below --fail-under %g%%", shown.Percent(score), cfg.FailUnder)
+			}
+		} else if pct, _ := coverage.SummaryMetric(report.Summary, cfg.Gate); pct < cfg.FailUnder {
+			return exitErrorf(ExitCoverageLow, "%s coverage %s is below --fail-under %g%%",
+				cfg.Gate, shown.Percent(pct), cfg.FailUnder)
+		}
+	}
+	if len(untested) > 0 && cfg.FailUntested {
+		return exitErrorf(ExitCoverageLow, "%d source file(s) have no coverage", len(untested))
+	}
+	if regressed && cfg.FailOnRegress {
+		return exitErrorf(ExitCoverageLow, "coverage regressed against baseline %s", cfg.BaselineFile)
 	}
 
 	return nil
 }
 
-func discoverTests(paths []string) ([]string, error) {
-	var testFiles []string
+// nothingAffected passes err on unless it is --since finding no test to
+// run, which isn't a failure: a docs-only change shouldn't fail a
+// pre-push hook
+func nothingAffected(cfg *Config, err error) error {
+	if errors.Is(err, perlcov.ErrNoAffectedTests) {
+		cfg.logf("%s; nothing to run\n", err)
+		return nil
+	}
+	return err
+}
+
+// libraryOptions translates cfg into the options of the run perlcov.RunCoverage
+// does. What it would print goes through cfg.logf, the progress reporter and
+// printTestResults (see afterTests).
+func libraryOptions(cfg *Config) perlcov.Options {
+	opts := perlcov.Options{
+		TestPaths:        cfg.TestPaths,
+		TestGlobs:        cfg.TestGlobs,
+		Ignore:           cfg.IgnoreDirs,
+		Filter:           cfg.filterRe,
+		Exclude:          cfg.excludeRe,
+		Since:            cfg.Since,
+		ShardIndex:       cfg.shardIndex,
+		ShardTotal:       cfg.shardTotal,
+		ShardTimings:     cfg.ShardTimings,
+		Root:             cfg.Root,
+		IncludePaths:     cfg.IncludePaths,
+		SourceDirs:       cfg.SourceDirs,
+		LocalLib:         cfg.LocalLib,
+		NoAutoInc:        cfg.NoAutoInc,
+		Env:              cfg.Env,
+		PerlPath:         cfg.PerlPath,
+		Jobs:             cfg.Jobs,
+		Harness:          cfg.Harness,
+		Retries:          cfg.Retries,
+		Order:            cfg.Order,
+		Seed:             cfg.Seed,
+		SerialGroup:      cfg.serialRe,
+		MaxMemoryMB:      cfg.MaxMemory,
+		BailPercent:      cfg.Bail,
+		NoTimingCache:    cfg.NoTimingCache,
+		ShowOutput:       cfg.ShowOutput,
+		NoCover:          cfg.NoCover,
+		NoRun:            cfg.NoRun,
+		SkipVersionCheck: cfg.SkipVersionCheck,
+		CoverDir:         cfg.CoverDir,
+		MergedDB:         cfg.MergedDB,
+		Accumulate:       cfg.Accumulate,
+		NoSelect:         cfg.NoSelect,
+		SelectMap:        cfg.selectMap,
+		CoverIgnore:      cfg.CoverIgnore,
+		CoverSelect:      cfg.CoverSelect,
+		NoDefaultIgnore:  cfg.NoDefaultIgnore,
+		JSONMerge:        cfg.JSONMerge,
+		DBFormat:         cfg.DBFormat,
+		XSCoverage:       cfg.XSCoverage,
+		XSDir:            cfg.XSDir,
+		ExcludeMarker:    cfg.markerRe,
+		Uncoverable:      cfg.uncoverRe,
+		UncoverableFile:  cfg.UncoverableFile,
+		IgnoreSubs:       cfg.ignoreSubRes,
+		SummaryOnly:      cfg.SummaryOnly,
+		PathStyle:        cfg.PathStyle,
+		PathMappings:     cfg.pathMappings,
+		CountEmpty:       cfg.CountEmpty,
+		Normalize:        cfg.Normalize,
+		Logger:           cfg.logger,
+		Logf:             cfg.logf,
+		OnProgress:       newProgressReporter(os.Stdout, cfg.Verbose, cfg.Quiet),
+		OnMergeProgress:  newMergeProgressReporter(os.Stdout, cfg.Quiet),
+	}
+	if !cfg.NoCover {
+		opts.Imports = cfg.Imports
+		opts.ImportArchives = cfg.ImportArchives
+	}
+	if cfg.IncludeTests {
+		opts.TestLib = cfg.TestLib
+	}
+	if cfg.criteria != nil || cfg.Pod || cfg.Time {
+		opts.Criteria = buildCriteria(cfg)
+	}
+	if cfg.PerTest {
+		opts.PerTestFile = filepath.Join(cfg.OutputDir, "per-test.json")
+	}
+	// Rerun failed tests to detect Devel::Cover-related failures, unless
+	// --quiet would hide the result and the exit code doesn't depend on it
+	// (--ignore-coverage-failures)
+	opts.RerunFailed = !cfg.NoRerunFailed && (!cfg.Quiet || cfg.IgnoreCoverFails)
+	opts.AfterTests = func(results []runner.TestResult) error {
+		afterTests(cfg, opts, results)
+		return nil
+	}
+	return opts
+}
 
-	for _, p := range paths {
-		info, err := os.Stat(p)
-		if err != nil {
-			return nil, fmt.Errorf("cannot access %s: %w", p, err)
+// afterTests prints the test results once the tests have run, while each
+// test's isolated coverage database still exists for --warn-empty-coverage
+func afterTests(cfg *Config, opts perlcov.Options, results []runner.TestResult) {
+	var emptyCoverage []string
+	if cfg.WarnEmpty && !cfg.NoCover {
+		var err error
+		if emptyCoverage, err = testsWithoutCoverage(results, cfg.PerlPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: can't tell which tests recorded no coverage: %v\n", err)
+		}
+	}
+	if !cfg.Quiet {
+		printTestResults(results)
+	}
+	if cfg.ShowWarnings {
+		printWarnings(results)
+	}
+	if cfg.Slowest > 0 {
+		printSlowestTests(results, cfg.Slowest)
+	}
+	if len(emptyCoverage) > 0 {
+		printTestsWithoutCoverage(emptyCoverage)
+	}
+	if len(getFailedTests(results)) > 0 && opts.RerunFailed && !cfg.NoCover && !cfg.Quiet {
+		fmt.Println("\n--- Rerunning failed tests without Devel::Cover ---")
+	}
+}
+
+// handleStaleLocks removes the stale lock files with --force-unlock and
+// otherwise warns about them. 'cover' can hang on them, so --html fails
+// with a clear error instead of running it.
+func handleStaleLocks(cfg *Config, locks []string) error {
+	if len(locks) == 0 {
+		return nil
+	}
+	if cfg.ForceUnlock {
+		if err := coverage.RemoveLocks(locks); err != nil {
+			return fmt.Errorf("failed to remove stale locks: %w", err)
 		}
+		cfg.logf("Removed %d stale lock file(s) from %s\n", len(locks), cfg.MergedDB)
+		return nil
+	}
 
-		if !info.IsDir() {
-			// It's a file
-			if strings.HasSuffix(p, ".t") {
-				testFiles = append(testFiles, p)
+	fmt.Fprintf(os.Stderr, "Warning: %d lock file(s) in %s are older than %s, probably left by a crashed run:\n",
+		len(locks), cfg.MergedDB, coverage.StaleLockAge)
+	for _, lock := range locks {
+		fmt.Fprintf(os.Stderr, "  %s\n", lock)
+	}
+	fmt.Fprintln(os.Stderr, "Use --force-unlock to remove them")
+	if cfg.HTML {
+		return exitErrorf(ExitInternalError, "stale lock files in %s would block 'cover' for --html (use --force-unlock)", cfg.MergedDB)
+	}
+	return nil
+}
+
+// printVersion prints the version and build metadata on the first line,
+// which starts "perlcov version X.Y.Z" for scripts, then the Devel::Cover
+// version perlPath finds
+func printVersion(perlPath string) {
+	commit, date := Commit, BuildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && commit == "":
+				commit = s.Value
+				if len(commit) > 12 {
+					commit = commit[:12]
+				}
+			case s.Key == "vcs.time" && date == "":
+				date, _, _ = strings.Cut(s.Value, "T")
 			}
-			continue
 		}
+	}
 
-		// It's a directory, find all .t files recursively
-		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
+	var meta []string
+	if commit != "" {
+		meta = append(meta, "commit "+commit)
+	}
+	if date != "" {
+		meta = append(meta, "built "+date)
+	}
+	meta = append(meta, runtime.Version())
+	fmt.Printf("perlcov version %s (%s)\n", Version, strings.Join(meta, ", "))
+
+	if version, err := runner.CheckDevelCover(perlPath); err == nil {
+		fmt.Printf("Devel::Cover %s (%s)\n", version, perlPath)
+	} else {
+		fmt.Printf("Devel::Cover not found for %s\n", perlPath)
+	}
+}
+
+// printSummaryLine renders the --summary-format template as a single line
+func printSummaryLine(tmpl *template.Template, summary coverage.CoverageSummary) error {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, summary); err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimRight(sb.String(), "\n"))
+	return nil
+}
+
+// compareBaseline prints the diff between the saved baseline and report,
+// and reports whether coverage regressed
+func compareBaseline(report *coverage.Report, path string, percent coverage.PercentFormat) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open baseline: %w", err)
+	}
+	defer f.Close()
+
+	baseline, err := coverage.ReadJSON(f)
+	if err != nil {
+		return false, fmt.Errorf("failed to read baseline %s: %w", path, err)
+	}
+
+	diff := coverage.Diff(baseline, report)
+	fmt.Printf("\n--- Coverage vs Baseline (%s) ---\n", path)
+	coverage.PrintDiff(diff, percent)
+	return diff.HasRegression(), nil
+}
+
+// historyReportRuns is how many recent runs --history-report lists
+const historyReportRuns = 10
+
+// printHistory prints the coverage trend recorded in the history file
+func printHistory(path string, pct coverage.PercentFormat) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	entries, err := coverage.ReadHistory(f)
+	if err != nil {
+		return fmt.Errorf("failed to read history %s: %w", path, err)
+	}
+	fmt.Printf("\n--- Coverage Trend (%d runs) ---\n", len(entries))
+	coverage.PrintHistory(entries, historyReportRuns, pct)
+	return nil
+}
+
+// gitCommit returns dir's git HEAD, or "" outside a repository or without
+// git
+func gitCommit(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeJSONReport writes the report as JSON to the given path
+func writeJSONReport(report *coverage.Report, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return coverage.WriteJSON(report, f)
+}
+
+// writeBadge writes the --badge SVG for the --badge-metric coverage
+func writeBadge(cfg *Config, report *perlcov.Report) error {
+	pct, _ := coverage.SummaryMetric(report.Summary, cfg.BadgeMetric)
+	label := "coverage"
+	if cfg.BadgeMetric != "statement" {
+		label = cfg.BadgeMetric + " coverage"
+	}
+	f, err := os.Create(cfg.Badge)
+	if err != nil {
+		return err
+	}
+	if err := coverage.WriteBadge(f, label, pct, cfg.thresholds, cfg.percent); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeJUnitReport writes the test results as JUnit XML to the given path
+func writeJUnitReport(results []runner.TestResult, started time.Time, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return runner.WriteJUnit(results, started, f)
+}
+
+// parseFormats parses the --format values: comma-separated format names,
+// each optionally followed by :file. Without a file a format goes to its
+// default file in outputDir, or stdout if it has none; "-" means stdout,
+// which only text may write to since the rest of the output goes there too.
+func parseFormats(values []string, outputDir string) ([]formatTarget, error) {
+	var entries []string
+	for _, v := range values {
+		entries = append(entries, strings.Split(v, ",")...)
+	}
+
+	var targets []formatTarget
+	seen := make(map[string]string) // path -> format writing it
+	for _, entry := range entries {
+		name, path, hasPath := strings.Cut(strings.TrimSpace(entry), ":")
+		if err := coverage.CheckFormat(name); err != nil {
+			return nil, err
+		}
+		if !hasPath {
+			_, file, _ := coverage.NewFormatter(name, coverage.PrintOptions{})
+			if file != "" {
+				path = filepath.Join(outputDir, file)
 			}
-			if !info.IsDir() && strings.HasSuffix(path, ".t") {
-				testFiles = append(testFiles, path)
+		} else if path == "" {
+			return nil, fmt.Errorf("%s: empty file name", name)
+		} else if path == "-" {
+			if name != "text" {
+				return nil, fmt.Errorf("%s can't write to stdout, where it would mix with the text table; give it a file", name)
 			}
-			return nil
-		})
-		if err != nil {
-			return nil, err
+			path = ""
+		}
+		if other, ok := seen[path]; ok && path != "" {
+			return nil, fmt.Errorf("%s and %s both write to %s", other, name, path)
+		}
+		seen[path] = name
+		targets = append(targets, formatTarget{name: name, path: path})
+	}
+	return targets, nil
+}
+
+// writeFormat writes the report in t's format to t's file, or stdout
+func writeFormat(report *coverage.Report, t formatTarget, opts coverage.PrintOptions) error {
+	if t.name == "text" {
+		opts.Color = false // only the terminal table is colored
+	}
+	formatter, _, err := coverage.NewFormatter(t.name, opts)
+	if err != nil {
+		return err
+	}
+	if t.path == "" {
+		return formatter.Write(report, os.Stdout)
+	}
+
+	f, err := os.Create(t.path)
+	if err != nil {
+		return err
+	}
+	if err := formatter.Write(report, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseShard parses --shard as <index>/<total>, both whole integers
+func parseShard(value string) (index, total int, err error) {
+	invalid := fmt.Errorf("invalid --shard value: %s (want <index>/<total>, e.g. 0/4)", value)
+	indexStr, totalStr, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0, invalid
+	}
+	if index, err = strconv.Atoi(indexStr); err != nil {
+		return 0, 0, invalid
+	}
+	if total, err = strconv.Atoi(totalStr); err != nil {
+		return 0, 0, invalid
+	}
+	return index, total, nil
+}
+
+// parseGroupBy parses a --group-by value of the form dir[:depth]
+func parseGroupBy(value string) (int, error) {
+	kind, depthStr, hasDepth := strings.Cut(value, ":")
+	if kind != "dir" {
+		return 0, fmt.Errorf("unknown --group-by value: %s (valid: dir[:depth])", value)
+	}
+	if !hasDepth {
+		return 2, nil
+	}
+	depth, err := strconv.Atoi(depthStr)
+	if err != nil || depth < 1 {
+		return 0, fmt.Errorf("invalid --group-by depth: %s (must be a positive integer)", depthStr)
+	}
+	return depth, nil
+}
+
+// useColor reports whether the coverage table should be colored: only on a
+// terminal, so piped output stays plain, and never with --no-color or $NO_COLOR
+func useColor(cfg *Config) bool {
+	return !cfg.NoColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+}
+
+// path resolves p against --root, leaving empty and absolute paths alone
+func (cfg *Config) path(p string) string {
+	if p == "" || cfg.Root == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(cfg.Root, p)
+}
+
+// partialRun reports whether the tests run are only part of the suite,
+// picked by --shard, --filter, --exclude or --since
+func (cfg *Config) partialRun() bool {
+	return !cfg.NoRun && (cfg.shardTotal > 0 || cfg.filterRe != nil || cfg.excludeRe != nil || cfg.Since != "")
+}
+
+// logf prints a status message unless --quiet is set
+func (cfg *Config) logf(format string, args ...interface{}) {
+	if !cfg.Quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// checkCoverRegex rejects regexes Devel::Cover would misread. Its options
+// are passed as one comma-separated list, so a regex can't contain a comma
+// or start with a dash. The regex itself is Perl's, so it isn't compiled
+// here.
+func checkCoverRegex(re string) error {
+	switch {
+	case re == "":
+		return fmt.Errorf("empty regex")
+	case strings.Contains(re, ","):
+		return fmt.Errorf("Devel::Cover options can't contain commas")
+	case strings.HasPrefix(re, "-"):
+		return fmt.Errorf("Devel::Cover would read a leading - as an option")
+	}
+	return nil
+}
+
+// parseLogLevel parses a --log-level name
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown level %q (want debug, info, warn or error)", name)
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// missingDirs returns the entries of dirs that aren't existing directories
+// under root
+func missingDirs(root string, dirs []string) []string {
+	var missing []string
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			missing = append(missing, dir)
 		}
 	}
+	return missing
+}
 
-	return testFiles, nil
+// ignoreMatchesNothing reports whether an --ignore pattern names a path
+// that doesn't exist. Only plain anchored paths such as t/fixtures/ can be
+// checked: globs, negations and bare names (which match at any depth) are
+// left alone.
+func ignoreMatchesNothing(root, pattern string) bool {
+	p := strings.TrimSuffix(pattern, "/")
+	if strings.HasPrefix(p, "!") || strings.ContainsAny(p, "*?[") || !strings.Contains(p, "/") {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(root, strings.TrimPrefix(p, "/")))
+	return os.IsNotExist(err)
+}
+
+// contains reports whether list contains s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// buildCriteria returns the Devel::Cover criteria to collect: --criteria
+// or the defaults, plus pod and time when --pod and --time are given
+func buildCriteria(cfg *Config) []string {
+	criteria := append([]string{}, runner.DefaultCriteria...)
+	if cfg.criteria != nil {
+		criteria = append([]string{}, cfg.criteria...)
+	}
+	if cfg.Pod && !contains(criteria, "pod") {
+		criteria = append(criteria, "pod")
+	}
+	if cfg.Time && !contains(criteria, "time") {
+		criteria = append(criteria, "time")
+	}
+	return criteria
+}
+
+// parseCriteria parses a --criteria value into a de-duplicated list
+func parseCriteria(value string) ([]string, error) {
+	var criteria []string
+	for _, c := range strings.Split(value, ",") {
+		c = strings.TrimSpace(c)
+		switch {
+		case c == "path":
+			return nil, fmt.Errorf("invalid --criteria: Devel::Cover accepts path but never records it")
+		case !contains(runner.ValidCriteria, c):
+			return nil, fmt.Errorf("invalid --criteria value: %q (valid: %s)", c, strings.Join(runner.ValidCriteria, ", "))
+		case !contains(criteria, c):
+			criteria = append(criteria, c)
+		}
+	}
+	return criteria, nil
+}
+
+// printSlowestTests lists the n slowest tests with their share of the
+// summed test time, to show which are worth splitting
+func printSlowestTests(results []runner.TestResult, n int) {
+	var total time.Duration
+	for _, r := range results {
+		total += r.Duration
+	}
+	fmt.Printf("\n--- Slowest Tests ---\n")
+	for _, r := range runner.SlowestTests(results, n) {
+		share := 0.0
+		if total > 0 {
+			share = float64(r.Duration) / float64(total) * 100
+		}
+		fmt.Printf("%9.2fs %5.1f%%  %s\n", r.Duration.Seconds(), share, r.File)
+	}
 }
 
 func printTestResults(results []runner.TestResult) {
@@ -342,7 +1785,14 @@ func printTestResults(results []runner.TestResult) {
 		if !r.Passed {
 			status = "✗"
 		}
-		fmt.Printf("%s %s (%.2fs)\n", status, r.File, r.Duration.Seconds())
+		switch {
+		case r.PassedOnRetry():
+			fmt.Printf("%s %s (%.2fs, passed on attempt %d)\n", status, r.File, r.Duration.Seconds(), r.Attempts)
+		case r.CoverageToolingError:
+			fmt.Printf("%s %s (%.2fs, Devel::Cover error)\n", status, r.File, r.Duration.Seconds())
+		default:
+			fmt.Printf("%s %s (%.2fs)\n", status, r.File, r.Duration.Seconds())
+		}
 		if !r.Passed && r.Error != "" {
 			// Show first few lines of error
 			lines := strings.Split(r.Error, "\n")
@@ -357,6 +1807,59 @@ func printTestResults(results []runner.TestResult) {
 	}
 }
 
+// printWarnings prints the stderr output of every test that wrote any,
+// grouped by test file
+func printWarnings(results []runner.TestResult) {
+	var withWarnings []runner.TestResult
+	for _, r := range results {
+		if strings.TrimSpace(r.Warnings) != "" {
+			withWarnings = append(withWarnings, r)
+		}
+	}
+	if len(withWarnings) == 0 {
+		return
+	}
+
+	fmt.Printf("\n--- Warnings (%d test files) ---\n", len(withWarnings))
+	for _, r := range withWarnings {
+		fmt.Println(r.File)
+		for _, line := range strings.Split(strings.TrimRight(r.Warnings, "\n"), "\n") {
+			fmt.Printf("      %s\n", line)
+		}
+	}
+}
+
+// testsWithoutCoverage returns the passing tests whose isolated coverage
+// database recorded nothing. Failing tests are already reported.
+func testsWithoutCoverage(results []runner.TestResult, perlPath string) ([]string, error) {
+	var dirs []string
+	tests := make(map[string]string) // coverage directory -> test
+	for _, r := range results {
+		if r.Passed && r.CoverDir != "" {
+			dirs = append(dirs, r.CoverDir)
+			tests[r.CoverDir] = r.File
+		}
+	}
+	emptyDirs, err := coverage.EmptyCoverageDBs(dirs, perlPath)
+	if err != nil {
+		return nil, err
+	}
+	var empty []string
+	for _, dir := range emptyDirs {
+		empty = append(empty, tests[dir])
+	}
+	return empty, nil
+}
+
+// printTestsWithoutCoverage lists passing tests that contributed no
+// coverage, typically because they forked or exec'd away from Devel::Cover
+func printTestsWithoutCoverage(tests []string) {
+	fmt.Printf("\n--- Tests Without Coverage Data (%d) ---\n", len(tests))
+	for _, test := range tests {
+		fmt.Printf("  %s\n", test)
+	}
+}
+
 func getFailedTests(results []runner.TestResult) []string {
 	var failed []string
 	for _, r := range results {
@@ -367,23 +1870,76 @@ func getFailedTests(results []runner.TestResult) []string {
 	return failed
 }
 
-func printRerunResults(original []runner.TestResult, rerun []runner.TestResult) {
-	// Create map for quick lookup
-	originalResults := make(map[string]bool)
-	for _, r := range original {
-		originalResults[r.File] = r.Passed
+func countPassedOnRetry(results []runner.TestResult) int {
+	count := 0
+	for _, r := range results {
+		if r.PassedOnRetry() {
+			count++
+		}
 	}
+	return count
+}
 
-	fmt.Println("\n--- Rerun Results (without Devel::Cover) ---")
-	for _, r := range rerun {
-		originalPassed := originalResults[r.File]
+// countCoverageOnlyFailures counts the failed tests that pass without Devel::Cover
+func countCoverageOnlyFailures(results []runner.TestResult) int {
+	count := 0
+	for _, r := range results {
+		if r.CoverageOnlyFailure {
+			count++
+		}
+	}
+	return count
+}
 
-		if r.Passed && !originalPassed {
+// emptyCoverageReason explains a report without any source files, a common
+// first-run confusion: whether the tests wrote no coverage runs (usually
+// because none of them compiled) or the runs recorded nothing perlcov
+// reports on. It returns "" when the report has files.
+func emptyCoverageReason(cfg *Config, report *coverage.Report, results []runner.TestResult) string {
+	if len(report.Files) > 0 {
+		return ""
+	}
+	if report.RunFiles > 0 {
+		return fmt.Sprintf("the %d coverage run(s) in %s recorded no files under %s; check --source, --cover-ignore and .perlcovignore, or run with --verbose",
+			report.RunFiles, cfg.MergedDB, strings.Join(cfg.SourceDirs, ", "))
+	}
+	if len(results) == 0 {
+		return fmt.Sprintf("%s holds no coverage runs", cfg.MergedDB)
+	}
+	if countCoverageToolingErrors(results) == len(results) {
+		return "Devel::Cover died in every test; check that it is installed for, and supports, this perl (see --perl-path)"
+	}
+	return fmt.Sprintf("none of the %d test(s) wrote a coverage run; did all tests fail to compile? Run with --verbose to see their errors", len(results))
+}
+
+// countCoverageToolingErrors counts the failed tests where Devel::Cover
+// itself died
+func countCoverageToolingErrors(results []runner.TestResult) int {
+	count := 0
+	for _, r := range results {
+		if r.CoverageToolingError {
+			count++
+		}
+	}
+	return count
+}
+
+// printRerunResults prints, for each failed test, whether it passed when
+// rerun without Devel::Cover (see TestResult.CoverageOnlyFailure) and
+// whether Devel::Cover itself died (see TestResult.CoverageToolingError)
+func printRerunResults(results []runner.TestResult) {
+	fmt.Println("\n--- Rerun Results (without Devel::Cover) ---")
+	for _, r := range results {
+		switch {
+		case r.Passed:
+		case r.CoverageToolingError && r.CoverageOnlyFailure:
+			fmt.Printf("⚠️  %s: Devel::Cover died, PASSED without it (coverage tooling error)\n", r.File)
+		case r.CoverageToolingError:
+			fmt.Printf("✗ %s: Devel::Cover died, and still FAILED without it\n", r.File)
+		case r.CoverageOnlyFailure:
 			fmt.Printf("⚠️  %s: PASSED without Devel::Cover (coverage-related failure)\n", r.File)
-		} else if !r.Passed && !originalPassed {
+		default:
 			fmt.Printf("✗ %s: Still FAILED (genuine test failure)\n", r.File)
-		} else {
-			fmt.Printf("? %s: Unexpected state\n", r.File)
 		}
 	}
 }
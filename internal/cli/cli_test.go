@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/user/perlcov/internal/coverage"
+)
+
+func TestParseFormats(t *testing.T) {
+	out := filepath.FromSlash("build/cover")
+	tests := []struct {
+		name   string
+		values []string
+		want   []formatTarget
+	}{
+		{
+			name:   "text goes to stdout",
+			values: []string{"text"},
+			want:   []formatTarget{{name: "text"}},
+		},
+		{
+			name:   "default file in the output directory",
+			values: []string{"json,clover"},
+			want: []formatTarget{
+				{name: "json", path: filepath.Join(out, "coverage.json")},
+				{name: "clover", path: filepath.Join(out, "clover.xml")},
+			},
+		},
+		{
+			name:   "repeated with explicit files",
+			values: []string{"text:-", " cobertura:ci/cobertura.xml"},
+			want: []formatTarget{
+				{name: "text"},
+				{name: "cobertura", path: "ci/cobertura.xml"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFormats(tt.values, out)
+			if err != nil {
+				t.Fatalf("parseFormats(%q) error: %v", tt.values, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFormats(%q) = %+v, want %+v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormatsErrors(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{[]string{"yaml"}, "unknown format"},
+		{[]string{"json:"}, "empty file name"},
+		{[]string{"json:-"}, "can't write to stdout"},
+		{[]string{"json:out.xml", "clover:out.xml"}, "both write to out.xml"},
+	}
+	for _, tt := range tests {
+		_, err := parseFormats(tt.values, "")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseFormats(%q) error = %v, want one containing %q", tt.values, err, tt.want)
+		}
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	dir := t.TempDir()
+	targets, err := parseFormats([]string{"json", "clover:" + filepath.Join(dir, "ci", "clover.xml")}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "ci"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	report := &coverage.Report{Files: map[string]*coverage.FileCoverage{
+		"lib/A.pm": {Path: "lib/A.pm", Statements: coverage.StatementCoverage{Covered: 1, Total: 2}},
+	}}
+	for _, target := range targets {
+		if err := writeFormat(report, target, coverage.PrintOptions{}); err != nil {
+			t.Fatalf("writeFormat(%s) error: %v", target.name, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "coverage.json"))
+	if err != nil || !json.Valid(data) {
+		t.Errorf("coverage.json = %q (%v), want JSON", data, err)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "ci", "clover.xml"))
+	if err != nil || xml.Unmarshal(data, new(struct{})) != nil {
+		t.Errorf("clover.xml = %q (%v), want XML", data, err)
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	index, total, err := parseShard("1/4")
+	if err != nil || index != 1 || total != 4 {
+		t.Errorf("parseShard(1/4) = %d, %d, %v, want 1, 4, nil", index, total, err)
+	}
+	for _, value := range []string{"1", "1/", "/4", "1/4abc", "1abc/4", "1/4/2", "1.5/4"} {
+		if _, _, err := parseShard(value); err == nil {
+			t.Errorf("parseShard(%q) succeeded, want an error", value)
+		}
+	}
+}
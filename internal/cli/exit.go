@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes, so CI can tell a test failure from a coverage dip
+const (
+	ExitTestsFailed   = 1 // One or more tests failed
+	ExitCoverageLow   = 2 // Coverage below --fail-under, regressed against the baseline, or untested files
+	ExitInternalError = 3 // Tooling problem, e.g. Devel::Cover missing or bad flags
+)
+
+// ExitError is an error that maps to a specific process exit code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// exitErrorf returns an ExitError with a formatted message
+func exitErrorf(code int, format string, args ...interface{}) error {
+	return &ExitError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// ExitCode returns the process exit code for an error returned by Run.
+// Errors without an explicit code are internal errors.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return ExitInternalError
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"tests failed", exitErrorf(ExitTestsFailed, "1 test(s) failed"), ExitTestsFailed},
+		{"wrapped coverage low", fmt.Errorf("run: %w", exitErrorf(ExitCoverageLow, "too low")), ExitCoverageLow},
+		{"plain error", errors.New("bad flag"), ExitInternalError},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("%s: ExitCode(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRunExitCodeOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		tap  string // The test's result line; "not ok" also exits 1
+		want int
+	}{
+		{"failed test outranks low coverage", "not ok 1", ExitTestsFailed},
+		{"low coverage alone", "ok 1", ExitCoverageLow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A fake "perl" that records one run covering 1 of 4
+			// statements in the database it is given
+			root := t.TempDir()
+			fakePerl := filepath.Join(root, "fake-perl")
+			script := `#!/bin/sh
+for arg; do
+	case "$arg" in
+	-MDevel::Cover=-db,*) db=${arg#-MDevel::Cover=-db,}; db=${db%%,*} ;;
+	esac
+done
+if [ -n "$db" ]; then
+	mkdir -p "$db/runs/1"
+	echo '{"runs": {"1": {"count": {"lib/A.pm": {"statement": [1, 0, 0, 0]}}}}}' > "$db/runs/1/cover.14"
+fi
+echo 1..1
+echo '` + tt.tap + `'
+case '` + tt.tap + `' in not*) exit 1 ;; esac
+`
+			files := map[string]string{
+				"fake-perl": script,
+				"t/a.t":     "",
+				"lib/A.pm":  "package A; 1;\n",
+			}
+			for name, content := range files {
+				path := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := Run([]string{"--root", root, "--perl-path", fakePerl, "--skip-version-check", "--no-timing-cache", "-q", "-j", "1", "--fail-under", "90"})
+			if got := ExitCode(err); got != tt.want {
+				t.Errorf("Run() = %v (exit code %d), want exit code %d", err, got, tt.want)
+			}
+		})
+	}
+}
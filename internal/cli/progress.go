@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/user/perlcov/internal/runner"
+)
+
+// progressWidth caps the live status line so it fits a typical terminal
+const progressWidth = 100
+
+// newProgressReporter returns a runner.OnProgress callback suited to out:
+// nothing with quiet, per-test start/finish lines with verbose, a live
+// status line on a TTY, or a plain line every 10 tests otherwise (e.g. in
+// CI logs).
+func newProgressReporter(out *os.File, verbose, quiet bool) func(runner.ProgressEvent) {
+	switch {
+	case quiet:
+		return func(runner.ProgressEvent) {}
+	case verbose:
+		return func(e runner.ProgressEvent) { printProgressVerbose(out, e) }
+	case isTerminal(out):
+		return func(e runner.ProgressEvent) { printProgressLive(out, e) }
+	default:
+		return func(e runner.ProgressEvent) { printProgressLines(out, e) }
+	}
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func printProgressVerbose(w io.Writer, e runner.ProgressEvent) {
+	if e.Started {
+		fmt.Fprintf(w, "  [start] %s\n", e.File)
+		return
+	}
+	status := "pass"
+	if !e.Result.Passed {
+		status = "FAIL"
+	}
+	fmt.Fprintf(w, "  [%s] %s (%.2fs) %d/%d\n", status, e.File, e.Result.Duration.Seconds(), e.Completed, e.Total)
+}
+
+func printProgressLive(w io.Writer, e runner.ProgressEvent) {
+	line := fmt.Sprintf("Progress: %d/%d (%d passed, %d failed)", e.Completed, e.Total, e.Passed, e.Failed())
+	if len(e.Running) > 0 {
+		line += " running: " + strings.Join(e.Running, ", ")
+	}
+	if len(line) > progressWidth {
+		line = line[:progressWidth-3] + "..."
+	}
+	// \033[K clears the rest of the previous, possibly longer, line
+	fmt.Fprintf(w, "\r%s\033[K", line)
+	if !e.Started && e.Completed == e.Total {
+		fmt.Fprintln(w)
+	}
+}
+
+func printProgressLines(w io.Writer, e runner.ProgressEvent) {
+	if e.Started {
+		return
+	}
+	if e.Completed%10 == 0 || e.Completed == e.Total {
+		fmt.Fprintf(w, "Progress: %d/%d tests completed (%d passed, %d failed)\n",
+			e.Completed, e.Total, e.Passed, e.Failed())
+	}
+}
+
+// mergeProgressMin is the fewest isolated directories worth reporting merge
+// progress for; smaller merges finish before a line would be read
+const mergeProgressMin = 50
+
+// newMergeProgressReporter returns an OnMergeProgress callback for out:
+// nothing with quiet or for small merges, a line updated in place on a TTY,
+// or a plain line per update otherwise
+func newMergeProgressReporter(out *os.File, quiet bool) func(done, total int) {
+	if quiet {
+		return nil
+	}
+	live := isTerminal(out)
+	return func(done, total int) {
+		if total <= mergeProgressMin {
+			return
+		}
+		if !live {
+			fmt.Fprintf(out, "Merging coverage: %d/%d directories\n", done, total)
+			return
+		}
+		fmt.Fprintf(out, "\rMerging coverage: %d/%d directories\033[K", done, total)
+		if done == total {
+			fmt.Fprintln(out)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DefaultAnnotationLimit caps the annotations WriteGitHubAnnotations emits,
+// since GitHub only shows a limited number per step anyway
+const DefaultAnnotationLimit = 50
+
+// githubPropertyEscaper escapes a workflow command property value
+var githubPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+// WriteGitHubAnnotations writes a GitHub Actions ::warning workflow command
+// for each range of consecutive uncovered statement lines, by path and then
+// line, so they show inline on the pull request. At most limit are written
+// (no limit if limit <= 0), followed by a ::notice saying how many were
+// left out. It returns the number of annotations written.
+func WriteGitHubAnnotations(report *Report, limit int, w io.Writer) (int, error) {
+	var paths []string
+	for path := range report.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	written, skipped := 0, 0
+	for _, path := range paths {
+		for _, r := range lineRanges(report.Files[path].Statements.Uncovered) {
+			if limit > 0 && written >= limit {
+				skipped++
+				continue
+			}
+			var err error
+			if r.start == r.end {
+				_, err = fmt.Fprintf(w, "::warning file=%s,line=%d::Uncovered line\n", githubPropertyEscaper.Replace(path), r.start)
+			} else {
+				_, err = fmt.Fprintf(w, "::warning file=%s,line=%d,endLine=%d::Uncovered lines %d-%d\n",
+					githubPropertyEscaper.Replace(path), r.start, r.end, r.start, r.end)
+			}
+			if err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+	if skipped > 0 {
+		if _, err := fmt.Fprintf(w, "::notice::%d more uncovered range(s) not annotated (limit %d)\n", skipped, limit); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,38 @@
+package coverage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteGitHubAnnotations(t *testing.T) {
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/B.pm":   {Statements: StatementCoverage{Uncovered: []int{3}}},
+		"lib/A,x.pm": {Statements: StatementCoverage{Uncovered: []int{42, 43, 44, 50}}},
+		"lib/C.pm":   {},
+	}}
+
+	var buf bytes.Buffer
+	n, err := WriteGitHubAnnotations(report, 0, &buf)
+	if err != nil {
+		t.Fatalf("WriteGitHubAnnotations() error: %v", err)
+	}
+	want := "::warning file=lib/A%2Cx.pm,line=42,endLine=44::Uncovered lines 42-44\n" +
+		"::warning file=lib/A%2Cx.pm,line=50::Uncovered line\n" +
+		"::warning file=lib/B.pm,line=3::Uncovered line\n"
+	if n != 3 || buf.String() != want {
+		t.Errorf("WriteGitHubAnnotations() = %d:\n%s\nwant 3:\n%s", n, buf.String(), want)
+	}
+
+	buf.Reset()
+	n, err = WriteGitHubAnnotations(report, 2, &buf)
+	if err != nil {
+		t.Fatalf("WriteGitHubAnnotations() error: %v", err)
+	}
+	want = "::warning file=lib/A%2Cx.pm,line=42,endLine=44::Uncovered lines 42-44\n" +
+		"::warning file=lib/A%2Cx.pm,line=50::Uncovered line\n" +
+		"::notice::1 more uncovered range(s) not annotated (limit 2)\n"
+	if n != 2 || buf.String() != want {
+		t.Errorf("limited WriteGitHubAnnotations() = %d:\n%s\nwant 2:\n%s", n, buf.String(), want)
+	}
+}
@@ -0,0 +1,201 @@
+package coverage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractCoverageArchive extracts a coverage database passed between CI
+// jobs as a .tar.gz or .zip artifact into a temporary directory and returns
+// the database's directory there: the shallowest one holding a runs/
+// directory, so an archived cover_db/ and an archive of its contents both
+// work. The format is sniffed rather than taken from the file name. cleanup
+// removes the extraction and must be called once the database has been
+// imported; it is nil when err is not.
+func ExtractCoverageArchive(archive string) (dir string, cleanup func(), err error) {
+	tmp, err := os.MkdirTemp("", "perlcov-archive-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(tmp) }
+
+	if err := extractArchive(archive, tmp); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to extract %s: %w", archive, err)
+	}
+	dir, err = findCoverageDB(tmp)
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("%s: %w", archive, err)
+	}
+	return dir, cleanup, nil
+}
+
+// extractArchive extracts archive into dest, telling zip from gzip by their
+// magic bytes
+func extractArchive(archive, dest string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic, err := bufio.NewReader(f).Peek(4)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return extractZip(f, info.Size(), dest)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return extractTar(gz, dest)
+	}
+	return fmt.Errorf("not a .tar.gz or .zip archive")
+}
+
+// archivePath resolves an archive entry's name inside dest, refusing
+// absolute names and names that climb out of it
+func archivePath(dest, name string) (string, error) {
+	name = filepath.FromSlash(strings.ReplaceAll(name, "\\", "/"))
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("entry %s has an absolute path", name)
+	}
+	path := filepath.Join(dest, name)
+	if rel, err := filepath.Rel(dest, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry %s points outside the archive", name)
+	}
+	return path, nil
+}
+
+// writeArchiveFile writes one regular file from an archive
+func writeArchiveFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// extractTar extracts the directories and regular files of a tar stream.
+// Links and other special entries aren't part of a coverage database and
+// are skipped.
+func extractTar(r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path, err := archivePath(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(path, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractZip extracts the directories and regular files of a zip archive
+func extractZip(r io.ReaderAt, size int64, dest string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		path, err := archivePath(dest, f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = writeArchiveFile(path, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findCoverageDB returns the shallowest directory under root holding a runs/
+// directory. Two at the same depth are ambiguous.
+func findCoverageDB(root string) (string, error) {
+	var found []string
+	depth := -1
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || d.Name() != "runs" {
+			return nil
+		}
+		db := filepath.Dir(path)
+		n := strings.Count(db, string(filepath.Separator))
+		switch {
+		case depth < 0 || n < depth:
+			found, depth = []string{db}, n
+		case n == depth:
+			found = append(found, db)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return "", err
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("archive holds no coverage database (no runs/ directory)")
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("archive holds %d coverage databases; archive one per file", len(found))
+}
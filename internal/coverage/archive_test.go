@@ -0,0 +1,129 @@
+package coverage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTarGz writes files, keyed by slash-separated name, as a .tar.gz
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeZip writes files, keyed by slash-separated name, as a .zip
+func writeZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractCoverageArchive(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cover_db/runs/1.2.3/cover.14": "{}",
+		"cover_db/structure/abc":       "{}",
+		"README":                       "shard 0",
+	}
+
+	for name, write := range map[string]func(*testing.T, string, map[string]string){
+		"cover.tar.gz": writeTarGz,
+		"cover.zip":    writeZip,
+		"cover.bin":    writeZip, // sniffed, not taken from the name
+	} {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(dir, name)
+			write(t, archive, files)
+
+			db, cleanup, err := ExtractCoverageArchive(archive)
+			if err != nil {
+				t.Fatalf("ExtractCoverageArchive() error: %v", err)
+			}
+			if filepath.Base(db) != "cover_db" {
+				t.Errorf("database = %s, want the archived cover_db", db)
+			}
+			if err := ValidateCoverageDB(db); err != nil {
+				t.Error(err)
+			}
+			if _, err := os.Stat(filepath.Join(db, "structure", "abc")); err != nil {
+				t.Errorf("structure file not extracted: %v", err)
+			}
+			cleanup()
+			if _, err := os.Stat(db); !os.IsNotExist(err) {
+				t.Errorf("cleanup left %s behind", db)
+			}
+		})
+	}
+}
+
+func TestExtractCoverageArchiveErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"no runs", map[string]string{"cover_db/structure/abc": "{}"}, "no runs/ directory"},
+		{"two databases", map[string]string{"a/runs/1/cover.14": "{}", "b/runs/1/cover.14": "{}"}, "2 coverage databases"},
+		{"escapes", map[string]string{"../evil/runs/1/cover.14": "{}"}, "outside the archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".tar.gz")
+			writeTarGz(t, archive, tt.files)
+			if _, _, err := ExtractCoverageArchive(archive); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ExtractCoverageArchive() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	plain := filepath.Join(dir, "cover_db.txt")
+	if err := os.WriteFile(plain, []byte("runs/"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ExtractCoverageArchive(plain); err == nil || !strings.Contains(err.Error(), "not a .tar.gz or .zip") {
+		t.Errorf("ExtractCoverageArchive() error = %v, want an unknown format", err)
+	}
+}
@@ -0,0 +1,51 @@
+package coverage
+
+import (
+	_ "embed"
+	"html"
+	"io"
+	"text/template"
+)
+
+//go:embed templates/badge.svg
+var badgeSVG string
+
+var badgeTemplate = template.Must(template.New("badge").Parse(badgeSVG))
+
+// badgeColors are shields.io's colors for each coverage level
+var badgeColors = map[string]string{
+	"high":   "#4c1",    // brightgreen
+	"medium": "#dfb317", // yellow
+	"low":    "#e05d44", // red
+}
+
+// badgeData is what templates/badge.svg renders
+type badgeData struct {
+	Label, Value, Color    string
+	LabelWidth, ValueWidth int
+	Width                  int
+	LabelX, ValueX         int
+}
+
+// badgeTextWidth approximates the width in pixels of s in 11px Verdana,
+// the badge font, plus padding on both sides
+func badgeTextWidth(s string) int {
+	return 7*len(s) + 10
+}
+
+// WriteBadge writes a shields.io-style SVG badge reading e.g.
+// "coverage | 85.3%", colored green, yellow or red by t's level for pct
+func WriteBadge(w io.Writer, label string, pct float64, t Thresholds, f PercentFormat) error {
+	value := f.Percent(pct)
+	data := badgeData{
+		Label:      html.EscapeString(label),
+		Value:      html.EscapeString(value),
+		Color:      badgeColors[t.Level(pct)],
+		LabelWidth: badgeTextWidth(label),
+		ValueWidth: badgeTextWidth(value),
+	}
+	data.Width = data.LabelWidth + data.ValueWidth
+	data.LabelX = data.LabelWidth / 2
+	data.ValueX = data.LabelWidth + data.ValueWidth/2
+	return badgeTemplate.Execute(w, data)
+}
@@ -0,0 +1,45 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestWriteBadge(t *testing.T) {
+	tests := []struct {
+		pct   float64
+		value string
+		color string
+	}{
+		{95, "95.0%", "#4c1"},
+		{72.3, "72.3%", "#dfb317"},
+		{12, "12.0%", "#e05d44"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteBadge(&buf, "coverage", tt.pct, DefaultThresholds, PercentFormat{}); err != nil {
+			t.Fatalf("WriteBadge(%v) error: %v", tt.pct, err)
+		}
+		svg := buf.String()
+		if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+			t.Errorf("WriteBadge(%v) wrote invalid XML: %v\n%s", tt.pct, err, svg)
+		}
+		for _, want := range []string{">coverage</text>", ">" + tt.value + "</text>", `fill="` + tt.color + `"`} {
+			if !strings.Contains(svg, want) {
+				t.Errorf("WriteBadge(%v) missing %q:\n%s", tt.pct, want, svg)
+			}
+		}
+	}
+}
+
+func TestWriteBadgeEscapes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteBadge(&buf, "a<b", 50, DefaultThresholds, PercentFormat{}); err != nil {
+		t.Fatalf("WriteBadge() error: %v", err)
+	}
+	if strings.Contains(buf.String(), "a<b") || !strings.Contains(buf.String(), "a&lt;b") {
+		t.Errorf("label not escaped:\n%s", buf.String())
+	}
+}
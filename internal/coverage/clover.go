@@ -0,0 +1,169 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// cloverCoverage is the Clover XML schema read by Bamboo and Bitbucket
+type cloverCoverage struct {
+	XMLName   xml.Name      `xml:"coverage"`
+	Generated int64         `xml:"generated,attr"` // milliseconds since the epoch
+	Clover    string        `xml:"clover,attr"`
+	Project   cloverProject `xml:"project"`
+}
+
+// cloverProject holds the rollup metrics and every file
+type cloverProject struct {
+	Timestamp int64         `xml:"timestamp,attr"`
+	Metrics   cloverMetrics `xml:"metrics"`
+	Files     []cloverFile  `xml:"file"`
+}
+
+// cloverMetrics counts coverage elements. Clover's conditionals are branch
+// sides and its methods are subroutines; elements is their sum with
+// statements. Files is only set on the project rollup.
+type cloverMetrics struct {
+	Statements          int `xml:"statements,attr"`
+	CoveredStatements   int `xml:"coveredstatements,attr"`
+	Conditionals        int `xml:"conditionals,attr"`
+	CoveredConditionals int `xml:"coveredconditionals,attr"`
+	Methods             int `xml:"methods,attr"`
+	CoveredMethods      int `xml:"coveredmethods,attr"`
+	Elements            int `xml:"elements,attr"`
+	CoveredElements     int `xml:"coveredelements,attr"`
+	Files               int `xml:"files,attr,omitempty"`
+}
+
+// cloverFile holds one source file's metrics and per-line hits
+type cloverFile struct {
+	Name    string        `xml:"name,attr"`
+	Path    string        `xml:"path,attr"`
+	Metrics cloverMetrics `xml:"metrics"`
+	Lines   []cloverLine  `xml:"line"`
+}
+
+// cloverLine is a statement line (type "stmt") or, on lines with branches,
+// a conditional (type "cond") with how often its true and false sides ran
+type cloverLine struct {
+	Num        int    `xml:"num,attr"`
+	Type       string `xml:"type,attr"`
+	Count      int    `xml:"count,attr"`
+	TrueCount  *int   `xml:"truecount,attr,omitempty"`
+	FalseCount *int   `xml:"falsecount,attr,omitempty"`
+}
+
+// cloverVersion is the Clover schema version written to the clover attribute
+const cloverVersion = "3.2.0"
+
+// add adds other's counts to m
+func (m *cloverMetrics) add(other cloverMetrics) {
+	m.Statements += other.Statements
+	m.CoveredStatements += other.CoveredStatements
+	m.Conditionals += other.Conditionals
+	m.CoveredConditionals += other.CoveredConditionals
+	m.Methods += other.Methods
+	m.CoveredMethods += other.CoveredMethods
+	m.Elements += other.Elements
+	m.CoveredElements += other.CoveredElements
+}
+
+// fileCloverMetrics takes a file's statement, branch and subroutine counts
+func fileCloverMetrics(fc *FileCoverage) cloverMetrics {
+	m := cloverMetrics{
+		Statements:          fc.Statements.Total,
+		CoveredStatements:   fc.Statements.Covered,
+		Conditionals:        fc.Branches.Total,
+		CoveredConditionals: fc.Branches.Covered,
+		Methods:             fc.Subroutines.Total,
+		CoveredMethods:      fc.Subroutines.Covered,
+	}
+	m.Elements = m.Statements + m.Conditionals + m.Methods
+	m.CoveredElements = m.CoveredStatements + m.CoveredConditionals + m.CoveredMethods
+	return m
+}
+
+// toCloverCoverage converts a Report to the Clover schema. Statement lines
+// become stmt lines; lines with positioned branches become cond lines
+// carrying the summed true and false counts.
+func toCloverCoverage(report *Report, generated time.Time) *cloverCoverage {
+	out := &cloverCoverage{
+		Generated: generated.UnixMilli(),
+		Clover:    cloverVersion,
+		Project:   cloverProject{Timestamp: generated.UnixMilli()},
+	}
+
+	var paths []string
+	for path := range report.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		fc := report.Files[path]
+		file := cloverFile{
+			Name:    filepath.Base(path),
+			Path:    path,
+			Metrics: fileCloverMetrics(fc),
+			Lines:   []cloverLine{},
+		}
+		out.Project.Metrics.add(file.Metrics)
+		out.Project.Metrics.Files++
+
+		conds := make(map[int]*cloverLine)
+		for _, b := range fc.Branches.Detail {
+			if b.Line == 0 {
+				continue
+			}
+			cl := conds[b.Line]
+			if cl == nil {
+				cl = &cloverLine{Num: b.Line, Type: "cond", TrueCount: new(int), FalseCount: new(int)}
+				conds[b.Line] = cl
+			}
+			*cl.TrueCount += b.True
+			*cl.FalseCount += b.False
+		}
+
+		var lines []int
+		for line := range fc.Statements.lines {
+			lines = append(lines, line)
+		}
+		for line := range conds {
+			if _, ok := fc.Statements.lines[line]; !ok {
+				lines = append(lines, line)
+			}
+		}
+		sort.Ints(lines)
+
+		for _, line := range lines {
+			if cl := conds[line]; cl != nil {
+				cl.Count = fc.Statements.lines[line]
+				file.Lines = append(file.Lines, *cl)
+				continue
+			}
+			file.Lines = append(file.Lines, cloverLine{Num: line, Type: "stmt", Count: fc.Statements.lines[line]})
+		}
+		out.Project.Files = append(out.Project.Files, file)
+	}
+
+	return out
+}
+
+// WriteClover writes the report as Clover XML, stamped with the generated
+// time. Like WriteSonarGeneric it needs per-line hits for the line
+// elements, so reports from ReadJSON only get the metrics.
+func WriteClover(report *Report, generated time.Time, w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(toCloverCoverage(report, generated)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
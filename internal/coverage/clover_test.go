@@ -0,0 +1,74 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteClover(t *testing.T) {
+	report := &Report{
+		Files: map[string]*FileCoverage{
+			"lib/B.pm": {
+				Path:        "lib/B.pm",
+				Statements:  StatementCoverage{Covered: 1, Total: 1, lines: map[int]int{1: 1}},
+				Subroutines: SubroutineCoverage{Covered: 0, Total: 1},
+			},
+			"lib/A/C.pm": {
+				Path:        "lib/A/C.pm",
+				Statements:  StatementCoverage{Covered: 2, Total: 3, lines: map[int]int{3: 2, 1: 5, 7: 0}},
+				Branches:    BranchCoverage{Covered: 3, Total: 4, Detail: []BranchHit{{Line: 3, True: 2, False: 0}, {Line: 3, True: 1, False: 1}}},
+				Subroutines: SubroutineCoverage{Covered: 1, Total: 1},
+			},
+		},
+	}
+	generated := time.UnixMilli(1714564800000)
+
+	var buf bytes.Buffer
+	if err := WriteClover(report, generated, &buf); err != nil {
+		t.Fatalf("WriteClover() error: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") {
+		t.Errorf("output lacks XML header: %q", buf.String())
+	}
+
+	var got cloverCoverage
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	if got.Generated != 1714564800000 || got.Project.Timestamp != 1714564800000 {
+		t.Errorf("generated = %d, timestamp = %d", got.Generated, got.Project.Timestamp)
+	}
+
+	wantProject := cloverMetrics{
+		Statements: 4, CoveredStatements: 3,
+		Conditionals: 4, CoveredConditionals: 3,
+		Methods: 2, CoveredMethods: 1,
+		Elements: 10, CoveredElements: 7,
+		Files: 2,
+	}
+	if got.Project.Metrics != wantProject {
+		t.Errorf("project metrics = %+v, want %+v", got.Project.Metrics, wantProject)
+	}
+
+	files := got.Project.Files
+	if len(files) != 2 || files[0].Path != "lib/A/C.pm" || files[0].Name != "C.pm" {
+		t.Fatalf("files = %+v, want lib/A/C.pm first", files)
+	}
+	if files[0].Metrics.Conditionals != 4 || files[0].Metrics.Files != 0 {
+		t.Errorf("lib/A/C.pm metrics = %+v", files[0].Metrics)
+	}
+
+	lines := files[0].Lines
+	if len(lines) != 3 {
+		t.Fatalf("lib/A/C.pm has %d lines, want 3", len(lines))
+	}
+	if lines[0].Num != 1 || lines[0].Type != "stmt" || lines[0].Count != 5 || lines[0].TrueCount != nil {
+		t.Errorf("line 1 = %+v", lines[0])
+	}
+	if l := lines[1]; l.Num != 3 || l.Type != "cond" || l.Count != 2 || *l.TrueCount != 3 || *l.FalseCount != 1 {
+		t.Errorf("line 3 = %+v", l)
+	}
+}
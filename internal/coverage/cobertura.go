@@ -0,0 +1,201 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// coberturaCoverage is the Cobertura XML schema read by GitLab, Jenkins
+// and SonarQube's Cobertura importers
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"` // milliseconds since the epoch
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+// coberturaPackage holds the files of one directory
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+// coberturaClass is one source file. Cobertura wants methods, but
+// Devel::Cover doesn't place every subroutine, so the list is left empty.
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+// coberturaLine is a statement line. Lines with placed conditions are
+// marked as branches and carry their decision outcomes in
+// condition-coverage, e.g. "50% (1/2)"; other lines omit both.
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int    `xml:"hits,attr"`
+	Branch            string `xml:"branch,attr,omitempty"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+// coberturaVersion is written to the version attribute
+const coberturaVersion = "perlcov"
+
+// coberturaRate formats covered/total as a rate between 0 and 1; nothing to
+// cover counts as fully covered, as Cobertura does. The rate is always
+// written in decimal notation, never as e.g. 1e-05.
+func coberturaRate(covered, total int) string {
+	if total == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(float64(covered)/float64(total), 'f', -1, 64)
+}
+
+// coberturaConditions sums the decision outcomes of the conditions on each
+// line, skipping conditions without a position
+func coberturaConditions(fc *FileCoverage) map[int]*ConditionHit {
+	lines := make(map[int]*ConditionHit)
+	for _, c := range fc.Conditions.Detail {
+		if c.Line == 0 || c.Total == 0 {
+			continue
+		}
+		l := lines[c.Line]
+		if l == nil {
+			l = &ConditionHit{Line: c.Line}
+			lines[c.Line] = l
+		}
+		l.Covered += c.Covered
+		l.Total += c.Total
+	}
+	return lines
+}
+
+// toCoberturaCoverage converts a Report to the Cobertura schema, with one
+// package per directory and one class per file. Branch rates count branch
+// sides and condition outcomes together, Cobertura having no separate
+// condition metric.
+func toCoberturaCoverage(report *Report, generated time.Time) *coberturaCoverage {
+	out := &coberturaCoverage{
+		Version:   coberturaVersion,
+		Timestamp: generated.UnixMilli(),
+		Sources:   []string{"."},
+		Packages:  []coberturaPackage{},
+	}
+
+	var paths []string
+	for p := range report.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	type counts struct{ lines, linesCovered, branches, branchesCovered int }
+	var total counts
+	var dirs []string
+	packages := make(map[string]*coberturaPackage)
+	pkgCounts := make(map[string]*counts)
+
+	for _, p := range paths {
+		fc := report.Files[p]
+		slashed := strings.ReplaceAll(p, "\\", "/")
+		dir := path.Dir(slashed)
+		pkg := packages[dir]
+		if pkg == nil {
+			pkg = &coberturaPackage{Name: dir}
+			packages[dir] = pkg
+			pkgCounts[dir] = &counts{}
+			dirs = append(dirs, dir)
+		}
+
+		var c counts
+		c.branches = fc.Branches.Total + fc.Conditions.Outcomes
+		c.branchesCovered = fc.Branches.Covered + fc.Conditions.OutcomesCovered
+
+		conds := coberturaConditions(fc)
+		var lines []int
+		for line := range fc.Statements.lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+
+		class := coberturaClass{
+			Name:     strings.TrimSuffix(slashed, path.Ext(slashed)),
+			Filename: p,
+			Lines:    []coberturaLine{},
+		}
+		for _, line := range lines {
+			hits := fc.Statements.lines[line]
+			cl := coberturaLine{Number: line, Hits: hits}
+			if cond := conds[line]; cond != nil {
+				cl.Branch = "true"
+				cl.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", cond.Covered*100/cond.Total, cond.Covered, cond.Total)
+			}
+			class.Lines = append(class.Lines, cl)
+			c.lines++
+			if hits > 0 {
+				c.linesCovered++
+			}
+		}
+		class.LineRate = coberturaRate(c.linesCovered, c.lines)
+		class.BranchRate = coberturaRate(c.branchesCovered, c.branches)
+		pkg.Classes = append(pkg.Classes, class)
+
+		for _, sum := range []*counts{pkgCounts[dir], &total} {
+			sum.lines += c.lines
+			sum.linesCovered += c.linesCovered
+			sum.branches += c.branches
+			sum.branchesCovered += c.branchesCovered
+		}
+	}
+
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		pkg, c := packages[dir], pkgCounts[dir]
+		pkg.LineRate = coberturaRate(c.linesCovered, c.lines)
+		pkg.BranchRate = coberturaRate(c.branchesCovered, c.branches)
+		out.Packages = append(out.Packages, *pkg)
+	}
+
+	out.LinesValid, out.LinesCovered = total.lines, total.linesCovered
+	out.BranchesValid, out.BranchesCovered = total.branches, total.branchesCovered
+	out.LineRate = coberturaRate(total.linesCovered, total.lines)
+	out.BranchRate = coberturaRate(total.branchesCovered, total.branches)
+	return out
+}
+
+// WriteCobertura writes the report as Cobertura XML, stamped with the
+// generated time. Lines get condition-coverage where Devel::Cover placed
+// the conditions on them. Like WriteClover it needs per-line hits, so
+// reports from ReadJSON only get the rates.
+func WriteCobertura(report *Report, generated time.Time, w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(toCoberturaCoverage(report, generated)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,81 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteCobertura(t *testing.T) {
+	report := &Report{
+		Files: map[string]*FileCoverage{
+			"lib/A.pm": {
+				Path:       "lib/A.pm",
+				Statements: StatementCoverage{Covered: 1, Total: 2, lines: map[int]int{1: 3, 2: 0}},
+			},
+			"lib/A/B.pm": {
+				Path:       "lib/A/B.pm",
+				Statements: StatementCoverage{Covered: 2, Total: 2, lines: map[int]int{4: 1, 5: 2}},
+				Branches:   BranchCoverage{Covered: 1, Total: 2},
+				Conditions: ConditionCoverage{
+					Outcomes: 6, OutcomesCovered: 3,
+					Detail: []ConditionHit{{Line: 4, Covered: 1, Total: 4}, {Line: 4, Covered: 2, Total: 2}, {Line: 0, Covered: 0, Total: 2}},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCobertura(report, time.UnixMilli(1714564800000), &buf); err != nil {
+		t.Fatalf("WriteCobertura() error: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") {
+		t.Errorf("output lacks XML header: %q", buf.String())
+	}
+
+	var got coberturaCoverage
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	if got.LinesValid != 4 || got.LinesCovered != 3 || got.LineRate != "0.75" {
+		t.Errorf("lines = %d/%d (%s), want 3/4 (0.75)", got.LinesCovered, got.LinesValid, got.LineRate)
+	}
+	if got.BranchesValid != 8 || got.BranchesCovered != 4 || got.BranchRate != "0.5" {
+		t.Errorf("branches = %d/%d (%s), want 4/8 (0.5)", got.BranchesCovered, got.BranchesValid, got.BranchRate)
+	}
+
+	if len(got.Packages) != 2 || got.Packages[0].Name != "lib" || got.Packages[1].Name != "lib/A" {
+		t.Fatalf("packages = %+v, want lib and lib/A", got.Packages)
+	}
+	class := got.Packages[1].Classes[0]
+	if class.Name != "lib/A/B" || class.Filename != "lib/A/B.pm" {
+		t.Errorf("class = %q (%q)", class.Name, class.Filename)
+	}
+	want := []coberturaLine{
+		{Number: 4, Hits: 1, Branch: "true", ConditionCoverage: "50% (3/6)"},
+		{Number: 5, Hits: 2},
+	}
+	if len(class.Lines) != len(want) || class.Lines[0] != want[0] || class.Lines[1] != want[1] {
+		t.Errorf("lines = %+v, want %+v", class.Lines, want)
+	}
+}
+
+func TestCoberturaRate(t *testing.T) {
+	tests := []struct {
+		covered, total int
+		want           string
+	}{
+		{0, 0, "1"},
+		{3, 4, "0.75"},
+		{4, 4, "1"},
+		{0, 10, "0"},
+		{1, 100000, "0.00001"},
+	}
+	for _, tt := range tests {
+		if got := coberturaRate(tt.covered, tt.total); got != tt.want {
+			t.Errorf("coberturaRate(%d, %d) = %q, want %q", tt.covered, tt.total, got, tt.want)
+		}
+	}
+}
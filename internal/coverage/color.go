@@ -0,0 +1,63 @@
+package coverage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Thresholds are the coverage percentages at or above which a value counts
+// as high or medium; anything below Medium is low
+type Thresholds struct {
+	High   float64
+	Medium float64
+}
+
+// DefaultThresholds are used by the HTML report and the colored table
+var DefaultThresholds = Thresholds{High: 90, Medium: 70}
+
+// ParseThresholds parses "high,medium", e.g. "90,70"
+func ParseThresholds(s string) (Thresholds, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Thresholds{}, fmt.Errorf("want <high>,<medium>, got %q", s)
+	}
+	high, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return Thresholds{}, fmt.Errorf("invalid high threshold %q", parts[0])
+	}
+	medium, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return Thresholds{}, fmt.Errorf("invalid medium threshold %q", parts[1])
+	}
+	if medium < 0 || high > 100 || medium > high {
+		return Thresholds{}, fmt.Errorf("thresholds must satisfy 0 <= medium <= high <= 100, got %q", s)
+	}
+	return Thresholds{High: high, Medium: medium}, nil
+}
+
+// Level returns "high", "medium" or "low" for a coverage percentage
+func (t Thresholds) Level(pct float64) string {
+	switch {
+	case pct >= t.High:
+		return "high"
+	case pct >= t.Medium:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// ANSI color codes for each coverage level
+var levelColors = map[string]string{
+	"high":   "\033[32m", // green
+	"medium": "\033[33m", // yellow
+	"low":    "\033[31m", // red
+}
+
+const colorReset = "\033[0m"
+
+// colorize wraps text in the color for pct's level
+func (t Thresholds) colorize(text string, pct float64) string {
+	return levelColors[t.Level(pct)] + text + colorReset
+}
@@ -3,12 +3,18 @@ package coverage
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // NormalizationMode represents a coverage normalization transformation
@@ -77,15 +83,63 @@ func ParseNormalizationModes(input string) (*NormalizationConfig, error) {
 type Report struct {
 	Files   map[string]*FileCoverage
 	Summary CoverageSummary
+
+	RunFiles  int                 // Run files read while merging
+	Skipped   []SkippedRun        // Run files that could not be parsed
+	Conflicts []StructureConflict // Sources whose runs instrumented different versions
+
+	// StaleLocks are .lock files left in the database by a run that
+	// crashed (see FindStaleLocks); 'cover' can hang on them
+	StaleLocks []string
+
+	// Root is the directory relative paths are relative to (the root
+	// passed when parsing, or the working directory) and PathStyle how
+	// Files keys are written; see SetPathStyle and SourcePath
+	Root      string
+	PathStyle string
+
+	// CountEmptyFiles makes files without statements count toward
+	// TotalFiles and CoveredFiles; by default they are left out of both
+	CountEmptyFiles bool
+
+	// Criteria are the Devel::Cover criteria that were collected; see
+	// SetCriteria. nil means all of them.
+	Criteria []string
+
+	// SummaryOnly is set when the report was parsed without per-line data
+	// (see ParseCoverageSummary): files have counts and their uncovered
+	// statement lines, but no uncovered branches, conditions or subroutines
+	SummaryOnly bool
+}
+
+// SkippedRun is a run file left out of the report because it could not be
+// read or decoded
+type SkippedRun struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// StructureConflict is a source file that runs instrumented at different
+// versions, e.g. edited between --accumulate runs. Counts index into the
+// structure of the version they were taken from, so only the newest
+// version's are kept; mixing them would put hits on the wrong lines.
+type StructureConflict struct {
+	File     string `json:"file"`
+	Digest   string `json:"digest"`   // Devel::Cover digest of the version kept
+	Versions int    `json:"versions"` // Versions seen, counting the kept one
+	Dropped  int    `json:"dropped"`  // Runs of older versions left out
 }
 
 // FileCoverage represents coverage data for a single file
 type FileCoverage struct {
 	Path        string
+	Kind        CoverageKind // KindPerl, or KindC for XS code measured with gcov
 	Statements  StatementCoverage
 	Branches    BranchCoverage
 	Conditions  ConditionCoverage
 	Subroutines SubroutineCoverage
+	Pod         PodCoverage
+	TimeData    map[int]float64 // line -> seconds spent (only with the time criterion)
 }
 
 // StatementCoverage holds statement coverage data
@@ -93,27 +147,89 @@ type StatementCoverage struct {
 	Covered   int
 	Total     int
 	Percent   float64
-	Uncovered []int // Line numbers
-	// Internal: line -> hit count for merging
+	Uncovered []int // Line numbers with a statement that never ran
+	// Internal: line -> merged hit count, summed over the line's statements.
+	// Every statement line is present; 0 means none of them ran
 	lines map[int]int
+	// Internal: line -> number of statements on it
+	counts map[int]int
+	// Internal: line -> number of its statements that never ran, for lines
+	// that ran only partly
+	missed map[int]int
 }
 
 // BranchCoverage holds branch coverage data
 type BranchCoverage struct {
-	Covered int
-	Total   int
-	Percent float64
+	Covered   int
+	Total     int
+	Percent   float64
+	Uncovered []int       // Lines with a branch not taken both ways
+	Detail    []BranchHit // Per-branch hit counts, in structure order
 }
 
-// ConditionCoverage holds condition coverage data
+// BranchHit holds the hit counts for both sides of a single branch
+type BranchHit struct {
+	Line        int  `json:"line"` // 0 when the structure file has no position
+	True        int  `json:"true"`
+	False       int  `json:"false"`
+	Uncoverable bool `json:"uncoverable,omitempty"` // Every side never taken is listed as uncoverable
+}
+
+// ConditionCoverage holds condition coverage data. Covered and Total count
+// Devel::Cover's condition states; Outcomes counts the same conditions as
+// decision outcomes, the unit branches use (see conditionOutcomes).
 type ConditionCoverage struct {
-	Covered int
-	Total   int
-	Percent float64
+	Covered         int
+	Total           int
+	Percent         float64
+	Outcomes        int
+	OutcomesCovered int // TrueCovered + FalseCovered
+	TrueCovered     int // Operands seen evaluating true
+	FalseCovered    int // Operands seen evaluating false
+	Detail          []ConditionHit
+	Partial         []CondInfo // Conditions with an outcome never seen, in structure order
+}
+
+// ConditionHit holds one condition's decision outcomes (see
+// conditionOutcomes), in structure order
+type ConditionHit struct {
+	Line        int      `json:"line"` // 0 when the structure file has no position
+	Covered     int      `json:"covered"`
+	Total       int      `json:"total"`
+	Missed      []string `json:"missed,omitempty"`      // Outcomes never seen (see conditionMissed)
+	States      []int    `json:"states,omitempty"`      // Devel::Cover's hits per condition state
+	Uncoverable bool     `json:"uncoverable,omitempty"` // Every state never seen is listed as uncoverable
+}
+
+// CondInfo names the outcomes a condition never evaluated to, e.g.
+// "false", or "left true" and "right false" for a two-operand condition
+type CondInfo struct {
+	Line   int      `json:"line"`
+	Missed []string `json:"missed"`
 }
 
 // SubroutineCoverage holds subroutine coverage data
 type SubroutineCoverage struct {
+	Covered   int
+	Total     int
+	Percent   float64
+	Uncovered []SubInfo // Subroutines never called, in structure order
+	Called    []SubInfo // Subroutines called at least once, in structure order
+}
+
+// SubInfo names a subroutine and the line it starts on
+type SubInfo struct {
+	Name string `json:"name"`
+	Line int    `json:"line"`
+}
+
+// String formats the subroutine as "name (line N)"
+func (s SubInfo) String() string {
+	return fmt.Sprintf("%s (line %d)", s.Name, s.Line)
+}
+
+// PodCoverage holds POD (documentation) coverage data
+type PodCoverage struct {
 	Covered int
 	Total   int
 	Percent float64
@@ -125,9 +241,11 @@ type CoverageSummary struct {
 	Branch       float64
 	Condition    float64
 	Subroutine   float64
+	Pod          float64
 	Combined     float64 // SonarQube-style combined coverage
 	TotalFiles   int
 	CoveredFiles int
+	Sonar        SonarCounts // Inputs of Combined
 
 	// Normalization state
 	Normalized          bool
@@ -135,33 +253,82 @@ type CoverageSummary struct {
 	SubroutinesAbsorbed bool // subroutines merged into statements
 }
 
+// SonarCounts are the raw inputs of SonarQube's overall coverage,
+// (CT + CF + LC) / (2*B + EL)
+type SonarCounts struct {
+	ConditionsTrue  int // CT: branches and condition operands seen true
+	ConditionsFalse int // CF: branches and condition operands seen false
+	Conditions      int // B: branches plus condition operands
+	LinesCovered    int // LC: covered statements
+	Lines           int // EL: executable statements
+}
+
+// Combined returns (CT + CF + LC) / (2*B + EL) as a percentage
+func (s SonarCounts) Combined() float64 {
+	total := 2*s.Conditions + s.Lines
+	if total == 0 {
+		return 0
+	}
+	return float64(s.ConditionsTrue+s.ConditionsFalse+s.LinesCovered) / float64(total) * 100
+}
+
 // runCoverageData represents coverage data from a single test run
 type runCoverageData struct {
-	Files []struct {
-		Path      string `json:"path"`
-		Statement struct {
-			Lines   map[string]int `json:"lines"`   // line number -> hit count (for uncovered lines display)
-			Covered int            `json:"covered"` // total covered statements
-			Total   int            `json:"total"`   // total statements
-		} `json:"statement"`
-		Branch struct {
-			Covered int `json:"covered"`
-			Total   int `json:"total"`
-		} `json:"branch"`
-		Condition struct {
-			Covered int `json:"covered"`
-			Total   int `json:"total"`
-		} `json:"condition"`
-		Subroutine struct {
-			Covered int `json:"covered"`
-			Total   int `json:"total"`
-		} `json:"subroutine"`
-	} `json:"files"`
+	Files     []runFileData       `json:"files"`
+	RunFiles  int                 `json:"run_files"`
+	Skipped   []SkippedRun        `json:"skipped"`
+	Conflicts []StructureConflict `json:"conflicts"`
+}
+
+// runFileData holds merged coverage counts for a single source file
+type runFileData struct {
+	Path      string `json:"path"`
+	Statement struct {
+		Lines   map[string]int `json:"lines"`   // line number -> merged hit count, every statement line
+		Counts  map[string]int `json:"counts"`  // line number -> statements on the line
+		Missed  map[string]int `json:"missed"`  // line number -> statements on the line that never ran
+		Covered int            `json:"covered"` // total covered statements
+		Total   int            `json:"total"`   // total statements
+	} `json:"statement"`
+	Branch       metricCounts       `json:"branch"`
+	BranchDetail []BranchHit        `json:"branch_detail"`
+	Condition    metricCounts       `json:"condition"`
+	CondOutcomes outcomeCounts      `json:"condition_outcomes"`
+	CondDetail   []ConditionHit     `json:"condition_detail"`
+	Subroutine   metricCounts       `json:"subroutine"`
+	UncalledSubs []SubInfo          `json:"uncovered_subs"`
+	CalledSubs   []SubInfo          `json:"covered_subs"`
+	Pod          metricCounts       `json:"pod"`
+	Time         map[string]float64 `json:"time"` // line number -> seconds spent
+}
+
+// metricCounts holds covered/total counts for a single metric
+type metricCounts struct {
+	Covered int `json:"covered"`
+	Total   int `json:"total"`
 }
 
 // ParseCoverageDB parses the Devel::Cover database and returns a report
-// If jsonMerge is true, uses pure Go to read JSON files and merge
-func ParseCoverageDB(coverDir string, jsonMerge bool, perlPath string) (*Report, error) {
+// with paths relative to root, the directory the tests ran in (the working
+// directory when empty).
+// If jsonMerge is true, uses pure Go to read JSON files and merge, decoding
+// run files with up to jobs goroutines (all CPUs if jobs <= 0)
+func ParseCoverageDB(coverDir, root string, jsonMerge bool, perlPath string, jobs int) (*Report, error) {
+	return parseCoverageDB(coverDir, root, jsonMerge, perlPath, jobs, false)
+}
+
+// ParseCoverageSummary is ParseCoverageDB for when only the totals are
+// needed: the Go merge keeps covered and total counts without building
+// per-line data, which saves time and memory on large databases. Branch
+// hits are kept, as SonarQube-style totals need them, and so are the
+// uncovered statement lines, for ExcludeUncoverable. The report has
+// SummaryOnly set.
+func ParseCoverageSummary(coverDir, root string, jsonMerge bool, perlPath string, jobs int) (*Report, error) {
+	return parseCoverageDB(coverDir, root, jsonMerge, perlPath, jobs, true)
+}
+
+// parseCoverageDB implements ParseCoverageDB and ParseCoverageSummary
+func parseCoverageDB(coverDir, root string, jsonMerge bool, perlPath string, jobs int, summaryOnly bool) (*Report, error) {
 	// Check if cover_db exists
 	if _, err := os.Stat(coverDir); os.IsNotExist(err) {
 		return nil, fmt.Errorf("coverage directory %s does not exist", coverDir)
@@ -172,8 +339,10 @@ func ParseCoverageDB(coverDir string, jsonMerge bool, perlPath string) (*Report,
 		return nil, fmt.Errorf("no coverage runs found in %s", runsDir)
 	}
 
-	// Detect file format by checking first run's cover file
-	isJSON := detectJSONFormat(runsDir)
+	// Detect the format of each run's cover file. The Go merge only reads
+	// JSON; the Perl merge reads every format, so it handles mixed databases
+	jsonRuns, otherRuns := detectRunFormats(runsDir)
+	isJSON := jsonRuns > 0 && otherRuns == 0
 
 	// If jsonMerge is requested and files aren't JSON, convert them first
 	if jsonMerge && !isJSON {
@@ -188,7 +357,7 @@ func ParseCoverageDB(coverDir string, jsonMerge bool, perlPath string) (*Report,
 
 	if isJSON {
 		// Use pure Go to read JSON files and merge
-		data, err = parseAllRunsJSON(coverDir)
+		data, err = parseAllRunsJSON(coverDir, jobs, summaryOnly)
 	} else {
 		// Use Perl to merge Storable/Sereal files
 		data, err = parseAllRuns(coverDir, perlPath)
@@ -197,45 +366,119 @@ func ParseCoverageDB(coverDir string, jsonMerge bool, perlPath string) (*Report,
 		return nil, err
 	}
 
+	// The merge skips .lock files, but 'cover' for --html doesn't
+	staleLocks, err := FindStaleLocks(coverDir, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for stale locks: %w", err)
+	}
+
 	// Build report from merged data
 	report := &Report{
-		Files: make(map[string]*FileCoverage),
+		Files:       make(map[string]*FileCoverage),
+		RunFiles:    data.RunFiles,
+		Skipped:     data.Skipped,
+		Conflicts:   data.Conflicts,
+		StaleLocks:  staleLocks,
+		SummaryOnly: summaryOnly,
 	}
 
 	for _, f := range data.Files {
+		if summaryOnly {
+			// The Perl merge always writes per-line data; drop it here,
+			// keeping the lines the Go merge keeps
+			for line := range f.Statement.Lines {
+				if f.Statement.Missed[line] == 0 {
+					delete(f.Statement.Lines, line)
+					delete(f.Statement.Counts, line)
+				}
+			}
+			f.Time, f.CondDetail, f.UncalledSubs, f.CalledSubs = nil, nil, nil, nil
+		}
 		fc := &FileCoverage{
 			Path: f.Path,
 			Statements: StatementCoverage{
 				Covered: f.Statement.Covered,
 				Total:   f.Statement.Total,
 				lines:   make(map[int]int),
+				counts:  make(map[int]int),
+				missed:  make(map[int]int),
 			},
 			Branches: BranchCoverage{
 				Covered: f.Branch.Covered,
 				Total:   f.Branch.Total,
+				Detail:  f.BranchDetail,
 			},
 			Conditions: ConditionCoverage{
-				Covered: f.Condition.Covered,
-				Total:   f.Condition.Total,
+				Covered:         f.Condition.Covered,
+				Total:           f.Condition.Total,
+				Outcomes:        f.CondOutcomes.Total,
+				OutcomesCovered: f.CondOutcomes.True + f.CondOutcomes.False,
+				TrueCovered:     f.CondOutcomes.True,
+				FalseCovered:    f.CondOutcomes.False,
+				Detail:          f.CondDetail,
+				Partial:         partialConditions(f.CondDetail),
 			},
 			Subroutines: SubroutineCoverage{
-				Covered: f.Subroutine.Covered,
-				Total:   f.Subroutine.Total,
+				Covered:   f.Subroutine.Covered,
+				Total:     f.Subroutine.Total,
+				Uncovered: f.UncalledSubs,
+				Called:    f.CalledSubs,
 			},
+			Pod: PodCoverage{
+				Covered: f.Pod.Covered,
+				Total:   f.Pod.Total,
+			},
+		}
+
+		// Build time map from line-keyed data
+		for lineStr, secs := range f.Time {
+			var line int
+			if _, err := fmt.Sscanf(lineStr, "%d", &line); err != nil {
+				continue
+			}
+			if fc.TimeData == nil {
+				fc.TimeData = make(map[int]float64)
+			}
+			fc.TimeData[line] += secs
 		}
 
-		// Build uncovered lines map
-		for lineStr := range f.Statement.Lines {
+		// Build per-line hit counts
+		for lineStr, hits := range f.Statement.Lines {
+			var line int
+			if _, err := fmt.Sscanf(lineStr, "%d", &line); err != nil {
+				continue
+			}
+			fc.Statements.lines[line] += hits
+		}
+		for lineStr, n := range f.Statement.Counts {
+			var line int
+			if _, err := fmt.Sscanf(lineStr, "%d", &line); err != nil {
+				continue
+			}
+			fc.Statements.counts[line] += n
+		}
+		for lineStr, n := range f.Statement.Missed {
 			var line int
 			if _, err := fmt.Sscanf(lineStr, "%d", &line); err != nil {
 				continue
 			}
-			fc.Statements.lines[line] = 0
+			fc.Statements.missed[line] += n
 		}
 
+		if !summaryOnly {
+			fc.Branches.Uncovered = uncoveredBranchLines(f.BranchDetail)
+		}
 		report.Files[f.Path] = fc
 	}
 
+	// Devel::Cover records files loaded through an absolute -I (as ours
+	// are) with absolute paths; report them relative to the checkout
+	report.Root = root
+	if root == "" {
+		report.Root, _ = os.Getwd()
+	}
+	report.SetPathStyle(PathRel)
+
 	// Calculate final percentages and summary
 	calculateSummary(report)
 
@@ -308,11 +551,13 @@ print "ok\n";
 	return nil
 }
 
-// detectJSONFormat checks if the coverage files are in JSON format
-func detectJSONFormat(runsDir string) bool {
+// detectRunFormats counts the run directories whose cover file is JSON and
+// those in another format (Storable or Sereal). A database can hold both,
+// e.g. after DEVEL_COVER_DB_FORMAT changed between runs.
+func detectRunFormats(runsDir string) (jsonRuns, otherRuns int) {
 	entries, err := os.ReadDir(runsDir)
 	if err != nil {
-		return false
+		return 0, 0
 	}
 
 	for _, entry := range entries {
@@ -333,28 +578,47 @@ func detectJSONFormat(runsDir string) bool {
 				continue
 			}
 
-			// Read first few bytes to detect format
-			coverPath := filepath.Join(runDir, f.Name())
-			file, err := os.Open(coverPath)
-			if err != nil {
+			isJSON, ok := isJSONFile(filepath.Join(runDir, f.Name()))
+			if !ok {
 				continue
 			}
-			defer file.Close()
-
-			buf := make([]byte, 1)
-			n, err := file.Read(buf)
-			if err != nil || n == 0 {
-				continue
+			if isJSON {
+				jsonRuns++
+			} else {
+				otherRuns++
 			}
-
-			// JSON files start with '{'
-			return buf[0] == '{'
+			break
 		}
 	}
 
-	return false
+	return jsonRuns, otherRuns
+}
+
+// isJSONFile reports whether path holds JSON, which starts with '{'; ok is
+// false if it is empty or unreadable
+func isJSONFile(path string) (isJSON, ok bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, false
+	}
+	defer file.Close()
+
+	buf := make([]byte, 1)
+	n, err := file.Read(buf)
+	if err != nil || n == 0 {
+		return false, false
+	}
+	return buf[0] == '{', true
 }
 
+// ErrUndecodableRuns is returned when run directories exist but none of their
+// data files could be read as JSON or by Sereal::Decoder or Storable
+var ErrUndecodableRuns = errors.New("no coverage run could be decoded")
+
+// undecodableExitCode is the exit status the merge script uses to signal
+// ErrUndecodableRuns
+const undecodableExitCode = 3
+
 // parseAllRuns parses all run directories and merges coverage data
 func parseAllRuns(coverDir string, perlPath string) (*runCoverageData, error) {
 	// Use Perl to parse all runs and merge - this is more accurate than merging in Go
@@ -366,68 +630,109 @@ use JSON::PP;
 local $SIG{__WARN__} = sub {};
 
 my $cover_db = $ARGV[0];
-my %merged;  # file -> { stmt => [], branch => [], cond => [], sub => [] }
+my %merged;  # file -> digest -> { stmt => [], branch => [], cond => [], sub => [], ... }
+
+# Decode a file written with DEVEL_COVER_DB_FORMAT=JSON; undef for other formats
+sub read_json {
+    my ($file) = @_;
+    open my $fh, '<:raw', $file or return;
+    local $/;
+    my $content = <$fh>;
+    close $fh;
+    return unless defined $content && $content =~ /^\{/;
+    return eval { JSON::PP->new->utf8->decode($content) };
+}
 
-# Load structure files to map indices to line numbers
-my %structures;
+# Load structure files to map indices to line numbers, by source file and
+# by the digest of the version they describe
+my (%structures, %struct_by_digest);
 for my $struct_file (glob("$cover_db/structure/*")) {
     next if -d $struct_file || $struct_file =~ /\.lock$/;
-    my $struct;
-    eval { require Storable; $struct = Storable::retrieve($struct_file); };
+    my $struct = read_json($struct_file);
+    eval { require Storable; $struct = Storable::retrieve($struct_file); } unless $struct;
     next unless $struct && ref $struct eq 'HASH' && $struct->{file};
     $structures{$struct->{file}} = $struct;
+    (my $name = $struct_file) =~ s{.*/}{};
+    $struct_by_digest{$struct->{digest} // $name} = $struct;
+}
+
+# A decoded run file must look like { runs => { id => { count => {...} } } }
+sub valid_run_data {
+    my ($data) = @_;
+    return ref $data eq 'HASH' && ref $data->{runs} eq 'HASH';
 }
 
 # Process all run directories
+my ($run_dirs, $decoded) = (0, 0);
+my @skipped;
 for my $run_dir (glob("$cover_db/runs/*")) {
     next unless -d $run_dir;
+    $run_dirs++;
 
-    # Find and load the cover data file
+    # Find and load the cover data file, trying JSON, Sereal, then Storable;
+    # runs written in different formats can share a database
     my $data;
     for my $file (glob("$run_dir/cover.*"), glob("$run_dir/*")) {
         next if -d $file || $file =~ /\.lock$/;
+        $data = read_json($file);
+        last if valid_run_data($data);
+        $data = undef;
         eval {
             if (eval { require Sereal::Decoder; 1 }) {
                 my $decoder = Sereal::Decoder->new;
-                open my $fh, '<:raw', $file or next;
+                open my $fh, '<:raw', $file or die;
                 local $/;
                 my $content = <$fh>;
                 close $fh;
                 $data = $decoder->decode($content);
             }
         };
-        last if $data;
+        last if valid_run_data($data);
+        $data = undef;
         eval {
             require Storable;
             $data = Storable::retrieve($file);
         };
-        last if $data;
+        last if valid_run_data($data);
+        $data = undef;
     }
-    next unless $data && ref $data eq 'HASH';
+    if (!$data) {
+        push @skipped, { path => $run_dir, reason => 'not decodable as JSON, Sereal or Storable' };
+        next;
+    }
+    $decoded++;
 
     # Merge coverage data from this run
-    my $runs = $data->{runs} || {};
+    my $runs = $data->{runs};
     for my $run_id (keys %$runs) {
         my $run = $runs->{$run_id};
-        my $count = $run->{count} || next;
+        next unless ref $run eq 'HASH' && ref $run->{count} eq 'HASH';
+        my $count = $run->{count};
 
         for my $file (keys %$count) {
             my $file_count = $count->{$file};
-
-            # Initialize merged data for this file if needed
-            if (!$merged{$file}) {
-                $merged{$file} = {
-                    stmt => [],
-                    branch => [],
-                    cond => [],
-                    sub => [],
-                };
-            }
+            next unless ref $file_count eq 'HASH';
+
+            # Counts index into the structure of the version the run
+            # instrumented, so each version of a file is merged separately
+            my $digest = ref $run->{digests} eq 'HASH' ? $run->{digests}{$file} // '' : '';
+            my $mf = $merged{$file}{$digest} //= {
+                stmt => [],
+                branch => [],
+                cond => [],
+                sub => [],
+                pod => [],
+                time => [],
+                runs => 0,
+                newest => 0,
+            };
+            $mf->{runs}++;
+            $mf->{newest} = $run->{start} if ($run->{start} // 0) > $mf->{newest};
 
             # Merge statement counts (add hits)
             if (my $stmt = $file_count->{statement}) {
                 for my $i (0 .. $#$stmt) {
-                    $merged{$file}{stmt}[$i] = ($merged{$file}{stmt}[$i] // 0) + ($stmt->[$i] // 0);
+                    $mf->{stmt}[$i] = ($mf->{stmt}[$i] // 0) + ($stmt->[$i] // 0);
                 }
             }
 
@@ -435,9 +740,9 @@ for my $run_dir (glob("$cover_db/runs/*")) {
             if (my $branch = $file_count->{branch}) {
                 for my $i (0 .. $#$branch) {
                     next unless ref $branch->[$i] eq 'ARRAY';
-                    $merged{$file}{branch}[$i] //= [0, 0];
-                    $merged{$file}{branch}[$i][0] += $branch->[$i][0] // 0;
-                    $merged{$file}{branch}[$i][1] += $branch->[$i][1] // 0;
+                    $mf->{branch}[$i] //= [0, 0];
+                    $mf->{branch}[$i][0] += $branch->[$i][0] // 0;
+                    $mf->{branch}[$i][1] += $branch->[$i][1] // 0;
                 }
             }
 
@@ -445,9 +750,9 @@ for my $run_dir (glob("$cover_db/runs/*")) {
             if (my $cond = $file_count->{condition}) {
                 for my $i (0 .. $#$cond) {
                     next unless ref $cond->[$i] eq 'ARRAY';
-                    $merged{$file}{cond}[$i] //= [];
+                    $mf->{cond}[$i] //= [];
                     for my $j (0 .. $#{$cond->[$i]}) {
-                        $merged{$file}{cond}[$i][$j] = ($merged{$file}{cond}[$i][$j] // 0) + ($cond->[$i][$j] // 0);
+                        $mf->{cond}[$i][$j] = ($mf->{cond}[$i][$j] // 0) + ($cond->[$i][$j] // 0);
                     }
                 }
             }
@@ -455,13 +760,97 @@ for my $run_dir (glob("$cover_db/runs/*")) {
             # Merge subroutine counts (add hits)
             if (my $sub = $file_count->{subroutine}) {
                 for my $i (0 .. $#$sub) {
-                    $merged{$file}{sub}[$i] = ($merged{$file}{sub}[$i] // 0) + ($sub->[$i] // 0);
+                    $mf->{sub}[$i] = ($mf->{sub}[$i] // 0) + ($sub->[$i] // 0);
+                }
+            }
+
+            # Merge POD counts (entries may be [covered, ...] or plain counts)
+            if (my $pod = $file_count->{pod}) {
+                for my $i (0 .. $#$pod) {
+                    my $val = ref $pod->[$i] eq 'ARRAY' ? $pod->[$i][0] : $pod->[$i];
+                    $mf->{pod}[$i] = ($mf->{pod}[$i] // 0) + ($val // 0);
+                }
+            }
+
+            # Merge time counts (add seconds per statement)
+            if (my $time = $file_count->{time}) {
+                for my $i (0 .. $#$time) {
+                    $mf->{time}[$i] = ($mf->{time}[$i] // 0) + ($time->[$i] // 0);
                 }
             }
         }
     }
 }
 
+if ($run_dirs && !$decoded) {
+    print STDERR "none of $run_dirs run directories could be decoded as JSON or with Sereal::Decoder or Storable\n";
+    exit 3;
+}
+
+# Keep one version of each file: the one instrumented by the newest run,
+# then by the most runs, and read lines from its structure; mirrors
+# merger.resolve in Go
+my @conflicts;
+for my $file (keys %merged) {
+    my $versions = $merged{$file};
+    my ($keep) = sort {
+        $versions->{$b}{newest} <=> $versions->{$a}{newest}
+            || $versions->{$b}{runs} <=> $versions->{$a}{runs}
+            || $a cmp $b
+    } keys %$versions;
+    if (keys %$versions > 1) {
+        my $dropped = 0;
+        $dropped += $versions->{$_}{runs} for grep { $_ ne $keep } keys %$versions;
+        push @conflicts, { file => $file, digest => $keep, versions => scalar(keys %$versions), dropped => $dropped };
+    }
+    $structures{$file} = $struct_by_digest{$keep} if $struct_by_digest{$keep};
+    $merged{$file} = $versions->{$keep};
+}
+@conflicts = sort { $a->{file} cmp $b->{file} } @conflicts;
+
+# Covered true and false decision outcomes and the total for a condition's
+# hit states; mirrors conditionOutcomes in Go
+sub condition_outcomes {
+    my ($type, @h) = @_;
+    my $or = $type =~ /^or/;
+    if (@h == 2) {
+        return $or ? ($h[0], $h[1], 2) : ($h[1], $h[0], 2);
+    }
+    if (@h == 3) {
+        my $either = ($h[1] || $h[2]) ? 1 : 0;
+        return $or ? ($h[0] + $h[1], $either + $h[2], 4) : ($either + $h[2], $h[0] + $h[1], 4);
+    }
+    if (@h == 4) {
+        return ((($h[0] || $h[1]) ? 1 : 0) + (($h[0] || $h[2]) ? 1 : 0),
+                (($h[2] || $h[3]) ? 1 : 0) + (($h[1] || $h[3]) ? 1 : 0), 4);
+    }
+    my $covered = 0;
+    $covered += $_ for @h;
+    return ($covered, 0, scalar @h);
+}
+
+# Decision outcomes never seen for a condition's hit states; mirrors
+# conditionMissed in Go
+sub condition_missed {
+    my ($type, @h) = @_;
+    my $or = $type =~ /^or/;
+    my @names = ('left true', 'left false', 'right true', 'right false');
+    my @seen;
+    if (@h == 2) {
+        @names = ('true', 'false');
+        @seen = $or ? ($h[0], $h[1]) : ($h[1], $h[0]);
+    } elsif (@h == 3) {
+        @seen = $or ? ($h[0], $h[1] || $h[2], $h[1], $h[2])
+                    : ($h[1] || $h[2], $h[0], $h[2], $h[1]);
+    } elsif (@h == 4) {
+        @seen = ($h[0] || $h[1], $h[2] || $h[3], $h[0] || $h[2], $h[1] || $h[3]);
+    } else {
+        @names = map { "state $_" } 1 .. @h;
+        @seen = @h;
+    }
+    return map { $seen[$_] ? () : $names[$_] } 0 .. $#seen;
+}
+
 # Convert merged data to output format
 my @files;
 for my $file (sort keys %merged) {
@@ -470,10 +859,13 @@ for my $file (sort keys %merged) {
 
     my %file_result = (
         path => $file,
-        statement => { lines => {}, covered => 0, total => 0 },
+        statement => { lines => {}, counts => {}, covered => 0, total => 0 },
         branch => { covered => 0, total => 0 },
         condition => { covered => 0, total => 0 },
+        condition_outcomes => { true => 0, false => 0, total => 0 },
         subroutine => { covered => 0, total => 0 },
+        pod => { covered => 0, total => 0 },
+        time => {},
     );
 
     # Count statement coverage
@@ -481,40 +873,88 @@ for my $file (sort keys %merged) {
     $file_result{statement}{total} = scalar(@{$m->{stmt}});
     for my $i (0 .. $#{$m->{stmt}}) {
         my $line = $stmt_lines->[$i] // ($i + 1);
-        if ($m->{stmt}[$i] && $m->{stmt}[$i] > 0) {
-            $file_result{statement}{covered}++;
-        } else {
-            $file_result{statement}{lines}{$line} = 0;
-        }
+        my $hits = $m->{stmt}[$i] && $m->{stmt}[$i] > 0 ? $m->{stmt}[$i] : 0;
+        $file_result{statement}{lines}{$line} += $hits;
+        $file_result{statement}{counts}{$line}++;
+        $file_result{statement}{missed}{$line}++ unless $hits;
+        $file_result{statement}{covered}++ if $hits;
+    }
+
+    # Sum time per line
+    for my $i (0 .. $#{$m->{time}}) {
+        next unless $m->{time}[$i];
+        my $line = $stmt_lines->[$i] // ($i + 1);
+        $file_result{time}{$line} += $m->{time}[$i];
     }
 
-    # Count branch coverage
-    for my $branch (@{$m->{branch}}) {
+    # Count branch coverage, keeping per-branch hits with their line
+    my $branch_pos = $struct && $struct->{branch} ? $struct->{branch} : [];
+    for my $i (0 .. $#{$m->{branch}}) {
+        my $branch = $m->{branch}[$i];
         next unless ref $branch eq 'ARRAY';
         $file_result{branch}{total} += 2;
         $file_result{branch}{covered}++ if $branch->[0] && $branch->[0] > 0;
         $file_result{branch}{covered}++ if $branch->[1] && $branch->[1] > 0;
+        my $pos = $branch_pos->[$i];
+        push @{$file_result{branch_detail}}, {
+            line  => 0 + (ref $pos eq 'ARRAY' ? $pos->[0] // 0 : 0),
+            true  => 0 + ($branch->[0] // 0),
+            false => 0 + ($branch->[1] // 0),
+        };
     }
 
-    # Count condition coverage
-    for my $cond (@{$m->{cond}}) {
+    # Count condition coverage, as states and as decision outcomes kept
+    # per condition with their line
+    my $cond_info = $struct && $struct->{condition} ? $struct->{condition} : [];
+    for my $i (0 .. $#{$m->{cond}}) {
+        my $cond = $m->{cond}[$i];
         next unless ref $cond eq 'ARRAY';
-        for my $val (@$cond) {
+        my @hit = map { ($_ && $_ > 0) ? 1 : 0 } @$cond;
+        for my $h (@hit) {
             $file_result{condition}{total}++;
-            $file_result{condition}{covered}++ if $val && $val > 0;
+            $file_result{condition}{covered} += $h;
         }
+        my $info = $cond_info->[$i];
+        my $type = ref $info eq 'ARRAY' && ref $info->[1] eq 'HASH' ? $info->[1]{type} // '' : '';
+        my ($true, $false, $total) = condition_outcomes($type, @hit);
+        $file_result{condition_outcomes}{true} += $true;
+        $file_result{condition_outcomes}{false} += $false;
+        $file_result{condition_outcomes}{total} += $total;
+        my @missed = condition_missed($type, @hit);
+        push @{$file_result{condition_detail}}, {
+            line    => 0 + (ref $info eq 'ARRAY' ? $info->[0] // 0 : 0),
+            covered => $true + $false,
+            total   => $total,
+            (@missed ? (missed => \@missed) : ()),
+            states  => [map { 0 + ($_ // 0) } @$cond],
+        };
     }
 
-    # Count subroutine coverage
-    for my $hits (@{$m->{sub}}) {
+    # Count subroutine coverage, naming the subs called and never called
+    my $sub_info = $struct && $struct->{subroutine} ? $struct->{subroutine} : [];
+    for my $i (0 .. $#{$m->{sub}}) {
+        my $hits = $m->{sub}[$i];
+        my $called = $hits && $hits > 0;
         $file_result{subroutine}{total}++;
-        $file_result{subroutine}{covered}++ if $hits && $hits > 0;
+        $file_result{subroutine}{covered}++ if $called;
+        my $info = $sub_info->[$i];
+        next unless ref $info eq 'ARRAY';
+        push @{$file_result{$called ? 'covered_subs' : 'uncovered_subs'}}, {
+            name => '' . ($info->[1] // ''),
+            line => 0 + ($info->[0] // 0),
+        };
+    }
+
+    # Count POD coverage
+    for my $hits (@{$m->{pod}}) {
+        $file_result{pod}{total}++;
+        $file_result{pod}{covered}++ if $hits && $hits > 0;
     }
 
     push @files, \%file_result;
 }
 
-print JSON::PP->new->utf8->encode({ files => \@files });
+print JSON::PP->new->utf8->encode({ files => \@files, run_files => $run_dirs, skipped => \@skipped, conflicts => \@conflicts });
 `
 
 	cmd := exec.Command(perlPath, "-e", script, coverDir)
@@ -523,6 +963,11 @@ print JSON::PP->new->utf8->encode({ files => \@files });
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == undecodableExitCode {
+			return nil, fmt.Errorf("%w in %s (is Sereal::Decoder installed?): %s",
+				ErrUndecodableRuns, coverDir, strings.TrimSpace(stderr.String()))
+		}
 		return nil, fmt.Errorf("failed to parse coverage: %w\nStderr: %s", err, stderr.String())
 	}
 
@@ -538,13 +983,24 @@ print JSON::PP->new->utf8->encode({ files => \@files });
 	return &data, nil
 }
 
+// outcomeCounts holds covered true and false decision outcomes out of Total
+type outcomeCounts struct {
+	True  int `json:"true"`
+	False int `json:"false"`
+	Total int `json:"total"`
+}
+
 // singleRunData represents coverage data from a single run (JSON format)
 type singleRunData struct {
-	File      string         `json:"file"`
-	Statement []int          `json:"statement"` // hit counts per line index
-	Branch    [][2]int       `json:"branch"`    // [true_hits, false_hits] per branch
-	Condition [][]int        `json:"condition"` // hits per condition state
-	Sub       []int          `json:"subroutine"`
+	File      string    `json:"file"`
+	Digest    string    `json:"digest"`    // Devel::Cover digest of the source version instrumented
+	Start     float64   `json:"start"`     // When the run started, in seconds since the epoch
+	Statement []int     `json:"statement"` // hit counts per line index
+	Branch    [][2]int  `json:"branch"`    // [true_hits, false_hits] per branch
+	Condition [][]int   `json:"condition"` // hits per condition state
+	Sub       []int     `json:"subroutine"`
+	Pod       []int     `json:"pod"`
+	Time      []float64 `json:"time"` // seconds per statement index
 }
 
 // jsonRunFile represents the JSON format Devel::Cover writes when DEVEL_COVER_DB_FORMAT=JSON
@@ -555,24 +1011,138 @@ type jsonRunFile struct {
 			Branch     [][]float64 `json:"branch"`    // float64 because Devel::Cover may output e.g. 25.0
 			Condition  [][]float64 `json:"condition"` // float64 for consistency
 			Subroutine []int       `json:"subroutine"`
+			Pod        []podCount  `json:"pod"`
+			Time       []float64   `json:"time"`
 		} `json:"count"`
+		Digests map[string]string `json:"digests"` // Source file -> digest of the version instrumented
+		Start   float64           `json:"start"`
 	} `json:"runs"`
 }
 
+// podCount is a POD coverage entry, which Devel::Cover may store either as a
+// plain count or as an array whose first element is the covered flag
+type podCount int
+
+// UnmarshalJSON accepts both the plain and array forms of a POD entry
+func (p *podCount) UnmarshalJSON(data []byte) error {
+	var n float64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*p = podCount(n)
+		return nil
+	}
+	var arr []interface{}
+	if err := json.Unmarshal(data, &arr); err != nil {
+		return err
+	}
+	*p = 0
+	if len(arr) > 0 {
+		if v, ok := arr[0].(float64); ok {
+			*p = podCount(v)
+		}
+	}
+	return nil
+}
+
 // jsonStructureFile represents the structure JSON format
 type jsonStructureFile struct {
-	File      string `json:"file"`
-	Statement []int  `json:"statement"`
+	File       string        `json:"file"`
+	Digest     string        `json:"digest"`
+	Statement  []int         `json:"statement"`
+	Branch     []structEntry `json:"branch"`
+	Condition  []structEntry `json:"condition"`
+	Subroutine []structEntry `json:"subroutine"`
+}
+
+// statementLine returns the source line of the i-th statement
+func (s *jsonStructureFile) statementLine(i int) int {
+	if s != nil && i < len(s.Statement) {
+		return s.Statement[i]
+	}
+	return i + 1 // Default: 1-indexed
+}
+
+// branchLine returns the source line of the i-th branch, or 0 if unknown
+func (s *jsonStructureFile) branchLine(i int) int {
+	if s != nil && i < len(s.Branch) {
+		return s.Branch[i].Line
+	}
+	return 0
+}
+
+// subroutine returns the name and line of the i-th subroutine, and false
+// if the structure doesn't have it
+func (s *jsonStructureFile) subroutine(i int) (SubInfo, bool) {
+	if s != nil && i < len(s.Subroutine) {
+		return SubInfo{Name: s.Subroutine[i].Name, Line: s.Subroutine[i].Line}, true
+	}
+	return SubInfo{}, false
+}
+
+// conditionLine returns the source line of the i-th condition, or 0 if
+// unknown
+func (s *jsonStructureFile) conditionLine(i int) int {
+	if s != nil && i < len(s.Condition) {
+		return s.Condition[i].Line
+	}
+	return 0
+}
+
+// conditionType returns the Devel::Cover type (e.g. "and_3") of the i-th
+// condition, or "" if unknown
+func (s *jsonStructureFile) conditionType(i int) string {
+	if s != nil && i < len(s.Condition) {
+		return s.Condition[i].Type
+	}
+	return ""
+}
+
+// structEntry is a structure file entry, which Devel::Cover stores as
+// [line, info] where info is a name or a details object
+type structEntry struct {
+	Line int
+	Name string
+	Type string // "type" from a details object, e.g. "or_3" for conditions
+}
+
+// UnmarshalJSON accepts [line, info] arrays as well as bare line numbers
+func (e *structEntry) UnmarshalJSON(data []byte) error {
+	var line float64
+	if err := json.Unmarshal(data, &line); err == nil {
+		e.Line = int(line)
+		return nil
+	}
+	var arr []interface{}
+	if err := json.Unmarshal(data, &arr); err != nil {
+		return err
+	}
+	if len(arr) > 0 {
+		if v, ok := arr[0].(float64); ok {
+			e.Line = int(v)
+		}
+	}
+	if len(arr) > 1 {
+		switch info := arr[1].(type) {
+		case string:
+			e.Name = info
+		case map[string]interface{}:
+			e.Type, _ = info["type"].(string)
+		}
+	}
+	return nil
 }
 
 // parseAllRunsJSON reads JSON coverage files directly (no Perl required)
-// This works when DEVEL_COVER_DB_FORMAT=JSON is set during test runs
-func parseAllRunsJSON(coverDir string) (*runCoverageData, error) {
+// This works when DEVEL_COVER_DB_FORMAT=JSON is set during test runs.
+// Run files are read by up to jobs goroutines (all CPUs if jobs <= 0).
+// With summaryOnly, per-line data is left out (see merger).
+func parseAllRunsJSON(coverDir string, jobs int, summaryOnly bool) (*runCoverageData, error) {
 	runsDir := filepath.Join(coverDir, "runs")
 	structDir := filepath.Join(coverDir, "structure")
 
-	// Load structure files for line number mapping
-	structures := make(map[string][]int)
+	// Load structure files for line number mapping, by source file and by
+	// the digest of the version they describe
+	structures := make(map[string]*jsonStructureFile)
+	versions := make(map[string]*jsonStructureFile)
 	structEntries, err := os.ReadDir(structDir)
 	if err == nil {
 		for _, entry := range structEntries {
@@ -584,12 +1154,16 @@ func parseAllRunsJSON(coverDir string) (*runCoverageData, error) {
 			if err != nil {
 				continue
 			}
-			var structFile jsonStructureFile
-			if err := json.Unmarshal(data, &structFile); err != nil {
+			structFile := &jsonStructureFile{}
+			if err := json.Unmarshal(data, structFile); err != nil {
 				continue
 			}
 			if structFile.File != "" {
-				structures[structFile.File] = structFile.Statement
+				structures[structFile.File] = structFile
+				if structFile.Digest == "" {
+					structFile.Digest = entry.Name()
+				}
+				versions[structFile.Digest] = structFile
 			}
 		}
 	}
@@ -599,223 +1173,398 @@ func parseAllRunsJSON(coverDir string) (*runCoverageData, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read runs directory: %w", err)
 	}
+	var runDirs []string
+	for _, entry := range runEntries {
+		if entry.IsDir() {
+			runDirs = append(runDirs, filepath.Join(runsDir, entry.Name()))
+		}
+	}
+
+	// Reading and decoding dominate, so do them in parallel and merge each
+	// run as it arrives, so only runs in flight are held in memory. Runs
+	// are merged in directory order so float sums (time) are reproducible.
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	type indexedResult struct {
+		index int
+		runDirResult
+	}
+	// A run holds a slot of the window from being read until it is merged,
+	// so a slow run only lets jobs runs after it pile up
+	window := make(chan struct{}, jobs)
+	indexes := make(chan int)
+	results := make(chan indexedResult, jobs)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < len(runDirs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results <- indexedResult{i, readRunDirJSON(runDirs[i])}
+			}
+		}()
+	}
+	go func() {
+		for i := range runDirs {
+			window <- struct{}{}
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+		close(results)
+	}()
+
+	mg := newMerger(structures)
+	mg.versions = versions
+	mg.summaryOnly = summaryOnly
+	var runFiles int
+	var skipped []SkippedRun
+	pending := make(map[int]runDirResult)
+	next := 0
+	for result := range results {
+		pending[result.index] = result.runDirResult
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if r.found {
+				runFiles++
+			}
+			if r.failed != nil {
+				skipped = append(skipped, *r.failed)
+			}
+			for _, run := range r.runs {
+				mg.addRun(run)
+			}
+			<-window
+		}
+	}
 
-	var allRuns [][]singleRunData
+	merged := mg.result()
+	merged.RunFiles = runFiles
+	merged.Skipped = skipped
+	return merged, nil
+}
 
-	for _, entry := range runEntries {
-		if !entry.IsDir() {
+// runDirResult is what readRunDirJSON found in one run directory
+type runDirResult struct {
+	runs   [][]singleRunData
+	found  bool        // The directory has a cover file or couldn't be listed
+	failed *SkippedRun // Set if no cover file could be parsed
+}
+
+// readRunDirJSON reads the JSON cover file of a run directory. The run
+// counts as skipped if it has cover.* files but none parse.
+func readRunDirJSON(runDir string) runDirResult {
+	// Find the cover.* file in this run directory
+	files, err := os.ReadDir(runDir)
+	if err != nil {
+		return runDirResult{found: true, failed: &SkippedRun{Path: runDir, Reason: err.Error()}}
+	}
+
+	var result runDirResult
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), ".lock") {
 			continue
 		}
-		runDir := filepath.Join(runsDir, entry.Name())
+		if !strings.HasPrefix(f.Name(), "cover.") {
+			continue
+		}
+		result.found = true
 
-		// Find the cover.* file in this run directory
-		files, err := os.ReadDir(runDir)
+		coverPath := filepath.Join(runDir, f.Name())
+		data, err := os.ReadFile(coverPath)
 		if err != nil {
+			result.failed = &SkippedRun{Path: coverPath, Reason: err.Error()}
 			continue
 		}
 
-		for _, f := range files {
-			if f.IsDir() || strings.HasSuffix(f.Name(), ".lock") {
-				continue
-			}
-			if !strings.HasPrefix(f.Name(), "cover.") {
-				continue
-			}
-
-			coverPath := filepath.Join(runDir, f.Name())
-			data, err := os.ReadFile(coverPath)
-			if err != nil {
-				continue
-			}
-
-			var runFile jsonRunFile
-			if err := json.Unmarshal(data, &runFile); err != nil {
-				continue
-			}
+		var runFile jsonRunFile
+		if err := json.Unmarshal(data, &runFile); err != nil {
+			result.failed = &SkippedRun{Path: coverPath, Reason: err.Error()}
+			continue
+		}
+		result.failed = nil
+
+		// Extract coverage data from all runs in this file
+		for _, run := range runFile.Runs {
+			var runData []singleRunData
+			for file, counts := range run.Count {
+				rd := singleRunData{
+					File:      file,
+					Digest:    run.Digests[file],
+					Start:     run.Start,
+					Statement: counts.Statement,
+					Sub:       counts.Subroutine,
+					Time:      counts.Time,
+				}
 
-			// Extract coverage data from all runs in this file
-			for _, run := range runFile.Runs {
-				var runData []singleRunData
-				for file, counts := range run.Count {
-					rd := singleRunData{
-						File:      file,
-						Statement: counts.Statement,
-						Sub:       counts.Subroutine,
-					}
+				for _, p := range counts.Pod {
+					rd.Pod = append(rd.Pod, int(p))
+				}
 
-					// Convert branch format (float64 -> int)
-					for _, b := range counts.Branch {
-						if len(b) >= 2 {
-							rd.Branch = append(rd.Branch, [2]int{int(b[0]), int(b[1])})
-						} else {
-							rd.Branch = append(rd.Branch, [2]int{0, 0})
-						}
+				// Convert branch format (float64 -> int)
+				for _, b := range counts.Branch {
+					if len(b) >= 2 {
+						rd.Branch = append(rd.Branch, [2]int{int(b[0]), int(b[1])})
+					} else {
+						rd.Branch = append(rd.Branch, [2]int{0, 0})
 					}
+				}
 
-					// Convert condition format (float64 -> int)
-					for _, c := range counts.Condition {
-						cond := make([]int, len(c))
-						for i, v := range c {
-							cond[i] = int(v)
-						}
-						rd.Condition = append(rd.Condition, cond)
+				// Convert condition format (float64 -> int)
+				for _, c := range counts.Condition {
+					cond := make([]int, len(c))
+					for i, v := range c {
+						cond[i] = int(v)
 					}
-
-					runData = append(runData, rd)
-				}
-				if len(runData) > 0 {
-					allRuns = append(allRuns, runData)
+					rd.Condition = append(rd.Condition, cond)
 				}
+
+				runData = append(runData, rd)
+			}
+			if len(runData) > 0 {
+				result.runs = append(result.runs, runData)
 			}
-			break // Only need one cover file per run
 		}
+		break // Only need one cover file per run
 	}
+	return result
+}
 
-	// Merge all runs in Go
-	return mergeRunsGo(allRuns, structures)
+// mergedFile holds one source file's counts summed over runs
+type mergedFile struct {
+	stmt   []int
+	branch [][2]int
+	cond   [][]int
+	sub    []int
+	pod    []int
+	time   []float64
+	runs   int     // Runs added
+	newest float64 // Latest start of those runs
 }
 
-// mergeRunsGo merges coverage data from multiple runs in Go
-func mergeRunsGo(allRuns [][]singleRunData, structures map[string][]int) (*runCoverageData, error) {
-	// Merged data per file
-	type mergedFile struct {
-		stmt   []int
-		branch [][2]int
-		cond   [][]int
-		sub    []int
-	}
+// mergeKey identifies one version of a source file. Counts index into the
+// structure of the version a run instrumented, so versions are summed
+// apart and only one is kept.
+type mergeKey struct {
+	file   string
+	digest string
+}
 
-	merged := make(map[string]*mergedFile)
+// merger sums runs into per-file counts as they are added, so a run's raw
+// data can be dropped once added instead of holding every run until the
+// merge. Runs may be added in any order.
+type merger struct {
+	structures map[string]*jsonStructureFile
+	versions   map[string]*jsonStructureFile // Structures by digest, if known
+	merged     map[mergeKey]*mergedFile
+
+	// summaryOnly skips per-line data in Result, keeping only the counts
+	// and branch hits
+	summaryOnly bool
+}
 
-	// Merge all runs
-	for _, runs := range allRuns {
-		for _, r := range runs {
-			m, exists := merged[r.File]
-			if !exists {
-				m = &mergedFile{
-					stmt:   make([]int, len(r.Statement)),
-					branch: make([][2]int, len(r.Branch)),
-					cond:   make([][]int, len(r.Condition)),
-					sub:    make([]int, len(r.Sub)),
-				}
-				// Initialize condition slices
-				for i, c := range r.Condition {
-					m.cond[i] = make([]int, len(c))
-				}
-				merged[r.File] = m
-			}
+// newMerger returns an empty merger using structures for line numbers
+func newMerger(structures map[string]*jsonStructureFile) *merger {
+	return &merger{structures: structures, merged: make(map[mergeKey]*mergedFile)}
+}
 
-			// Extend slices if needed
-			for len(m.stmt) < len(r.Statement) {
-				m.stmt = append(m.stmt, 0)
+// addRun adds one run's counts to the totals
+func (mg *merger) addRun(run []singleRunData) {
+	merged := mg.merged
+	for _, r := range run {
+		key := mergeKey{r.File, r.Digest}
+		m, exists := merged[key]
+		if !exists {
+			m = &mergedFile{
+				stmt:   make([]int, len(r.Statement)),
+				branch: make([][2]int, len(r.Branch)),
+				cond:   make([][]int, len(r.Condition)),
+				sub:    make([]int, len(r.Sub)),
+				pod:    make([]int, len(r.Pod)),
 			}
-			for len(m.branch) < len(r.Branch) {
-				m.branch = append(m.branch, [2]int{0, 0})
+			// Initialize condition slices
+			for i, c := range r.Condition {
+				m.cond[i] = make([]int, len(c))
 			}
-			for len(m.sub) < len(r.Sub) {
-				m.sub = append(m.sub, 0)
+			merged[key] = m
+		}
+		m.runs++
+		if r.Start > m.newest {
+			m.newest = r.Start
+		}
+
+		// Extend slices if needed
+		for len(m.stmt) < len(r.Statement) {
+			m.stmt = append(m.stmt, 0)
+		}
+		for len(m.branch) < len(r.Branch) {
+			m.branch = append(m.branch, [2]int{0, 0})
+		}
+		for len(m.sub) < len(r.Sub) {
+			m.sub = append(m.sub, 0)
+		}
+		for len(m.cond) < len(r.Condition) {
+			m.cond = append(m.cond, nil)
+		}
+		for len(m.pod) < len(r.Pod) {
+			m.pod = append(m.pod, 0)
+		}
+		for len(m.time) < len(r.Time) {
+			m.time = append(m.time, 0)
+		}
+
+		// Add statement counts
+		for i, v := range r.Statement {
+			m.stmt[i] += v
+		}
+
+		// Add branch counts
+		for i, b := range r.Branch {
+			m.branch[i][0] += b[0]
+			m.branch[i][1] += b[1]
+		}
+
+		// Add condition counts
+		for i, c := range r.Condition {
+			if m.cond[i] == nil {
+				m.cond[i] = make([]int, len(c))
 			}
-			for len(m.cond) < len(r.Condition) {
-				m.cond = append(m.cond, nil)
+			for len(m.cond[i]) < len(c) {
+				m.cond[i] = append(m.cond[i], 0)
 			}
-
-			// Add statement counts
-			for i, v := range r.Statement {
-				m.stmt[i] += v
+			for j, v := range c {
+				m.cond[i][j] += v
 			}
+		}
 
-			// Add branch counts
-			for i, b := range r.Branch {
-				m.branch[i][0] += b[0]
-				m.branch[i][1] += b[1]
-			}
+		// Add subroutine counts
+		for i, v := range r.Sub {
+			m.sub[i] += v
+		}
 
-			// Add condition counts
-			for i, c := range r.Condition {
-				if m.cond[i] == nil {
-					m.cond[i] = make([]int, len(c))
-				}
-				for len(m.cond[i]) < len(c) {
-					m.cond[i] = append(m.cond[i], 0)
-				}
-				for j, v := range c {
-					m.cond[i][j] += v
-				}
-			}
+		// Add POD counts
+		for i, v := range r.Pod {
+			m.pod[i] += v
+		}
 
-			// Add subroutine counts
-			for i, v := range r.Sub {
-				m.sub[i] += v
-			}
+		// Add time per statement
+		for i, v := range r.Time {
+			m.time[i] += v
 		}
 	}
+}
 
-	// Convert to output format
-	var files []struct {
-		Path      string `json:"path"`
-		Statement struct {
-			Lines   map[string]int `json:"lines"`
-			Covered int            `json:"covered"`
-			Total   int            `json:"total"`
-		} `json:"statement"`
-		Branch struct {
-			Covered int `json:"covered"`
-			Total   int `json:"total"`
-		} `json:"branch"`
-		Condition struct {
-			Covered int `json:"covered"`
-			Total   int `json:"total"`
-		} `json:"condition"`
-		Subroutine struct {
-			Covered int `json:"covered"`
-			Total   int `json:"total"`
-		} `json:"subroutine"`
-	}
-
-	for file, m := range merged {
-		f := struct {
-			Path      string `json:"path"`
-			Statement struct {
-				Lines   map[string]int `json:"lines"`
-				Covered int            `json:"covered"`
-				Total   int            `json:"total"`
-			} `json:"statement"`
-			Branch struct {
-				Covered int `json:"covered"`
-				Total   int `json:"total"`
-			} `json:"branch"`
-			Condition struct {
-				Covered int `json:"covered"`
-				Total   int `json:"total"`
-			} `json:"condition"`
-			Subroutine struct {
-				Covered int `json:"covered"`
-				Total   int `json:"total"`
-			} `json:"subroutine"`
-		}{
-			Path: file,
-		}
-		f.Statement.Lines = make(map[string]int)
-
-		// Get line mappings from structure
-		stmtLines := structures[file]
-
-		// Count statement coverage
+// resolve keeps one version of each file: the one instrumented by the
+// newest run, then by the most runs, then the smallest digest so the
+// choice is reproducible. It reports files seen at more than one version.
+func (mg *merger) resolve() (map[string]mergeKey, []StructureConflict) {
+	kept := make(map[string]mergeKey)
+	versions := make(map[string]int)
+	for key, m := range mg.merged {
+		versions[key.file]++
+		k, ok := kept[key.file]
+		if !ok {
+			kept[key.file] = key
+			continue
+		}
+		o := mg.merged[k]
+		if m.newest > o.newest ||
+			m.newest == o.newest && (m.runs > o.runs || m.runs == o.runs && key.digest < k.digest) {
+			kept[key.file] = key
+		}
+	}
+
+	var conflicts []StructureConflict
+	for file, n := range versions {
+		if n < 2 {
+			continue
+		}
+		c := StructureConflict{File: file, Digest: kept[file].digest, Versions: n}
+		for key, m := range mg.merged {
+			if key.file == file && key != kept[file] {
+				c.Dropped += m.runs
+			}
+		}
+		conflicts = append(conflicts, c)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].File < conflicts[j].File
+	})
+	return kept, conflicts
+}
+
+// result converts the totals to the merged output format
+func (mg *merger) result() *runCoverageData {
+	kept, conflicts := mg.resolve()
+
+	// Convert to output format
+	var files []runFileData
+
+	detail := !mg.summaryOnly
+	for file, key := range kept {
+		m := mg.merged[key]
+		f := runFileData{Path: file}
+
+		// Get line mappings from the structure of the version kept
+		structure := mg.versions[key.digest]
+		if structure == nil {
+			structure = mg.structures[file]
+		}
+
+		// Summary-only reports keep just the lines with a statement that
+		// never ran, so uncoverable markers can still be applied
+		var missedLines map[int]bool
+		if !detail {
+			for i, hits := range m.stmt {
+				if hits == 0 {
+					if missedLines == nil {
+						missedLines = make(map[int]bool)
+					}
+					missedLines[structure.statementLine(i)] = true
+				}
+			}
+		}
+		if detail || missedLines != nil {
+			f.Statement.Lines = make(map[string]int)
+			f.Statement.Counts = make(map[string]int)
+			f.Statement.Missed = make(map[string]int)
+		}
+
+		// Count statement coverage
 		f.Statement.Total = len(m.stmt)
 		for i, hits := range m.stmt {
-			line := i + 1 // Default: 1-indexed
-			if i < len(stmtLines) {
-				line = stmtLines[i]
-			}
 			if hits > 0 {
 				f.Statement.Covered++
-			} else {
-				f.Statement.Lines[fmt.Sprintf("%d", line)] = 0
 			}
+			if line := structure.statementLine(i); detail || missedLines[line] {
+				f.Statement.Lines[fmt.Sprintf("%d", line)] += hits
+				f.Statement.Counts[fmt.Sprintf("%d", line)]++
+				if hits == 0 {
+					f.Statement.Missed[fmt.Sprintf("%d", line)]++
+				}
+			}
+		}
+
+		// Sum time per line
+		for i, secs := range m.time {
+			if secs == 0 || !detail {
+				continue
+			}
+			line := structure.statementLine(i)
+			if f.Time == nil {
+				f.Time = make(map[string]float64)
+			}
+			f.Time[fmt.Sprintf("%d", line)] += secs
 		}
 
-		// Count branch coverage
-		for _, b := range m.branch {
+		// Count branch coverage, keeping per-branch hits with their line
+		for i, b := range m.branch {
 			f.Branch.Total += 2
 			if b[0] > 0 {
 				f.Branch.Covered++
@@ -823,24 +1572,61 @@ func mergeRunsGo(allRuns [][]singleRunData, structures map[string][]int) (*runCo
 			if b[1] > 0 {
 				f.Branch.Covered++
 			}
+			f.BranchDetail = append(f.BranchDetail, BranchHit{
+				Line:  structure.branchLine(i),
+				True:  b[0],
+				False: b[1],
+			})
 		}
 
-		// Count condition coverage
-		for _, c := range m.cond {
+		// Count condition coverage, as states and as decision outcomes kept
+		// per condition with their line
+		for i, c := range m.cond {
 			for _, hits := range c {
 				f.Condition.Total++
 				if hits > 0 {
 					f.Condition.Covered++
 				}
 			}
+			trueHit, falseHit, total := conditionOutcomes(c, structure.conditionType(i))
+			f.CondOutcomes.True += trueHit
+			f.CondOutcomes.False += falseHit
+			f.CondOutcomes.Total += total
+			if !detail {
+				continue
+			}
+			f.CondDetail = append(f.CondDetail, ConditionHit{
+				Line:    structure.conditionLine(i),
+				Covered: trueHit + falseHit,
+				Total:   total,
+				Missed:  conditionMissed(c, structure.conditionType(i)),
+				States:  append([]int(nil), c...),
+			})
 		}
 
-		// Count subroutine coverage
-		for _, hits := range m.sub {
+		// Count subroutine coverage, naming the subs called and never called
+		for i, hits := range m.sub {
 			f.Subroutine.Total++
 			if hits > 0 {
 				f.Subroutine.Covered++
 			}
+			sub, ok := structure.subroutine(i)
+			if !ok || !detail {
+				continue
+			}
+			if hits > 0 {
+				f.CalledSubs = append(f.CalledSubs, sub)
+			} else {
+				f.UncalledSubs = append(f.UncalledSubs, sub)
+			}
+		}
+
+		// Count POD coverage
+		for _, hits := range m.pod {
+			f.Pod.Total++
+			if hits > 0 {
+				f.Pod.Covered++
+			}
 		}
 
 		files = append(files, f)
@@ -851,7 +1637,139 @@ func mergeRunsGo(allRuns [][]singleRunData, structures map[string][]int) (*runCo
 		return files[i].Path < files[j].Path
 	})
 
-	return &runCoverageData{Files: files}, nil
+	return &runCoverageData{Files: files, Conflicts: conflicts}
+}
+
+// conditionOutcomes converts a Devel::Cover condition's per-state hit counts
+// into covered true and false decision outcomes: one of each per boolean
+// operand, which is the unit branches use (true and false per branch) and
+// SonarQube's condition model. kind is the Devel::Cover condition type,
+// e.g. "and_3"; for and/or it decides which states mean true. Devel::Cover
+// records:
+//
+//	and_2: !l, l                  or_2: l, !l         (constant right operand)
+//	and_3: !l, l&&!r, l&&r        or_3: l, !l&&r, !l&&!r
+//	xor_4: l&&r, l&&!r, !l&&r, !l&&!r
+//
+// Unknown kinds are treated as and. Other shapes fall back to one outcome
+// per state, counted as true.
+func conditionOutcomes(states []int, kind string) (trueHit, falseHit, total int) {
+	h := make([]int, len(states))
+	for i, hits := range states {
+		if hits > 0 {
+			h[i] = 1
+		}
+	}
+	either := func(a, b int) int {
+		if a+b > 0 {
+			return 1
+		}
+		return 0
+	}
+	or := strings.HasPrefix(kind, "or")
+
+	switch len(h) {
+	case 2:
+		if or {
+			return h[0], h[1], 2
+		}
+		return h[1], h[0], 2
+	case 3:
+		// The first state decides the left operand alone; the other two
+		// share its opposite value and decide the right operand
+		if or {
+			return h[0] + h[1], either(h[1], h[2]) + h[2], 4
+		}
+		return either(h[1], h[2]) + h[2], h[0] + h[1], 4
+	case 4:
+		return either(h[0], h[1]) + either(h[0], h[2]), either(h[2], h[3]) + either(h[1], h[3]), 4
+	}
+	for _, v := range h {
+		trueHit += v
+	}
+	return trueHit, 0, len(h)
+}
+
+// conditionMissed names the decision outcomes conditionOutcomes counts as
+// uncovered: "true" or "false" for a condition with a constant right
+// operand, "left true" through "right false" for one with two operands, and
+// "state N" (1-based) for shapes conditionOutcomes doesn't know.
+func conditionMissed(states []int, kind string) []string {
+	h := make([]bool, len(states))
+	for i, hits := range states {
+		h[i] = hits > 0
+	}
+	or := strings.HasPrefix(kind, "or")
+
+	// Whether each outcome was seen, in the order they are named
+	var seen []bool
+	names := []string{"left true", "left false", "right true", "right false"}
+	switch len(h) {
+	case 2:
+		names = []string{"true", "false"}
+		if or {
+			seen = []bool{h[0], h[1]}
+		} else {
+			seen = []bool{h[1], h[0]}
+		}
+	case 3:
+		if or {
+			seen = []bool{h[0], h[1] || h[2], h[1], h[2]}
+		} else {
+			seen = []bool{h[1] || h[2], h[0], h[2], h[1]}
+		}
+	case 4:
+		seen = []bool{h[0] || h[1], h[2] || h[3], h[0] || h[2], h[1] || h[3]}
+	default:
+		names = make([]string, len(h))
+		for i := range h {
+			names[i] = fmt.Sprintf("state %d", i+1)
+		}
+		seen = h
+	}
+
+	var missed []string
+	for i, ok := range seen {
+		if !ok {
+			missed = append(missed, names[i])
+		}
+	}
+	return missed
+}
+
+// partialConditions lists the conditions in detail with a missed outcome
+func partialConditions(detail []ConditionHit) []CondInfo {
+	var partial []CondInfo
+	for _, c := range detail {
+		if len(c.Missed) > 0 && !c.Uncoverable {
+			partial = append(partial, CondInfo{Line: c.Line, Missed: c.Missed})
+		}
+	}
+	return partial
+}
+
+// sonarCounts totals the inputs of SonarQube's coverage formula over the
+// report. Branch true/false hits come from BranchCoverage.Detail and
+// condition operands from ConditionCoverage; statements stand in for lines.
+func sonarCounts(report *Report) SonarCounts {
+	var s SonarCounts
+	for _, fc := range report.Files {
+		for _, b := range fc.Branches.Detail {
+			s.Conditions++
+			if b.True > 0 {
+				s.ConditionsTrue++
+			}
+			if b.False > 0 {
+				s.ConditionsFalse++
+			}
+		}
+		s.Conditions += fc.Conditions.Outcomes / 2
+		s.ConditionsTrue += fc.Conditions.TrueCovered
+		s.ConditionsFalse += fc.Conditions.FalseCovered
+		s.LinesCovered += fc.Statements.Covered
+		s.Lines += fc.Statements.Total
+	}
+	return s
 }
 
 // calculateSummary calculates final coverage percentages and summary
@@ -860,12 +1778,17 @@ func calculateSummary(report *Report) {
 	var totalBranch, coveredBranch int
 	var totalCond, coveredCond int
 	var totalSub, coveredSub int
+	var totalPod, coveredPod int
 
 	for _, fc := range report.Files {
-		// Build uncovered lines list from the lines map (for verbose display)
+		// Build uncovered lines list from the lines map (for verbose display):
+		// lines with a statement that never ran, including lines where
+		// others did
 		fc.Statements.Uncovered = nil
-		for line := range fc.Statements.lines {
-			fc.Statements.Uncovered = append(fc.Statements.Uncovered, line)
+		for line, hits := range fc.Statements.lines {
+			if hits == 0 || fc.Statements.missed[line] > 0 {
+				fc.Statements.Uncovered = append(fc.Statements.Uncovered, line)
+			}
 		}
 		sort.Ints(fc.Statements.Uncovered)
 
@@ -882,6 +1805,9 @@ func calculateSummary(report *Report) {
 		if fc.Subroutines.Total > 0 {
 			fc.Subroutines.Percent = float64(fc.Subroutines.Covered) / float64(fc.Subroutines.Total) * 100
 		}
+		if fc.Pod.Total > 0 {
+			fc.Pod.Percent = float64(fc.Pod.Covered) / float64(fc.Pod.Total) * 100
+		}
 
 		// Accumulate totals
 		totalStmt += fc.Statements.Total
@@ -892,12 +1818,10 @@ func calculateSummary(report *Report) {
 		coveredCond += fc.Conditions.Covered
 		totalSub += fc.Subroutines.Total
 		coveredSub += fc.Subroutines.Covered
-
-		report.Summary.TotalFiles++
-		if fc.Statements.Covered > 0 {
-			report.Summary.CoveredFiles++
-		}
+		totalPod += fc.Pod.Total
+		coveredPod += fc.Pod.Covered
 	}
+	countFiles(report)
 
 	// Calculate summary percentages
 	if totalStmt > 0 {
@@ -912,16 +1836,97 @@ func calculateSummary(report *Report) {
 	if totalSub > 0 {
 		report.Summary.Subroutine = float64(coveredSub) / float64(totalSub) * 100
 	}
+	if totalPod > 0 {
+		report.Summary.Pod = float64(coveredPod) / float64(totalPod) * 100
+	}
 
-	// Calculate SonarQube-style combined coverage:
-	// Coverage = (CT + CF + LC) / (2*B + EL)
-	// Where: CT = conditions true, CF = conditions false (we approximate with covered conditions)
-	//        LC = lines covered, B = branches, EL = executable lines
-	// Simplified: (coveredCond + coveredStmt) / (totalCond + totalStmt)
-	combinedTotal := totalCond + totalStmt
-	combinedCovered := coveredCond + coveredStmt
-	if combinedTotal > 0 {
-		report.Summary.Combined = float64(combinedCovered) / float64(combinedTotal) * 100
+	// Calculate SonarQube-style combined coverage from the raw counts
+	report.Summary.Sonar = sonarCounts(report)
+	report.Summary.Combined = report.Summary.Sonar.Combined()
+}
+
+// countFiles sets the summary's file counts. A file is covered when any of
+// its statements ran. Files with no statements at all (e.g. modules of
+// only constants) are excluded from both counts unless CountEmptyFiles is
+// set, in which case they count as covered: nothing in them was missed.
+func countFiles(report *Report) {
+	report.Summary.TotalFiles = 0
+	report.Summary.CoveredFiles = 0
+	for _, fc := range report.Files {
+		if fc.Statements.Total == 0 {
+			if report.CountEmptyFiles {
+				report.Summary.TotalFiles++
+				report.Summary.CoveredFiles++
+			}
+			continue
+		}
+		report.Summary.TotalFiles++
+		if fc.Statements.Covered > 0 {
+			report.Summary.CoveredFiles++
+		}
+	}
+}
+
+// SetCountEmptyFiles sets CountEmptyFiles and updates the file counts
+func (report *Report) SetCountEmptyFiles(count bool) {
+	report.CountEmptyFiles = count
+	countFiles(report)
+}
+
+// SetCriteria records which Devel::Cover criteria were collected and
+// clears every file's metrics outside them: uninstrumented code would
+// otherwise read as never covered. Columns for them are no longer printed.
+func (report *Report) SetCriteria(criteria []string) {
+	report.Criteria = criteria
+	for _, fc := range report.Files {
+		if !report.Collected("statement") {
+			fc.Statements = StatementCoverage{}
+		}
+		if !report.Collected("branch") {
+			fc.Branches = BranchCoverage{}
+		}
+		if !report.Collected("condition") {
+			fc.Conditions = ConditionCoverage{}
+		}
+		if !report.Collected("subroutine") {
+			fc.Subroutines = SubroutineCoverage{}
+		}
+		if !report.Collected("pod") {
+			fc.Pod = PodCoverage{}
+		}
+		if !report.Collected("time") {
+			fc.TimeData = nil
+		}
+	}
+	calculateSummary(report)
+}
+
+// Collected reports whether criterion was collected
+func (report *Report) Collected(criterion string) bool {
+	if report.Criteria == nil {
+		return true
+	}
+	for _, c := range report.Criteria {
+		if c == criterion {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveFiles drops files for which exclude returns true and recalculates
+// the summary so excluded files don't count toward it. It must be called
+// before Normalize.
+func (report *Report) RemoveFiles(exclude func(path string) bool) {
+	removed := false
+	for path := range report.Files {
+		if exclude(path) {
+			delete(report.Files, path)
+			removed = true
+		}
+	}
+	if removed {
+		calculateSummary(report)
 	}
 }
 
@@ -934,13 +1939,14 @@ func (report *Report) Normalize(config *NormalizationConfig) {
 
 	report.Summary.Normalized = true
 
-	// Apply conditions-to-branches: merge condition counts into branch counts
+	// Apply conditions-to-branches: merge condition outcomes into branch counts
 	if config.ConditionsToBranch {
 		report.Summary.ConditionsAbsorbed = true
 		for _, fc := range report.Files {
-			// Add condition counts to branch counts
-			fc.Branches.Total += fc.Conditions.Total
-			fc.Branches.Covered += fc.Conditions.Covered
+			// Add condition outcomes to branch outcomes; Devel::Cover's
+			// per-state condition counts are a different unit
+			fc.Branches.Total += fc.Conditions.Outcomes
+			fc.Branches.Covered += fc.Conditions.OutcomesCovered
 			if fc.Branches.Total > 0 {
 				fc.Branches.Percent = float64(fc.Branches.Covered) / float64(fc.Branches.Total) * 100
 			}
@@ -948,6 +1954,10 @@ func (report *Report) Normalize(config *NormalizationConfig) {
 			fc.Conditions.Total = 0
 			fc.Conditions.Covered = 0
 			fc.Conditions.Percent = 0
+			fc.Conditions.Outcomes = 0
+			fc.Conditions.OutcomesCovered = 0
+			fc.Conditions.Detail = nil
+			fc.Conditions.Partial = nil
 		}
 	}
 
@@ -977,12 +1987,21 @@ func (report *Report) Normalize(config *NormalizationConfig) {
 			fc.Branches.Total = 0
 			fc.Branches.Covered = 0
 			fc.Branches.Percent = 0
+			fc.Branches.Uncovered = nil
+			fc.Branches.Detail = nil
 			fc.Conditions.Total = 0
 			fc.Conditions.Covered = 0
 			fc.Conditions.Percent = 0
+			fc.Conditions.Outcomes = 0
+			fc.Conditions.OutcomesCovered = 0
+			fc.Conditions.Detail = nil
+			fc.Conditions.Partial = nil
 			fc.Subroutines.Total = 0
 			fc.Subroutines.Covered = 0
 			fc.Subroutines.Percent = 0
+			fc.Pod.Total = 0
+			fc.Pod.Covered = 0
+			fc.Pod.Percent = 0
 		}
 	}
 
@@ -996,6 +2015,7 @@ func (report *Report) recalculateSummary() {
 	var totalBranch, coveredBranch int
 	var totalCond, coveredCond int
 	var totalSub, coveredSub int
+	var totalPod, coveredPod int
 
 	for _, fc := range report.Files {
 		totalStmt += fc.Statements.Total
@@ -1006,12 +2026,15 @@ func (report *Report) recalculateSummary() {
 		coveredCond += fc.Conditions.Covered
 		totalSub += fc.Subroutines.Total
 		coveredSub += fc.Subroutines.Covered
+		totalPod += fc.Pod.Total
+		coveredPod += fc.Pod.Covered
 	}
 
 	report.Summary.Statement = 0
 	report.Summary.Branch = 0
 	report.Summary.Condition = 0
 	report.Summary.Subroutine = 0
+	report.Summary.Pod = 0
 	report.Summary.Combined = 0
 
 	if totalStmt > 0 {
@@ -1026,32 +2049,188 @@ func (report *Report) recalculateSummary() {
 	if totalSub > 0 {
 		report.Summary.Subroutine = float64(coveredSub) / float64(totalSub) * 100
 	}
+	if totalPod > 0 {
+		report.Summary.Pod = float64(coveredPod) / float64(totalPod) * 100
+	}
+
+	// Combined coverage is SonarQube's own formula over the raw counts
+	// from calculateSummary, so normalization doesn't change it
+	report.Summary.Combined = report.Summary.Sonar.Combined()
+}
+
+// reportColumn describes a metric column in the text report
+type reportColumn struct {
+	header  string
+	counts  func(f *FileCoverage) (covered, total int)
+	summary float64
+}
 
-	// Recalculate combined
-	combinedTotal := totalCond + totalStmt
-	combinedCovered := coveredCond + coveredStmt
-	if combinedTotal > 0 {
-		report.Summary.Combined = float64(combinedCovered) / float64(combinedTotal) * 100
+// reportColumns returns the metric columns to show based on normalization
+// and which metrics were collected
+func reportColumns(report *Report) []reportColumn {
+	var cols []reportColumn
+	if report.Collected("statement") {
+		cols = append(cols, reportColumn{"Stmt", func(f *FileCoverage) (int, int) { return f.Statements.Covered, f.Statements.Total }, report.Summary.Statement})
 	}
+	if report.Collected("branch") {
+		cols = append(cols, reportColumn{"Branch", func(f *FileCoverage) (int, int) { return f.Branches.Covered, f.Branches.Total }, report.Summary.Branch})
+	}
+	if !report.Summary.ConditionsAbsorbed && report.Collected("condition") {
+		cols = append(cols, reportColumn{"Cond", func(f *FileCoverage) (int, int) { return f.Conditions.Covered, f.Conditions.Total }, report.Summary.Condition})
+	}
+	if !report.Summary.SubroutinesAbsorbed && report.Collected("subroutine") {
+		cols = append(cols, reportColumn{"Sub", func(f *FileCoverage) (int, int) { return f.Subroutines.Covered, f.Subroutines.Total }, report.Summary.Subroutine})
+	}
+	// POD is only collected with --pod, so hide the column when there's no data
+	if report.hasPod() {
+		cols = append(cols, reportColumn{"Pod", func(f *FileCoverage) (int, int) { return f.Pod.Covered, f.Pod.Total }, report.Summary.Pod})
+	}
+	return cols
 }
 
-// PrintReport prints the coverage report to stdout
-func PrintReport(report *Report, verbose bool) {
-	// Sort files by path
+// tableHeader returns the header row of a coverage table: label padded to
+// labelWidth, then each column's header right-aligned over its cells
+func tableHeader(label string, labelWidth int, cols []reportColumn) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-*s", labelWidth, label)
+	for _, c := range cols {
+		fmt.Fprintf(&b, " %10s", c.header)
+	}
+	return b.String()
+}
+
+// hasPod reports whether any file has POD coverage data
+func (report *Report) hasPod() bool {
+	for _, fc := range report.Files {
+		if fc.Pod.Total > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintOptions controls how PrintReport renders the table
+type PrintOptions struct {
+	Verbose    bool          // Show uncovered lines and branches per file
+	Color      bool          // Color percentages by Thresholds (for terminals)
+	Thresholds Thresholds    // Cutoffs for coloring
+	Sort       string        // File order, one of the Sort* constants (default SortPath)
+	Top        int           // Show only the first Top files after sorting (0 shows all)
+	FullPaths  bool          // Don't truncate paths; the path column fits the longest
+	MaxWidth   int           // Truncate paths longer than this (0: DefaultPathWidth, or none with FullPaths)
+	Percent    PercentFormat // How percentages are shown (zero value: DefaultPercentFormat)
+}
+
+// DefaultPathWidth is the longest path the text report shows in full
+// unless PrintOptions.FullPaths or MaxWidth is set
+const DefaultPathWidth = 58
+
+// pathLimit returns the length paths are truncated to, or 0 for none
+func (opts PrintOptions) pathLimit() int {
+	switch {
+	case opts.MaxWidth > 0:
+		return opts.MaxWidth
+	case opts.FullPaths:
+		return 0
+	}
+	return DefaultPathWidth
+}
+
+// labelColumnWidth returns the width of the table's first column: its
+// longest label plus a two-space gap before the metric columns
+func labelColumnWidth(labels []string) int {
+	width := 0
+	for _, label := range labels {
+		width = max(width, len(label))
+	}
+	return width + 2
+}
+
+// Report file orderings for PrintOptions.Sort
+const (
+	SortPath      = "path"      // Alphabetical
+	SortStatement = "statement" // Lowest statement coverage first
+	SortBranch    = "branch"    // Lowest branch coverage first
+	SortUncovered = "uncovered" // Most uncovered statements first
+)
+
+// ValidSorts lists the accepted values for PrintOptions.Sort
+var ValidSorts = []string{SortPath, SortStatement, SortBranch, SortUncovered}
+
+// sortedPaths returns the report's file paths in the given order. Files
+// without data for the sorted metric go last; ties are broken by path.
+func sortedPaths(report *Report, sortBy string) []string {
 	var paths []string
 	for path := range report.Files {
 		paths = append(paths, path)
 	}
 	sort.Strings(paths)
 
-	// Determine which columns to show based on normalization
-	showCond := !report.Summary.ConditionsAbsorbed
-	showSub := !report.Summary.SubroutinesAbsorbed
+	// key returns the value to sort ascending by
+	var key func(*FileCoverage) float64
+	switch sortBy {
+	case SortStatement:
+		key = func(f *FileCoverage) float64 { return sortPercent(f.Statements.Covered, f.Statements.Total) }
+	case SortBranch:
+		key = func(f *FileCoverage) float64 { return sortPercent(f.Branches.Covered, f.Branches.Total) }
+	case SortUncovered:
+		key = func(f *FileCoverage) float64 { return -float64(f.Statements.Total - f.Statements.Covered) }
+	default:
+		return paths
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		return key(report.Files[paths[i]]) < key(report.Files[paths[j]])
+	})
+	return paths
+}
+
+// sortPercent returns the coverage percentage, or a value above 100 when
+// there is nothing to cover so such files sort after all others
+func sortPercent(covered, total int) float64 {
+	if total == 0 {
+		return 101
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+// PrintReport prints the coverage report to stdout
+func PrintReport(report *Report, opts PrintOptions) {
+	TextFormatter{Options: opts}.Write(report, os.Stdout)
+}
+
+// TextFormatter writes the coverage table, one row per file and a total
+type TextFormatter struct {
+	Options PrintOptions
+}
+
+// Write writes the coverage table to out and returns the first write error
+func (t TextFormatter) Write(report *Report, out io.Writer) error {
+	w := &errWriter{w: out}
+	opts := t.Options
+	verbose := opts.Verbose
+	paths := sortedPaths(report, opts.Sort)
+	if report.SummaryOnly {
+		paths = nil // Only the totals were asked for
+	}
+	hidden := 0
+	if opts.Top > 0 && len(paths) > opts.Top {
+		hidden = len(paths) - opts.Top
+		paths = paths[:opts.Top]
+	}
+
+	labels := make([]string, len(paths))
+	for i, path := range paths {
+		labels[i] = truncatePath(path, opts.pathLimit())
+	}
+	labelWidth := labelColumnWidth(append([]string{"File", "Total"}, labels...))
+
+	cols := reportColumns(report)
 	showCombined := report.Summary.Normalized && report.Summary.Combined > 0
 
 	// Print normalization note if active
 	if report.Summary.Normalized {
-		fmt.Print("\n[normalized: ")
+		fmt.Fprint(w, "\n[normalized: ")
 		var notes []string
 		if report.Summary.ConditionsAbsorbed {
 			notes = append(notes, "conditions→branches")
@@ -1059,114 +2238,436 @@ func PrintReport(report *Report, verbose bool) {
 		if report.Summary.SubroutinesAbsorbed {
 			notes = append(notes, "subroutines→statements")
 		}
-		fmt.Print(strings.Join(notes, ", "))
-		fmt.Println("]")
-	}
-
-	// Build header based on active columns
-	if showCond && showSub {
-		fmt.Printf("\n%-60s %10s %10s %10s %10s\n",
-			"File", "Stmt", "Branch", "Cond", "Sub")
-		fmt.Println(strings.Repeat("-", 104))
-	} else if showCond {
-		fmt.Printf("\n%-60s %10s %10s %10s\n",
-			"File", "Stmt", "Branch", "Cond")
-		fmt.Println(strings.Repeat("-", 94))
-	} else if showSub {
-		fmt.Printf("\n%-60s %10s %10s %10s\n",
-			"File", "Stmt", "Branch", "Sub")
-		fmt.Println(strings.Repeat("-", 94))
-	} else {
-		// Minimal: just Stmt and Branch
-		fmt.Printf("\n%-60s %10s %10s\n",
-			"File", "Stmt", "Branch")
-		fmt.Println(strings.Repeat("-", 84))
+		fmt.Fprint(w, strings.Join(notes, ", "))
+		fmt.Fprintln(w, "]")
 	}
 
+	// Print header for the active columns, ruled to its printed width
+	header := tableHeader("File", labelWidth, cols)
+	rule := strings.Repeat("-", len(header))
+	fmt.Fprintf(w, "\n%s\n", header)
+	fmt.Fprintln(w, rule)
+
 	// Print each file
-	for _, path := range paths {
+	for i, path := range paths {
 		f := report.Files[path]
-		displayPath := path
-		if len(displayPath) > 58 {
-			displayPath = "..." + displayPath[len(displayPath)-55:]
-		}
-
-		stmtStr := formatCoverage(f.Statements.Covered, f.Statements.Total)
-		branchStr := formatCoverage(f.Branches.Covered, f.Branches.Total)
-		condStr := formatCoverage(f.Conditions.Covered, f.Conditions.Total)
-		subStr := formatCoverage(f.Subroutines.Covered, f.Subroutines.Total)
-
-		if showCond && showSub {
-			fmt.Printf("%-60s %10s %10s %10s %10s\n",
-				displayPath, stmtStr, branchStr, condStr, subStr)
-		} else if showCond {
-			fmt.Printf("%-60s %10s %10s %10s\n",
-				displayPath, stmtStr, branchStr, condStr)
-		} else if showSub {
-			fmt.Printf("%-60s %10s %10s %10s\n",
-				displayPath, stmtStr, branchStr, subStr)
-		} else {
-			fmt.Printf("%-60s %10s %10s\n",
-				displayPath, stmtStr, branchStr)
+		fmt.Fprintf(w, "%-*s", labelWidth, labels[i])
+		for _, c := range cols {
+			covered, total := c.counts(f)
+			cell := fmt.Sprintf(" %10s", formatCoverage(covered, total, opts.Percent))
+			if opts.Color && total > 0 {
+				cell = opts.Thresholds.colorize(cell, float64(covered)/float64(total)*100)
+			}
+			fmt.Fprint(w, cell)
 		}
+		fmt.Fprintln(w)
 
-		// Show uncovered lines in verbose mode
+		// Show uncovered lines and partially taken branches in verbose mode
 		if verbose && len(f.Statements.Uncovered) > 0 {
-			fmt.Printf("    Uncovered lines: %v\n", f.Statements.Uncovered)
+			fmt.Fprintf(w, "    Uncovered lines: %s\n", formatLineRanges(f.Statements.Uncovered))
+		}
+		if verbose && len(f.Branches.Uncovered) > 0 {
+			fmt.Fprintf(w, "    Uncovered branch lines: %s\n", formatLineRanges(f.Branches.Uncovered))
+		}
+		if verbose && len(f.Subroutines.Uncovered) > 0 {
+			fmt.Fprintf(w, "    Uncovered subs: %s\n", formatSubs(f.Subroutines.Uncovered))
+		}
+		if verbose {
+			for _, note := range branchNotes(f.Branches.Detail) {
+				fmt.Fprintf(w, "    %s\n", note)
+			}
+			for _, note := range conditionNotes(f.Conditions.Partial) {
+				fmt.Fprintf(w, "    %s\n", note)
+			}
 		}
 	}
 
+	if hidden > 0 {
+		fmt.Fprintf(w, "... %d more file(s) not shown (totals include all files)\n", hidden)
+	}
+
 	// Print summary
-	if showCond && showSub {
-		fmt.Println(strings.Repeat("-", 104))
-		fmt.Printf("%-60s %9.1f%% %9.1f%% %9.1f%% %9.1f%%\n",
-			"Total",
-			report.Summary.Statement,
-			report.Summary.Branch,
-			report.Summary.Condition,
-			report.Summary.Subroutine)
-	} else if showCond {
-		fmt.Println(strings.Repeat("-", 94))
-		fmt.Printf("%-60s %9.1f%% %9.1f%% %9.1f%%\n",
-			"Total",
-			report.Summary.Statement,
-			report.Summary.Branch,
-			report.Summary.Condition)
-	} else if showSub {
-		fmt.Println(strings.Repeat("-", 94))
-		fmt.Printf("%-60s %9.1f%% %9.1f%% %9.1f%%\n",
-			"Total",
-			report.Summary.Statement,
-			report.Summary.Branch,
-			report.Summary.Subroutine)
-	} else {
-		fmt.Println(strings.Repeat("-", 84))
-		fmt.Printf("%-60s %9.1f%% %9.1f%%\n",
-			"Total",
-			report.Summary.Statement,
-			report.Summary.Branch)
+	fmt.Fprintln(w, rule)
+	fmt.Fprintf(w, "%-*s", labelWidth, "Total")
+	for _, c := range cols {
+		cell := fmt.Sprintf(" %10s", opts.Percent.Percent(c.summary))
+		if opts.Color {
+			cell = opts.Thresholds.colorize(cell, c.summary)
+		}
+		fmt.Fprint(w, cell)
 	}
+	fmt.Fprintln(w)
 
 	// Show combined coverage for SonarQube mode
 	if showCombined {
-		fmt.Printf("\nCombined coverage (SonarQube-style): %.1f%%\n", report.Summary.Combined)
+		fmt.Fprintf(w, "\nCombined coverage (SonarQube-style): %s\n", opts.Percent.Percent(report.Summary.Combined))
+	}
+	return w.err
+}
+
+// formatSubs joins subroutines as "BUILD (line 12), _private (line 88)"
+func formatSubs(subs []SubInfo) string {
+	parts := make([]string, len(subs))
+	for i, s := range subs {
+		parts[i] = s.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatLineRanges joins line numbers as "1-5, 40-41, 99", collapsing runs
+// of consecutive lines. The input needn't be sorted; repeats are dropped.
+func formatLineRanges(lines []int) string {
+	var parts []string
+	for _, r := range lineRanges(lines) {
+		if r.start == r.end {
+			parts = append(parts, strconv.Itoa(r.start))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", r.start, r.end))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// lineRange is a run of consecutive lines, start to end inclusive
+type lineRange struct {
+	start, end int
+}
+
+// lineRanges collapses line numbers into runs of consecutive lines, in
+// order. The input needn't be sorted; repeats are dropped.
+func lineRanges(lines []int) []lineRange {
+	sorted := append([]int(nil), lines...)
+	sort.Ints(sorted)
+
+	var ranges []lineRange
+	for i := 0; i < len(sorted); {
+		r := lineRange{sorted[i], sorted[i]}
+		for i++; i < len(sorted) && sorted[i] <= r.end+1; i++ {
+			r.end = sorted[i]
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// TotalTime returns the total time spent in the file across all lines
+func (fc *FileCoverage) TotalTime() float64 {
+	var total float64
+	for _, secs := range fc.TimeData {
+		total += secs
+	}
+	return total
+}
+
+// PrintSlowestFiles prints the n files with the most time spent, as
+// recorded by Devel::Cover's time criterion, with each file's hottest line.
+// Paths are shown as opts' FullPaths and MaxWidth say, like the report's.
+func PrintSlowestFiles(report *Report, n int, opts PrintOptions) {
+	writeSlowestFiles(report, n, opts, os.Stdout)
+}
+
+// writeSlowestFiles writes PrintSlowestFiles' table to w
+func writeSlowestFiles(report *Report, n int, opts PrintOptions, w io.Writer) {
+	var files []*FileCoverage
+	for _, fc := range report.Files {
+		if len(fc.TimeData) > 0 {
+			files = append(files, fc)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(w, "\nNo time data collected")
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		ti, tj := files[i].TotalTime(), files[j].TotalTime()
+		if ti != tj {
+			return ti > tj
+		}
+		return files[i].Path < files[j].Path
+	})
+	if n > 0 && len(files) > n {
+		files = files[:n]
+	}
+
+	labels := make([]string, len(files))
+	for i, fc := range files {
+		labels[i] = truncatePath(fc.Path, opts.pathLimit())
+	}
+	labelWidth := labelColumnWidth(append([]string{"File"}, labels...))
+
+	fmt.Fprintf(w, "\n--- Slowest Files ---\n")
+	header := tableHeader("File", labelWidth, []reportColumn{{header: "Time"}, {header: "Hot line"}})
+	fmt.Fprintln(w, header)
+	fmt.Fprintln(w, strings.Repeat("-", len(header)))
+	for i, fc := range files {
+		hotLine, hotTime := 0, -1.0
+		for line, secs := range fc.TimeData {
+			if secs > hotTime || (secs == hotTime && line < hotLine) {
+				hotLine, hotTime = line, secs
+			}
+		}
+
+		fmt.Fprintf(w, "%-*s %9.4fs %10d\n", labelWidth, labels[i], fc.TotalTime(), hotLine)
+	}
+}
+
+// uncoveredBranchLines returns the sorted, de-duplicated lines of branches
+// that were not taken both ways. Branches without a known line are skipped.
+func uncoveredBranchLines(detail []BranchHit) []int {
+	seen := make(map[int]bool)
+	var lines []int
+	for _, b := range detail {
+		if b.Line == 0 || (b.True > 0 && b.False > 0) || b.Uncoverable || seen[b.Line] {
+			continue
+		}
+		seen[b.Line] = true
+		lines = append(lines, b.Line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// branchNotes describes branches that were not taken both ways,
+// e.g. "line 42: false branch never taken"
+func branchNotes(detail []BranchHit) []string {
+	var notes []string
+	for _, b := range detail {
+		var missing string
+		switch {
+		case b.Uncoverable:
+			continue
+		case b.True == 0 && b.False == 0:
+			missing = "branch never reached"
+		case b.True == 0:
+			missing = "true branch never taken"
+		case b.False == 0:
+			missing = "false branch never taken"
+		default:
+			continue
+		}
+		line := "?"
+		if b.Line > 0 {
+			line = fmt.Sprintf("%d", b.Line)
+		}
+		notes = append(notes, fmt.Sprintf("line %s: %s", line, missing))
+	}
+	return notes
+}
+
+// conditionNotes describes conditions with an outcome never seen,
+// e.g. "line 30: condition never evaluated false" or "line 31: left operand
+// never evaluated true, right operand never evaluated false"
+func conditionNotes(partial []CondInfo) []string {
+	var notes []string
+	for _, c := range partial {
+		phrases := make([]string, len(c.Missed))
+		for i, m := range c.Missed {
+			switch operand, outcome, _ := strings.Cut(m, " "); operand {
+			case "true", "false":
+				phrases[i] = "condition never evaluated " + m
+			case "left", "right":
+				phrases[i] = operand + " operand never evaluated " + outcome
+			default:
+				phrases[i] = m + " never reached"
+			}
+		}
+		line := "?"
+		if c.Line > 0 {
+			line = fmt.Sprintf("%d", c.Line)
+		}
+		notes = append(notes, fmt.Sprintf("line %s: %s", line, strings.Join(phrases, ", ")))
 	}
+	return notes
 }
 
-func formatCoverage(covered, total int) string {
+func formatCoverage(covered, total int, f PercentFormat) string {
 	if total == 0 {
 		return "n/a"
 	}
-	pct := float64(covered) / float64(total) * 100
-	return fmt.Sprintf("%.1f%%", pct)
+	return f.Percent(float64(covered) / float64(total) * 100)
 }
 
 // MergeCoverageDBs merges multiple isolated coverage directories into a single output directory
 // Each isolated directory is expected to have the standard Devel::Cover structure:
 // - runs/: subdirectories containing coverage data from each test run
 // - structure/: source file structure information
-// After merging, the isolated directories are cleaned up
-func MergeCoverageDBs(isolatedDirs []string, outputDir string) error {
+// outputDir may already hold runs (e.g. with --accumulate); new runs are
+// numbered after them and existing structure files are kept.
+// Directories are copied by up to jobs goroutines (all CPUs if jobs <= 0),
+// and onProgress, if set, is called with the number of directories done
+// about every 10% and for the last one. Calls are serialized.
+// After merging, the isolated directories are cleaned up, unless a copy
+// failed. If only the cleanup fails, the merge is complete and the error
+// wraps ErrMergeCleanup.
+func MergeCoverageDBs(isolatedDirs []string, outputDir string, jobs int, onProgress func(done, total int)) error {
+	return mergeCoverageDBs(isolatedDirs, outputDir, true, jobs, onProgress)
+}
+
+// ValidateCoverageDB checks that dir looks like a Devel::Cover database
+func ValidateCoverageDB(dir string) error {
+	info, err := os.Stat(filepath.Join(dir, "runs"))
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a coverage database (no runs/ directory)", dir)
+	}
+	return nil
+}
+
+// EmptyCoverageDBs returns those of dirs whose database recorded nothing:
+// no run in it counted a file. Runs stored as JSON are decoded here; those
+// in Devel::Cover's binary formats are decoded by a single perl, with
+// Sereal::Decoder or Storable as the merge does.
+func EmptyCoverageDBs(dirs []string, perlPath string) ([]string, error) {
+	hasData := make(map[string]bool)
+	binary := make(map[string][]string) // database -> its binary run files
+	var files []string
+	for _, dir := range dirs {
+		found, runFiles := runsHaveData(filepath.Join(dir, "runs"))
+		hasData[dir] = found
+		binary[dir] = runFiles
+		files = append(files, runFiles...)
+	}
+	if len(files) > 0 {
+		counted, err := binaryRunsWithCounts(files, perlPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			for _, f := range binary[dir] {
+				hasData[dir] = hasData[dir] || counted[f]
+			}
+		}
+	}
+
+	var empty []string
+	for _, dir := range dirs {
+		if !hasData[dir] {
+			empty = append(empty, dir)
+		}
+	}
+	return empty, nil
+}
+
+// runsHaveData reports whether a run under runsDir stored as JSON counted
+// a file, and otherwise lists the run files in a binary format, which
+// decoding takes Perl for
+func runsHaveData(runsDir string) (bool, []string) {
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		return false, nil
+	}
+	var binary []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		runDir := filepath.Join(runsDir, entry.Name())
+		files, err := os.ReadDir(runDir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasPrefix(f.Name(), "cover.") || strings.HasSuffix(f.Name(), ".lock") {
+				continue
+			}
+			if isJSON, ok := isJSONFile(filepath.Join(runDir, f.Name())); ok && !isJSON {
+				binary = append(binary, filepath.Join(runDir, f.Name()))
+			}
+		}
+		for _, run := range readRunDirJSON(runDir).runs {
+			if len(run) > 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, binary
+}
+
+// binaryRunsWithCounts decodes Devel::Cover run files in Sereal or
+// Storable format with perl and returns those holding counts for at least
+// one file
+func binaryRunsWithCounts(files []string, perlPath string) (map[string]bool, error) {
+	script := `
+use strict;
+use warnings;
+
+my $sereal = eval { require Sereal::Decoder; Sereal::Decoder->new };
+while (my $file = <STDIN>) {
+    chomp $file;
+    my $data;
+    if ($sereal) {
+        $data = eval {
+            open my $fh, '<:raw', $file or die;
+            local $/;
+            $sereal->decode(<$fh>);
+        };
+    }
+    $data = eval { require Storable; Storable::retrieve($file) } unless ref $data eq 'HASH';
+    next unless ref $data eq 'HASH' && ref $data->{runs} eq 'HASH';
+    for my $run (values %{ $data->{runs} }) {
+        if (ref $run->{count} eq 'HASH' && %{ $run->{count} }) {
+            print "$file\n";
+            last;
+        }
+    }
+}
+`
+	cmd := exec.Command(perlPath, "-e", script)
+	cmd.Stdin = strings.NewReader(strings.Join(files, "\n") + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode run files: %w\nStderr: %s", err, stderr.String())
+	}
+	counted := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			counted[line] = true
+		}
+	}
+	return counted, nil
+}
+
+// ImportCoverageDBs merges externally produced coverage databases into
+// outputDir, alongside any runs already there. The source databases are
+// left untouched.
+func ImportCoverageDBs(dirs []string, outputDir string) error {
+	for _, dir := range dirs {
+		if err := ValidateCoverageDB(dir); err != nil {
+			return err
+		}
+	}
+	return mergeCoverageDBs(dirs, outputDir, false, 0, nil)
+}
+
+// ErrMergeCleanup is returned by MergeCoverageDBs when every directory was
+// merged but some could not be removed afterwards
+var ErrMergeCleanup = errors.New("failed to remove merged coverage directories")
+
+// mergeCoverageDBs copies runs and structure files from each directory into
+// outputDir, numbering runs after any already present, and optionally
+// removes the source directories.
+//
+// Nothing is added up here: each run is copied as is, and counts are summed
+// when the merged database is parsed, by Devel::Cover or the Go merger, just
+// as if the runs had been recorded in one database. So that this gives the
+// same result however many jobs copy, the merge runs in three steps:
+//
+//  1. Plan, serially and in directory order: list each directory's runs
+//     and give them the next run numbers, and pick the first directory
+//     holding each structure file not already in outputDir.
+//  2. Copy each directory's planned runs and structure files concurrently.
+//     No two copies share a destination, so they need no locking.
+//  3. With removeSources, remove the source directories, but only once
+//     every copy succeeded: a directory's structure files may have been
+//     left to an earlier directory's copy, so a failed copy leaves all of
+//     them in place.
+//
+// Run numbers therefore match a serial merge, and structure files, which
+// are named by source digest and so identical wherever they come from, are
+// each written once.
+func mergeCoverageDBs(isolatedDirs []string, outputDir string, removeSources bool, jobs int, onProgress func(done, total int)) error {
 	// Filter to only directories that exist and have content
 	var validDirs []string
 	for _, dir := range isolatedDirs {
@@ -1179,9 +2680,6 @@ func MergeCoverageDBs(isolatedDirs []string, outputDir string) error {
 		return fmt.Errorf("no valid coverage directories to merge")
 	}
 
-	total := len(validDirs)
-	showProgress := total > 50 // Only show progress for large merges
-
 	// Create output directory structure
 	outputRunsDir := filepath.Join(outputDir, "runs")
 	outputStructDir := filepath.Join(outputDir, "structure")
@@ -1193,75 +2691,162 @@ func MergeCoverageDBs(isolatedDirs []string, outputDir string) error {
 		return fmt.Errorf("failed to create output structure directory: %w", err)
 	}
 
-	// Track which structure files we've already copied (by filename to avoid duplicates)
-	copiedStructures := make(map[string]bool)
+	plans := planMerge(validDirs, outputRunsDir, outputStructDir)
 
-	// Global run counter to avoid conflicts when merging
-	runCounter := 1
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	total := len(plans)
+	step := total / 10
+	if step < 1 {
+		step = 1
+	}
+	indexes := make(chan int)
+	errs := make([]error, total)
+	var mu sync.Mutex
+	done := 0
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < total; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = plans[i].copy()
+				mu.Lock()
+				done++
+				if onProgress != nil && (done%step == 0 || done == total) {
+					onProgress(done, total)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range plans {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-	// Process each isolated directory
-	for idx, isolatedDir := range validDirs {
-		// Show progress for large merges
-		if showProgress && (idx%100 == 0 || idx == total-1) {
-			fmt.Printf("\rMerging coverage: %d/%d directories...   ", idx+1, total)
+	// Report the first failure in directory order, as a serial merge would
+	for _, err := range errs {
+		if err != nil {
+			return err
 		}
+	}
+
+	if !removeSources {
+		return nil
+	}
+	dirs := make(chan int)
+	removeErrs := make([]error, total)
+	for w := 0; w < jobs && w < total; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range dirs {
+				removeErrs[i] = os.RemoveAll(plans[i].dir)
+			}
+		}()
+	}
+	for i := range plans {
+		dirs <- i
+	}
+	close(dirs)
+	wg.Wait()
+
+	// The merged database is complete, so the caller decides how much a
+	// leftover directory matters
+	if err := errors.Join(removeErrs...); err != nil {
+		return fmt.Errorf("%w: %w", ErrMergeCleanup, err)
+	}
+	return nil
+}
 
-		// Merge runs
-		runsDir := filepath.Join(isolatedDir, "runs")
+// mergeCopy is what mergeCoverageDBs copies from one source directory:
+// run directories to their numbered destinations, and structure files
+type mergeCopy struct {
+	dir        string
+	runs       [][2]string // Source and destination run directories
+	structures [][2]string // Source and destination structure files
+}
+
+// planMerge lists the runs and structure files each directory contributes,
+// numbering runs after those in outputRunsDir in directory order. Structure
+// files already in outputStructDir or planned from an earlier directory
+// are left out.
+func planMerge(dirs []string, outputRunsDir, outputStructDir string) []mergeCopy {
+	runCounter := nextRunNumber(outputRunsDir)
+	planned := make(map[string]bool)
+	plans := make([]mergeCopy, len(dirs))
+	for i, dir := range dirs {
+		plans[i].dir = dir
+
+		runsDir := filepath.Join(dir, "runs")
 		if entries, err := os.ReadDir(runsDir); err == nil {
 			for _, entry := range entries {
 				if !entry.IsDir() {
 					continue
 				}
-
-				srcRunDir := filepath.Join(runsDir, entry.Name())
-				dstRunDir := filepath.Join(outputRunsDir, fmt.Sprintf("%d", runCounter))
+				dst := filepath.Join(outputRunsDir, strconv.Itoa(runCounter))
 				runCounter++
-
-				// Copy the run directory
-				if err := copyDir(srcRunDir, dstRunDir); err != nil {
-					return fmt.Errorf("failed to copy run directory %s: %w", srcRunDir, err)
-				}
+				plans[i].runs = append(plans[i].runs, [2]string{filepath.Join(runsDir, entry.Name()), dst})
 			}
 		}
 
-		// Merge structure files
-		structDir := filepath.Join(isolatedDir, "structure")
+		structDir := filepath.Join(dir, "structure")
 		if entries, err := os.ReadDir(structDir); err == nil {
 			for _, entry := range entries {
-				if entry.IsDir() || strings.HasSuffix(entry.Name(), ".lock") {
+				name := entry.Name()
+				if entry.IsDir() || strings.HasSuffix(name, ".lock") || planned[name] {
 					continue
 				}
+				planned[name] = true
 
-				// Skip if we've already copied this structure file
-				if copiedStructures[entry.Name()] {
+				// Structure files are named by source digest, so one already
+				// in a populated database describes the same source
+				dst := filepath.Join(outputStructDir, name)
+				if _, err := os.Stat(dst); err == nil {
 					continue
 				}
-
-				srcPath := filepath.Join(structDir, entry.Name())
-				dstPath := filepath.Join(outputStructDir, entry.Name())
-
-				if err := copyFile(srcPath, dstPath); err != nil {
-					return fmt.Errorf("failed to copy structure file %s: %w", srcPath, err)
-				}
-				copiedStructures[entry.Name()] = true
+				plans[i].structures = append(plans[i].structures, [2]string{filepath.Join(structDir, name), dst})
 			}
 		}
+	}
+	return plans
+}
 
-		// Clean up the isolated directory
-		if err := os.RemoveAll(isolatedDir); err != nil {
-			// Log but don't fail on cleanup errors
-			fmt.Fprintf(os.Stderr, "Warning: failed to clean up %s: %v\n", isolatedDir, err)
+// copy performs the planned copies, stopping at the first failure
+func (m mergeCopy) copy() error {
+	for _, run := range m.runs {
+		if err := copyDir(run[0], run[1]); err != nil {
+			return fmt.Errorf("failed to copy run directory %s: %w", run[0], err)
 		}
 	}
-
-	if showProgress {
-		fmt.Println() // Newline after progress
+	for _, st := range m.structures {
+		if err := copyFile(st[0], st[1]); err != nil {
+			return fmt.Errorf("failed to copy structure file %s: %w", st[0], err)
+		}
 	}
-
 	return nil
 }
 
+// nextRunNumber returns the first free run number after the numbered run
+// directories already in runsDir
+func nextRunNumber(runsDir string) int {
+	next := 1
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		return next
+	}
+	for _, entry := range entries {
+		var n int
+		if _, err := fmt.Sscanf(entry.Name(), "%d", &n); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return next
+}
+
 // copyDir copies a directory recursively
 func copyDir(src, dst string) error {
 	srcInfo, err := os.Stat(src)
@@ -1319,13 +2904,40 @@ func copyFile(src, dst string) error {
 	return err
 }
 
-// GenerateHTML generates an HTML report using the cover command
+// FindCoverCommand locates Devel::Cover's cover script, preferring the one
+// installed next to perlPath (or the perl it links to) so it matches the
+// perl the tests ran under, then cover on PATH
+func FindCoverCommand(perlPath string) (string, error) {
+	perl, err := exec.LookPath(perlPath)
+	if err == nil {
+		dirs := []string{filepath.Dir(perl)}
+		if resolved, err := filepath.EvalSymlinks(perl); err == nil {
+			dirs = append(dirs, filepath.Dir(resolved))
+		}
+		for _, dir := range dirs {
+			sibling := filepath.Join(dir, "cover")
+			if info, err := os.Stat(sibling); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				return sibling, nil
+			}
+		}
+	}
+	if cover, err := exec.LookPath("cover"); err == nil {
+		return cover, nil
+	}
+	if perl == "" {
+		perl = perlPath
+	}
+	return "", fmt.Errorf("the 'cover' command from Devel::Cover was not found next to %s or on PATH", perl)
+}
+
+// GenerateHTML generates an HTML report using the cover command found by
+// FindCoverCommand
 // Note: This is slow because it uses the cover command to merge and render
-func GenerateHTML(coverDir, _ string) error {
+func GenerateHTML(coverCmd, coverDir string) error {
 	fmt.Println("Merging coverage data for HTML report (this may take a while)...")
 
 	// Use the cover command to generate HTML - it will merge runs automatically
-	cmd := exec.Command("cover", "-report", "html", coverDir)
+	cmd := exec.Command(coverCmd, "-report", "html", coverDir)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -1,6 +1,21 @@
 package coverage
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestParseNormalizationModes(t *testing.T) {
 	tests := []struct {
@@ -107,9 +122,11 @@ func TestNormalize_ConditionsToBranches(t *testing.T) {
 					Percent: 50.0,
 				},
 				Conditions: ConditionCoverage{
-					Covered: 3,
-					Total:   6,
-					Percent: 50.0,
+					Covered:         3,
+					Total:           6,
+					Percent:         50.0,
+					Outcomes:        8, // e.g. two and/or conditions
+					OutcomesCovered: 4,
 				},
 				Subroutines: SubroutineCoverage{
 					Covered: 2,
@@ -130,11 +147,11 @@ func TestNormalize_ConditionsToBranches(t *testing.T) {
 	fc := report.Files["lib/Foo.pm"]
 
 	// Branches should now include conditions
-	if fc.Branches.Total != 16 { // 10 + 6
-		t.Errorf("Branches.Total = %d, want 16", fc.Branches.Total)
+	if fc.Branches.Total != 18 { // 10 + 8 condition outcomes
+		t.Errorf("Branches.Total = %d, want 18", fc.Branches.Total)
 	}
-	if fc.Branches.Covered != 8 { // 5 + 3
-		t.Errorf("Branches.Covered = %d, want 8", fc.Branches.Covered)
+	if fc.Branches.Covered != 9 { // 5 + 4
+		t.Errorf("Branches.Covered = %d, want 9", fc.Branches.Covered)
 	}
 
 	// Conditions should be zeroed
@@ -168,9 +185,11 @@ func TestNormalize_SubroutinesToStatements(t *testing.T) {
 					Percent: 50.0,
 				},
 				Conditions: ConditionCoverage{
-					Covered: 3,
-					Total:   6,
-					Percent: 50.0,
+					Covered:         3,
+					Total:           6,
+					Percent:         50.0,
+					Outcomes:        8, // e.g. two and/or conditions
+					OutcomesCovered: 4,
 				},
 				Subroutines: SubroutineCoverage{
 					Covered: 4,
@@ -229,9 +248,11 @@ func TestNormalize_SimpleMode(t *testing.T) {
 					Percent: 50.0,
 				},
 				Conditions: ConditionCoverage{
-					Covered: 3,
-					Total:   6,
-					Percent: 50.0,
+					Covered:         3,
+					Total:           6,
+					Percent:         50.0,
+					Outcomes:        8, // e.g. two and/or conditions
+					OutcomesCovered: 4,
 				},
 				Subroutines: SubroutineCoverage{
 					Covered: 2,
@@ -285,9 +306,11 @@ func TestNormalize_SonarQubeStyle(t *testing.T) {
 					Percent: 50.0,
 				},
 				Conditions: ConditionCoverage{
-					Covered: 3,
-					Total:   6,
-					Percent: 50.0,
+					Covered:         3,
+					Total:           6,
+					Percent:         50.0,
+					Outcomes:        8, // e.g. two and/or conditions
+					OutcomesCovered: 4,
 				},
 				Subroutines: SubroutineCoverage{
 					Covered: 2,
@@ -309,8 +332,8 @@ func TestNormalize_SonarQubeStyle(t *testing.T) {
 	fc := report.Files["lib/Qux.pm"]
 
 	// Conditions should be absorbed into branches
-	if fc.Branches.Total != 16 { // 10 + 6
-		t.Errorf("Branches.Total = %d, want 16", fc.Branches.Total)
+	if fc.Branches.Total != 18 { // 10 + 8 condition outcomes
+		t.Errorf("Branches.Total = %d, want 18", fc.Branches.Total)
 	}
 	if fc.Conditions.Total != 0 {
 		t.Errorf("Conditions.Total = %d, want 0", fc.Conditions.Total)
@@ -388,7 +411,7 @@ func TestFormatCoverage(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := formatCoverage(tt.covered, tt.total)
+			got := formatCoverage(tt.covered, tt.total, PercentFormat{})
 			if got != tt.want {
 				t.Errorf("formatCoverage(%d, %d) = %q, want %q", tt.covered, tt.total, got, tt.want)
 			}
@@ -474,6 +497,33 @@ func TestCalculateSummary(t *testing.T) {
 	}
 }
 
+func TestCalculateSummary_EmptyFiles(t *testing.T) {
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/A.pm":         {Statements: StatementCoverage{Covered: 3, Total: 4}},
+		"lib/B.pm":         {Statements: StatementCoverage{Covered: 0, Total: 2}},
+		"lib/Constants.pm": {Statements: StatementCoverage{}},
+	}}
+
+	calculateSummary(report)
+	if report.Summary.TotalFiles != 2 || report.Summary.CoveredFiles != 1 {
+		t.Errorf("files = %d/%d, want 1/2 with the empty file excluded",
+			report.Summary.CoveredFiles, report.Summary.TotalFiles)
+	}
+
+	report.SetCountEmptyFiles(true)
+	if report.Summary.TotalFiles != 3 || report.Summary.CoveredFiles != 2 {
+		t.Errorf("files = %d/%d, want 2/3 with the empty file counted as covered",
+			report.Summary.CoveredFiles, report.Summary.TotalFiles)
+	}
+
+	// The setting survives a later recalculation
+	report.RemoveFiles(func(path string) bool { return path == "lib/B.pm" })
+	if report.Summary.TotalFiles != 2 || report.Summary.CoveredFiles != 2 {
+		t.Errorf("files = %d/%d after RemoveFiles, want 2/2",
+			report.Summary.CoveredFiles, report.Summary.TotalFiles)
+	}
+}
+
 func TestNormalize_CombinedModes(t *testing.T) {
 	report := &Report{
 		Files: map[string]*FileCoverage{
@@ -488,8 +538,10 @@ func TestNormalize_CombinedModes(t *testing.T) {
 					Total:   10,
 				},
 				Conditions: ConditionCoverage{
-					Covered: 3,
-					Total:   6,
+					Covered:         3,
+					Total:           6,
+					Outcomes:        8, // e.g. two and/or conditions
+					OutcomesCovered: 4,
 				},
 				Subroutines: SubroutineCoverage{
 					Covered: 2,
@@ -513,8 +565,8 @@ func TestNormalize_CombinedModes(t *testing.T) {
 	fc := report.Files["lib/Test.pm"]
 
 	// Both conditions and subroutines should be absorbed
-	if fc.Branches.Total != 16 { // 10 + 6
-		t.Errorf("Branches.Total = %d, want 16", fc.Branches.Total)
+	if fc.Branches.Total != 18 { // 10 + 8 condition outcomes
+		t.Errorf("Branches.Total = %d, want 18", fc.Branches.Total)
 	}
 	if fc.Statements.Total != 24 { // 20 + 4
 		t.Errorf("Statements.Total = %d, want 24", fc.Statements.Total)
@@ -534,3 +586,1610 @@ func TestNormalize_CombinedModes(t *testing.T) {
 		t.Error("SubroutinesAbsorbed = false, want true")
 	}
 }
+
+func TestRemoveFiles(t *testing.T) {
+	report := &Report{
+		Files: map[string]*FileCoverage{
+			"lib/Keep.pm": {
+				Path:       "lib/Keep.pm",
+				Statements: StatementCoverage{Covered: 8, Total: 10},
+			},
+			"lib/Gen/Skip.pm": {
+				Path:       "lib/Gen/Skip.pm",
+				Statements: StatementCoverage{Covered: 0, Total: 90},
+			},
+		},
+	}
+	calculateSummary(report)
+
+	report.RemoveFiles(func(path string) bool {
+		return strings.HasPrefix(path, "lib/Gen/")
+	})
+
+	if _, ok := report.Files["lib/Gen/Skip.pm"]; ok {
+		t.Error("lib/Gen/Skip.pm should have been removed")
+	}
+	if report.Summary.TotalFiles != 1 {
+		t.Errorf("Summary.TotalFiles = %d, want 1", report.Summary.TotalFiles)
+	}
+	if report.Summary.Statement != 80.0 {
+		t.Errorf("Summary.Statement = %f, want 80.0", report.Summary.Statement)
+	}
+}
+
+func TestMerger_Pod(t *testing.T) {
+	runs := [][]singleRunData{
+		{{File: "lib/Doc.pm", Statement: []int{1}, Pod: []int{1, 0, 0}}},
+		{{File: "lib/Doc.pm", Statement: []int{0}, Pod: []int{0, 1, 0}}},
+	}
+
+	data := mergeRuns(runs, nil)
+	if len(data.Files) != 1 {
+		t.Fatalf("len(Files) = %d, want 1", len(data.Files))
+	}
+	pod := data.Files[0].Pod
+	if pod.Total != 3 || pod.Covered != 2 {
+		t.Errorf("Pod = %d/%d, want 2/3", pod.Covered, pod.Total)
+	}
+}
+
+func TestPodCountUnmarshal(t *testing.T) {
+	var counts []podCount
+	if err := json.Unmarshal([]byte(`[1, [0, "reason"], [1], 0]`), &counts); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	want := []podCount{1, 0, 1, 0}
+	for i, c := range counts {
+		if c != want[i] {
+			t.Errorf("counts[%d] = %d, want %d", i, c, want[i])
+		}
+	}
+}
+
+func TestMerger_Time(t *testing.T) {
+	runs := [][]singleRunData{
+		{{File: "lib/Slow.pm", Statement: []int{1, 1}, Time: []float64{0.5, 0.25}}},
+		{{File: "lib/Slow.pm", Statement: []int{1, 1}, Time: []float64{0.5, 0}}},
+	}
+	structures := map[string]*jsonStructureFile{"lib/Slow.pm": {Statement: []int{10, 20}}}
+
+	data := mergeRuns(runs, structures)
+	got := data.Files[0].Time
+	if got["10"] != 1.0 || got["20"] != 0.25 {
+		t.Errorf("Time = %v, want map[10:1 20:0.25]", got)
+	}
+
+	fc := &FileCoverage{TimeData: map[int]float64{10: 1.0, 20: 0.25}}
+	if fc.TotalTime() != 1.25 {
+		t.Errorf("TotalTime() = %f, want 1.25", fc.TotalTime())
+	}
+}
+
+func TestMerger_UncoveredSubs(t *testing.T) {
+	runs := [][]singleRunData{
+		{{File: "lib/Subs.pm", Sub: []int{1, 0, 0}}},
+		{{File: "lib/Subs.pm", Sub: []int{0, 0, 2}}},
+	}
+	var structure jsonStructureFile
+	if err := json.Unmarshal([]byte(`{"subroutine": [[3, "new"], [12, "BUILD"], [88, "_private"]]}`), &structure); err != nil {
+		t.Fatal(err)
+	}
+
+	data := mergeRuns(runs, map[string]*jsonStructureFile{"lib/Subs.pm": &structure})
+	f := data.Files[0]
+	if f.Subroutine.Covered != 2 || f.Subroutine.Total != 3 {
+		t.Errorf("Subroutine = %d/%d, want 2/3", f.Subroutine.Covered, f.Subroutine.Total)
+	}
+	want := []SubInfo{{Name: "BUILD", Line: 12}}
+	if !reflect.DeepEqual(f.UncalledSubs, want) {
+		t.Errorf("UncalledSubs = %v, want %v", f.UncalledSubs, want)
+	}
+	want = []SubInfo{{Name: "new", Line: 3}, {Name: "_private", Line: 88}}
+	if !reflect.DeepEqual(f.CalledSubs, want) {
+		t.Errorf("CalledSubs = %v, want %v", f.CalledSubs, want)
+	}
+	if got := formatSubs([]SubInfo{{"BUILD", 12}, {"_private", 88}}); got != "BUILD (line 12), _private (line 88)" {
+		t.Errorf("formatSubs() = %q", got)
+	}
+}
+
+func TestFormatLineRanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []int
+		want  string
+	}{
+		{"empty", nil, ""},
+		{"single", []int{7}, "7"},
+		{"separate", []int{3, 9}, "3, 9"},
+		{"adjacent pair", []int{40, 41}, "40-41"},
+		{"mixed", []int{1, 2, 3, 4, 5, 40, 41, 99}, "1-5, 40-41, 99"},
+		{"unsorted with repeats", []int{12, 10, 11, 11, 20}, "10-12, 20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatLineRanges(tt.lines); got != tt.want {
+				t.Errorf("formatLineRanges(%v) = %q, want %q", tt.lines, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerger_ConditionDetail(t *testing.T) {
+	runs := [][]singleRunData{
+		{{File: "lib/Cond.pm", Condition: [][]int{{1, 0, 0}, {0, 2}}}},
+	}
+	var structure jsonStructureFile
+	if err := json.Unmarshal([]byte(`{"condition": [[7, {"type": "and_3"}], [9, {"type": "or_2"}]]}`), &structure); err != nil {
+		t.Fatal(err)
+	}
+
+	data := mergeRuns(runs, map[string]*jsonStructureFile{"lib/Cond.pm": &structure})
+	// and_3 with only !l seen covers the left operand's false outcome;
+	// or_2 with only !l seen covers its false outcome
+	want := []ConditionHit{
+		{Line: 7, Covered: 1, Total: 4, Missed: []string{"left true", "right true", "right false"}, States: []int{1, 0, 0}},
+		{Line: 9, Covered: 1, Total: 2, Missed: []string{"true"}, States: []int{0, 2}},
+	}
+	if got := data.Files[0].CondDetail; !reflect.DeepEqual(got, want) {
+		t.Errorf("CondDetail = %v, want %v", got, want)
+	}
+	wantPartial := []CondInfo{{Line: 7, Missed: want[0].Missed}, {Line: 9, Missed: want[1].Missed}}
+	if got := partialConditions(data.Files[0].CondDetail); !reflect.DeepEqual(got, wantPartial) {
+		t.Errorf("partialConditions() = %v, want %v", got, wantPartial)
+	}
+}
+
+func TestParseAllRuns_ConditionMissed(t *testing.T) {
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("perl not available")
+	}
+
+	// The Perl merge must name the same missed outcomes as conditionMissed
+	states := [][]int{{1, 0, 0}, {0, 2}, {1, 1, 1}, {1, 0, 0, 1}, {0, 1, 0, 1, 0}}
+	coverDir := t.TempDir()
+	runDir := filepath.Join(coverDir, "runs", "1")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := `use Storable; store({runs => {1 => {count => {"lib/A.pm" => {statement => [1], condition => ` +
+		`[[1, 0, 0], [0, 2], [1, 1, 1], [1, 0, 0, 1], [0, 1, 0, 1, 0]]}}}}}, $ARGV[0])`
+	if out, err := exec.Command("perl", "-e", store, filepath.Join(runDir, "cover.14")).CombinedOutput(); err != nil {
+		t.Fatalf("failed to write Storable run: %v\n%s", err, out)
+	}
+
+	data, err := parseAllRuns(coverDir, "perl")
+	if err != nil {
+		t.Fatalf("parseAllRuns() error: %v", err)
+	}
+	detail := data.Files[0].CondDetail
+	if len(detail) != len(states) {
+		t.Fatalf("got %d conditions, want %d", len(detail), len(states))
+	}
+	for i, s := range states {
+		if got, want := detail[i].Missed, conditionMissed(s, ""); !reflect.DeepEqual(got, want) {
+			t.Errorf("condition %v: Perl missed %q, Go %q", s, got, want)
+		}
+		if !reflect.DeepEqual(detail[i].States, s) {
+			t.Errorf("condition %v: Perl states %v", s, detail[i].States)
+		}
+	}
+}
+
+func TestParseAllRuns_StructureConflict(t *testing.T) {
+	// lib/A.pm was edited between runs: its older version's counts index
+	// into a different structure, so only the newest version's are kept
+	coverDir := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(coverDir, "structure", "aaa"), `{"file":"lib/A.pm","digest":"aaa","statement":[10,11]}`)
+	write(filepath.Join(coverDir, "structure", "bbb"), `{"file":"lib/A.pm","digest":"bbb","statement":[20,21,22]}`)
+	write(filepath.Join(coverDir, "structure", "ccc"), `{"file":"lib/B.pm","digest":"ccc","statement":[5]}`)
+	runs := []string{
+		`{"runs":{"1":{"start":100,"digests":{"lib/A.pm":"aaa","lib/B.pm":"ccc"},"count":{"lib/A.pm":{"statement":[1,1]},"lib/B.pm":{"statement":[1]}}}}}`,
+		`{"runs":{"2":{"start":200,"digests":{"lib/A.pm":"bbb"},"count":{"lib/A.pm":{"statement":[1,0,0]}}}}}`,
+		`{"runs":{"3":{"start":150,"digests":{"lib/A.pm":"aaa"},"count":{"lib/A.pm":{"statement":[1,0]}}}}}`,
+	}
+	for i, run := range runs {
+		write(filepath.Join(coverDir, "runs", strconv.Itoa(i), "cover.14"), run)
+	}
+
+	check := func(t *testing.T, data *runCoverageData) {
+		t.Helper()
+		wantConflicts := []StructureConflict{{File: "lib/A.pm", Digest: "bbb", Versions: 2, Dropped: 2}}
+		if !reflect.DeepEqual(data.Conflicts, wantConflicts) {
+			t.Errorf("Conflicts = %+v, want %+v", data.Conflicts, wantConflicts)
+		}
+		if len(data.Files) != 2 {
+			t.Fatalf("got %d files, want 2", len(data.Files))
+		}
+		want := map[string]int{"20": 1, "21": 0, "22": 0}
+		if got := data.Files[0].Statement.Lines; !reflect.DeepEqual(got, want) {
+			t.Errorf("lib/A.pm Lines = %v, want %v", got, want)
+		}
+		if got := data.Files[1].Statement.Lines; !reflect.DeepEqual(got, map[string]int{"5": 1}) {
+			t.Errorf("lib/B.pm Lines = %v, want map[5:1]", got)
+		}
+	}
+
+	t.Run("go", func(t *testing.T) {
+		data, err := parseAllRunsJSON(coverDir, 0, false)
+		if err != nil {
+			t.Fatalf("parseAllRunsJSON() error: %v", err)
+		}
+		check(t, data)
+	})
+	t.Run("perl", func(t *testing.T) {
+		if _, err := exec.LookPath("perl"); err != nil {
+			t.Skip("perl not available")
+		}
+		data, err := parseAllRuns(coverDir, "perl")
+		if err != nil {
+			t.Fatalf("parseAllRuns() error: %v", err)
+		}
+		check(t, data)
+	})
+}
+
+func TestMerger_LineHits(t *testing.T) {
+	runs := [][]singleRunData{
+		{{File: "lib/Hits.pm", Statement: []int{1, 0, 2, 0}}},
+		{{File: "lib/Hits.pm", Statement: []int{3, 0, 0, 0}}},
+	}
+	// Statements 2 and 3 share line 11
+	structures := map[string]*jsonStructureFile{"lib/Hits.pm": {Statement: []int{10, 11, 11, 12}}}
+
+	data := mergeRuns(runs, structures)
+	got := data.Files[0].Statement
+	if len(got.Lines) != 3 || got.Lines["10"] != 4 || got.Lines["11"] != 2 || got.Lines["12"] != 0 {
+		t.Errorf("Lines = %v, want map[10:4 11:2 12:0]", got.Lines)
+	}
+	if got.Covered != 2 || got.Total != 4 {
+		t.Errorf("Statement = %d/%d, want 2/4", got.Covered, got.Total)
+	}
+	if len(got.Missed) != 2 || got.Missed["11"] != 1 || got.Missed["12"] != 1 {
+		t.Errorf("Missed = %v, want map[11:1 12:1]", got.Missed)
+	}
+
+	// Line 11 ran only partly, so it is uncovered too
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/Hits.pm": {Statements: StatementCoverage{Covered: 2, Total: 4,
+			lines: map[int]int{10: 4, 11: 2, 12: 0}, missed: map[int]int{11: 1, 12: 1}}},
+	}}
+	calculateSummary(report)
+	if uncovered := report.Files["lib/Hits.pm"].Statements.Uncovered; fmt.Sprint(uncovered) != "[11 12]" {
+		t.Errorf("Uncovered = %v, want [11 12]", uncovered)
+	}
+}
+
+func TestParseAllRuns_LineHits(t *testing.T) {
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("perl not available")
+	}
+
+	coverDir := t.TempDir()
+	store := `use Storable; store({runs => {1 => {count => {"lib/A.pm" => {statement => [$ARGV[1], 0, 1]}}}}}, $ARGV[0])`
+	for i, hits := range []string{"2", "5"} {
+		runDir := filepath.Join(coverDir, "runs", strconv.Itoa(i))
+		if err := os.MkdirAll(runDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command("perl", "-e", store, filepath.Join(runDir, "cover.14"), hits).CombinedOutput(); err != nil {
+			t.Fatalf("failed to write Storable run: %v\n%s", err, out)
+		}
+	}
+
+	data, err := parseAllRuns(coverDir, "perl")
+	if err != nil {
+		t.Fatalf("parseAllRuns() error: %v", err)
+	}
+	// Without a structure file, statement i is reported on line i+1
+	got := data.Files[0].Statement.Lines
+	if len(got) != 3 || got["1"] != 7 || got["2"] != 0 || got["3"] != 2 {
+		t.Errorf("Lines = %v, want map[1:7 2:0 3:2]", got)
+	}
+	if missed := data.Files[0].Statement.Missed; len(missed) != 1 || missed["2"] != 1 {
+		t.Errorf("Missed = %v, want map[2:1]", missed)
+	}
+}
+
+// mergeRuns adds runs to a new merger in order and returns the result
+func mergeRuns(runs [][]singleRunData, structures map[string]*jsonStructureFile) *runCoverageData {
+	m := newMerger(structures)
+	for _, run := range runs {
+		m.addRun(run)
+	}
+	return m.result()
+}
+
+// sumRuns is the batch merge: it sums every run's counts for each file
+// into a single run, growing the arrays to the longest run seen
+func sumRuns(runs [][]singleRunData) []singleRunData {
+	var files []string
+	sums := make(map[string]*singleRunData)
+	for _, run := range runs {
+		for _, r := range run {
+			sum, ok := sums[r.File]
+			if !ok {
+				sum = &singleRunData{File: r.File}
+				sums[r.File] = sum
+				files = append(files, r.File)
+			}
+			for i, v := range r.Statement {
+				for len(sum.Statement) <= i {
+					sum.Statement = append(sum.Statement, 0)
+				}
+				sum.Statement[i] += v
+			}
+			for i, b := range r.Branch {
+				for len(sum.Branch) <= i {
+					sum.Branch = append(sum.Branch, [2]int{})
+				}
+				sum.Branch[i][0] += b[0]
+				sum.Branch[i][1] += b[1]
+			}
+			for i, c := range r.Condition {
+				for len(sum.Condition) <= i {
+					sum.Condition = append(sum.Condition, nil)
+				}
+				for j, v := range c {
+					for len(sum.Condition[i]) <= j {
+						sum.Condition[i] = append(sum.Condition[i], 0)
+					}
+					sum.Condition[i][j] += v
+				}
+			}
+			for i, v := range r.Sub {
+				for len(sum.Sub) <= i {
+					sum.Sub = append(sum.Sub, 0)
+				}
+				sum.Sub[i] += v
+			}
+			for i, v := range r.Pod {
+				for len(sum.Pod) <= i {
+					sum.Pod = append(sum.Pod, 0)
+				}
+				sum.Pod[i] += v
+			}
+			for i, v := range r.Time {
+				for len(sum.Time) <= i {
+					sum.Time = append(sum.Time, 0)
+				}
+				sum.Time[i] += v
+			}
+		}
+	}
+	summed := make([]singleRunData, 0, len(files))
+	for _, file := range files {
+		summed = append(summed, *sums[file])
+	}
+	return summed
+}
+
+func TestMerger_StreamingMatchesBatch(t *testing.T) {
+	structures := map[string]*jsonStructureFile{"lib/A.pm": {
+		Statement: []int{1, 2, 2},
+		Branch:    []structEntry{{Line: 2}},
+		Condition: []structEntry{{Line: 2, Type: "or_3"}},
+	}}
+	tests := map[string][][]singleRunData{
+		"single run": {
+			{{File: "lib/A.pm", Statement: []int{1, 0, 2}, Branch: [][2]int{{1, 0}}}},
+		},
+		"runs of different lengths": {
+			{{File: "lib/A.pm", Statement: []int{1}}},
+			{{File: "lib/A.pm", Statement: []int{0, 1, 1}, Condition: [][]int{{0, 1}}}},
+			{{File: "lib/A.pm", Condition: [][]int{{1, 0, 1}}, Sub: []int{1, 0}}},
+		},
+		"several files": {
+			{{File: "lib/A.pm", Statement: []int{1, 1, 0}}, {File: "lib/B.pm", Statement: []int{0}, Pod: []int{1}}},
+			{{File: "lib/B.pm", Statement: []int{3}, Time: []float64{0.5}}},
+			{{File: "lib/C.pm", Branch: [][2]int{{0, 0}, {2, 1}}}},
+		},
+	}
+	for name, runs := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Every run summed up front, then merged as one
+			batch := mergeRuns([][]singleRunData{sumRuns(runs)}, structures)
+
+			// Streamed one run at a time, in reverse order
+			m := newMerger(structures)
+			for i := len(runs) - 1; i >= 0; i-- {
+				m.addRun(runs[i])
+			}
+			if streamed := m.result(); !reflect.DeepEqual(streamed, batch) {
+				t.Errorf("streamed = %+v\nbatch = %+v", streamed, batch)
+			}
+		})
+	}
+}
+
+func TestMerger_BranchDetail(t *testing.T) {
+	runs := [][]singleRunData{
+		{{File: "lib/If.pm", Statement: []int{1}, Branch: [][2]int{{1, 0}, {0, 0}}}},
+		{{File: "lib/If.pm", Statement: []int{1}, Branch: [][2]int{{1, 0}, {0, 2}}}},
+	}
+	var structure jsonStructureFile
+	if err := json.Unmarshal([]byte(`{"file":"lib/If.pm","statement":[3],"branch":[[42,{"text":"if $x"}],[57,{"text":"unless $y"}]]}`), &structure); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+
+	data := mergeRuns(runs, map[string]*jsonStructureFile{"lib/If.pm": &structure})
+	want := []BranchHit{{Line: 42, True: 2, False: 0}, {Line: 57, True: 0, False: 2}}
+	got := data.Files[0].BranchDetail
+	if len(got) != len(want) {
+		t.Fatalf("BranchDetail = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("BranchDetail[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBranchNotes(t *testing.T) {
+	notes := branchNotes([]BranchHit{
+		{Line: 42, True: 3, False: 0},
+		{Line: 50, True: 1, False: 1},
+		{Line: 57, True: 0, False: 2},
+		{Line: 0, True: 0, False: 0},
+	})
+	want := []string{
+		"line 42: false branch never taken",
+		"line 57: true branch never taken",
+		"line ?: branch never reached",
+	}
+	if strings.Join(notes, "\n") != strings.Join(want, "\n") {
+		t.Errorf("branchNotes() = %q, want %q", notes, want)
+	}
+}
+
+func TestParseAllRuns_Undecodable(t *testing.T) {
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("perl not available")
+	}
+
+	coverDir := t.TempDir()
+	runDir := filepath.Join(coverDir, "runs", "1")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, "cover.14"), []byte("not a coverage db"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := parseAllRuns(coverDir, "perl")
+	if !errors.Is(err, ErrUndecodableRuns) {
+		t.Fatalf("parseAllRuns() error = %v, want ErrUndecodableRuns", err)
+	}
+
+	// A second, decodable run must be merged on its own without stale data
+	goodDir := filepath.Join(coverDir, "runs", "2")
+	if err := os.MkdirAll(goodDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := `use Storable; store({runs => {1 => {count => {"lib/A.pm" => {statement => [1, 0]}}}}}, $ARGV[0])`
+	if out, err := exec.Command("perl", "-e", store, filepath.Join(goodDir, "cover.14")).CombinedOutput(); err != nil {
+		t.Fatalf("failed to write Storable run: %v\n%s", err, out)
+	}
+
+	data, err := parseAllRuns(coverDir, "perl")
+	if err != nil {
+		t.Fatalf("parseAllRuns() error: %v", err)
+	}
+	if len(data.Files) != 1 || data.Files[0].Statement.Covered != 1 || data.Files[0].Statement.Total != 2 {
+		t.Errorf("Files = %+v, want lib/A.pm with 1/2 statements", data.Files)
+	}
+	if data.RunFiles != 2 || len(data.Skipped) != 1 || data.Skipped[0].Path != runDir {
+		t.Errorf("RunFiles = %d, Skipped = %+v, want 2 and %s", data.RunFiles, data.Skipped, runDir)
+	}
+}
+
+func TestParseAllRuns_MixedFormats(t *testing.T) {
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("perl not available")
+	}
+
+	coverDir := t.TempDir()
+	jsonDir := filepath.Join(coverDir, "runs", "1")
+	storableDir := filepath.Join(coverDir, "runs", "2")
+	for _, dir := range []string{jsonDir, storableDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jsonRun := `{"runs": {"1": {"count": {"lib/A.pm": {"statement": [1, 0]}}}}}`
+	if err := os.WriteFile(filepath.Join(jsonDir, "cover.14"), []byte(jsonRun), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := `use Storable; store({runs => {1 => {count => {"lib/A.pm" => {statement => [0, 2]}}}}}, $ARGV[0])`
+	if out, err := exec.Command("perl", "-e", store, filepath.Join(storableDir, "cover.14")).CombinedOutput(); err != nil {
+		t.Fatalf("failed to write Storable run: %v\n%s", err, out)
+	}
+
+	if jsonRuns, otherRuns := detectRunFormats(filepath.Join(coverDir, "runs")); jsonRuns != 1 || otherRuns != 1 {
+		t.Errorf("detectRunFormats() = %d, %d, want 1, 1", jsonRuns, otherRuns)
+	}
+
+	report, err := ParseCoverageDB(coverDir, "", false, "perl", 0)
+	if err != nil {
+		t.Fatalf("ParseCoverageDB() error: %v", err)
+	}
+	fc := report.Files["lib/A.pm"]
+	if fc == nil || fc.Statements.Covered != 2 || fc.Statements.Total != 2 {
+		t.Errorf("lib/A.pm = %+v, want both statements covered by the two runs", fc)
+	}
+	if len(report.Skipped) != 0 {
+		t.Errorf("Skipped = %+v, want none", report.Skipped)
+	}
+}
+
+func TestParseCoverageSummary(t *testing.T) {
+	coverDir := t.TempDir()
+	runDir := filepath.Join(coverDir, "runs", "1")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run := `{"runs": {"1": {"count": {"lib/A.pm": {"statement": [1, 0, 3], "branch": [[1, 0]], ` +
+		`"condition": [[0, 1, 0]], "subroutine": [1, 0]}}}}}`
+	if err := os.WriteFile(filepath.Join(runDir, "cover.14"), []byte(run), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := ParseCoverageDB(coverDir, "", true, "perl", 0)
+	if err != nil {
+		t.Fatalf("ParseCoverageDB() error: %v", err)
+	}
+	summary, err := ParseCoverageSummary(coverDir, "", true, "perl", 0)
+	if err != nil {
+		t.Fatalf("ParseCoverageSummary() error: %v", err)
+	}
+	if !summary.SummaryOnly || full.SummaryOnly {
+		t.Errorf("SummaryOnly = %v and %v, want true for the summary only", summary.SummaryOnly, full.SummaryOnly)
+	}
+	if !reflect.DeepEqual(summary.Summary, full.Summary) {
+		t.Errorf("Summary = %+v, want %+v", summary.Summary, full.Summary)
+	}
+
+	fc := summary.Files["lib/A.pm"]
+	if len(fc.Statements.lines) != 1 || len(fc.Branches.Uncovered) != 0 ||
+		len(fc.Conditions.Detail) != 0 || len(fc.Subroutines.Uncovered) != 0 {
+		t.Errorf("lib/A.pm kept per-line data: %+v", fc)
+	}
+	if fmt.Sprint(fc.Statements.Uncovered) != "[2]" {
+		t.Errorf("Statements.Uncovered = %v, want only the uncovered line [2]", fc.Statements.Uncovered)
+	}
+	if len(fc.Branches.Detail) != 1 {
+		t.Errorf("Branches.Detail = %v, want the branch's hits kept", fc.Branches.Detail)
+	}
+
+	var buf bytes.Buffer
+	if err := (TextFormatter{}).Write(summary, &buf); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if strings.Contains(buf.String(), "lib/A.pm") || !strings.Contains(buf.String(), "Total") {
+		t.Errorf("summary-only table should hold only the totals:\n%s", buf.String())
+	}
+}
+
+func TestParseAllRunsJSON_CorruptRun(t *testing.T) {
+	coverDir := t.TempDir()
+	writeRun := func(name, content string) string {
+		runDir := filepath.Join(coverDir, "runs", name)
+		if err := os.MkdirAll(runDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(runDir, "cover.14")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	writeRun("1", `{"runs": {"1": {"count": {"lib/A.pm": {"statement": [1, 0]}}}}}`)
+	corrupt := writeRun("2", `{"runs": {"1": {"count":`)
+
+	data, err := parseAllRunsJSON(coverDir, 0, false)
+	if err != nil {
+		t.Fatalf("parseAllRunsJSON() error: %v", err)
+	}
+	if len(data.Files) != 1 || data.Files[0].Statement.Covered != 1 {
+		t.Errorf("Files = %+v, want lib/A.pm with 1 covered statement", data.Files)
+	}
+	if data.RunFiles != 2 || len(data.Skipped) != 1 {
+		t.Fatalf("RunFiles = %d, Skipped = %+v, want 2 and one skipped", data.RunFiles, data.Skipped)
+	}
+	if data.Skipped[0].Path != corrupt || data.Skipped[0].Reason == "" {
+		t.Errorf("Skipped[0] = %+v, want %s with a reason", data.Skipped[0], corrupt)
+	}
+}
+
+func TestEmptyCoverageDBs(t *testing.T) {
+	perl, err := exec.LookPath("perl")
+	if err != nil {
+		t.Skip("perl not found")
+	}
+	tmp := t.TempDir()
+	writeRun := func(db, content string) string {
+		t.Helper()
+		runDir := filepath.Join(tmp, db, "runs", "1")
+		if err := os.MkdirAll(runDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if content != "" {
+			if err := os.WriteFile(filepath.Join(runDir, "cover.14"), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return filepath.Join(tmp, db)
+	}
+	writeStorable := func(db, count string) string {
+		t.Helper()
+		dir := writeRun(db, "")
+		file := filepath.Join(dir, "runs", "1", "cover.14")
+		script := "use Storable qw(nstore); nstore({runs => {1 => {collected => ['statement'], count => {" + count + "}}}}, $ARGV[0])"
+		if out, err := exec.Command(perl, "-e", script, file).CombinedOutput(); err != nil {
+			t.Skipf("can't write a Storable run: %v\n%s", err, out)
+		}
+		return dir
+	}
+
+	dbs := []string{
+		filepath.Join(tmp, "missing"),
+		writeRun("nofile", ""),
+		writeRun("json", `{"runs": {"1": {"count": {"lib/A.pm": {"statement": [1]}}}}}`),
+		writeRun("empty", `{"runs": {"1": {"count": {}}}}`),
+		writeStorable("storable", `"lib/A.pm" => {statement => [1]}`),
+		writeStorable("storable-empty", ""),
+		writeRun("garbage", "=\xf3rl\x04"),
+	}
+	got, err := EmptyCoverageDBs(dbs, perl)
+	if err != nil {
+		t.Fatalf("EmptyCoverageDBs() error: %v", err)
+	}
+	want := []string{dbs[0], dbs[1], dbs[3], dbs[5], dbs[6]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EmptyCoverageDBs() = %v, want %v", got, want)
+	}
+}
+
+func TestUncoveredBranchLines(t *testing.T) {
+	got := uncoveredBranchLines([]BranchHit{
+		{Line: 57, True: 0, False: 2},
+		{Line: 42, True: 3, False: 0},
+		{Line: 50, True: 1, False: 1},
+		{Line: 42, True: 0, False: 0},
+		{Line: 0, True: 0, False: 0},
+	})
+	want := []int{42, 57}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("uncoveredBranchLines() = %v, want %v", got, want)
+	}
+}
+
+func TestImportCoverageDBs(t *testing.T) {
+	tmp := t.TempDir()
+	mkRun := func(db, run string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(tmp, db, "runs", run), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmp, db, "runs", run, "cover.14"), []byte(db), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkRun("out", "1")
+	mkRun("shard_a", "100.1")
+	mkRun("shard_b", "200.1")
+	out := filepath.Join(tmp, "out")
+
+	err := ImportCoverageDBs([]string{filepath.Join(tmp, "shard_a"), filepath.Join(tmp, "shard_b")}, out)
+	if err != nil {
+		t.Fatalf("ImportCoverageDBs() error: %v", err)
+	}
+
+	// Existing run 1 is kept; imported runs are numbered after it
+	for run, want := range map[string]string{"1": "out", "2": "shard_a", "3": "shard_b"} {
+		got, err := os.ReadFile(filepath.Join(out, "runs", run, "cover.14"))
+		if err != nil || string(got) != want {
+			t.Errorf("runs/%s = %q (%v), want %q", run, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "shard_a", "runs")); err != nil {
+		t.Error("imported database was removed")
+	}
+
+	if err := ImportCoverageDBs([]string{tmp + "/missing"}, out); err == nil {
+		t.Error("ImportCoverageDBs() accepted a directory without runs/")
+	}
+}
+
+func TestMergeCoverageDBs_PopulatedOutput(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		path := filepath.Join(tmp, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("out/runs/1/cover.14", "earlier")
+	write("out/runs/2/cover.14", "earlier")
+	write("out/structure/abc123", "earlier")
+	write("iso/runs/1700000000.1.1/cover.14", "new")
+	write("iso/structure/abc123", "new")
+	write("iso/structure/def456", "new")
+	out := filepath.Join(tmp, "out")
+
+	if err := MergeCoverageDBs([]string{filepath.Join(tmp, "iso")}, out, 1, nil); err != nil {
+		t.Fatalf("MergeCoverageDBs() error: %v", err)
+	}
+
+	for rel, want := range map[string]string{
+		"runs/1/cover.14":  "earlier",
+		"runs/2/cover.14":  "earlier",
+		"runs/3/cover.14":  "new",
+		"structure/abc123": "earlier",
+		"structure/def456": "new",
+	} {
+		got, err := os.ReadFile(filepath.Join(out, rel))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", rel, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "iso")); !os.IsNotExist(err) {
+		t.Error("isolated directory was not removed")
+	}
+}
+
+func TestMergeCoverageDBs_FailedCopyKeepsSources(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		path := filepath.Join(tmp, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// iso_b's structure file is copied from iso_a, whose run can't be read
+	write("iso_a/structure/abc123", "shared")
+	if err := os.MkdirAll(filepath.Join(tmp, "iso_a", "runs", "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(tmp, "missing"), filepath.Join(tmp, "iso_a", "runs", "1", "cover.14")); err != nil {
+		t.Skipf("can't create a symlink: %v", err)
+	}
+	write("iso_b/runs/1/cover.14", "b")
+	write("iso_b/structure/abc123", "shared")
+
+	dirs := []string{filepath.Join(tmp, "iso_a"), filepath.Join(tmp, "iso_b")}
+	if err := MergeCoverageDBs(dirs, filepath.Join(tmp, "out"), 2, nil); err == nil {
+		t.Fatal("MergeCoverageDBs() = nil error, want the failed copy")
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("%s was removed after a failed merge", dir)
+		}
+	}
+}
+
+func TestMergeCoverageDBs_ParallelMatchesSerial(t *testing.T) {
+	// Build the same isolated databases twice, with several runs each and
+	// structure files shared between them, then merge one set serially and
+	// the other with several jobs
+	tmp := t.TempDir()
+	const dirs = 25
+	build := func(set string) []string {
+		t.Helper()
+		var isolated []string
+		for i := 0; i < dirs; i++ {
+			dir := filepath.Join(tmp, set, fmt.Sprintf("iso_%d", i))
+			files := map[string]string{
+				fmt.Sprintf("structure/digest%d", i%4): fmt.Sprintf("structure %d", i%4),
+				"structure/digest.lock":                "",
+			}
+			for r := 0; r <= i%3; r++ {
+				files[fmt.Sprintf("runs/1700000000.%d.%d/cover.14", i, r)] = fmt.Sprintf("dir %d run %d", i, r)
+			}
+			for rel, content := range files {
+				path := filepath.Join(dir, rel)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			isolated = append(isolated, dir)
+		}
+		return isolated
+	}
+	tree := func(root string) map[string]string {
+		t.Helper()
+		files := make(map[string]string)
+		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			rel, _ := filepath.Rel(root, path)
+			files[rel] = string(data)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	serialOut := filepath.Join(tmp, "serial_db")
+	if err := MergeCoverageDBs(build("serial"), serialOut, 1, nil); err != nil {
+		t.Fatalf("serial MergeCoverageDBs() error: %v", err)
+	}
+	var progress []int
+	parallelOut := filepath.Join(tmp, "parallel_db")
+	err := MergeCoverageDBs(build("parallel"), parallelOut, 8, func(done, total int) {
+		if total != dirs {
+			t.Errorf("progress total = %d, want %d", total, dirs)
+		}
+		progress = append(progress, done)
+	})
+	if err != nil {
+		t.Fatalf("parallel MergeCoverageDBs() error: %v", err)
+	}
+
+	serial, parallel := tree(serialOut), tree(parallelOut)
+	if !reflect.DeepEqual(serial, parallel) {
+		t.Errorf("parallel merge = %v\nserial merge = %v", parallel, serial)
+	}
+	if got := len(serial); got != 4+49 {
+		t.Errorf("merged %d files, want 4 structure files and 49 runs", got)
+	}
+	if serial["runs/1/cover.14"] != "dir 0 run 0" || serial["runs/49/cover.14"] != "dir 24 run 0" {
+		t.Errorf("runs not numbered in directory order: %v", serial)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "parallel", "iso_0")); !os.IsNotExist(err) {
+		t.Error("isolated directory was not removed")
+	}
+
+	// Progress comes in order, about every 10%, ending with the last directory
+	if len(progress) == 0 || progress[len(progress)-1] != dirs {
+		t.Fatalf("progress = %v, want it to end at %d", progress, dirs)
+	}
+	if !sort.IntsAreSorted(progress) || len(progress) > 13 {
+		t.Errorf("progress = %v, want at most 13 increasing updates", progress)
+	}
+}
+
+func TestSetCriteria(t *testing.T) {
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/A.pm": {
+			Path:        "lib/A.pm",
+			Statements:  StatementCoverage{Covered: 3, Total: 4, lines: map[int]int{1: 1, 2: 0}},
+			Branches:    BranchCoverage{Covered: 0, Total: 6, Detail: []BranchHit{{Line: 1}}},
+			Conditions:  ConditionCoverage{Covered: 0, Total: 2},
+			Subroutines: SubroutineCoverage{Covered: 1, Total: 2},
+		},
+	}}
+	if !report.Collected("branch") {
+		t.Error("Collected() = false before SetCriteria, want every criterion")
+	}
+
+	report.SetCriteria([]string{"statement", "subroutine"})
+
+	fc := report.Files["lib/A.pm"]
+	if fc.Branches.Total != 0 || fc.Branches.Detail != nil || fc.Conditions.Total != 0 {
+		t.Errorf("uncollected metrics kept: branches %+v, conditions %+v", fc.Branches, fc.Conditions)
+	}
+	if fc.Statements.Total != 4 || fc.Subroutines.Total != 2 {
+		t.Errorf("collected metrics changed: statements %+v, subroutines %+v", fc.Statements, fc.Subroutines)
+	}
+	if report.Summary.Statement != 75 || report.Summary.Branch != 0 {
+		t.Errorf("Summary = %.1f%% statement, %.1f%% branch, want 75 and 0", report.Summary.Statement, report.Summary.Branch)
+	}
+
+	var headers []string
+	for _, c := range reportColumns(report) {
+		headers = append(headers, c.header)
+	}
+	if strings.Join(headers, " ") != "Stmt Sub" {
+		t.Errorf("reportColumns() = %v, want [Stmt Sub]", headers)
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	th, err := ParseThresholds("80, 50")
+	if err != nil {
+		t.Fatalf("ParseThresholds() error: %v", err)
+	}
+	for pct, want := range map[float64]string{80: "high", 79.9: "medium", 50: "medium", 49.9: "low"} {
+		if got := th.Level(pct); got != want {
+			t.Errorf("Level(%.1f) = %s, want %s", pct, got, want)
+		}
+	}
+
+	for _, bad := range []string{"90", "a,70", "70,90", "101,50", "90,-1"} {
+		if _, err := ParseThresholds(bad); err == nil {
+			t.Errorf("ParseThresholds(%q) should fail", bad)
+		}
+	}
+}
+
+func TestSortedPaths(t *testing.T) {
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/A.pm":     {Statements: StatementCoverage{Covered: 9, Total: 10}, Branches: BranchCoverage{Covered: 1, Total: 4}},
+		"lib/B.pm":     {Statements: StatementCoverage{Covered: 1, Total: 2}},
+		"lib/C.pm":     {Statements: StatementCoverage{Covered: 5, Total: 10}, Branches: BranchCoverage{Covered: 2, Total: 2}},
+		"lib/Empty.pm": {},
+	}}
+
+	tests := []struct {
+		sortBy string
+		want   string
+	}{
+		{SortPath, "lib/A.pm,lib/B.pm,lib/C.pm,lib/Empty.pm"},
+		{SortStatement, "lib/B.pm,lib/C.pm,lib/A.pm,lib/Empty.pm"},
+		{SortBranch, "lib/A.pm,lib/C.pm,lib/B.pm,lib/Empty.pm"},
+		{SortUncovered, "lib/C.pm,lib/A.pm,lib/B.pm,lib/Empty.pm"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(sortedPaths(report, tt.sortBy), ","); got != tt.want {
+			t.Errorf("sortedPaths(%s) = %s, want %s", tt.sortBy, got, tt.want)
+		}
+	}
+}
+
+func TestGroupByDir(t *testing.T) {
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/App/Foo.pm":     {Statements: StatementCoverage{Covered: 3, Total: 4}},
+		"lib/App/Foo/Bar.pm": {Statements: StatementCoverage{Covered: 1, Total: 4}, Branches: BranchCoverage{Covered: 1, Total: 2}},
+		"lib/Util/String.pm": {Statements: StatementCoverage{Covered: 2, Total: 2}},
+		"script.pl":          {Statements: StatementCoverage{Covered: 0, Total: 1}},
+	}}
+
+	groups := GroupByDir(report, 2)
+	var dirs []string
+	for _, g := range groups {
+		dirs = append(dirs, g.Dir)
+	}
+	if got := strings.Join(dirs, ","); got != "./,lib/App/,lib/Util/" {
+		t.Fatalf("groups = %s, want ./,lib/App/,lib/Util/", got)
+	}
+
+	app := groups[1]
+	if len(app.Files) != 2 || app.Files[0] != "lib/App/Foo.pm" {
+		t.Errorf("lib/App/ files = %v", app.Files)
+	}
+	if app.Coverage.Statements.Covered != 4 || app.Coverage.Statements.Total != 8 || app.Coverage.Statements.Percent != 50 {
+		t.Errorf("lib/App/ statements = %+v, want 4/8 (50%%)", app.Coverage.Statements)
+	}
+	if app.Coverage.Branches.Total != 2 {
+		t.Errorf("lib/App/ branches total = %d, want 2", app.Coverage.Branches.Total)
+	}
+
+	if got := len(GroupByDir(report, 3)); got != 4 {
+		t.Errorf("depth 3 gave %d groups, want 4", got)
+	}
+}
+
+func TestGroupDir(t *testing.T) {
+	tests := []struct {
+		path  string
+		depth int
+		want  string
+	}{
+		{"lib/App/Foo/Bar.pm", 2, "lib/App/"},
+		{"script.pl", 2, "./"},
+		{"/home/dev/proj/lib/App/Foo.pm", 2, "/home/dev/"},
+		{"/A.pm", 2, "/"},
+		{`lib\App\Foo\Bar.pm`, 2, "lib/App/"},
+		{`C:\proj\lib\App\Foo.pm`, 2, "C:/proj/lib/"},
+		{"C:/proj/lib/Foo.pm", 1, "C:/proj/"},
+	}
+	for _, tt := range tests {
+		if got := groupDir(tt.path, tt.depth); got != tt.want {
+			t.Errorf("groupDir(%q, %d) = %q, want %q", tt.path, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestConditionOutcomes(t *testing.T) {
+	tests := []struct {
+		states        []int
+		kind          string
+		tr, fa, total int
+	}{
+		{[]int{0, 3}, "or_2", 0, 1, 2},        // $x || die: left operand false only
+		{[]int{0, 3}, "and_2", 1, 0, 2},       // $x && 1: left operand true only
+		{[]int{2, 0, 0}, "and_3", 0, 1, 4},    // a && b: only !a seen
+		{[]int{2, 1, 0}, "and_3", 1, 2, 4},    // a && b: !a, a&&!b -> a true/false, b false
+		{[]int{1, 0, 0}, "or_3", 1, 0, 4},     // a || b: only a seen
+		{[]int{1, 1, 1}, "or_3", 2, 2, 4},     // every state hit
+		{[]int{1, 0, 0, 1}, "xor_4", 2, 2, 4}, // l&&r and !l&&!r cover both operands both ways
+		{[]int{1, 1, 0, 0}, "xor_4", 2, 1, 4}, // left only ever true
+		{[]int{1, 0, 1, 0, 1}, "", 3, 0, 5},
+	}
+	for _, tt := range tests {
+		tr, fa, total := conditionOutcomes(tt.states, tt.kind)
+		if tr != tt.tr || fa != tt.fa || total != tt.total {
+			t.Errorf("conditionOutcomes(%v, %q) = %d/%d/%d, want %d/%d/%d",
+				tt.states, tt.kind, tr, fa, total, tt.tr, tt.fa, tt.total)
+		}
+	}
+}
+
+func TestConditionMissed(t *testing.T) {
+	tests := []struct {
+		states []int
+		kind   string
+		want   []string
+	}{
+		{[]int{0, 3}, "or_2", []string{"true"}},
+		{[]int{0, 3}, "and_2", []string{"false"}},
+		{[]int{2, 0, 0}, "and_3", []string{"left true", "right true", "right false"}},
+		{[]int{2, 1, 0}, "and_3", []string{"right true"}},
+		{[]int{1, 0, 0}, "or_3", []string{"left false", "right true", "right false"}},
+		{[]int{1, 1, 1}, "or_3", nil},
+		{[]int{1, 1, 0, 0}, "xor_4", []string{"left false"}},
+		{[]int{0, 0, 0, 0}, "xor_4", []string{"left true", "left false", "right true", "right false"}},
+		{[]int{1, 0, 1, 0, 1}, "", []string{"state 2", "state 4"}},
+	}
+	for _, tt := range tests {
+		got := conditionMissed(tt.states, tt.kind)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("conditionMissed(%v, %q) = %q, want %q", tt.states, tt.kind, got, tt.want)
+		}
+		// Every outcome conditionOutcomes leaves uncovered is named
+		tr, fa, total := conditionOutcomes(tt.states, tt.kind)
+		if len(got) != total-tr-fa {
+			t.Errorf("conditionMissed(%v, %q) names %d outcomes, conditionOutcomes misses %d", tt.states, tt.kind, len(got), total-tr-fa)
+		}
+	}
+}
+
+func TestConditionNotes(t *testing.T) {
+	got := conditionNotes([]CondInfo{
+		{Line: 30, Missed: []string{"false"}},
+		{Line: 31, Missed: []string{"left true", "right false"}},
+		{Missed: []string{"state 2"}},
+	})
+	want := []string{
+		"line 30: condition never evaluated false",
+		"line 31: left operand never evaluated true, right operand never evaluated false",
+		"line ?: state 2 never reached",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("conditionNotes() = %q, want %q", got, want)
+	}
+}
+
+func TestCombinedCoverage_SonarQubeFormula(t *testing.T) {
+	// (CT + CF + LC) / (2*B + EL) with 2 branches (2 true, 1 false),
+	// one a && b condition (1 true, 1 false of 4 outcomes), and 10 of
+	// 20 lines: (3 + 2 + 10) / (4 + 4 + 20) = 15/28
+	newReport := func() *Report {
+		return &Report{Files: map[string]*FileCoverage{
+			"lib/Sonar.pm": {
+				Statements: StatementCoverage{Covered: 10, Total: 20},
+				Branches: BranchCoverage{Covered: 3, Total: 4, Detail: []BranchHit{
+					{Line: 3, True: 1, False: 2},
+					{Line: 7, True: 4, False: 0},
+				}},
+				Conditions: ConditionCoverage{
+					Covered: 2, Total: 3, Outcomes: 4, OutcomesCovered: 2,
+					TrueCovered: 1, FalseCovered: 1,
+				},
+			},
+		}}
+	}
+	want := 15.0 / 28.0 * 100
+
+	report := newReport()
+	calculateSummary(report)
+	if diff := report.Summary.Combined - want; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Combined = %f, want %f", report.Summary.Combined, want)
+	}
+	if s := report.Summary.Sonar; s.ConditionsTrue != 3 || s.ConditionsFalse != 2 || s.Conditions != 4 {
+		t.Errorf("Sonar = %+v, want CT=3 CF=2 B=4", s)
+	}
+
+	// Absorbing conditions into branches must not change the combined value
+	report = newReport()
+	calculateSummary(report)
+	config, _ := ParseNormalizationModes("sonarqube")
+	report.Normalize(config)
+	if diff := report.Summary.Combined - want; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Combined after sonarqube normalization = %f, want %f", report.Summary.Combined, want)
+	}
+}
+
+func TestMarkedFiles(t *testing.T) {
+	dir := t.TempDir()
+	generated := filepath.Join(dir, "Generated.pm")
+	late := filepath.Join(dir, "Late.pm")
+	plain := filepath.Join(dir, "Plain.pm")
+	missing := filepath.Join(dir, "Missing.pm")
+
+	head := "package Generated;\n# GENERATED FILE - DO NOT EDIT\n1;\n"
+	if err := os.WriteFile(generated, []byte(head), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A marker below the scanned head doesn't count
+	body := "package Late;\n" + strings.Repeat("1;\n", MarkerLines) + "# GENERATED FILE\n"
+	if err := os.WriteFile(late, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(plain, []byte("package Plain;\n1;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report := &Report{Files: map[string]*FileCoverage{
+		generated: {}, late: {}, plain: {}, missing: {},
+	}}
+	marked, errs := MarkedFiles(report, regexp.MustCompile(`GENERATED FILE`))
+	if len(marked) != 1 || !marked[generated] {
+		t.Errorf("marked = %v, want only %s", marked, generated)
+	}
+	if len(errs) != 1 || !os.IsNotExist(errs[0]) {
+		t.Errorf("errs = %v, want one not-exist error for %s", errs, missing)
+	}
+}
+
+func TestExcludeUncoverable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Guard.pm")
+	source := "package Guard;\n" +
+		"sub check {\n" +
+		"    my $x = shift;\n" +
+		"    die 'impossible' unless $x; # uncoverable statement\n" +
+		"    return $x;\n" +
+		"}\n" +
+		"warn 'missed' if $ENV{DEBUG}; # uncoverable branch true\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report := &Report{Files: map[string]*FileCoverage{
+		path: {Statements: StatementCoverage{
+			Covered: 2, Total: 5,
+			// Line 4 holds two statements (die and unless)
+			lines:  map[int]int{3: 1, 4: 0, 5: 1, 7: 0},
+			counts: map[int]int{3: 1, 4: 2, 5: 1, 7: 1},
+		}},
+	}}
+	calculateSummary(report)
+
+	dropped, errs := report.ExcludeUncoverable(regexp.MustCompile(DefaultUncoverableMarker))
+	if dropped != 1 || len(errs) != 0 {
+		t.Fatalf("ExcludeUncoverable() = %d, %v, want 1, no errors", dropped, errs)
+	}
+	st := report.Files[path].Statements
+	if st.Total != 3 || st.Covered != 2 {
+		t.Errorf("Statements = %d/%d, want 2/3", st.Covered, st.Total)
+	}
+	if len(st.Uncovered) != 1 || st.Uncovered[0] != 7 {
+		t.Errorf("Uncovered = %v, want [7]", st.Uncovered)
+	}
+	if got := fmt.Sprintf("%.1f", report.Summary.Statement); got != "66.7" {
+		t.Errorf("Summary.Statement = %s, want 66.7", got)
+	}
+}
+
+func TestExcludeUncoverableSummaryOnly(t *testing.T) {
+	root := t.TempDir()
+	source := "package Guard;\n" +
+		"my $x = shift;\n" +
+		"die 'impossible' unless $x; # uncoverable statement\n" +
+		"warn 'missed';\n"
+	if err := os.MkdirAll(filepath.Join(root, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "lib", "Guard.pm"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverDir := filepath.Join(root, "cover_db")
+	runDir := filepath.Join(coverDir, "runs", "1")
+	structDir := filepath.Join(coverDir, "structure")
+	for _, dir := range []string{runDir, structDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Line 3 holds the die and the unless, which ran
+	run := `{"runs": {"1": {"count": {"lib/Guard.pm": {"statement": [1, 1, 0, 1, 0]}}}}}`
+	structure := `{"file": "lib/Guard.pm", "statement": [1, 2, 3, 3, 4]}`
+	if err := os.WriteFile(filepath.Join(runDir, "cover.14"), []byte(run), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(structDir, "abc"), []byte(structure), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	marker := regexp.MustCompile(DefaultUncoverableMarker)
+	var totals []int
+	for _, parse := range []func(string, string, bool, string, int) (*Report, error){ParseCoverageDB, ParseCoverageSummary} {
+		report, err := parse(coverDir, root, true, "perl", 0)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if dropped, errs := report.ExcludeUncoverable(marker); dropped != 1 || len(errs) != 0 {
+			t.Fatalf("ExcludeUncoverable() = %d, %v, want 1, no errors", dropped, errs)
+		}
+		st := report.Files["lib/Guard.pm"].Statements
+		totals = append(totals, st.Covered, st.Total)
+	}
+	// The summary-only report leaves out the same statement as the full one
+	if fmt.Sprint(totals) != "[3 4 3 4]" {
+		t.Errorf("covered/total of full and summary-only reports = %v, want 3/4 for both", totals)
+	}
+}
+
+func TestExcludeUncoverablePartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Guard.pm")
+	source := "package Guard;\n" +
+		"my $x = shift; die 'impossible' unless $x; # uncoverable statement\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Line 2 holds three statements, of which only the die never ran
+	report := &Report{Files: map[string]*FileCoverage{
+		path: {Statements: StatementCoverage{
+			Covered: 3, Total: 4,
+			lines:  map[int]int{1: 1, 2: 2},
+			counts: map[int]int{1: 1, 2: 3},
+			missed: map[int]int{2: 1},
+		}},
+	}}
+	calculateSummary(report)
+
+	dropped, errs := report.ExcludeUncoverable(regexp.MustCompile(DefaultUncoverableMarker))
+	if dropped != 1 || len(errs) != 0 {
+		t.Fatalf("ExcludeUncoverable() = %d, %v, want 1, no errors", dropped, errs)
+	}
+	st := report.Files[path].Statements
+	if st.Total != 3 || st.Covered != 3 || len(st.Uncovered) != 0 {
+		t.Errorf("Statements = %d/%d, uncovered %v, want 3/3 and none", st.Covered, st.Total, st.Uncovered)
+	}
+}
+
+func TestSetPathStyle(t *testing.T) {
+	root := filepath.FromSlash("/home/ci/checkout")
+	abs := func(rel string) string { return filepath.Join(root, rel) }
+	outside := filepath.FromSlash("/usr/share/perl5/Carp.pm")
+
+	report := &Report{Root: root, Files: map[string]*FileCoverage{
+		abs("lib/A.pm"): {
+			Path: abs("lib/A.pm"),
+			Statements: StatementCoverage{
+				Covered: 2, Total: 4,
+				lines:  map[int]int{1: 2, 2: 0, 3: 1, 4: 0},
+				counts: map[int]int{1: 1, 2: 1, 3: 1, 4: 1},
+				missed: map[int]int{2: 1, 4: 1},
+			},
+			Branches:    BranchCoverage{Covered: 1, Total: 2, Detail: []BranchHit{{Line: 3, True: 1}}},
+			Subroutines: SubroutineCoverage{Covered: 1, Total: 2, Called: []SubInfo{{"new", 1}}, Uncovered: []SubInfo{{"run", 2}}},
+		},
+		"lib/A.pm": {
+			Path: "lib/A.pm",
+			Statements: StatementCoverage{
+				Covered: 2, Total: 4,
+				lines:  map[int]int{1: 1, 2: 1, 3: 0, 4: 0},
+				counts: map[int]int{1: 1, 2: 1, 3: 1, 4: 1},
+				missed: map[int]int{3: 1, 4: 1},
+			},
+			Branches:    BranchCoverage{Covered: 1, Total: 2, Detail: []BranchHit{{Line: 3, False: 2}}},
+			Subroutines: SubroutineCoverage{Covered: 2, Total: 2, Called: []SubInfo{{"new", 1}, {"run", 2}}},
+		},
+		"lib/B.pm": {Path: "lib/B.pm", Statements: StatementCoverage{Covered: 1, Total: 2}},
+		outside:    {Path: outside, Statements: StatementCoverage{Covered: 1, Total: 1}},
+	}}
+
+	report.SetPathStyle(PathRel)
+	if len(report.Files) != 3 {
+		t.Fatalf("Files = %d, want 3 after merging both lib/A.pm paths", len(report.Files))
+	}
+	fc := report.Files["lib/A.pm"]
+	if fc == nil || fc.Path != "lib/A.pm" {
+		t.Fatalf("lib/A.pm = %+v, want both entries merged under it", fc)
+	}
+	if fc.Statements.Covered != 3 || fc.Statements.Total != 4 || !reflect.DeepEqual(fc.Statements.Uncovered, []int{4}) {
+		t.Errorf("Statements = %+v, want 3/4 covered with line 4 uncovered", fc.Statements)
+	}
+	if fc.Statements.lines[1] != 3 {
+		t.Errorf("line 1 hits = %d, want 3 summed over both entries", fc.Statements.lines[1])
+	}
+	if fc.Branches.Covered != 2 || len(fc.Branches.Uncovered) != 0 {
+		t.Errorf("Branches = %+v, want both sides taken", fc.Branches)
+	}
+	if fc.Subroutines.Covered != 2 || len(fc.Subroutines.Uncovered) != 0 {
+		t.Errorf("Subroutines = %+v, want both called", fc.Subroutines)
+	}
+	if report.Files[outside] == nil {
+		t.Errorf("%s outside the root should stay absolute", outside)
+	}
+	if report.Summary.TotalFiles != 3 {
+		t.Errorf("Summary.TotalFiles = %d, want 3", report.Summary.TotalFiles)
+	}
+
+	report.SetPathStyle(PathAbs)
+	if fc := report.Files[abs("lib/B.pm")]; fc == nil || fc.Path != abs("lib/B.pm") {
+		t.Errorf("Files = %v, want %s", report.Files, abs("lib/B.pm"))
+	}
+	if report.PathStyle != PathAbs {
+		t.Errorf("PathStyle = %q, want abs", report.PathStyle)
+	}
+}
+
+func TestMapPaths(t *testing.T) {
+	lib := filepath.FromSlash
+	report := &Report{Files: map[string]*FileCoverage{
+		lib("blib/lib/App.pm"): {Path: lib("blib/lib/App.pm"), Statements: StatementCoverage{Covered: 3, Total: 4}},
+		lib("blib/lib/App/Util.pm"): {Path: lib("blib/lib/App/Util.pm"), Statements: StatementCoverage{
+			Covered: 1, Total: 4,
+			lines:  map[int]int{1: 0, 2: 0, 3: 1, 4: 0},
+			counts: map[int]int{1: 1, 2: 1, 3: 1, 4: 1},
+			missed: map[int]int{1: 1, 2: 1, 4: 1},
+		}},
+		lib("lib/App/Util.pm"): {Path: lib("lib/App/Util.pm"), Statements: StatementCoverage{
+			Covered: 2, Total: 4,
+			lines:  map[int]int{1: 1, 2: 1, 3: 0, 4: 0},
+			counts: map[int]int{1: 1, 2: 1, 3: 1, 4: 1},
+			missed: map[int]int{3: 1, 4: 1},
+		}},
+		lib("blib/libfoo/X.pm"): {Path: lib("blib/libfoo/X.pm"), Statements: StatementCoverage{Covered: 1, Total: 1}},
+		lib("blib/arch/Y.pm"):   {Path: lib("blib/arch/Y.pm"), Statements: StatementCoverage{Covered: 1, Total: 1}},
+	}}
+	calculateSummary(report)
+
+	var mappings []PathMapping
+	for _, spec := range []string{"blib/lib=lib", "blib/arch = lib"} {
+		m, err := ParsePathMapping(spec)
+		if err != nil {
+			t.Fatalf("ParsePathMapping(%q) error: %v", spec, err)
+		}
+		mappings = append(mappings, m)
+	}
+	report.MapPaths(mappings)
+
+	var paths []string
+	for path, fc := range report.Files {
+		if fc.Path != path {
+			t.Errorf("Files[%s].Path = %s", path, fc.Path)
+		}
+		paths = append(paths, filepath.ToSlash(path))
+	}
+	sort.Strings(paths)
+	want := []string{"blib/libfoo/X.pm", "lib/App.pm", "lib/App/Util.pm", "lib/Y.pm"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+	// lib/App/Util.pm was also recorded directly; each entry ran lines
+	// the other missed
+	if got := report.Files[lib("lib/App/Util.pm")].Statements; got.Covered != 3 || !reflect.DeepEqual(got.Uncovered, []int{4}) {
+		t.Errorf("lib/App/Util.pm statements = %+v, want both entries merged to 3 covered", got)
+	}
+
+	for _, bad := range []string{"blib/lib", "=lib", "blib/lib="} {
+		if _, err := ParsePathMapping(bad); err == nil {
+			t.Errorf("ParsePathMapping(%q) = nil error, want one", bad)
+		}
+	}
+}
+
+func TestUntestedFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, rel := range []string{"lib/App.pm", "lib/App/Loaded.pm", "lib/App/Never.pm", "lib/App/Skip.pm", "lib/App/Empty.pm", "lib/README.pod"} {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("1;\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := &Report{Root: root, Files: map[string]*FileCoverage{
+		filepath.Join("lib", "App.pm"):           {Statements: StatementCoverage{Covered: 2, Total: 3}},
+		filepath.Join("lib", "App", "Loaded.pm"): {Statements: StatementCoverage{Covered: 0, Total: 3}},
+		filepath.Join("lib", "App", "Empty.pm"):  {Statements: StatementCoverage{}},
+	}}
+	exclude := func(path string) bool { return strings.HasSuffix(path, "Skip.pm") }
+
+	// Absolute source dirs map onto the report's relative paths
+	got, err := UntestedFiles(report, []string{filepath.Join(root, "lib"), filepath.Join(root, "missing")}, exclude)
+	if err != nil {
+		t.Fatalf("UntestedFiles() error: %v", err)
+	}
+	want := []string{filepath.Join("lib", "App", "Loaded.pm"), filepath.Join("lib", "App", "Never.pm")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("UntestedFiles() = %v, want %v", got, want)
+	}
+}
+
+// writeBenchRuns writes a database of 200 JSON runs over 50 files to
+// coverDir, with branch, condition and subroutine counts unless
+// statementsOnly
+func writeBenchRuns(b *testing.B, coverDir string, statementsOnly bool) {
+	b.Helper()
+	var sb strings.Builder
+	sb.WriteString(`{"runs": {"1": {"count": {`)
+	for f := 0; f < 50; f++ {
+		if f > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(`"lib/M` + strconv.Itoa(f) + `.pm": {"statement": [`)
+		for i := 0; i < 200; i++ {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(strconv.Itoa(i % 3))
+		}
+		sb.WriteString(`]`)
+		if !statementsOnly {
+			sb.WriteString(`, "branch": [[1, 0], [0, 2]], "condition": [[1, 0, 1]], "subroutine": [1, 0, 2]`)
+		}
+		sb.WriteString(`}`)
+	}
+	sb.WriteString(`}}}}`)
+	run := []byte(sb.String())
+	for i := 0; i < 200; i++ {
+		runDir := filepath.Join(coverDir, "runs", strconv.Itoa(i))
+		if err := os.MkdirAll(runDir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(runDir, "cover.14"), run, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseAllRunsJSON compares serial and parallel decoding of a
+// database of many JSON runs, e.g.
+// go test -bench ParseAllRunsJSON ./internal/coverage
+func BenchmarkParseAllRunsJSON(b *testing.B) {
+	coverDir := b.TempDir()
+	writeBenchRuns(b, coverDir, false)
+
+	for _, jobs := range []int{1, 2, 4, 8} {
+		b.Run("jobs="+strconv.Itoa(jobs), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := parseAllRunsJSON(coverDir, jobs, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkParseCriteria compares parsing a database collected with the
+// default criteria against one collected with --statements-only, e.g.
+// go test -bench ParseCriteria ./internal/coverage
+func BenchmarkParseCriteria(b *testing.B) {
+	for _, tt := range []struct {
+		name           string
+		statementsOnly bool
+	}{
+		{"default", false},
+		{"statements-only", true},
+	} {
+		coverDir := b.TempDir()
+		writeBenchRuns(b, coverDir, tt.statementsOnly)
+		b.Run(tt.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := parseAllRunsJSON(coverDir, 1, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFindStaleLocks(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := now.Add(-2 * StaleLockAge)
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("true not available: %v", err)
+	}
+
+	locks := []struct {
+		name    string
+		content string
+		mtime   time.Time
+	}{
+		{"structure/a.lock", "", old},                                        // stale
+		{"structure/b.lock", "", now},                                        // fresh
+		{"c.lock", strconv.Itoa(os.Getpid()), old},                           // owner alive
+		{"runs/d.lock", strconv.Itoa(exited.ProcessState.Pid()) + "\n", old}, // owner exited
+		{"structure/e", "", old},                                             // not a lock
+	}
+	for _, l := range locks {
+		path := filepath.Join(dir, l.name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(l.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, l.mtime, l.mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stale, err := FindStaleLocks(dir, now)
+	if err != nil {
+		t.Fatalf("FindStaleLocks() error: %v", err)
+	}
+	want := []string{filepath.Join(dir, "runs/d.lock"), filepath.Join(dir, "structure/a.lock")}
+	if !reflect.DeepEqual(stale, want) {
+		t.Fatalf("FindStaleLocks() = %v, want %v", stale, want)
+	}
+
+	if err := RemoveLocks(append(stale, filepath.Join(dir, "missing.lock"))); err != nil {
+		t.Fatalf("RemoveLocks() error: %v", err)
+	}
+	if stale, _ := FindStaleLocks(dir, now); len(stale) != 0 {
+		t.Errorf("after RemoveLocks, FindStaleLocks() = %v", stale)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "structure/b.lock")); err != nil {
+		t.Errorf("fresh lock was removed: %v", err)
+	}
+}
+
+func TestFindCoverCommand(t *testing.T) {
+	dir := t.TempDir()
+	perlDir := filepath.Join(dir, "perl5", "bin")
+	pathDir := filepath.Join(dir, "path")
+	for _, d := range []string{perlDir, pathDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := []byte("#!/bin/sh\n")
+	perl := filepath.Join(perlDir, "perl")
+	for _, path := range []string{perl, filepath.Join(pathDir, "cover")} {
+		if err := os.WriteFile(path, script, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", pathDir)
+
+	// Without a cover next to perl, the one on PATH is used
+	if got, err := FindCoverCommand(perl); err != nil || got != filepath.Join(pathDir, "cover") {
+		t.Errorf("FindCoverCommand() = %q, %v, want cover from PATH", got, err)
+	}
+
+	// cover next to perl wins, also when perl is reached through a link
+	sibling := filepath.Join(perlDir, "cover")
+	if err := os.WriteFile(sibling, script, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "perl")
+	if err := os.Symlink(perl, link); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{perl, link} {
+		if got, err := FindCoverCommand(p); err != nil || got != sibling {
+			t.Errorf("FindCoverCommand(%s) = %q, %v, want %s", p, got, err, sibling)
+		}
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, err := FindCoverCommand("perl"); err == nil || !strings.Contains(err.Error(), "'cover' command from Devel::Cover") {
+		t.Errorf("FindCoverCommand() error = %v, want cover not found", err)
+	}
+}
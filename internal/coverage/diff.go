@@ -0,0 +1,134 @@
+package coverage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Change kinds for a file in a ReportDiff
+const (
+	FileChanged = "changed"
+	FileAdded   = "added"
+	FileRemoved = "removed"
+)
+
+// FileDiff is the statement coverage change of a single file between two reports
+type FileDiff struct {
+	Path   string
+	Status string  // FileChanged, FileAdded or FileRemoved
+	Old    float64 // Statement coverage in the old report (0 if added)
+	New    float64 // Statement coverage in the new report (0 if removed)
+}
+
+// Delta returns the change in statement coverage percentage points
+func (d FileDiff) Delta() float64 {
+	return d.New - d.Old
+}
+
+// Regressed reports whether an existing file lost statement coverage
+func (d FileDiff) Regressed() bool {
+	return d.Status == FileChanged && d.Delta() < 0
+}
+
+// MetricDiff is the change of a single summary metric between two reports
+type MetricDiff struct {
+	Name string
+	Old  float64
+	New  float64
+}
+
+// Delta returns the change in percentage points
+func (d MetricDiff) Delta() float64 {
+	return d.New - d.Old
+}
+
+// ReportDiff holds the differences between two coverage reports.
+// Files whose coverage is unchanged are omitted.
+type ReportDiff struct {
+	Summary []MetricDiff
+	Files   []FileDiff // Sorted by path
+}
+
+// Diff compares a baseline report against a new one
+func Diff(old, new *Report) *ReportDiff {
+	diff := &ReportDiff{
+		Summary: []MetricDiff{
+			{"Statement", old.Summary.Statement, new.Summary.Statement},
+			{"Branch", old.Summary.Branch, new.Summary.Branch},
+			{"Condition", old.Summary.Condition, new.Summary.Condition},
+			{"Subroutine", old.Summary.Subroutine, new.Summary.Subroutine},
+		},
+	}
+
+	for path, nf := range new.Files {
+		of, ok := old.Files[path]
+		if !ok {
+			diff.Files = append(diff.Files, FileDiff{Path: path, Status: FileAdded, New: nf.Statements.Percent})
+			continue
+		}
+		if of.Statements.Percent != nf.Statements.Percent {
+			diff.Files = append(diff.Files, FileDiff{
+				Path:   path,
+				Status: FileChanged,
+				Old:    of.Statements.Percent,
+				New:    nf.Statements.Percent,
+			})
+		}
+	}
+	for path, of := range old.Files {
+		if _, ok := new.Files[path]; !ok {
+			diff.Files = append(diff.Files, FileDiff{Path: path, Status: FileRemoved, Old: of.Statements.Percent})
+		}
+	}
+
+	sort.Slice(diff.Files, func(i, j int) bool {
+		return diff.Files[i].Path < diff.Files[j].Path
+	})
+	return diff
+}
+
+// HasRegression reports whether any existing file or summary metric lost coverage
+func (d *ReportDiff) HasRegression() bool {
+	for _, m := range d.Summary {
+		if m.Delta() < 0 {
+			return true
+		}
+	}
+	for _, f := range d.Files {
+		if f.Regressed() {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintDiff prints the per-file and summary changes, flagging regressions,
+// with percentages shown in format f
+func PrintDiff(d *ReportDiff, f PercentFormat) {
+	if len(d.Files) == 0 {
+		fmt.Println("No per-file coverage changes")
+	}
+	for _, file := range d.Files {
+		switch file.Status {
+		case FileAdded:
+			fmt.Printf("  %s: new file, %s\n", file.Path, f.Percent(file.New))
+		case FileRemoved:
+			fmt.Printf("  %s: removed (was %s)\n", file.Path, f.Percent(file.Old))
+		default:
+			marker := ""
+			if file.Regressed() {
+				marker = "  ⚠️  regression"
+			}
+			fmt.Printf("  %s: %s → %s (%s)%s\n", file.Path, f.Percent(file.Old), f.Percent(file.New), f.Delta(file.Delta()), marker)
+		}
+	}
+
+	fmt.Println()
+	for _, m := range d.Summary {
+		marker := ""
+		if m.Delta() < 0 {
+			marker = "  ⚠️  regression"
+		}
+		fmt.Printf("%-12s %s → %s (%s)%s\n", m.Name+":", f.Percent(m.Old), f.Percent(m.New), f.Delta(m.Delta()), marker)
+	}
+}
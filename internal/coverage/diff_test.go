@@ -0,0 +1,83 @@
+package coverage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Report{Files: map[string]*FileCoverage{
+		"lib/Foo.pm":  {Statements: StatementCoverage{Covered: 72, Total: 100}},
+		"lib/Same.pm": {Statements: StatementCoverage{Covered: 5, Total: 10}},
+		"lib/Gone.pm": {Statements: StatementCoverage{Covered: 1, Total: 2}},
+	}}
+	new := &Report{Files: map[string]*FileCoverage{
+		"lib/Foo.pm":  {Statements: StatementCoverage{Covered: 60, Total: 100}},
+		"lib/Same.pm": {Statements: StatementCoverage{Covered: 5, Total: 10}},
+		"lib/New.pm":  {Statements: StatementCoverage{Covered: 4, Total: 4}},
+	}}
+	calculateSummary(old)
+	calculateSummary(new)
+
+	d := Diff(old, new)
+	want := []FileDiff{
+		{Path: "lib/Foo.pm", Status: FileChanged, Old: 72, New: 60},
+		{Path: "lib/Gone.pm", Status: FileRemoved, Old: 50},
+		{Path: "lib/New.pm", Status: FileAdded, New: 100},
+	}
+	if len(d.Files) != len(want) {
+		t.Fatalf("Files = %+v, want %+v", d.Files, want)
+	}
+	for i := range want {
+		if d.Files[i] != want[i] {
+			t.Errorf("Files[%d] = %+v, want %+v", i, d.Files[i], want[i])
+		}
+	}
+	if !d.Files[0].Regressed() {
+		t.Error("lib/Foo.pm should be a regression")
+	}
+	if !d.HasRegression() {
+		t.Error("HasRegression() = false, want true")
+	}
+	if Diff(old, old).HasRegression() {
+		t.Error("HasRegression() = true for identical reports")
+	}
+}
+
+func TestReadJSONRoundTrip(t *testing.T) {
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/A.pm": {
+			Path:        "lib/A.pm",
+			Statements:  StatementCoverage{Covered: 1, Total: 2, lines: map[int]int{7: 0}},
+			Branches:    BranchCoverage{Covered: 1, Total: 4},
+			Subroutines: SubroutineCoverage{Covered: 1, Total: 2, Uncovered: []SubInfo{{Name: "BUILD", Line: 12}}},
+		},
+	}}
+	calculateSummary(report)
+
+	var buf bytes.Buffer
+	if err := WriteJSON(report, &buf); err != nil {
+		t.Fatalf("WriteJSON() error: %v", err)
+	}
+	got, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatalf("ReadJSON() error: %v", err)
+	}
+
+	fc := got.Files["lib/A.pm"]
+	if fc == nil {
+		t.Fatal("lib/A.pm missing after round trip")
+	}
+	if fc.Statements.Percent != 50 || fc.Branches.Percent != 25 {
+		t.Errorf("percents = %.1f/%.1f, want 50/25", fc.Statements.Percent, fc.Branches.Percent)
+	}
+	if len(fc.Statements.Uncovered) != 1 || fc.Statements.Uncovered[0] != 7 {
+		t.Errorf("Uncovered = %v, want [7]", fc.Statements.Uncovered)
+	}
+	if subs := fc.Subroutines.Uncovered; len(subs) != 1 || subs[0] != (SubInfo{Name: "BUILD", Line: 12}) {
+		t.Errorf("Subroutines.Uncovered = %v, want [BUILD (line 12)]", subs)
+	}
+	if got.Summary != report.Summary {
+		t.Errorf("Summary = %+v, want %+v", got.Summary, report.Summary)
+	}
+}
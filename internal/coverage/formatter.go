@@ -0,0 +1,103 @@
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formatter writes a report in one output format
+type Formatter interface {
+	Write(report *Report, w io.Writer) error
+}
+
+// FormatterFunc adapts a write function to Formatter
+type FormatterFunc func(report *Report, w io.Writer) error
+
+// Write calls f(report, w)
+func (f FormatterFunc) Write(report *Report, w io.Writer) error {
+	return f(report, w)
+}
+
+// format is a registered output format
+type format struct {
+	file string                            // default file name; "" means stdout
+	new  func(opts PrintOptions) Formatter // opts only matter to text output
+}
+
+// formats maps each --format name to its formatter
+var formats = map[string]format{
+	"text": {
+		new: func(opts PrintOptions) Formatter { return TextFormatter{Options: opts} },
+	},
+	"json": {
+		file: "coverage.json",
+		new:  func(PrintOptions) Formatter { return FormatterFunc(WriteJSON) },
+	},
+	"sonar-generic": {
+		file: "sonar-coverage.xml",
+		new:  func(PrintOptions) Formatter { return FormatterFunc(WriteSonarGeneric) },
+	},
+	"cobertura": {
+		file: "cobertura.xml",
+		new: func(PrintOptions) Formatter {
+			return FormatterFunc(func(report *Report, w io.Writer) error {
+				return WriteCobertura(report, time.Now(), w)
+			})
+		},
+	},
+	"clover": {
+		file: "clover.xml",
+		new: func(PrintOptions) Formatter {
+			return FormatterFunc(func(report *Report, w io.Writer) error {
+				return WriteClover(report, time.Now(), w)
+			})
+		},
+	},
+}
+
+// FormatNames returns the registered format names, sorted
+func FormatNames() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckFormat returns an error listing the valid formats if name isn't one
+func CheckFormat(name string) error {
+	if _, ok := formats[name]; !ok {
+		return fmt.Errorf("unknown format %q (valid: %s)", name, strings.Join(FormatNames(), ", "))
+	}
+	return nil
+}
+
+// NewFormatter returns the formatter registered under name and the file
+// name it writes to by default ("" for stdout)
+func NewFormatter(name string, opts PrintOptions) (Formatter, string, error) {
+	if err := CheckFormat(name); err != nil {
+		return nil, "", err
+	}
+	f := formats[name]
+	return f.new(opts), f.file, nil
+}
+
+// errWriter remembers the first write error so a formatter can write with
+// fmt.Fprintf throughout and check once at the end
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
@@ -0,0 +1,151 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewFormatter(t *testing.T) {
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/A.pm": {Path: "lib/A.pm", Statements: StatementCoverage{Covered: 1, Total: 2}},
+	}}
+	calculateSummary(report)
+
+	for _, name := range FormatNames() {
+		f, _, err := NewFormatter(name, PrintOptions{})
+		if err != nil {
+			t.Fatalf("NewFormatter(%q) error: %v", name, err)
+		}
+		var buf bytes.Buffer
+		if err := f.Write(report, &buf); err != nil {
+			t.Errorf("%s Write() error: %v", name, err)
+		}
+		if !strings.Contains(buf.String(), "lib/A.pm") {
+			t.Errorf("%s output lacks lib/A.pm:\n%s", name, buf.String())
+		}
+	}
+
+	if _, file, _ := NewFormatter("json", PrintOptions{}); file != "coverage.json" {
+		t.Errorf("json default file = %q, want coverage.json", file)
+	}
+	if _, file, _ := NewFormatter("text", PrintOptions{}); file != "" {
+		t.Errorf("text default file = %q, want stdout", file)
+	}
+}
+
+func TestNewFormatter_Unknown(t *testing.T) {
+	_, _, err := NewFormatter("lcov", PrintOptions{})
+	if err == nil {
+		t.Fatal("NewFormatter(\"lcov\") succeeded, want an error")
+	}
+	for _, name := range append([]string{"lcov"}, FormatNames()...) {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q doesn't mention %q", err, name)
+		}
+	}
+}
+
+func TestTextFormatterPathWidth(t *testing.T) {
+	long := "lib/" + strings.Repeat("Deep/", 12) + "Module.pm" // 73 characters
+	report := &Report{Files: map[string]*FileCoverage{
+		long:       {Statements: StatementCoverage{Covered: 1, Total: 1}},
+		"lib/A.pm": {Statements: StatementCoverage{Covered: 1, Total: 2}},
+	}}
+	calculateSummary(report)
+
+	tests := []struct {
+		name      string
+		opts      PrintOptions
+		wantPath  string
+		wantWidth int // of the path column
+	}{
+		{"default", PrintOptions{}, "..." + long[len(long)-55:], 60},
+		{"full paths", PrintOptions{FullPaths: true}, long, len(long) + 2},
+		{"max width", PrintOptions{FullPaths: true, MaxWidth: 20}, "..." + long[len(long)-17:], 22},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := (TextFormatter{Options: tt.opts}).Write(report, &buf); err != nil {
+			t.Fatalf("%s: Write() error: %v", tt.name, err)
+		}
+		lines := strings.Split(buf.String(), "\n")
+		// lines: "", header, rule, lib/A.pm, long path, rule, total
+		// Each metric header is right-aligned in " %10s"
+		if got := strings.Index(lines[1], "Stmt") - 7; got != tt.wantWidth {
+			t.Errorf("%s: path column is %d wide, want %d:\n%s", tt.name, got, tt.wantWidth, buf.String())
+		}
+		if !strings.HasPrefix(lines[4], tt.wantPath+"  ") {
+			t.Errorf("%s: row %q, want path %q", tt.name, lines[4], tt.wantPath)
+		}
+	}
+}
+
+func TestWriteSlowestFilesPathWidth(t *testing.T) {
+	long := "lib/" + strings.Repeat("Deep/", 12) + "Module.pm" // 73 characters
+	report := &Report{Files: map[string]*FileCoverage{
+		long:       {Path: long, TimeData: map[int]float64{3: 0.5, 9: 1.5}},
+		"lib/A.pm": {Path: "lib/A.pm", TimeData: map[int]float64{1: 0.25}},
+	}}
+
+	tests := []struct {
+		name     string
+		opts     PrintOptions
+		wantPath string
+	}{
+		{"default", PrintOptions{}, "..." + long[len(long)-55:]},
+		{"full paths", PrintOptions{FullPaths: true}, long},
+		{"max width", PrintOptions{FullPaths: true, MaxWidth: 20}, "..." + long[len(long)-17:]},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		writeSlowestFiles(report, 10, tt.opts, &buf)
+		lines := strings.Split(buf.String(), "\n")
+		// lines: "", title, header, rule, long path, lib/A.pm
+		wantRow := fmt.Sprintf("%-*s %9.4fs %10d", len(tt.wantPath)+2, tt.wantPath, 2.0, 9)
+		if lines[4] != wantRow {
+			t.Errorf("%s: row %q, want %q", tt.name, lines[4], wantRow)
+		}
+		if len(lines[2]) != len(lines[4]) || len(lines[3]) != len(lines[2]) {
+			t.Errorf("%s: header, rule and rows differ in width:\n%s", tt.name, buf.String())
+		}
+	}
+}
+
+func TestTextFormatterRule(t *testing.T) {
+	long := "lib/" + strings.Repeat("Deep/", 12) + "Module.pm"
+	tests := []struct {
+		name   string
+		report *Report
+		opts   PrintOptions
+	}{
+		{"statements only", &Report{Files: map[string]*FileCoverage{
+			"lib/A.pm": {Statements: StatementCoverage{Covered: 1, Total: 2}},
+		}, Criteria: []string{"statement"}}, PrintOptions{}},
+		{"all columns", &Report{Files: map[string]*FileCoverage{
+			"lib/A.pm": {Statements: StatementCoverage{Covered: 1, Total: 2}, Pod: PodCoverage{Covered: 1, Total: 1}},
+		}}, PrintOptions{Percent: PercentFormat{Precision: 4}}},
+		{"full paths", &Report{Files: map[string]*FileCoverage{
+			long: {Statements: StatementCoverage{Covered: 1, Total: 1}},
+		}}, PrintOptions{FullPaths: true}},
+	}
+	for _, tt := range tests {
+		calculateSummary(tt.report)
+		var buf bytes.Buffer
+		if err := (TextFormatter{Options: tt.opts}).Write(tt.report, &buf); err != nil {
+			t.Fatalf("%s: Write() error: %v", tt.name, err)
+		}
+		lines := strings.Split(buf.String(), "\n")
+		// lines: "", header, rule, row, rule, total
+		header, rule := lines[1], lines[2]
+		if strings.Trim(rule, "-") != "" || len(rule) != len(header) {
+			t.Errorf("%s: rule is %d wide, header %d:\n%s", tt.name, len(rule), len(header), buf.String())
+		}
+		for _, line := range lines[3:6] {
+			if len(line) != len(header) {
+				t.Errorf("%s: %q is %d wide, header %d", tt.name, line, len(line), len(header))
+			}
+		}
+	}
+}
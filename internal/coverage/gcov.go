@@ -0,0 +1,270 @@
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CoverageKind says how a file's coverage was measured
+type CoverageKind string
+
+const (
+	KindPerl CoverageKind = ""  // Devel::Cover (the default)
+	KindC    CoverageKind = "C" // gcov, for XS and other C code
+)
+
+var (
+	gcovLineRe     = regexp.MustCompile(`^\s*([^:\s]+):\s*(\d+):`)
+	gcovBranchRe   = regexp.MustCompile(`^branch\s+\d+\s+(?:taken (\d+)|never executed)`)
+	gcovFunctionRe = regexp.MustCompile(`^function (\S+) called (\d+)`)
+)
+
+// gcovFile accumulates gcov counts for one source file, summed over every
+// object file that compiled it (e.g. a header included by several XS files)
+type gcovFile struct {
+	lines     map[int]int    // line -> execution count, for executable lines
+	branches  map[int][]int  // line -> times each branch arc was taken
+	functions map[string]int // function -> times called
+}
+
+// CollectGcov runs gcov on every .gcda file under xsDir and returns the C
+// coverage of the source files under xsDir (system headers are left out).
+// gcov runs in each .gcda file's directory, where XS builds compile, so
+// relative source paths in the .gcno notes resolve.
+func CollectGcov(xsDir, gcovPath string) ([]*FileCoverage, error) {
+	root, err := filepath.Abs(xsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var gcdas []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".gcda") {
+			gcdas = append(gcdas, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(gcdas) == 0 {
+		return nil, fmt.Errorf("no .gcda files under %s (build the XS code with --coverage and run the tests first)", xsDir)
+	}
+
+	files := make(map[string]*gcovFile)
+	for _, gcda := range gcdas {
+		dir := filepath.Dir(gcda)
+		var stdout, stderr bytes.Buffer
+		// -t writes the annotated source to stdout instead of .gcov files
+		cmd := exec.Command(gcovPath, "-b", "-c", "-t", filepath.Base(gcda))
+		cmd.Dir = dir
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%s failed on %s: %w\n%s", gcovPath, gcda, err, stderr.String())
+		}
+		if err := parseGcov(&stdout, dir, files); err != nil {
+			return nil, fmt.Errorf("failed to parse gcov output for %s: %w", gcda, err)
+		}
+	}
+
+	var paths []string
+	for path := range files {
+		if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var result []*FileCoverage
+	for _, path := range paths {
+		result = append(result, files[path].toFileCoverage(path))
+	}
+	return result, nil
+}
+
+// RemoveGcda deletes the .gcda counter files under xsDir and returns how
+// many there were. Instrumented code adds to existing counters, so without
+// this a run's C coverage would include every previous run's.
+func RemoveGcda(xsDir string) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(xsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".gcda") {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// parseGcov adds gcov's text output (one or more annotated sources, as
+// written by gcov -b -c -t) to files, keyed by absolute source path.
+// Relative source paths are resolved against dir.
+func parseGcov(r io.Reader, dir string, files map[string]*gcovFile) error {
+	var cur *gcovFile
+	line := 0 // last source line seen, which branches belong to
+	// Branch arcs seen per line in the current source, so a file compiled
+	// into several objects sums each arc instead of repeating it
+	var arcs map[int]int
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+
+		if m := gcovLineRe.FindStringSubmatch(text); m != nil {
+			count, num := m[1], m[2]
+			if num == "0" {
+				// Header: a new source file starts at its Source: line
+				rest := strings.TrimSpace(text[len(m[0]):])
+				if src, ok := strings.CutPrefix(rest, "Source:"); ok {
+					if !filepath.IsAbs(src) {
+						src = filepath.Join(dir, src)
+					}
+					src = filepath.Clean(src)
+					if files[src] == nil {
+						files[src] = &gcovFile{
+							lines:     make(map[int]int),
+							branches:  make(map[int][]int),
+							functions: make(map[string]int),
+						}
+					}
+					cur = files[src]
+					arcs = make(map[int]int)
+				}
+				continue
+			}
+			if cur == nil {
+				return fmt.Errorf("source line before a Source: header: %q", text)
+			}
+			line, _ = strconv.Atoi(num)
+			switch count {
+			case "-":
+				// Not executable
+			case "#####", "=====", "$$$$$", "%%%%%":
+				// Never executed (the variants mark exceptional or partial blocks)
+				if _, ok := cur.lines[line]; !ok {
+					cur.lines[line] = 0
+				}
+			default:
+				hits, err := strconv.Atoi(strings.TrimSuffix(count, "*"))
+				if err != nil {
+					return fmt.Errorf("invalid execution count %q on line %d", count, line)
+				}
+				cur.lines[line] += hits
+			}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+
+		if m := gcovBranchRe.FindStringSubmatch(text); m != nil {
+			taken, _ := strconv.Atoi(m[1]) // "never executed" leaves m[1] empty
+			if i := arcs[line]; i < len(cur.branches[line]) {
+				cur.branches[line][i] += taken
+			} else {
+				cur.branches[line] = append(cur.branches[line], taken)
+			}
+			arcs[line]++
+		} else if m := gcovFunctionRe.FindStringSubmatch(text); m != nil {
+			calls, _ := strconv.Atoi(m[2])
+			cur.functions[m[1]] += calls
+		}
+	}
+	return scanner.Err()
+}
+
+// toFileCoverage converts the counts to a FileCoverage of kind KindC. Each
+// executable line is one statement and each branch arc one branch side;
+// arcs are paired into BranchHits in order, so a switch's odd last arc
+// counts toward the branch totals but has no Detail entry.
+func (g *gcovFile) toFileCoverage(path string) *FileCoverage {
+	fc := &FileCoverage{
+		Path: path,
+		Kind: KindC,
+		Statements: StatementCoverage{
+			lines:  make(map[int]int),
+			counts: make(map[int]int),
+			missed: make(map[int]int),
+		},
+	}
+
+	for line, hits := range g.lines {
+		fc.Statements.lines[line] = hits
+		fc.Statements.counts[line] = 1
+		fc.Statements.Total++
+		if hits > 0 {
+			fc.Statements.Covered++
+		} else {
+			fc.Statements.missed[line] = 1
+		}
+	}
+
+	var branchLines []int
+	for line := range g.branches {
+		branchLines = append(branchLines, line)
+	}
+	sort.Ints(branchLines)
+	for _, line := range branchLines {
+		arcs := g.branches[line]
+		missed := false
+		for _, taken := range arcs {
+			fc.Branches.Total++
+			if taken > 0 {
+				fc.Branches.Covered++
+			} else {
+				missed = true
+			}
+		}
+		for i := 0; i+1 < len(arcs); i += 2 {
+			fc.Branches.Detail = append(fc.Branches.Detail, BranchHit{Line: line, True: arcs[i], False: arcs[i+1]})
+		}
+		if missed {
+			fc.Branches.Uncovered = append(fc.Branches.Uncovered, line)
+		}
+	}
+
+	for _, calls := range g.functions {
+		fc.Subroutines.Total++
+		if calls > 0 {
+			fc.Subroutines.Covered++
+		}
+	}
+	return fc
+}
+
+// AddFiles adds coverage measured outside Devel::Cover, such as gcov's, to
+// the report. Paths are put in the report's path style and replace any
+// entry already there; the summary is recalculated.
+func (report *Report) AddFiles(files []*FileCoverage) {
+	style := report.PathStyle
+	if style == "" {
+		style = PathRel
+	}
+	for _, fc := range files {
+		fc.Path = styledPath(fc.Path, style, report.Root)
+		report.Files[fc.Path] = fc
+	}
+	calculateSummary(report)
+}
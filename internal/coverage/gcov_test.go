@@ -0,0 +1,142 @@
+package coverage
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const gcovOutput = `        -:    0:Source:src/foo.c
+        -:    0:Graph:foo.gcno
+        -:    0:Data:foo.gcda
+        -:    0:Runs:1
+        -:    1:#include <stdio.h>
+function helper called 1 returned 100% blocks executed 75%
+        1:    2:static int helper(int x) {
+        1:    3:    if (x > 2)
+branch  0 taken 0 (fallthrough)
+branch  1 taken 1
+    #####:    4:        return 1;
+       1*:    5:    return 0;
+        -:    6:}
+function unused called 0 returned 0% blocks executed 0%
+    #####:    7:static int unused(void) { return 5; }
+        1:    8:    switch (x) {
+branch  0 taken 1
+branch  1 never executed
+branch  2 taken 0
+call    0 returned 1
+        -:    0:Source:/usr/include/stdio.h
+        3:   10:extern int printf(const char *, ...);
+`
+
+func TestParseGcov(t *testing.T) {
+	files := make(map[string]*gcovFile)
+	if err := parseGcov(strings.NewReader(gcovOutput), "/project", files); err != nil {
+		t.Fatalf("parseGcov() error: %v", err)
+	}
+	if len(files) != 2 || files["/project/src/foo.c"] == nil || files["/usr/include/stdio.h"] == nil {
+		t.Fatalf("parsed sources %v, want /project/src/foo.c and /usr/include/stdio.h", files)
+	}
+
+	fc := files["/project/src/foo.c"].toFileCoverage("/project/src/foo.c")
+	if fc.Kind != KindC {
+		t.Errorf("Kind = %q, want %q", fc.Kind, KindC)
+	}
+	if fc.Statements.Covered != 4 || fc.Statements.Total != 6 {
+		t.Errorf("Statements = %d/%d, want 4/6", fc.Statements.Covered, fc.Statements.Total)
+	}
+	if fc.Statements.lines[5] != 1 || fc.Statements.lines[4] != 0 {
+		t.Errorf("line hits = %v, want line 5 run once and line 4 never", fc.Statements.lines)
+	}
+	if fc.Branches.Covered != 2 || fc.Branches.Total != 5 {
+		t.Errorf("Branches = %d/%d, want 2/5", fc.Branches.Covered, fc.Branches.Total)
+	}
+	// The switch's third arc has no pair, so only two BranchHits
+	if len(fc.Branches.Detail) != 2 || fc.Branches.Detail[1] != (BranchHit{Line: 8, True: 1, False: 0}) {
+		t.Errorf("Branches.Detail = %+v", fc.Branches.Detail)
+	}
+	if fc.Subroutines.Covered != 1 || fc.Subroutines.Total != 2 {
+		t.Errorf("Subroutines = %d/%d, want 1/2", fc.Subroutines.Covered, fc.Subroutines.Total)
+	}
+}
+
+func TestParseGcov_SumsObjects(t *testing.T) {
+	// A header compiled into two objects shows up once per object
+	section := "        -:    0:Source:inc.h\n" +
+		"function f called 1 returned 100% blocks executed 100%\n" +
+		"        1:    1:if (x)\n" +
+		"branch  0 taken 1\n" +
+		"branch  1 taken 0\n"
+	files := make(map[string]*gcovFile)
+	for i := 0; i < 2; i++ {
+		if err := parseGcov(strings.NewReader(section), "/p", files); err != nil {
+			t.Fatalf("parseGcov() error: %v", err)
+		}
+	}
+	g := files["/p/inc.h"]
+	if g.lines[1] != 2 || len(g.branches[1]) != 2 || g.branches[1][0] != 2 || g.functions["f"] != 2 {
+		t.Errorf("got lines %v, branches %v, functions %v, want counts summed", g.lines, g.branches, g.functions)
+	}
+}
+
+func TestCollectGcov(t *testing.T) {
+	for _, tool := range []string{"gcc", "gcov"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+
+	dir := t.TempDir()
+	src := "int half(int x) {\n  if (x > 1)\n    return x / 2;\n  return 0;\n}\nint main(void) { return half(4) - 2; }\n"
+	if err := os.WriteFile(filepath.Join(dir, "xs.c"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(name string, args ...string) {
+		t.Helper()
+		cmd := exec.Command(name, args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Skipf("%s failed: %v\n%s", name, err, out)
+		}
+	}
+	run("gcc", "--coverage", "-O0", "-o", "xs", "xs.c")
+	run(filepath.Join(dir, "xs"))
+
+	files, err := CollectGcov(dir, "gcov")
+	if err != nil {
+		t.Fatalf("CollectGcov() error: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != filepath.Join(dir, "xs.c") {
+		t.Fatalf("CollectGcov() = %+v, want only xs.c", files)
+	}
+	if fc := files[0]; fc.Statements.Covered == 0 || fc.Statements.Covered == fc.Statements.Total {
+		t.Errorf("Statements = %d/%d, want partial coverage", fc.Statements.Covered, fc.Statements.Total)
+	}
+
+	removed, err := RemoveGcda(dir)
+	if err != nil || removed != 1 {
+		t.Fatalf("RemoveGcda() = %d, %v, want 1", removed, err)
+	}
+	if _, err := CollectGcov(dir, "gcov"); err == nil {
+		t.Error("CollectGcov() without .gcda files succeeded")
+	}
+}
+
+func TestAddFiles(t *testing.T) {
+	report := &Report{
+		Root:  "/project",
+		Files: map[string]*FileCoverage{"lib/A.pm": {Path: "lib/A.pm", Statements: StatementCoverage{Covered: 1, Total: 2}}},
+	}
+	report.AddFiles([]*FileCoverage{{Path: "/project/Foo.c", Kind: KindC, Statements: StatementCoverage{Covered: 3, Total: 4}}})
+
+	fc := report.Files["Foo.c"]
+	if fc == nil || fc.Path != "Foo.c" || fc.Kind != KindC {
+		t.Fatalf("Files = %v, want Foo.c added with a relative path", report.Files)
+	}
+	if report.Summary.Statement < 66.6 || report.Summary.Statement > 66.7 {
+		t.Errorf("Summary.Statement = %.2f, want 4/6", report.Summary.Statement)
+	}
+}
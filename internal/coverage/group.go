@@ -0,0 +1,171 @@
+package coverage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DirGroup holds the rolled-up coverage of all files under a directory
+type DirGroup struct {
+	Dir      string        // Directory prefix with a trailing slash, or "./" for top-level files
+	Files    []string      // Paths of the files in the group, sorted
+	Coverage *FileCoverage // Summed counts and percentages of the files
+}
+
+// GroupByDir aggregates the report's files by their directory truncated to
+// depth components, e.g. depth 2 groups lib/App/Foo/Bar.pm under lib/App/.
+// Groups are sorted by directory.
+func GroupByDir(report *Report, depth int) []DirGroup {
+	byDir := make(map[string]*DirGroup)
+	for path, fc := range report.Files {
+		dir := groupDir(path, depth)
+		g, ok := byDir[dir]
+		if !ok {
+			g = &DirGroup{Dir: dir, Coverage: &FileCoverage{Path: dir}}
+			byDir[dir] = g
+		}
+		g.Files = append(g.Files, path)
+
+		sum := g.Coverage
+		sum.Statements.Covered += fc.Statements.Covered
+		sum.Statements.Total += fc.Statements.Total
+		sum.Branches.Covered += fc.Branches.Covered
+		sum.Branches.Total += fc.Branches.Total
+		sum.Conditions.Covered += fc.Conditions.Covered
+		sum.Conditions.Total += fc.Conditions.Total
+		sum.Conditions.Outcomes += fc.Conditions.Outcomes
+		sum.Conditions.OutcomesCovered += fc.Conditions.OutcomesCovered
+		sum.Subroutines.Covered += fc.Subroutines.Covered
+		sum.Subroutines.Total += fc.Subroutines.Total
+		sum.Pod.Covered += fc.Pod.Covered
+		sum.Pod.Total += fc.Pod.Total
+	}
+
+	var groups []DirGroup
+	for _, g := range byDir {
+		sort.Strings(g.Files)
+		sum := g.Coverage
+		sum.Statements.Percent = percent(sum.Statements.Covered, sum.Statements.Total)
+		sum.Branches.Percent = percent(sum.Branches.Covered, sum.Branches.Total)
+		sum.Conditions.Percent = percent(sum.Conditions.Covered, sum.Conditions.Total)
+		sum.Subroutines.Percent = percent(sum.Subroutines.Covered, sum.Subroutines.Total)
+		sum.Pod.Percent = percent(sum.Pod.Covered, sum.Pod.Total)
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Dir < groups[j].Dir
+	})
+	return groups
+}
+
+// groupDir returns the directory of path truncated to depth components.
+// Backslashes count as separators, as paths may come from Windows, and a
+// root or volume, e.g. / or C:/, prefixes the group without counting
+// toward depth.
+func groupDir(path string, depth int) string {
+	slashed := strings.ReplaceAll(path, "\\", "/")
+	var root string
+	if len(slashed) >= 2 && slashed[1] == ':' && unicode.IsLetter(rune(slashed[0])) {
+		root, slashed = slashed[:2], slashed[2:]
+	}
+	if trimmed := strings.TrimLeft(slashed, "/"); trimmed != slashed {
+		root, slashed = root+"/", trimmed
+	}
+
+	parts := strings.Split(slashed, "/")
+	parts = parts[:len(parts)-1] // Drop the file name
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	if len(parts) == 0 {
+		if root != "" {
+			return root
+		}
+		return "./"
+	}
+	return root + strings.Join(parts, "/") + "/"
+}
+
+// percent returns covered/total as a percentage, or 0 when total is 0
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+// PrintGroupedReport prints one row per directory group, optionally
+// followed by the group's files, and the totals over the whole report
+func PrintGroupedReport(report *Report, groups []DirGroup, showFiles bool, opts PrintOptions) {
+	// Directory labels leave room for their " (N files)" suffix and file
+	// labels for their indent
+	limit := opts.pathLimit()
+	dirLimit, fileLimit := 0, 0
+	if limit > 0 {
+		dirLimit, fileLimit = max(limit-12, 4), max(limit-2, 4)
+	}
+	labels := []string{"Directory", "Total"}
+	for _, g := range groups {
+		labels = append(labels, fmt.Sprintf("%s (%d files)", truncatePath(g.Dir, dirLimit), len(g.Files)))
+		if showFiles {
+			for _, path := range g.Files {
+				labels = append(labels, "  "+truncatePath(path, fileLimit))
+			}
+		}
+	}
+	labelWidth := labelColumnWidth(labels)
+
+	cols := reportColumns(report)
+	header := tableHeader("Directory", labelWidth, cols)
+	rule := strings.Repeat("-", len(header))
+
+	fmt.Printf("\n%s\n", header)
+	fmt.Println(rule)
+
+	printRow := func(label string, fc *FileCoverage) {
+		fmt.Printf("%-*s", labelWidth, label)
+		for _, c := range cols {
+			covered, total := c.counts(fc)
+			cell := fmt.Sprintf(" %10s", formatCoverage(covered, total, opts.Percent))
+			if opts.Color && total > 0 {
+				cell = opts.Thresholds.colorize(cell, percent(covered, total))
+			}
+			fmt.Print(cell)
+		}
+		fmt.Println()
+	}
+
+	next := 2 // labels[0:2] are the header and total
+	for _, g := range groups {
+		printRow(labels[next], g.Coverage)
+		next++
+		if showFiles {
+			for _, path := range g.Files {
+				printRow(labels[next], report.Files[path])
+				next++
+			}
+		}
+	}
+
+	fmt.Println(rule)
+	fmt.Printf("%-*s", labelWidth, "Total")
+	for _, c := range cols {
+		cell := fmt.Sprintf(" %10s", opts.Percent.Percent(c.summary))
+		if opts.Color {
+			cell = opts.Thresholds.colorize(cell, c.summary)
+		}
+		fmt.Print(cell)
+	}
+	fmt.Println()
+}
+
+// truncatePath shortens path to at most max characters, keeping the end;
+// max <= 0 leaves it whole
+func truncatePath(path string, max int) string {
+	if max <= 0 || len(path) <= max {
+		return path
+	}
+	return "..." + path[len(path)-(max-3):]
+}
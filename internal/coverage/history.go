@@ -0,0 +1,151 @@
+package coverage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// HistoryEntry is one run's summary, stored as a JSON line in the history
+// file written by --history
+type HistoryEntry struct {
+	Time       time.Time `json:"time"`
+	Commit     string    `json:"commit,omitempty"` // git HEAD, empty outside a repository
+	Statement  float64   `json:"statement"`
+	Branch     float64   `json:"branch"`
+	Condition  float64   `json:"condition"`
+	Subroutine float64   `json:"subroutine"`
+	Combined   float64   `json:"combined"`
+	Files      int       `json:"files"`
+}
+
+// NewHistoryEntry records the report's summary as of the given time
+func NewHistoryEntry(report *Report, at time.Time, commit string) HistoryEntry {
+	s := report.Summary
+	return HistoryEntry{
+		Time:       at.UTC(),
+		Commit:     commit,
+		Statement:  s.Statement,
+		Branch:     s.Branch,
+		Condition:  s.Condition,
+		Subroutine: s.Subroutine,
+		Combined:   s.Combined,
+		Files:      s.TotalFiles,
+	}
+}
+
+// AppendHistory appends the entry to the history file at path, creating it
+// if needed. The line is written with a single O_APPEND write, so runs
+// appending at the same time can't interleave or overwrite each other.
+func AppendHistory(path string, entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadHistory reads the entries of a history file, oldest first. Blank
+// lines are skipped.
+func ReadHistory(r io.Reader) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var e HistoryEntry
+		if err := json.Unmarshal([]byte(text), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// sparkTicks are the bar heights used by sparkline, lowest first
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws one bar per value, scaled between the smallest and
+// largest value so small drifts stay visible. A flat series is drawn at
+// mid height.
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo = min(lo, v)
+		hi = max(hi, v)
+	}
+
+	var sb strings.Builder
+	for _, v := range values {
+		i := len(sparkTicks) / 2
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparkTicks)-1))
+		}
+		sb.WriteRune(sparkTicks[i])
+	}
+	return sb.String()
+}
+
+// PrintHistory prints a sparkline per metric over all entries, then a table
+// of the last n runs (all of them if n <= 0), with percentages shown in pct
+func PrintHistory(entries []HistoryEntry, n int, pct PercentFormat) {
+	if len(entries) == 0 {
+		fmt.Println("No coverage history yet")
+		return
+	}
+
+	metrics := []struct {
+		name  string
+		value func(HistoryEntry) float64
+	}{
+		{"Statement", func(e HistoryEntry) float64 { return e.Statement }},
+		{"Branch", func(e HistoryEntry) float64 { return e.Branch }},
+		{"Condition", func(e HistoryEntry) float64 { return e.Condition }},
+		{"Subroutine", func(e HistoryEntry) float64 { return e.Subroutine }},
+	}
+	first, last := entries[0], entries[len(entries)-1]
+	for _, m := range metrics {
+		values := make([]float64, len(entries))
+		for i, e := range entries {
+			values[i] = m.value(e)
+		}
+		fmt.Printf("%-12s %s  %s → %s (%s)\n", m.name+":", sparkline(values),
+			pct.Percent(m.value(first)), pct.Percent(m.value(last)), pct.Delta(m.value(last)-m.value(first)))
+	}
+
+	recent := entries
+	if n > 0 && len(recent) > n {
+		recent = recent[len(recent)-n:]
+	}
+	fmt.Println()
+	// Columns fit the widest percentage, 100%
+	w := max(len(pct.Percent(100)), 6)
+	fmt.Printf("%-16s  %-8s  %*s  %*s  %*s  %*s\n", "Time (UTC)", "Commit", w, "Stmt", w, "Bran", w, "Cond", w, "Sub")
+	for _, e := range recent {
+		commit := e.Commit
+		if len(commit) > 8 {
+			commit = commit[:8]
+		}
+		fmt.Printf("%-16s  %-8s  %*s  %*s  %*s  %*s\n", e.Time.UTC().Format("2006-01-02 15:04"), commit,
+			w, pct.Percent(e.Statement), w, pct.Percent(e.Branch), w, pct.Percent(e.Condition), w, pct.Percent(e.Subroutine))
+	}
+}
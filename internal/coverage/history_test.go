@@ -0,0 +1,77 @@
+package coverage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppendHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	report := &Report{Summary: CoverageSummary{Statement: 80, Branch: 50, TotalFiles: 3}}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Concurrent appends must each land on their own line
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := AppendHistory(path, NewHistoryEntry(report, at, "abc123")); err != nil {
+				t.Errorf("AppendHistory() error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := ReadHistory(f)
+	if err != nil {
+		t.Fatalf("ReadHistory() error: %v", err)
+	}
+	if len(entries) != 20 {
+		t.Fatalf("read %d entries, want 20", len(entries))
+	}
+	want := HistoryEntry{Time: at, Commit: "abc123", Statement: 80, Branch: 50, Files: 3}
+	if entries[0] != want {
+		t.Errorf("entry = %+v, want %+v", entries[0], want)
+	}
+}
+
+func TestReadHistory_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, []byte("{\"statement\": 1}\n\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := ReadHistory(f); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("ReadHistory() error = %v, want a line 3 error", err)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		values   []float64
+		expected string
+	}{
+		{nil, ""},
+		{[]float64{50, 50}, "▅▅"},
+		{[]float64{70, 80, 75}, "▁█▄"},
+	}
+	for _, tt := range tests {
+		if got := sparkline(tt.values); got != tt.expected {
+			t.Errorf("sparkline(%v) = %q, want %q", tt.values, got, tt.expected)
+		}
+	}
+}
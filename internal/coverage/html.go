@@ -0,0 +1,159 @@
+package coverage
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+// htmlCell is a single coverage value in the HTML report
+type htmlCell struct {
+	Text  string
+	Class string // high, medium, low or na
+}
+
+// htmlRow is a per-file row on the index page
+type htmlRow struct {
+	Path  string
+	Link  string
+	Cells []htmlCell
+}
+
+// htmlIndex is the data for the index page
+type htmlIndex struct {
+	Headers []string
+	Rows    []htmlRow
+	Totals  []htmlCell
+}
+
+// htmlLine is a single annotated source line
+type htmlLine struct {
+	Number    int
+	Text      string
+	Uncovered bool
+}
+
+// htmlFile is the data for a per-file source page
+type htmlFile struct {
+	Path    string
+	Headers []string
+	Cells   []htmlCell
+	Lines   []htmlLine
+	Error   string
+}
+
+// GenerateNativeHTML renders an HTML report from the parsed report into
+// outDir without using the cover command: an index.html with the per-file
+// table plus one annotated source page per file highlighting uncovered
+// lines. Source files are read from disk by their report path.
+func GenerateNativeHTML(report *Report, outDir string) error {
+	tmpl, err := template.ParseFS(templatesFS, "templates/*.html")
+	if err != nil {
+		return fmt.Errorf("failed to parse HTML templates: %w", err)
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("failed to create HTML output directory: %w", err)
+	}
+
+	var paths []string
+	for path := range report.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	cols := reportColumns(report)
+	var headers []string
+	for _, c := range cols {
+		headers = append(headers, c.header)
+	}
+
+	index := htmlIndex{Headers: headers}
+	for _, c := range cols {
+		index.Totals = append(index.Totals, htmlCell{
+			Text:  DefaultPercentFormat.Percent(c.summary),
+			Class: coverageClass(c.summary),
+		})
+	}
+
+	for _, path := range paths {
+		fc := report.Files[path]
+		var cells []htmlCell
+		for _, c := range cols {
+			covered, total := c.counts(fc)
+			cell := htmlCell{Text: formatCoverage(covered, total, DefaultPercentFormat), Class: "na"}
+			if total > 0 {
+				cell.Class = coverageClass(float64(covered) / float64(total) * 100)
+			}
+			cells = append(cells, cell)
+		}
+
+		link := htmlPageName(path)
+		index.Rows = append(index.Rows, htmlRow{Path: path, Link: link, Cells: cells})
+
+		page := htmlFile{Path: path, Headers: headers, Cells: cells}
+		page.Lines, err = annotateSource(report.SourcePath(path), fc.Statements.Uncovered)
+		if err != nil {
+			page.Error = err.Error()
+		}
+		if err := writeTemplate(tmpl, "file.html", filepath.Join(outDir, link), page); err != nil {
+			return err
+		}
+	}
+
+	return writeTemplate(tmpl, "index.html", filepath.Join(outDir, "index.html"), index)
+}
+
+// writeTemplate renders the named template to path
+func writeTemplate(tmpl *template.Template, name, path string, data interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := tmpl.ExecuteTemplate(f, name, data); err != nil {
+		return fmt.Errorf("failed to render %s: %w", path, err)
+	}
+	return nil
+}
+
+// annotateSource reads a source file and marks its uncovered lines
+func annotateSource(path string, uncovered []int) ([]htmlLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	uncoveredSet := make(map[int]bool, len(uncovered))
+	for _, line := range uncovered {
+		uncoveredSet[line] = true
+	}
+
+	var lines []htmlLine
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		lines = append(lines, htmlLine{Number: n, Text: scanner.Text(), Uncovered: uncoveredSet[n]})
+	}
+	return lines, scanner.Err()
+}
+
+// htmlPageName returns the per-file page name for a source path
+func htmlPageName(path string) string {
+	name := strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(path)
+	return strings.TrimLeft(name, "-.") + ".html"
+}
+
+// coverageClass returns the CSS class for a coverage percentage
+func coverageClass(pct float64) string {
+	return DefaultThresholds.Level(pct)
+}
@@ -0,0 +1,62 @@
+package coverage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateNativeHTML(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "Foo.pm")
+	if err := os.WriteFile(src, []byte("package Foo;\nsub bar { 1 < 2 }\n1;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report := &Report{
+		Files: map[string]*FileCoverage{
+			src: {
+				Path:       src,
+				Statements: StatementCoverage{Covered: 2, Total: 3, lines: map[int]int{2: 0}},
+			},
+		},
+	}
+	calculateSummary(report)
+
+	outDir := filepath.Join(dir, "html")
+	if err := GenerateNativeHTML(report, outDir); err != nil {
+		t.Fatalf("GenerateNativeHTML() error: %v", err)
+	}
+
+	index, err := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if err != nil {
+		t.Fatalf("index.html not written: %v", err)
+	}
+	page := htmlPageName(src)
+	if !strings.Contains(string(index), `href="`+page+`"`) {
+		t.Errorf("index.html does not link to %s", page)
+	}
+
+	filePage, err := os.ReadFile(filepath.Join(outDir, page))
+	if err != nil {
+		t.Fatalf("%s not written: %v", page, err)
+	}
+	// Source must be escaped and the uncovered line marked
+	if !strings.Contains(string(filePage), `<tr class="uncovered"><td class="num">2</td><td>sub bar { 1 &lt; 2 }</td></tr>`) {
+		t.Errorf("file page does not mark line 2 as uncovered:\n%s", filePage)
+	}
+}
+
+func TestHTMLPageName(t *testing.T) {
+	tests := map[string]string{
+		"lib/Foo/Bar.pm":  "lib-Foo-Bar.pm.html",
+		"/abs/lib/Foo.pm": "abs-lib-Foo.pm.html",
+		"./lib/Foo.pm":    "lib-Foo.pm.html",
+	}
+	for in, want := range tests {
+		if got := htmlPageName(in); got != want {
+			t.Errorf("htmlPageName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package coverage
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// jsonReport is the stable JSON schema written by WriteJSON.
+// It is kept separate from Report so internal fields can change freely.
+type jsonReport struct {
+	Summary jsonSummary `json:"summary"`
+	Files   []jsonFile  `json:"files"`
+}
+
+// jsonSummary mirrors CoverageSummary, including normalization flags
+type jsonSummary struct {
+	Statement    float64   `json:"statement"`
+	Branch       float64   `json:"branch"`
+	Condition    float64   `json:"condition"`
+	Subroutine   float64   `json:"subroutine"`
+	Pod          float64   `json:"pod"`
+	Combined     float64   `json:"combined"`
+	TotalFiles   int       `json:"total_files"`
+	CoveredFiles int       `json:"covered_files"`
+	Sonar        jsonSonar `json:"sonar"`
+
+	Normalized          bool `json:"normalized"`
+	ConditionsAbsorbed  bool `json:"conditions_absorbed"`
+	SubroutinesAbsorbed bool `json:"subroutines_absorbed"`
+}
+
+// jsonSonar holds the raw inputs of the combined coverage
+type jsonSonar struct {
+	ConditionsTrue  int `json:"conditions_true"`
+	ConditionsFalse int `json:"conditions_false"`
+	Conditions      int `json:"conditions"`
+	LinesCovered    int `json:"lines_covered"`
+	Lines           int `json:"lines"`
+}
+
+// jsonMetric holds covered/total/percent for a single metric
+type jsonMetric struct {
+	Covered int     `json:"covered"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+// jsonStatementMetric adds the uncovered line list to jsonMetric
+type jsonStatementMetric struct {
+	jsonMetric
+	Uncovered []int `json:"uncovered"`
+}
+
+// jsonSubMetric adds the uncalled subroutines to jsonMetric
+type jsonSubMetric struct {
+	jsonMetric
+	Uncovered []SubInfo `json:"uncovered"`
+}
+
+// jsonCondMetric adds the partially covered conditions to jsonMetric
+type jsonCondMetric struct {
+	jsonMetric
+	Partial []CondInfo `json:"partial"`
+}
+
+// jsonFile holds per-file coverage detail
+type jsonFile struct {
+	Path       string              `json:"path"`
+	Kind       string              `json:"kind,omitempty"` // "C" for gcov-measured XS code
+	Statement  jsonStatementMetric `json:"statement"`
+	Branch     jsonMetric          `json:"branch"`
+	Condition  jsonCondMetric      `json:"condition"`
+	Subroutine jsonSubMetric       `json:"subroutine"`
+	Pod        jsonMetric          `json:"pod"`
+}
+
+// toJSONReport converts a Report to the stable JSON schema
+func toJSONReport(report *Report) *jsonReport {
+	s := report.Summary
+	out := &jsonReport{
+		Summary: jsonSummary{
+			Statement:           s.Statement,
+			Branch:              s.Branch,
+			Condition:           s.Condition,
+			Subroutine:          s.Subroutine,
+			Pod:                 s.Pod,
+			Combined:            s.Combined,
+			TotalFiles:          s.TotalFiles,
+			CoveredFiles:        s.CoveredFiles,
+			Sonar:               jsonSonar(s.Sonar),
+			Normalized:          s.Normalized,
+			ConditionsAbsorbed:  s.ConditionsAbsorbed,
+			SubroutinesAbsorbed: s.SubroutinesAbsorbed,
+		},
+		Files: []jsonFile{},
+	}
+
+	var paths []string
+	for path := range report.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		fc := report.Files[path]
+		uncovered := fc.Statements.Uncovered
+		if uncovered == nil {
+			uncovered = []int{}
+		}
+		uncoveredSubs := fc.Subroutines.Uncovered
+		if uncoveredSubs == nil {
+			uncoveredSubs = []SubInfo{}
+		}
+		partialConds := fc.Conditions.Partial
+		if partialConds == nil {
+			partialConds = []CondInfo{}
+		}
+		out.Files = append(out.Files, jsonFile{
+			Path: path,
+			Kind: string(fc.Kind),
+			Statement: jsonStatementMetric{
+				jsonMetric: jsonMetric{fc.Statements.Covered, fc.Statements.Total, fc.Statements.Percent},
+				Uncovered:  uncovered,
+			},
+			Branch: jsonMetric{fc.Branches.Covered, fc.Branches.Total, fc.Branches.Percent},
+			Condition: jsonCondMetric{
+				jsonMetric: jsonMetric{fc.Conditions.Covered, fc.Conditions.Total, fc.Conditions.Percent},
+				Partial:    partialConds,
+			},
+			Subroutine: jsonSubMetric{
+				jsonMetric: jsonMetric{fc.Subroutines.Covered, fc.Subroutines.Total, fc.Subroutines.Percent},
+				Uncovered:  uncoveredSubs,
+			},
+			Pod: jsonMetric{fc.Pod.Covered, fc.Pod.Total, fc.Pod.Percent},
+		})
+	}
+
+	return out
+}
+
+// WriteJSON writes the full coverage report as indented JSON
+func WriteJSON(report *Report, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(toJSONReport(report))
+}
+
+// ReadJSON reads a report previously written by WriteJSON. Per-line hit
+// counts are not part of the schema, so only the totals, percentages and
+// uncovered lines are restored.
+func ReadJSON(r io.Reader) (*Report, error) {
+	var in jsonReport
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, err
+	}
+
+	s := in.Summary
+	report := &Report{
+		Files: make(map[string]*FileCoverage, len(in.Files)),
+		Summary: CoverageSummary{
+			Statement:           s.Statement,
+			Branch:              s.Branch,
+			Condition:           s.Condition,
+			Subroutine:          s.Subroutine,
+			Pod:                 s.Pod,
+			Combined:            s.Combined,
+			TotalFiles:          s.TotalFiles,
+			CoveredFiles:        s.CoveredFiles,
+			Sonar:               SonarCounts(s.Sonar),
+			Normalized:          s.Normalized,
+			ConditionsAbsorbed:  s.ConditionsAbsorbed,
+			SubroutinesAbsorbed: s.SubroutinesAbsorbed,
+		},
+	}
+
+	for _, f := range in.Files {
+		report.Files[f.Path] = &FileCoverage{
+			Path: f.Path,
+			Kind: CoverageKind(f.Kind),
+			Statements: StatementCoverage{
+				Covered:   f.Statement.Covered,
+				Total:     f.Statement.Total,
+				Percent:   f.Statement.Percent,
+				Uncovered: f.Statement.Uncovered,
+			},
+			Branches: BranchCoverage{Covered: f.Branch.Covered, Total: f.Branch.Total, Percent: f.Branch.Percent},
+			Conditions: ConditionCoverage{
+				Covered: f.Condition.Covered,
+				Total:   f.Condition.Total,
+				Percent: f.Condition.Percent,
+				Partial: f.Condition.Partial,
+			},
+			Subroutines: SubroutineCoverage{
+				Covered:   f.Subroutine.Covered,
+				Total:     f.Subroutine.Total,
+				Percent:   f.Subroutine.Percent,
+				Uncovered: f.Subroutine.Uncovered,
+			},
+			Pod: PodCoverage{Covered: f.Pod.Covered, Total: f.Pod.Total, Percent: f.Pod.Percent},
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,93 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	report := &Report{
+		Files: map[string]*FileCoverage{
+			"lib/B.pm": {
+				Path:       "lib/B.pm",
+				Statements: StatementCoverage{Covered: 1, Total: 2, lines: map[int]int{7: 0}},
+			},
+			"lib/A.pm": {
+				Path:       "lib/A.pm",
+				Statements: StatementCoverage{Covered: 2, Total: 2, lines: map[int]int{}},
+				Branches:   BranchCoverage{Covered: 1, Total: 2},
+				Conditions: ConditionCoverage{Covered: 1, Total: 2, Partial: []CondInfo{{Line: 4, Missed: []string{"false"}}}},
+			},
+		},
+	}
+	calculateSummary(report)
+	report.Summary.ConditionsAbsorbed = true
+
+	var buf bytes.Buffer
+	if err := WriteJSON(report, &buf); err != nil {
+		t.Fatalf("WriteJSON() error: %v", err)
+	}
+
+	var got jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+
+	if len(got.Files) != 2 {
+		t.Fatalf("len(Files) = %d, want 2", len(got.Files))
+	}
+	// Files should be sorted by path
+	if got.Files[0].Path != "lib/A.pm" {
+		t.Errorf("Files[0].Path = %q, want lib/A.pm", got.Files[0].Path)
+	}
+	if got.Files[0].Branch.Percent != 50.0 {
+		t.Errorf("Files[0].Branch.Percent = %f, want 50.0", got.Files[0].Branch.Percent)
+	}
+	if p := got.Files[0].Condition.Partial; len(p) != 1 || p[0].Line != 4 || p[0].Missed[0] != "false" {
+		t.Errorf("Files[0].Condition.Partial = %v, want line 4 missing false", p)
+	}
+	if got.Files[1].Condition.Partial == nil {
+		t.Error("Files[1].Condition.Partial = null, want []")
+	}
+	if len(got.Files[1].Statement.Uncovered) != 1 || got.Files[1].Statement.Uncovered[0] != 7 {
+		t.Errorf("Files[1].Statement.Uncovered = %v, want [7]", got.Files[1].Statement.Uncovered)
+	}
+	if got.Summary.Statement != 75.0 {
+		t.Errorf("Summary.Statement = %f, want 75.0", got.Summary.Statement)
+	}
+	if !got.Summary.ConditionsAbsorbed {
+		t.Error("Summary.ConditionsAbsorbed = false, want true")
+	}
+}
+
+func TestTestAttribution(t *testing.T) {
+	a := NewTestAttribution()
+	a.Add("t/b.t", &Report{Files: map[string]*FileCoverage{
+		"lib/Foo.pm": {Statements: StatementCoverage{Covered: 3, Total: 10}},
+		"lib/Bar.pm": {Statements: StatementCoverage{Covered: 0, Total: 5}},
+	}})
+	a.Add("t/a.t", &Report{Files: map[string]*FileCoverage{
+		"lib/Foo.pm": {Statements: StatementCoverage{Covered: 1, Total: 10}},
+	}})
+
+	var buf bytes.Buffer
+	if err := WritePerTestJSON(a, &buf); err != nil {
+		t.Fatalf("WritePerTestJSON() error: %v", err)
+	}
+	var got TestAttribution
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+
+	// Files with no covered statements are not attributed to the test
+	if len(got.Tests["t/b.t"]) != 1 || got.Tests["t/b.t"][0].Path != "lib/Foo.pm" {
+		t.Errorf("Tests[t/b.t] = %v, want only lib/Foo.pm", got.Tests["t/b.t"])
+	}
+	if tests := got.Files["lib/Foo.pm"]; len(tests) != 2 || tests[0] != "t/a.t" {
+		t.Errorf("Files[lib/Foo.pm] = %v, want [t/a.t t/b.t]", tests)
+	}
+	if _, ok := got.Files["lib/Bar.pm"]; ok {
+		t.Error("lib/Bar.pm should not be attributed to any test")
+	}
+}
@@ -0,0 +1,84 @@
+package coverage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StaleLockAge is how old a .lock file in a coverage database must be
+// before it is taken to be left over from a crashed run
+const StaleLockAge = 10 * time.Minute
+
+// FindStaleLocks returns the .lock files under coverDir last modified
+// before now-StaleLockAge. A lock whose content is a process ID is only
+// stale once that process has exited, so a long-running concurrent run
+// keeps its locks.
+func FindStaleLocks(coverDir string, now time.Time) ([]string, error) {
+	var stale []string
+	err := filepath.WalkDir(coverDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // released while walking
+			}
+			return err
+		}
+		if now.Sub(info.ModTime()) < StaleLockAge {
+			return nil
+		}
+		if pid, ok := lockPID(path); ok && processAlive(pid) {
+			return nil
+		}
+		stale = append(stale, path)
+		return nil
+	})
+	return stale, err
+}
+
+// RemoveLocks deletes the given lock files; ones already gone are ignored
+func RemoveLocks(paths []string) error {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockPID returns the process ID written in a lock file, if it holds one
+func lockPID(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	return pid, true
+}
+
+// processAlive reports whether a process with the given ID is running.
+// Signal 0 checks for the process without signaling it; EPERM means it
+// exists but belongs to another user. Platforms without signal 0 report
+// false, leaving the age check to decide.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
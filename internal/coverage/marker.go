@@ -0,0 +1,101 @@
+package coverage
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultUncoverableMarker matches Devel::Cover's "# uncoverable statement"
+// comments. Its "# uncoverable branch" and "# uncoverable condition"
+// annotations name other criteria, so they don't leave statements out.
+const DefaultUncoverableMarker = `#\s*uncoverable\s+statement\b`
+
+// MarkerLines is how many lines at the top of a source file are searched
+// for an exclusion marker
+const MarkerLines = 20
+
+// MarkedFiles returns the report's files whose first MarkerLines lines
+// match marker, e.g. a "GENERATED FILE - DO NOT EDIT" header. Files that
+// can't be read are not marked; their errors are returned so callers can
+// warn.
+func MarkedFiles(report *Report, marker *regexp.Regexp) (map[string]bool, []error) {
+	marked := make(map[string]bool)
+	var errs []error
+	for path := range report.Files {
+		found, err := HeadMatches(report.SourcePath(path), marker)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if found {
+			marked[path] = true
+		}
+	}
+	return marked, errs
+}
+
+// HeadMatches reports whether any of the first MarkerLines lines of path
+// match re
+func HeadMatches(path string, re *regexp.Regexp) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 0; i < MarkerLines && scanner.Scan(); i++ {
+		if re.Match(scanner.Bytes()) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// ExcludeUncoverable drops uncovered statement lines whose source matches
+// marker, e.g. a defensive die with a trailing "# uncoverable statement"
+// comment: they leave Uncovered and their statements leave the statement
+// total. It returns the number of lines dropped, and the errors for files that
+// couldn't be read, which are left as they are. It must be called before
+// Normalize.
+func (report *Report) ExcludeUncoverable(marker *regexp.Regexp) (int, []error) {
+	dropped := 0
+	var errs []error
+	for path, fc := range report.Files {
+		if len(fc.Statements.Uncovered) == 0 {
+			continue
+		}
+		data, err := os.ReadFile(report.SourcePath(path))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		source := strings.Split(string(data), "\n")
+		for _, line := range fc.Statements.Uncovered {
+			if line < 1 || line > len(source) || !marker.MatchString(source[line-1]) {
+				continue
+			}
+			statements := fc.Statements.counts[line]
+			if fc.Statements.lines[line] > 0 {
+				// Only the line's statements that never ran leave the total
+				statements = fc.Statements.missed[line]
+				fc.Statements.counts[line] -= statements
+			} else {
+				if statements == 0 {
+					statements = 1
+				}
+				delete(fc.Statements.lines, line)
+				delete(fc.Statements.counts, line)
+			}
+			delete(fc.Statements.missed, line)
+			fc.Statements.Total -= statements
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		calculateSummary(report)
+	}
+	return dropped, errs
+}
@@ -0,0 +1,222 @@
+package coverage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Path styles for Report.Files keys
+const (
+	PathRel = "rel" // Relative to Report.Root where possible (default)
+	PathAbs = "abs" // Absolute
+)
+
+// ValidPathStyles lists the accepted --path-style values
+var ValidPathStyles = []string{PathRel, PathAbs}
+
+// SetPathStyle rewrites the report's file paths in style, relative to or
+// joined with report.Root. With PathRel, files outside Root keep their
+// absolute path. If two recorded paths name the same file, e.g. one run
+// loaded it through an absolute -I and another through lib, their entries
+// are merged (see mergeFileCoverage). It must be called before Normalize.
+func (report *Report) SetPathStyle(style string) {
+	report.PathStyle = style
+	files := make(map[string]*FileCoverage, len(report.Files))
+	for path, fc := range report.Files {
+		path = styledPath(path, style, report.Root)
+		if existing := files[path]; existing != nil {
+			mergeFileCoverage(existing, fc)
+			continue
+		}
+		fc.Path = path
+		files[path] = fc
+	}
+	dropped := len(report.Files) != len(files)
+	report.Files = files
+	if dropped {
+		calculateSummary(report)
+	}
+}
+
+// mergeFileCoverage adds src's hits to dst, two entries recorded for the
+// same source file. Statement and branch hits are summed and the covered
+// counts raised by what ran only in src; subroutines called in either
+// count as called. Condition and pod coverage, and any criterion one of
+// the entries has no detail for (e.g. from a summary-only parse), keep the
+// entry covering more.
+func mergeFileCoverage(dst, src *FileCoverage) {
+	mergeStatements(&dst.Statements, &src.Statements)
+
+	if b, s := &dst.Branches, &src.Branches; len(b.Detail) == len(s.Detail) && len(b.Detail) > 0 {
+		for i, hit := range s.Detail {
+			d := &b.Detail[i]
+			if d.True == 0 && hit.True > 0 {
+				b.Covered++
+			}
+			if d.False == 0 && hit.False > 0 {
+				b.Covered++
+			}
+			d.True += hit.True
+			d.False += hit.False
+		}
+		b.Covered = min(b.Covered, b.Total)
+		b.Uncovered = uncoveredBranchLines(b.Detail)
+	} else if s.Covered > b.Covered {
+		*b = *s
+	}
+
+	if src.Conditions.Covered > dst.Conditions.Covered {
+		dst.Conditions = src.Conditions
+	}
+	mergeSubroutines(&dst.Subroutines, &src.Subroutines)
+	if src.Pod.Covered > dst.Pod.Covered {
+		dst.Pod = src.Pod
+	}
+
+	for line, secs := range src.TimeData {
+		if dst.TimeData == nil {
+			dst.TimeData = make(map[int]float64)
+		}
+		dst.TimeData[line] += secs
+	}
+}
+
+// mergeStatements adds src's statement hits to dst. A statement counts as
+// run if it ran in either, so a line keeps the fewer of its missed
+// statements.
+func mergeStatements(dst, src *StatementCoverage) {
+	if dst.missed == nil || src.missed == nil {
+		if src.Covered > dst.Covered {
+			*dst = *src
+		}
+		return
+	}
+	before := 0
+	for _, n := range dst.missed {
+		before += n
+	}
+	for line, hits := range src.lines {
+		dst.lines[line] += hits
+		dst.counts[line] = max(dst.counts[line], src.counts[line])
+	}
+	after := 0
+	for line, n := range dst.missed {
+		n = min(n, src.missed[line])
+		if n == 0 {
+			delete(dst.missed, line)
+			continue
+		}
+		dst.missed[line] = n
+		after += n
+	}
+	dst.Covered += before - after
+}
+
+// mergeSubroutines marks dst's subroutines called in src as called
+func mergeSubroutines(dst, src *SubroutineCoverage) {
+	if len(dst.Called)+len(dst.Uncovered) != dst.Total || len(src.Called)+len(src.Uncovered) != src.Total {
+		if src.Covered > dst.Covered {
+			*dst = *src
+		}
+		return
+	}
+	called := make(map[SubInfo]bool, len(src.Called))
+	for _, sub := range src.Called {
+		called[sub] = true
+	}
+	var uncovered []SubInfo
+	for _, sub := range dst.Uncovered {
+		if called[sub] {
+			dst.Called = append(dst.Called, sub)
+			dst.Covered++
+		} else {
+			uncovered = append(uncovered, sub)
+		}
+	}
+	dst.Uncovered = uncovered
+	sort.SliceStable(dst.Called, func(i, j int) bool { return dst.Called[i].Line < dst.Called[j].Line })
+}
+
+// SourcePath returns where the source file the report calls path is on
+// disk: path joined with Root when it is relative
+func (report *Report) SourcePath(path string) string {
+	return styledPath(path, PathAbs, report.Root)
+}
+
+// styledPath converts path to style relative to root
+func styledPath(path, style, root string) string {
+	if root == "" {
+		return path
+	}
+	switch style {
+	case PathAbs:
+		if !filepath.IsAbs(path) {
+			return filepath.Join(root, path)
+		}
+	case PathRel:
+		if filepath.IsAbs(path) {
+			rel, err := filepath.Rel(root, path)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return rel
+			}
+		}
+	}
+	return path
+}
+
+// PathMapping rewrites report paths under From to the same path under To,
+// e.g. blib/lib to lib for tests run against a built distribution
+type PathMapping struct {
+	From string
+	To   string
+}
+
+// ParsePathMapping parses a mapping written as from=to, e.g. "blib/lib=lib"
+func ParsePathMapping(s string) (PathMapping, error) {
+	from, to, ok := strings.Cut(s, "=")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !ok || from == "" || to == "" {
+		return PathMapping{}, fmt.Errorf("want <from>=<to>, got %q", s)
+	}
+	return PathMapping{From: filepath.Clean(from), To: filepath.Clean(to)}, nil
+}
+
+// apply returns path rewritten by m, and whether m applies to it. A
+// mapping matches whole path components: blib/lib maps blib/lib/Foo.pm
+// but not blib/libfoo/Foo.pm.
+func (m PathMapping) apply(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, m.From)
+	if !ok || (rest != "" && rest[0] != filepath.Separator) {
+		return path, false
+	}
+	return m.To + rest, true
+}
+
+// MapPaths rewrites the report's file paths by the first of mappings that
+// matches each, so coverage recorded for built copies is reported under
+// their sources. As with SetPathStyle, if a mapped path is already in the
+// report the two entries are merged. It must be called before Normalize.
+func (report *Report) MapPaths(mappings []PathMapping) {
+	if len(mappings) == 0 {
+		return
+	}
+	files := make(map[string]*FileCoverage, len(report.Files))
+	for path, fc := range report.Files {
+		for _, m := range mappings {
+			if p, ok := m.apply(path); ok {
+				path = p
+				break
+			}
+		}
+		if existing := files[path]; existing != nil {
+			mergeFileCoverage(existing, fc)
+			continue
+		}
+		fc.Path = path
+		files[path] = fc
+	}
+	report.Files = files
+	calculateSummary(report)
+}
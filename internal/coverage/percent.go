@@ -0,0 +1,57 @@
+package coverage
+
+import (
+	"math"
+	"strconv"
+)
+
+// Rounding modes for displayed percentages
+const (
+	RoundNearest = "nearest" // Round to the nearest shown digit (default)
+	RoundFloor   = "floor"   // Round down, so a percentage never shows as a value it hasn't reached
+)
+
+// ValidRoundings lists the accepted rounding modes
+var ValidRoundings = []string{RoundNearest, RoundFloor}
+
+// DefaultPrecision is how many decimals percentages show by default
+const DefaultPrecision = 1
+
+// PercentFormat controls how percentages are displayed. It only changes
+// what is shown: thresholds are always compared against unrounded values.
+// The zero value formats like DefaultPercentFormat.
+type PercentFormat struct {
+	Precision int    // Decimals shown
+	Round     string // RoundNearest ("" too) or RoundFloor
+}
+
+// DefaultPercentFormat shows one decimal, rounded to nearest
+var DefaultPercentFormat = PercentFormat{Precision: DefaultPrecision, Round: RoundNearest}
+
+// Number formats pct without a percent sign, e.g. "79.9"
+func (f PercentFormat) Number(pct float64) string {
+	if f == (PercentFormat{}) {
+		f = DefaultPercentFormat
+	}
+	if f.Round == RoundFloor {
+		// The nudge keeps values like 0.29*100 = 28.999999999999996 from
+		// flooring a digit short
+		scale := math.Pow(10, float64(f.Precision))
+		pct = math.Floor(pct*scale+1e-9) / scale
+	}
+	return strconv.FormatFloat(pct, 'f', f.Precision, 64)
+}
+
+// Percent formats pct with a percent sign, e.g. "79.9%"
+func (f PercentFormat) Percent(pct float64) string {
+	return f.Number(pct) + "%"
+}
+
+// Delta formats a change in percentage points with its sign, e.g. "+1.5"
+func (f PercentFormat) Delta(delta float64) string {
+	s := f.Number(delta)
+	if s[0] != '-' {
+		s = "+" + s
+	}
+	return s
+}
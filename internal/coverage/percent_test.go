@@ -0,0 +1,42 @@
+package coverage
+
+import "testing"
+
+func TestPercentFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		f    PercentFormat
+		pct  float64
+		want string
+	}{
+		{"zero value is the default", PercentFormat{}, 79.95, "80.0%"},
+		{"nearest", DefaultPercentFormat, 42.857, "42.9%"},
+		{"floor", PercentFormat{Precision: 1, Round: RoundFloor}, 79.95, "79.9%"},
+		{"floor keeps exact values", PercentFormat{Precision: 1, Round: RoundFloor}, 0.29 * 100, "29.0%"},
+		{"floor at full", PercentFormat{Precision: 1, Round: RoundFloor}, 100, "100.0%"},
+		{"more decimals", PercentFormat{Precision: 2, Round: RoundNearest}, 79.95, "79.95%"},
+		{"precision without rounding mode", PercentFormat{Precision: 3}, 79.95, "79.950%"},
+		{"no decimals", PercentFormat{Precision: 0, Round: RoundNearest}, 79.5, "80%"},
+		{"no decimals floored", PercentFormat{Precision: 0, Round: RoundFloor}, 79.99, "79%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.f.Percent(tt.pct); got != tt.want {
+				t.Errorf("Percent(%v) = %q, want %q", tt.pct, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentFormatDelta(t *testing.T) {
+	f := DefaultPercentFormat
+	for delta, want := range map[float64]string{1.54: "+1.5", 0: "+0.0", -2.25: "-2.2", -0.01: "-0.0"} {
+		if got := f.Delta(delta); got != want {
+			t.Errorf("Delta(%v) = %q, want %q", delta, got, want)
+		}
+	}
+	floor := PercentFormat{Precision: 1, Round: RoundFloor}
+	if got := floor.Delta(-0.01); got != "-0.1" {
+		t.Errorf("floor Delta(-0.01) = %q, want -0.1", got)
+	}
+}
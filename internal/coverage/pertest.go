@@ -0,0 +1,60 @@
+package coverage
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// AttributedFile records how much of a source file a single test covered
+type AttributedFile struct {
+	Path              string `json:"path"`
+	StatementsCovered int    `json:"statements_covered"`
+	StatementsTotal   int    `json:"statements_total"`
+}
+
+// TestAttribution maps each test file to the source files it exercised,
+// along with the reverse index from source file to tests
+type TestAttribution struct {
+	Tests map[string][]AttributedFile `json:"tests"` // test file -> covered source files
+	Files map[string][]string         `json:"files"` // source file -> tests covering it
+}
+
+// NewTestAttribution creates an empty attribution matrix
+func NewTestAttribution() *TestAttribution {
+	return &TestAttribution{
+		Tests: make(map[string][]AttributedFile),
+		Files: make(map[string][]string),
+	}
+}
+
+// Add records the source files covered by testFile from its own report.
+// Files the test loaded but never executed a statement in are skipped.
+func (a *TestAttribution) Add(testFile string, report *Report) {
+	covered := []AttributedFile{}
+	for path, fc := range report.Files {
+		if fc.Statements.Covered == 0 {
+			continue
+		}
+		covered = append(covered, AttributedFile{
+			Path:              path,
+			StatementsCovered: fc.Statements.Covered,
+			StatementsTotal:   fc.Statements.Total,
+		})
+		a.Files[path] = append(a.Files[path], testFile)
+	}
+	sort.Slice(covered, func(i, j int) bool {
+		return covered[i].Path < covered[j].Path
+	})
+	a.Tests[testFile] = covered
+}
+
+// WritePerTestJSON writes the attribution matrix as indented JSON
+func WritePerTestJSON(a *TestAttribution, w io.Writer) error {
+	for _, tests := range a.Files {
+		sort.Strings(tests)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
@@ -0,0 +1,97 @@
+package coverage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScoreMetrics are the summary metrics a weighted score can blend
+var ScoreMetrics = []string{"statement", "branch", "condition", "subroutine", "pod"}
+
+// SummaryMetric returns the summary percentage of a ScoreMetrics name, and
+// false for other names
+func SummaryMetric(s CoverageSummary, metric string) (float64, bool) {
+	switch metric {
+	case "statement":
+		return s.Statement, true
+	case "branch":
+		return s.Branch, true
+	case "condition":
+		return s.Condition, true
+	case "subroutine":
+		return s.Subroutine, true
+	case "pod":
+		return s.Pod, true
+	}
+	return 0, false
+}
+
+// ParseScoreWeights parses weights written as metric:weight pairs separated
+// by commas, e.g. "statement:0.5,branch:0.3,subroutine:0.2"
+func ParseScoreWeights(spec string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(spec, ",") {
+		metric, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+		metric = strings.TrimSpace(metric)
+		if !ok {
+			return nil, fmt.Errorf("%q is not metric:weight", part)
+		}
+		if _, dup := weights[metric]; dup {
+			return nil, fmt.Errorf("%s is weighted twice", metric)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for %s: %q", metric, value)
+		}
+		weights[metric] = w
+	}
+	if err := ValidateScoreWeights(weights); err != nil {
+		return nil, err
+	}
+	return weights, nil
+}
+
+// ValidateScoreWeights checks that weights name ScoreMetrics and are finite
+// and non-negative, with a positive sum to normalize by
+func ValidateScoreWeights(weights map[string]float64) error {
+	var sum float64
+	for metric, w := range weights {
+		if _, ok := SummaryMetric(CoverageSummary{}, metric); !ok {
+			return fmt.Errorf("unknown metric %q (valid: %s)", metric, strings.Join(ScoreMetrics, ", "))
+		}
+		if math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+			return fmt.Errorf("weight for %s must be a non-negative number, got %g", metric, w)
+		}
+		sum += w
+	}
+	if sum == 0 {
+		return fmt.Errorf("weights must not all be zero")
+	}
+	return nil
+}
+
+// WeightedScore blends the summary's percentages into one 0-100 score,
+// dividing by the sum of the weights so they needn't add up to 1. Weights
+// that fail ValidateScoreWeights give 0.
+func WeightedScore(summary CoverageSummary, weights map[string]float64) float64 {
+	if ValidateScoreWeights(weights) != nil {
+		return 0
+	}
+	// Sum in a fixed order so the score doesn't vary in the last digit
+	metrics := make([]string, 0, len(weights))
+	for metric := range weights {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	var score, sum float64
+	for _, metric := range metrics {
+		pct, _ := SummaryMetric(summary, metric)
+		score += weights[metric] * pct
+		sum += weights[metric]
+	}
+	return score / sum
+}
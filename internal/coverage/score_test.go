@@ -0,0 +1,54 @@
+package coverage
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseScoreWeights(t *testing.T) {
+	weights, err := ParseScoreWeights("statement:0.5, branch:0.3,subroutine:0.2")
+	if err != nil {
+		t.Fatalf("ParseScoreWeights() error: %v", err)
+	}
+	if len(weights) != 3 || weights["statement"] != 0.5 || weights["branch"] != 0.3 || weights["subroutine"] != 0.2 {
+		t.Errorf("weights = %v", weights)
+	}
+
+	for spec, want := range map[string]string{
+		"statement":                 "not metric:weight",
+		"statement:x":               "invalid weight",
+		"statement:1,statement:2":   "weighted twice",
+		"lines:1":                   "unknown metric",
+		"statement:-1,branch:2":     "non-negative",
+		"statement:NaN":             "non-negative",
+		"statement:0,branch:0":      "all be zero",
+		"statement:1,,branch:1":     "not metric:weight",
+		"subroutine:1,time:1":       "unknown metric",
+		"statement:1,condition:Inf": "non-negative",
+	} {
+		if _, err := ParseScoreWeights(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseScoreWeights(%q) error = %v, want %q", spec, err, want)
+		}
+	}
+}
+
+func TestWeightedScore(t *testing.T) {
+	summary := CoverageSummary{Statement: 80, Branch: 50, Condition: 40, Subroutine: 100, Pod: 10}
+	tests := []struct {
+		weights map[string]float64
+		want    float64
+	}{
+		{map[string]float64{"statement": 0.5, "branch": 0.3, "subroutine": 0.2}, 75},
+		{map[string]float64{"statement": 5, "branch": 3, "subroutine": 2}, 75}, // normalized
+		{map[string]float64{"statement": 1}, 80},
+		{map[string]float64{"condition": 1, "pod": 0}, 40},
+		{map[string]float64{"statement": -1, "branch": 2}, 0}, // invalid
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := WeightedScore(summary, tt.weights); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("WeightedScore(%v) = %g, want %g", tt.weights, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+)
+
+// sonarCoverage is SonarQube's Generic Coverage format, see
+// https://docs.sonarsource.com/sonarqube/latest/analyzing-source-code/test-coverage/generic-test-data/
+type sonarCoverage struct {
+	XMLName xml.Name    `xml:"coverage"`
+	Version int         `xml:"version,attr"`
+	Files   []sonarFile `xml:"file"`
+}
+
+// sonarFile holds the covered lines of one source file
+type sonarFile struct {
+	Path  string      `xml:"path,attr"`
+	Lines []sonarLine `xml:"lineToCover"`
+}
+
+// sonarLine is a single lineToCover; the branch attributes are nil on
+// lines without branches so coveredBranches="0" is still written
+type sonarLine struct {
+	LineNumber      int  `xml:"lineNumber,attr"`
+	Covered         bool `xml:"covered,attr"`
+	BranchesToCover *int `xml:"branchesToCover,attr,omitempty"`
+	CoveredBranches *int `xml:"coveredBranches,attr,omitempty"`
+}
+
+// sonarBranches counts the branch sides on one line
+type sonarBranches struct {
+	toCover, covered int
+}
+
+// toSonarCoverage converts a Report to the generic coverage schema. Every
+// statement line becomes a lineToCover, covered if any statement on it ran;
+// branches with a known line add their true and false sides to that line.
+func toSonarCoverage(report *Report) *sonarCoverage {
+	out := &sonarCoverage{Version: 1}
+
+	var paths []string
+	for path := range report.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		fc := report.Files[path]
+
+		branches := make(map[int]*sonarBranches)
+		for _, b := range fc.Branches.Detail {
+			if b.Line == 0 {
+				continue
+			}
+			bl := branches[b.Line]
+			if bl == nil {
+				bl = &sonarBranches{}
+				branches[b.Line] = bl
+			}
+			bl.toCover += 2
+			if b.True > 0 {
+				bl.covered++
+			}
+			if b.False > 0 {
+				bl.covered++
+			}
+		}
+
+		var lines []int
+		for line := range fc.Statements.lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+
+		file := sonarFile{Path: path, Lines: []sonarLine{}}
+		for _, line := range lines {
+			sl := sonarLine{LineNumber: line, Covered: fc.Statements.lines[line] > 0}
+			if bl := branches[line]; bl != nil {
+				sl.BranchesToCover = &bl.toCover
+				sl.CoveredBranches = &bl.covered
+			}
+			file.Lines = append(file.Lines, sl)
+		}
+		out.Files = append(out.Files, file)
+	}
+
+	return out
+}
+
+// WriteSonarGeneric writes the report in SonarQube's Generic Coverage XML
+// format, for sonar.coverageReportPaths. It needs per-line hits, so it only
+// works on reports built from a coverage database, not ReadJSON.
+func WriteSonarGeneric(report *Report, w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(toSonarCoverage(report)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
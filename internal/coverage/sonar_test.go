@@ -0,0 +1,62 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestWriteSonarGeneric(t *testing.T) {
+	report := &Report{
+		Files: map[string]*FileCoverage{
+			"lib/B.pm": {
+				Path:       "lib/B.pm",
+				Statements: StatementCoverage{lines: map[int]int{1: 1}},
+			},
+			"lib/A.pm": {
+				Path:       "lib/A.pm",
+				Statements: StatementCoverage{lines: map[int]int{3: 2, 1: 5, 7: 0}},
+				Branches: BranchCoverage{Detail: []BranchHit{
+					{Line: 3, True: 2, False: 0},
+					{Line: 3, True: 1, False: 1},
+					{Line: 7, True: 0, False: 0},
+					{Line: 0, True: 1, False: 1}, // no position: dropped
+				}},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSonarGeneric(report, &buf); err != nil {
+		t.Fatalf("WriteSonarGeneric() error: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") {
+		t.Errorf("output lacks XML header: %q", buf.String())
+	}
+
+	var got sonarCoverage
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	if got.Version != 1 || len(got.Files) != 2 || got.Files[0].Path != "lib/A.pm" {
+		t.Fatalf("got version %d, files %+v", got.Version, got.Files)
+	}
+
+	lines := got.Files[0].Lines
+	if len(lines) != 3 {
+		t.Fatalf("lib/A.pm has %d lines, want 3", len(lines))
+	}
+	if lines[0].LineNumber != 1 || !lines[0].Covered || lines[0].BranchesToCover != nil {
+		t.Errorf("line 1 = %+v, want covered without branches", lines[0])
+	}
+	if l := lines[1]; l.LineNumber != 3 || l.BranchesToCover == nil || *l.BranchesToCover != 4 || *l.CoveredBranches != 3 {
+		t.Errorf("line 3 = %+v, want 3 of 4 branches covered", l)
+	}
+	if l := lines[2]; l.LineNumber != 7 || l.Covered || l.CoveredBranches == nil || *l.CoveredBranches != 0 {
+		t.Errorf("line 7 = %+v, want uncovered with coveredBranches=0", l)
+	}
+	if !strings.Contains(buf.String(), `<lineToCover lineNumber="7" covered="false" branchesToCover="2" coveredBranches="0"></lineToCover>`) {
+		t.Errorf("line 7 not written as expected:\n%s", buf.String())
+	}
+}
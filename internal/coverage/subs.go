@@ -0,0 +1,48 @@
+package coverage
+
+import "regexp"
+
+// ExcludeSubs drops subroutines whose name matches any of patterns from
+// subroutine coverage, e.g. accessors Moose or Moo generate: they leave
+// Called or Uncovered and the subroutine totals. Statements inside them
+// still count. Only subroutines named by the structure files can match,
+// so a summary-only report is left as it is. It returns the number of
+// subroutines dropped, and must be called before Normalize.
+func (report *Report) ExcludeSubs(patterns []*regexp.Regexp) int {
+	matches := func(sub SubInfo) bool {
+		for _, re := range patterns {
+			if re.MatchString(sub.Name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	dropped := 0
+	for _, fc := range report.Files {
+		subs := &fc.Subroutines
+		var called, uncovered []SubInfo
+		for _, sub := range subs.Called {
+			if matches(sub) {
+				subs.Covered--
+				subs.Total--
+				dropped++
+				continue
+			}
+			called = append(called, sub)
+		}
+		for _, sub := range subs.Uncovered {
+			if matches(sub) {
+				subs.Total--
+				dropped++
+				continue
+			}
+			uncovered = append(uncovered, sub)
+		}
+		subs.Called, subs.Uncovered = called, uncovered
+	}
+	if dropped > 0 {
+		calculateSummary(report)
+	}
+	return dropped
+}
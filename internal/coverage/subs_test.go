@@ -0,0 +1,106 @@
+package coverage
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParseAllRuns_CalledSubs(t *testing.T) {
+	coverDir := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(coverDir, "structure", "aaa"),
+		`{"file":"lib/A.pm","digest":"aaa","statement":[3],"subroutine":[[3,"new"],[12,"name"],[20,"_build_name"]]}`)
+	write(filepath.Join(coverDir, "runs", "1", "cover.14"),
+		`{"runs":{"1":{"digests":{"lib/A.pm":"aaa"},"count":{"lib/A.pm":{"statement":[1],"subroutine":[1,0,3]}}}}}`)
+
+	check := func(t *testing.T, data *runCoverageData) {
+		t.Helper()
+		if len(data.Files) != 1 {
+			t.Fatalf("got %d files, want 1", len(data.Files))
+		}
+		f := data.Files[0]
+		if want := []SubInfo{{"new", 3}, {"_build_name", 20}}; !reflect.DeepEqual(f.CalledSubs, want) {
+			t.Errorf("CalledSubs = %v, want %v", f.CalledSubs, want)
+		}
+		if want := []SubInfo{{"name", 12}}; !reflect.DeepEqual(f.UncalledSubs, want) {
+			t.Errorf("UncalledSubs = %v, want %v", f.UncalledSubs, want)
+		}
+	}
+
+	t.Run("go", func(t *testing.T) {
+		data, err := parseAllRunsJSON(coverDir, 0, false)
+		if err != nil {
+			t.Fatalf("parseAllRunsJSON() error: %v", err)
+		}
+		check(t, data)
+	})
+	t.Run("perl", func(t *testing.T) {
+		if _, err := exec.LookPath("perl"); err != nil {
+			t.Skip("perl not available")
+		}
+		data, err := parseAllRuns(coverDir, "perl")
+		if err != nil {
+			t.Fatalf("parseAllRuns() error: %v", err)
+		}
+		check(t, data)
+	})
+}
+
+func TestExcludeSubs(t *testing.T) {
+	report := &Report{Files: map[string]*FileCoverage{
+		"lib/A.pm": {
+			Statements: StatementCoverage{Covered: 4, Total: 5},
+			Subroutines: SubroutineCoverage{
+				Covered:   3,
+				Total:     5,
+				Called:    []SubInfo{{"new", 3}, {"_build_name", 20}, {"has_name", 24}},
+				Uncovered: []SubInfo{{"name", 12}, {"clear_name", 28}},
+			},
+		},
+		"lib/B.pm": {
+			Subroutines: SubroutineCoverage{Covered: 1, Total: 1, Called: []SubInfo{{"run", 5}}},
+		},
+	}}
+	calculateSummary(report)
+
+	dropped := report.ExcludeSubs([]*regexp.Regexp{regexp.MustCompile(`^_build_`), regexp.MustCompile(`^(has|clear)_`)})
+	if dropped != 3 {
+		t.Errorf("ExcludeSubs() = %d, want 3", dropped)
+	}
+	subs := report.Files["lib/A.pm"].Subroutines
+	if subs.Covered != 1 || subs.Total != 2 {
+		t.Errorf("lib/A.pm subroutines = %d/%d, want 1/2", subs.Covered, subs.Total)
+	}
+	if want := []SubInfo{{"new", 3}}; !reflect.DeepEqual(subs.Called, want) {
+		t.Errorf("Called = %v, want %v", subs.Called, want)
+	}
+	if want := []SubInfo{{"name", 12}}; !reflect.DeepEqual(subs.Uncovered, want) {
+		t.Errorf("Uncovered = %v, want %v", subs.Uncovered, want)
+	}
+	if subs.Percent != 50 {
+		t.Errorf("Percent = %v, want 50", subs.Percent)
+	}
+	// Statements are left alone
+	if s := report.Files["lib/A.pm"].Statements; s.Covered != 4 || s.Total != 5 {
+		t.Errorf("statements = %d/%d, want 4/5", s.Covered, s.Total)
+	}
+	if got := report.Summary.Subroutine; got < 66.6 || got > 66.7 {
+		t.Errorf("Summary.Subroutine = %v, want 2 of 3 subs", got)
+	}
+
+	if dropped := report.ExcludeSubs([]*regexp.Regexp{regexp.MustCompile(`^nothing$`)}); dropped != 0 {
+		t.Errorf("ExcludeSubs() without matches = %d, want 0", dropped)
+	}
+}
@@ -0,0 +1,241 @@
+package coverage
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// UncoverableEntry is one line of a Devel::Cover .uncoverable file, as
+// written by "cover -add_uncoverable_point":
+//
+//	lib/Foo.pm branch 5f0a...c1 0 1 default unreachable unless configured
+//
+// The source line is identified by the MD5 of its text rather than its
+// number, so entries follow the line when code above it moves.
+type UncoverableEntry struct {
+	File      string
+	Criterion string // statement, branch, condition or subroutine
+	LineMD5   string // Hex MD5 of the source line, newline included
+	Count     int    // Which of the criterion's points on the line, from 0
+	Type      int    // Branch side (0 true, 1 false) or condition state
+	Class     string // Devel::Cover's class, e.g. "default"
+	Note      string
+}
+
+// ReadUncoverableFile parses a Devel::Cover .uncoverable file. Blank lines
+// are skipped; lines with fewer than six fields are an error.
+func ReadUncoverableFile(path string) ([]UncoverableEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []UncoverableEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		// Like Devel::Cover's split " ", $_, 7: the note may hold spaces
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("%s:%d: want file, criterion, line digest, count, type and class", path, n)
+		}
+		count, err1 := strconv.Atoi(fields[3])
+		typ, err2 := strconv.Atoi(fields[4])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s:%d: count and type must be numbers", path, n)
+		}
+		entry := UncoverableEntry{
+			File:      fields[0],
+			Criterion: fields[1],
+			LineMD5:   fields[2],
+			Count:     count,
+			Type:      typ,
+			Class:     fields[5],
+		}
+		if len(fields) > 6 {
+			entry.Note = strings.Join(fields[6:], " ")
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ExcludeUncoverableEntries leaves the points entries list out of the
+// report's totals, as Devel::Cover does: an uncoverable point that was
+// never covered leaves its criterion's total, while one that was covered
+// still counts. Entries for files not in the report, criteria perlcov
+// doesn't track or lines no longer in the source are ignored. It returns
+// the number of points left out, and the errors for files that couldn't
+// be read. It must be called before Normalize.
+func (report *Report) ExcludeUncoverableEntries(entries []UncoverableEntry) (int, []error) {
+	byFile := make(map[string][]UncoverableEntry)
+	for _, e := range entries {
+		if _, ok := report.Files[e.File]; ok {
+			byFile[e.File] = append(byFile[e.File], e)
+		}
+	}
+
+	dropped := 0
+	var errs []error
+	for path, list := range byFile {
+		lines, err := lineDigests(report.SourcePath(path))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fc := report.Files[path]
+		x := excludedPoints{
+			statements: make(map[int]int),
+			branches:   make(map[int]*[2]bool),
+			conditions: make(map[int]map[int]bool),
+		}
+		for _, e := range list {
+			for _, line := range lines[e.LineMD5] {
+				if fc.excludePoint(e, line, x) {
+					dropped++
+				}
+			}
+		}
+	}
+	if dropped > 0 {
+		calculateSummary(report)
+	}
+	return dropped, errs
+}
+
+// excludedPoints records the points of one file already left out, so
+// repeated entries count once
+type excludedPoints struct {
+	statements map[int]int          // line -> statements left out
+	branches   map[int]*[2]bool     // Detail index -> sides left out
+	conditions map[int]map[int]bool // Detail index -> states left out
+}
+
+// excludePoint leaves one uncoverable point on line out of fc's totals and
+// reports whether it did
+func (fc *FileCoverage) excludePoint(e UncoverableEntry, line int, x excludedPoints) bool {
+	switch e.Criterion {
+	case "statement":
+		// Statements are only known per line, so a line counts as
+		// uncovered only when none of its statements ran
+		statements, ok := fc.Statements.counts[line]
+		if !ok || fc.Statements.lines[line] > 0 || e.Count >= statements || x.statements[line] >= statements {
+			return false
+		}
+		x.statements[line]++
+		fc.Statements.Total--
+		if x.statements[line] == statements {
+			delete(fc.Statements.lines, line)
+			delete(fc.Statements.counts, line)
+			delete(fc.Statements.missed, line)
+		}
+		return true
+
+	case "branch":
+		i := nthOnLine(len(fc.Branches.Detail), e.Count, line, func(i int) int { return fc.Branches.Detail[i].Line })
+		if i < 0 || e.Type < 0 || e.Type > 1 {
+			return false
+		}
+		b := &fc.Branches.Detail[i]
+		sides := [2]int{b.True, b.False}
+		if x.branches[i] == nil {
+			x.branches[i] = &[2]bool{}
+		}
+		done := x.branches[i]
+		if sides[e.Type] > 0 || done[e.Type] {
+			return false
+		}
+		done[e.Type] = true
+		fc.Branches.Total--
+		// Devel::Cover lists each side on its own; the branch stops
+		// counting as uncovered once every side never taken is listed
+		b.Uncoverable = (sides[0] > 0 || done[0]) && (sides[1] > 0 || done[1])
+		fc.Branches.Uncovered = uncoveredBranchLines(fc.Branches.Detail)
+		return true
+
+	case "condition":
+		i := nthOnLine(len(fc.Conditions.Detail), e.Count, line, func(i int) int { return fc.Conditions.Detail[i].Line })
+		if i < 0 {
+			return false
+		}
+		c := &fc.Conditions.Detail[i]
+		if x.conditions[i] == nil {
+			x.conditions[i] = make(map[int]bool)
+		}
+		done := x.conditions[i]
+		if e.Type < 0 || e.Type >= len(c.States) || c.States[e.Type] > 0 || done[e.Type] {
+			return false
+		}
+		done[e.Type] = true
+		fc.Conditions.Total--
+		c.Uncoverable = true
+		for state, hits := range c.States {
+			if hits == 0 && !done[state] {
+				c.Uncoverable = false
+			}
+		}
+		fc.Conditions.Partial = partialConditions(fc.Conditions.Detail)
+		return true
+
+	case "subroutine":
+		for i, sub := range fc.Subroutines.Uncovered {
+			if sub.Line != line {
+				continue
+			}
+			if e.Count > 0 {
+				e.Count--
+				continue
+			}
+			fc.Subroutines.Total--
+			fc.Subroutines.Uncovered = append(fc.Subroutines.Uncovered[:i:i], fc.Subroutines.Uncovered[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// nthOnLine returns the index of the n-th of count points whose lineOf is
+// line, or -1
+func nthOnLine(count, n, line int, lineOf func(int) int) int {
+	for i := 0; i < count; i++ {
+		if lineOf(i) != line {
+			continue
+		}
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return -1
+}
+
+// lineDigests maps the hex MD5 of each of path's lines, newline included as
+// Devel::Cover reads them, to the line numbers with that text
+func lineDigests(path string) (map[string][]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string][]int)
+	for i, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		sum := md5.Sum([]byte(line))
+		key := hex.EncodeToString(sum[:])
+		digests[key] = append(digests[key], i+1)
+	}
+	return digests, nil
+}
@@ -0,0 +1,119 @@
+package coverage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadUncoverableFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".uncoverable")
+	content := "lib/A.pm branch 0123abcd 0 1 default never false in tests\n\nlib/A.pm statement 4567ef01 1 0 default\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadUncoverableFile(path)
+	if err != nil {
+		t.Fatalf("ReadUncoverableFile() error: %v", err)
+	}
+	want := []UncoverableEntry{
+		{File: "lib/A.pm", Criterion: "branch", LineMD5: "0123abcd", Count: 0, Type: 1, Class: "default", Note: "never false in tests"},
+		{File: "lib/A.pm", Criterion: "statement", LineMD5: "4567ef01", Count: 1, Type: 0, Class: "default"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v\nwant %+v", entries, want)
+	}
+
+	if err := os.WriteFile(path, []byte("lib/A.pm branch 0123abcd x 1 default\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadUncoverableFile(path); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("ReadUncoverableFile() error = %v, want one naming line 1", err)
+	}
+}
+
+func TestExcludeUncoverableEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Guard.pm")
+	source := []string{
+		"package Guard;\n",
+		"sub check {\n",
+		"    my $x = shift;\n",
+		"    die 'impossible' unless $x;\n",
+		"    return $x && $y;\n",
+		"}\n",
+		"sub unused { 1 }\n",
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(source, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	digest := func(line int) string {
+		sum := md5.Sum([]byte(source[line-1]))
+		return hex.EncodeToString(sum[:])
+	}
+
+	report := &Report{Files: map[string]*FileCoverage{
+		path: {
+			Statements: StatementCoverage{
+				Covered: 3, Total: 5,
+				lines:  map[int]int{3: 1, 4: 0, 5: 1, 7: 0},
+				counts: map[int]int{3: 1, 4: 2, 5: 1, 7: 1},
+			},
+			Branches: BranchCoverage{
+				Covered: 1, Total: 2,
+				Detail: []BranchHit{{Line: 4, True: 0, False: 3}},
+			},
+			Conditions: ConditionCoverage{
+				Covered: 1, Total: 3,
+				Detail: []ConditionHit{{Line: 5, Covered: 2, Total: 4, Missed: []string{"right true", "right false"}, States: []int{1, 0, 0}}},
+			},
+			Subroutines: SubroutineCoverage{
+				Covered: 1, Total: 2,
+				Uncovered: []SubInfo{{Name: "unused", Line: 7}},
+			},
+		},
+	}}
+	report.Files[path].Branches.Uncovered = uncoveredBranchLines(report.Files[path].Branches.Detail)
+	report.Files[path].Conditions.Partial = partialConditions(report.Files[path].Conditions.Detail)
+	calculateSummary(report)
+
+	entries := []UncoverableEntry{
+		// Both statements on line 4, the second listed twice
+		{File: path, Criterion: "statement", LineMD5: digest(4), Count: 0},
+		{File: path, Criterion: "statement", LineMD5: digest(4), Count: 1},
+		{File: path, Criterion: "statement", LineMD5: digest(4), Count: 1},
+		// Line 3 ran, so it still counts
+		{File: path, Criterion: "statement", LineMD5: digest(3), Count: 0},
+		{File: path, Criterion: "branch", LineMD5: digest(4), Count: 0, Type: 0},
+		// One of the condition's two missed states
+		{File: path, Criterion: "condition", LineMD5: digest(5), Count: 0, Type: 1},
+		{File: path, Criterion: "subroutine", LineMD5: digest(7), Count: 0},
+		{File: "lib/Elsewhere.pm", Criterion: "statement", LineMD5: digest(4)},
+	}
+	dropped, errs := report.ExcludeUncoverableEntries(entries)
+	if dropped != 5 || len(errs) != 0 {
+		t.Fatalf("ExcludeUncoverableEntries() = %d, %v, want 5, no errors", dropped, errs)
+	}
+
+	fc := report.Files[path]
+	if fc.Statements.Total != 3 || fmt.Sprint(fc.Statements.Uncovered) != "[7]" {
+		t.Errorf("Statements = total %d, uncovered %v, want 3, [7]", fc.Statements.Total, fc.Statements.Uncovered)
+	}
+	if fc.Branches.Total != 1 || len(fc.Branches.Uncovered) != 0 || !fc.Branches.Detail[0].Uncoverable {
+		t.Errorf("Branches = total %d, uncovered %v, want 1, none", fc.Branches.Total, fc.Branches.Uncovered)
+	}
+	if fc.Conditions.Total != 2 || len(fc.Conditions.Partial) != 1 || fc.Conditions.Detail[0].Uncoverable {
+		t.Errorf("Conditions = total %d, partial %v, want 2 and still partial", fc.Conditions.Total, fc.Conditions.Partial)
+	}
+	if fc.Subroutines.Total != 1 || len(fc.Subroutines.Uncovered) != 0 {
+		t.Errorf("Subroutines = total %d, uncovered %v, want 1, none", fc.Subroutines.Total, fc.Subroutines.Uncovered)
+	}
+	if got := fmt.Sprintf("%.1f", report.Summary.Branch); got != "100.0" {
+		t.Errorf("Summary.Branch = %s, want 100.0", got)
+	}
+}
@@ -0,0 +1,64 @@
+package coverage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UntestedFiles returns the .pm files under dirs that no test covered:
+// files missing from the report, because no test loaded them so Devel::Cover
+// never saw them, and files where no statement ran. Files for which exclude
+// returns true are skipped, as are directories that don't exist. Relative
+// dirs are under the report's Root, and paths are in its path style.
+func UntestedFiles(report *Report, dirs []string, exclude func(path string) bool) ([]string, error) {
+	style := report.PathStyle
+	if style == "" {
+		style = PathRel
+	}
+
+	seen := make(map[string]bool)
+	var untested []string
+	for _, dir := range dirs {
+		dir = report.SourcePath(dir)
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == dir {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".pm") {
+				return nil
+			}
+			key := styledPath(filepath.Clean(path), style, report.Root)
+			if seen[key] || (exclude != nil && exclude(key)) {
+				return nil
+			}
+			seen[key] = true
+			if fc := report.Files[key]; fc == nil || (fc.Statements.Total > 0 && fc.Statements.Covered == 0) {
+				untested = append(untested, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+	}
+	sort.Strings(untested)
+	return untested, nil
+}
+
+// PrintUntestedFiles lists files returned by UntestedFiles
+func PrintUntestedFiles(untested []string) {
+	if len(untested) == 0 {
+		return
+	}
+	fmt.Printf("\n--- Untested Files (%d) ---\n", len(untested))
+	for _, path := range untested {
+		fmt.Printf("  %s\n", path)
+	}
+}
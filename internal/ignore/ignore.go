@@ -0,0 +1,167 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the ignore file read from the project root
+const FileName = ".perlcovignore"
+
+// rule is a single compiled ignore pattern
+type rule struct {
+	pattern string
+	negate  bool
+	re      *regexp.Regexp
+}
+
+// Matcher matches project-relative paths against gitignore-style patterns.
+// Later patterns take precedence over earlier ones, so a negated pattern
+// (!pattern) can re-include a path excluded by an earlier pattern.
+type Matcher struct {
+	root  string
+	rules []rule
+}
+
+// New creates a Matcher rooted at root. Absolute paths passed to Match are
+// made relative to root before matching.
+func New(root string) *Matcher {
+	return &Matcher{root: root}
+}
+
+// Load reads patterns from an ignore file. A missing file is not an error.
+func (m *Matcher) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := m.Add(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Add compiles and appends a single pattern
+func (m *Matcher) Add(pattern string) error {
+	r := rule{pattern: pattern}
+	p := pattern
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	}
+
+	// A trailing slash means the pattern only matches directories, so it
+	// only matches files somewhere beneath it
+	dirOnly := strings.HasSuffix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+	if p == "" {
+		return fmt.Errorf("empty pattern %q", pattern)
+	}
+
+	// Patterns containing a slash are anchored to the root; others match
+	// at any depth
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	var sb strings.Builder
+	if anchored {
+		sb.WriteString("^")
+	} else {
+		sb.WriteString("^(?:.*/)?")
+	}
+	sb.WriteString(globToRegexp(p))
+	if dirOnly {
+		sb.WriteString("/.*$")
+	} else {
+		sb.WriteString("(?:/.*)?$")
+	}
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	r.re = re
+	m.rules = append(m.rules, r)
+	return nil
+}
+
+// CompileGlob compiles a glob supporting *, ** and ? that must match a whole
+// slash-separated path, e.g. "**/*.t" matches "a.t" and "x/y/a.t"
+func CompileGlob(glob string) (*regexp.Regexp, error) {
+	if glob == "" {
+		return nil, fmt.Errorf("empty glob")
+	}
+	if strings.HasPrefix(glob, "/") {
+		return nil, fmt.Errorf("glob %q must be relative", glob)
+	}
+	return regexp.Compile("^" + globToRegexp(glob) + "$")
+}
+
+// globToRegexp converts a glob supporting *, ** and ? to a regexp fragment
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// Match reports whether path is ignored
+func (m *Matcher) Match(path string) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+
+	rel := path
+	if filepath.IsAbs(rel) && m.root != "" {
+		if r, err := filepath.Rel(m.root, rel); err == nil && !strings.HasPrefix(r, "..") {
+			rel = r
+		}
+	}
+	rel = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(rel)), "./")
+
+	ignored := false
+	for _, r := range m.rules {
+		if r.re.MatchString(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// Empty reports whether the matcher has no patterns
+func (m *Matcher) Empty() bool {
+	return m == nil || len(m.rules) == 0
+}
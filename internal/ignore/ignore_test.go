@@ -0,0 +1,106 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		expected bool
+	}{
+		{"no patterns", nil, "lib/Foo.pm", false},
+		{"basename at any depth", []string{"*.bak"}, "lib/App/Foo.bak", true},
+		{"basename no match", []string{"*.bak"}, "lib/App/Foo.pm", false},
+		{"directory name at any depth", []string{"Generated"}, "lib/App/Generated/Foo.pm", true},
+		{"dir-only pattern matches files beneath", []string{"vendor/"}, "lib/vendor/Foo.pm", true},
+		{"dir-only pattern does not match file", []string{"Foo.pm/"}, "lib/Foo.pm", false},
+		{"anchored pattern", []string{"/t/slow"}, "t/slow/big.t", true},
+		{"anchored pattern not at root", []string{"/t/slow"}, "xt/t/slow/big.t", false},
+		{"single star does not cross slash", []string{"lib/*.pm"}, "lib/App/Foo.pm", false},
+		{"single star in segment", []string{"lib/*.pm"}, "lib/Foo.pm", true},
+		{"leading double star", []string{"**/Schema/*.pm"}, "lib/App/Schema/User.pm", true},
+		{"middle double star", []string{"lib/**/Foo.pm"}, "lib/A/B/Foo.pm", true},
+		{"middle double star zero dirs", []string{"lib/**/Foo.pm"}, "lib/Foo.pm", true},
+		{"trailing double star", []string{"t/author/**"}, "t/author/pod.t", true},
+		{"question mark", []string{"0?-load.t"}, "t/00-load.t", true},
+		{"negation re-includes", []string{"lib/App/**", "!lib/App/Core.pm"}, "lib/App/Core.pm", false},
+		{"negation other paths still ignored", []string{"lib/App/**", "!lib/App/Core.pm"}, "lib/App/Util.pm", true},
+		{"later pattern wins", []string{"!lib/Foo.pm", "lib/Foo.pm"}, "lib/Foo.pm", true},
+		{"dot-slash prefix", []string{"lib/Foo.pm"}, "./lib/Foo.pm", true},
+		{"absolute path under root", []string{"lib/Foo.pm"}, "/project/lib/Foo.pm", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New("/project")
+			for _, p := range tt.patterns {
+				if err := m.Add(p); err != nil {
+					t.Fatalf("Add(%q) error: %v", p, err)
+				}
+			}
+			if got := m.Match(tt.path); got != tt.expected {
+				t.Errorf("Match(%q) with %v = %v, want %v", tt.path, tt.patterns, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		expected   bool
+	}{
+		{"**/*.t", "a.t", true},
+		{"**/*.t", "unit/deep/a.t", true},
+		{"**/*.t", "a.test", false},
+		{"**/*.test", "api/users.test", true},
+		{"*.t", "unit/a.t", false},
+		{"author/*.t", "author/pod.t", true},
+		{"author/*.t", "x/author/pod.t", false},
+	}
+	for _, tt := range tests {
+		re, err := CompileGlob(tt.glob)
+		if err != nil {
+			t.Fatalf("CompileGlob(%q) error: %v", tt.glob, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.expected {
+			t.Errorf("CompileGlob(%q) matches %q = %v, want %v", tt.glob, tt.path, got, tt.expected)
+		}
+	}
+
+	for _, bad := range []string{"", "/abs/*.t"} {
+		if _, err := CompileGlob(bad); err == nil {
+			t.Errorf("CompileGlob(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	content := "# generated code\n\nlib/Gen/\n!lib/Gen/Keep.pm\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(dir)
+	if err := m.Load(path); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !m.Match("lib/Gen/Foo.pm") {
+		t.Error("lib/Gen/Foo.pm should be ignored")
+	}
+	if m.Match("lib/Gen/Keep.pm") {
+		t.Error("lib/Gen/Keep.pm should not be ignored")
+	}
+
+	// Missing files are not an error
+	if err := New(dir).Load(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("Load() of missing file error: %v", err)
+	}
+}
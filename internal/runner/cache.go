@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// CacheFile is the default location of the results cache
+const CacheFile = ".perlcov-timings.json"
+
+// CachedResult holds the outcome of a test from a previous run
+type CachedResult struct {
+	Passed   bool    `json:"passed"`
+	Duration float64 `json:"duration"` // seconds
+}
+
+// Cache holds per-test results from previous runs, keyed by test file path
+type Cache struct {
+	Tests map[string]CachedResult `json:"tests"`
+}
+
+// LoadCache reads a results cache. A missing or unreadable cache yields an
+// empty cache since it only affects scheduling, never correctness.
+func LoadCache(path string) *Cache {
+	cache, err := ReadCache(path)
+	if err != nil {
+		return &Cache{Tests: make(map[string]CachedResult)}
+	}
+	return cache
+}
+
+// ReadCache reads a results cache, failing if it is missing or unreadable
+func ReadCache(path string) (*Cache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cache := &Cache{}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cache.Tests == nil {
+		cache.Tests = make(map[string]CachedResult)
+	}
+	return cache, nil
+}
+
+// Update records the given results, keeping entries for tests not in this run
+func (c *Cache) Update(results []TestResult) {
+	for _, r := range results {
+		c.Tests[r.File] = CachedResult{
+			Passed:   r.Passed,
+			Duration: r.Duration.Seconds(),
+		}
+	}
+}
+
+// Save writes the cache to path
+func (c *Cache) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// perlSourceExts are the changed files that can affect what tests do, and
+// so must be traced to the tests that load them
+var perlSourceExts = []string{".pm", ".pl", ".xs"}
+
+// TestsForChanges returns the tests among testFiles that the changed files
+// can affect: changed tests themselves, and tests whose module, from the
+// select map or else the filename (see moduleCandidates), is a changed .pm
+// under one of sourceDirs. It also returns the changed Perl sources no
+// test maps to, such as test helpers or scripts; any test may load those,
+// so callers can't safely narrow the run when there are some. Other
+// changes, such as documentation, are ignored.
+func TestsForChanges(testFiles, changed, sourceDirs []string, selectMap *SelectMap) ([]string, []string) {
+	changedSet := make(map[string]bool)
+	for _, f := range changed {
+		changedSet[filepath.Clean(f)] = true
+	}
+
+	// Changed modules, as Module/Name.pm relative to their source dir
+	modules := make(map[string]string)
+	var unmapped []string
+	for _, f := range changed {
+		f = filepath.Clean(f)
+		if !isPerlSource(f) || strings.HasSuffix(f, ".t") {
+			continue
+		}
+		if module := sourceModuleFile(f, sourceDirs); module != "" {
+			modules[module] = f
+		} else {
+			unmapped = append(unmapped, f)
+		}
+	}
+
+	var selected []string
+	mapped := make(map[string]bool)
+	for _, tf := range testFiles {
+		hit := changedSet[filepath.Clean(tf)]
+		for _, module := range testModuleFiles(tf, selectMap) {
+			if f, ok := modules[module]; ok {
+				mapped[f] = true
+				hit = true
+			}
+		}
+		if hit {
+			selected = append(selected, tf)
+		}
+	}
+	for _, f := range modules {
+		if !mapped[f] {
+			unmapped = append(unmapped, f)
+		}
+	}
+	sort.Strings(unmapped)
+	return selected, unmapped
+}
+
+// isPerlSource reports whether path has one of perlSourceExts
+func isPerlSource(path string) bool {
+	for _, ext := range perlSourceExts {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceModuleFile returns path relative to the first of sourceDirs holding
+// it, with forward slashes, if it is a .pm file there
+func sourceModuleFile(path string, sourceDirs []string) string {
+	if !strings.HasSuffix(path, ".pm") {
+		return ""
+	}
+	for _, dir := range sourceDirs {
+		rel, err := filepath.Rel(filepath.Clean(dir), path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return ""
+}
+
+// testModuleFiles returns the module files testFile may be testing, as
+// Module/Name.pm: the select map's modules when it maps the test, or else
+// every filename candidate, since the run may pick any that exists
+func testModuleFiles(testFile string, selectMap *SelectMap) []string {
+	modules, ok := selectMap.Modules(testFile)
+	if !ok {
+		modules = moduleCandidates(testFile)
+	}
+	files := make([]string, len(modules))
+	for i, m := range modules {
+		files[i] = strings.ReplaceAll(m, "::", "/") + ".pm"
+	}
+	return files
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// junitTestSuites is the root of a JUnit XML report, in the dialect most CI
+// systems (Jenkins, GitLab, GitHub Actions reporters) accept
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds every test file of one perlcov run
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is one test file
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+// junitFailure carries a failed test's captured error output
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes results as a JUnit XML report with one testcase per
+// test file. Failures carry the test's Error, and stdout and stderr go to
+// system-out and system-err. Text is escaped by encoding/xml, which also
+// replaces characters XML can't hold (e.g. control bytes in Perl dumps).
+func WriteJUnit(results []TestResult, started time.Time, w io.Writer) error {
+	suite := junitTestSuite{Name: "perlcov", Tests: len(results)}
+	if !started.IsZero() {
+		suite.Timestamp = started.UTC().Format(time.RFC3339)
+	}
+
+	var total time.Duration
+	for _, r := range results {
+		total += r.Duration
+		tc := junitTestCase{
+			Name:      r.File,
+			ClassName: junitClassName(r.File),
+			Time:      junitSeconds(r.Duration),
+			SystemOut: r.Output,
+			SystemErr: r.Warnings,
+		}
+		if !r.Passed {
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: firstLine(r.Error), Text: r.Error}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = junitSeconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// junitClassName turns a test path into a dotted class name, as JUnit
+// consumers group test cases by it: t/api/users.t -> t.api.users
+func junitClassName(testFile string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(testFile, "./"), ".t")
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// junitSeconds formats d as JUnit's fractional seconds
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// firstLine returns the first non-blank line of s, for failure messages
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return "test failed"
+}
@@ -0,0 +1,211 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryPoll is how often a held-back test checks the running tests' memory
+const memoryPoll = 200 * time.Millisecond
+
+// memorySettle is how long a test runs before its memory counts as
+// measured, while no test has been seen to grow yet
+const memorySettle = time.Second
+
+// MemoryThrottleSupported reports whether MaxMemoryMB can be enforced: it
+// reads process memory from /proc, so only on Linux
+func MemoryThrottleSupported() bool {
+	_, err := os.Stat("/proc/self/statm")
+	return err == nil
+}
+
+// memoryGate holds back new tests while the running ones, with every
+// process they started, use more resident memory than limit. A test that
+// just started hasn't grown yet, so each running test counts as at least
+// the most any test has been seen to use, and before that is known a new
+// test waits for the running ones to settle. It is still soft: one test
+// always runs, however much it uses. A nil gate lets everything through.
+type memoryGate struct {
+	limit  int64                          // bytes
+	rss    func(pids []int) map[int]int64 // resident bytes of each pid with its descendants
+	poll   time.Duration
+	settle time.Duration
+
+	mu      sync.Mutex
+	running int
+	pids    map[int]time.Time // tracked processes and when they started
+	peak    int64             // most one test has been seen to use
+}
+
+// newMemoryGate returns the gate for r.MaxMemoryMB, or nil without a limit
+// or without /proc
+func (r *Runner) newMemoryGate() *memoryGate {
+	if r.MaxMemoryMB <= 0 || !MemoryThrottleSupported() {
+		return nil
+	}
+	return &memoryGate{
+		limit:  int64(r.MaxMemoryMB) << 20,
+		rss:    treeRSS,
+		poll:   memoryPoll,
+		settle: memorySettle,
+		pids:   make(map[int]time.Time),
+	}
+}
+
+// wait blocks until a test may start and counts it as running; held
+// reports whether it had to wait
+func (g *memoryGate) wait() (held bool) {
+	if g == nil {
+		return false
+	}
+	for {
+		if g.admit() {
+			return held
+		}
+		held = true
+		time.Sleep(g.poll)
+	}
+}
+
+// admit counts a test as running if the running ones leave room for it.
+// A test let through but yet to start its process can't be measured, so
+// it leaves no room.
+func (g *memoryGate) admit() bool {
+	g.mu.Lock()
+	if g.running == 0 {
+		g.running++
+		g.mu.Unlock()
+		return true
+	}
+	started := make(map[int]time.Time, len(g.pids))
+	pids := make([]int, 0, len(g.pids))
+	for pid, t := range g.pids {
+		started[pid] = t
+		pids = append(pids, pid)
+	}
+	measurable := len(pids) >= g.running
+	peak := g.peak
+	g.mu.Unlock()
+
+	usage := g.rss(pids)
+	var total, seen int64
+	for pid, t := range started {
+		rss := usage[pid]
+		seen = max(seen, rss)
+		if peak == 0 && time.Since(t) < g.settle {
+			measurable = false
+		}
+		total += max(rss, peak)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.peak = max(g.peak, seen)
+	if !measurable || total >= g.limit {
+		return false
+	}
+	g.running++
+	return true
+}
+
+// done counts a test started after wait as finished
+func (g *memoryGate) done() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.running--
+	g.mu.Unlock()
+}
+
+// track adds a running test process to the measured set
+func (g *memoryGate) track(pid int) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.pids[pid] = time.Now()
+	g.mu.Unlock()
+}
+
+// untrack removes a test process once it has exited
+func (g *memoryGate) untrack(pid int) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	delete(g.pids, pid)
+	g.mu.Unlock()
+}
+
+// treeRSS sums the resident memory of each of pids and all its
+// descendants, so a prove harness counts with the perl it runs. Processes
+// that exit while /proc is read are skipped.
+func treeRSS(pids []int) map[int]int64 {
+	usage := make(map[int]int64, len(pids))
+	if len(pids) == 0 {
+		return usage
+	}
+	children := make(map[int][]int)
+	entries, _ := os.ReadDir("/proc")
+	for _, e := range entries {
+		child, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if ppid := parentPID(child); ppid > 0 {
+			children[ppid] = append(children[ppid], child)
+		}
+	}
+
+	for _, root := range pids {
+		seen := make(map[int]bool)
+		queue := []int{root}
+		for len(queue) > 0 {
+			pid := queue[0]
+			queue = queue[1:]
+			if seen[pid] {
+				continue
+			}
+			seen[pid] = true
+			usage[root] += processRSS(pid)
+			queue = append(queue, children[pid]...)
+		}
+	}
+	return usage
+}
+
+// parentPID reads a process's parent from /proc/<pid>/stat, or 0
+func parentPID(pid int) int {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+	// The command name in parentheses can hold spaces, so split after it
+	s := string(data)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+// processRSS reads a process's resident memory in bytes from
+// /proc/<pid>/statm, or 0
+func processRSS(pid int) int64 {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "statm"))
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, _ := strconv.ParseInt(fields[1], 10, 64)
+	return pages * int64(os.Getpagesize())
+}
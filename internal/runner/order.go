@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// Supported test dispatch orders
+const (
+	OrderDefault     = ""             // Longest cached duration first, else discovery order
+	OrderAlpha       = "alpha"        // Sorted by path
+	OrderSize        = "size"         // Largest files first, for better parallel packing
+	OrderRandom      = "random"       // Shuffled, reproducible with Seed
+	OrderFailedFirst = "failed-first" // Tests that failed in the cached previous run first
+)
+
+// ValidOrders lists the accepted values for Runner.Order
+var ValidOrders = []string{OrderAlpha, OrderSize, OrderRandom, OrderFailedFirst}
+
+// dispatchOrder returns the indices of testFiles in the order they should be
+// sent to workers. Results stay indexed by original position, so only the
+// dispatch order changes.
+func (r *Runner) dispatchOrder(testFiles []string) []int {
+	order := make([]int, len(testFiles))
+	for i := range order {
+		order[i] = i
+	}
+
+	switch r.Order {
+	case OrderDefault:
+		r.sortByCachedDuration(testFiles, order)
+	case OrderAlpha:
+		sort.SliceStable(order, func(a, b int) bool {
+			return testFiles[order[a]] < testFiles[order[b]]
+		})
+	case OrderSize:
+		sizes := make([]int64, len(testFiles))
+		for i, tf := range testFiles {
+			if info, err := os.Stat(tf); err == nil {
+				sizes[i] = info.Size()
+			}
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			if sizes[order[a]] != sizes[order[b]] {
+				return sizes[order[a]] > sizes[order[b]]
+			}
+			return testFiles[order[a]] < testFiles[order[b]]
+		})
+	case OrderRandom:
+		rng := rand.New(rand.NewSource(r.Seed))
+		rng.Shuffle(len(order), func(a, b int) {
+			order[a], order[b] = order[b], order[a]
+		})
+	case OrderFailedFirst:
+		failed := func(i int) bool {
+			if r.Cache == nil {
+				return false
+			}
+			prev, ok := r.Cache.Tests[testFiles[i]]
+			return ok && !prev.Passed
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return failed(order[a]) && !failed(order[b])
+		})
+	}
+
+	return order
+}
+
+// dispatchGroups splits the dispatch order into the units handed to
+// workers. Tests whose path gives the same SerialGroup key share a unit, run
+// one after another on a single worker, at the position of the first of
+// them; every other test is a unit of its own.
+func (r *Runner) dispatchGroups(testFiles []string) [][]int {
+	var units [][]int
+	byKey := make(map[string]int) // serial group key -> index in units
+	for _, i := range r.dispatchOrder(testFiles) {
+		key, ok := serialKey(r.SerialGroup, testFiles[i])
+		if !ok {
+			units = append(units, []int{i})
+			continue
+		}
+		if u, seen := byKey[key]; seen {
+			units[u] = append(units[u], i)
+			continue
+		}
+		byKey[key] = len(units)
+		units = append(units, []int{i})
+	}
+	return units
+}
+
+// serialKey returns the serial group testFile belongs to: the text of re's
+// first capture group, or of the whole match when re has none. ok is false
+// when re is nil or doesn't match.
+func serialKey(re *regexp.Regexp, testFile string) (key string, ok bool) {
+	if re == nil {
+		return "", false
+	}
+	m := re.FindStringSubmatch(testFile)
+	if m == nil {
+		return "", false
+	}
+	if len(m) > 1 {
+		return m[1], true
+	}
+	return m[0], true
+}
+
+// sortByCachedDuration orders tests longest-first using durations from the
+// cache (LPT scheduling), so slow tests don't start last and leave workers
+// idle at the end of the run. Tests without a cached duration are assumed
+// to take the median time and are dispatched ahead of known tests of equal
+// duration, since they might be slow. Without cached durations the order is
+// left unchanged.
+func (r *Runner) sortByCachedDuration(testFiles []string, order []int) {
+	durations, unknown, ok := r.Cache.estimateDurations(testFiles)
+	if !ok {
+		return
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		da, db := durations[order[a]], durations[order[b]]
+		if da != db {
+			return da > db
+		}
+		return unknown[order[a]] && !unknown[order[b]]
+	})
+}
+
+// estimateDurations returns the cached duration of each test, using the
+// median of the known durations for tests that have none. ok is false when
+// the cache holds no duration for any of the tests.
+func (c *Cache) estimateDurations(testFiles []string) (durations []float64, unknown []bool, ok bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+
+	var known []float64
+	for _, tf := range testFiles {
+		if prev, ok := c.Tests[tf]; ok && prev.Duration > 0 {
+			known = append(known, prev.Duration)
+		}
+	}
+	if len(known) == 0 {
+		return nil, nil, false
+	}
+	sort.Float64s(known)
+	median := known[len(known)/2]
+
+	durations = make([]float64, len(testFiles))
+	unknown = make([]bool, len(testFiles))
+	for i, tf := range testFiles {
+		if prev, ok := c.Tests[tf]; ok && prev.Duration > 0 {
+			durations[i] = prev.Duration
+		} else {
+			durations[i] = median
+			unknown[i] = true
+		}
+	}
+	return durations, unknown, true
+}
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ProgressEvent describes a test starting or finishing during a run
+type ProgressEvent struct {
+	File      string
+	Started   bool        // true when File just started, false when it finished
+	Result    *TestResult // Result of the finished test (nil on start)
+	Completed int
+	Passed    int
+	Total     int
+	Running   []string // Tests currently running, sorted
+}
+
+// Failed returns the number of completed tests that failed
+func (e ProgressEvent) Failed() int {
+	return e.Completed - e.Passed
+}
+
+// progress tracks a run's state and publishes events to a callback
+type progress struct {
+	mu        sync.Mutex
+	onEvent   func(ProgressEvent)
+	total     int
+	completed int
+	passed    int
+	running   map[string]bool
+}
+
+// newProgress creates a tracker for total tests. A nil onEvent prints a
+// progress line every 10 tests and for the last one.
+func newProgress(total int, onEvent func(ProgressEvent)) *progress {
+	if onEvent == nil {
+		onEvent = printProgress
+	}
+	return &progress{onEvent: onEvent, total: total, running: make(map[string]bool)}
+}
+
+func (p *progress) start(file string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running[file] = true
+	p.onEvent(p.event(file, true, nil))
+}
+
+func (p *progress) finish(result TestResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.running, result.File)
+	p.completed++
+	if result.Passed {
+		p.passed++
+	}
+	p.onEvent(p.event(result.File, false, &result))
+}
+
+// event snapshots the current state; p.mu must be held
+func (p *progress) event(file string, started bool, result *TestResult) ProgressEvent {
+	running := make([]string, 0, len(p.running))
+	for f := range p.running {
+		running = append(running, f)
+	}
+	sort.Strings(running)
+	return ProgressEvent{
+		File:      file,
+		Started:   started,
+		Result:    result,
+		Completed: p.completed,
+		Passed:    p.passed,
+		Total:     p.total,
+		Running:   running,
+	}
+}
+
+// printProgress is the default progress output
+func printProgress(e ProgressEvent) {
+	if e.Started {
+		return
+	}
+	// Print progress every 10 tests or for the last one
+	if e.Completed%10 == 0 || e.Completed == e.Total {
+		fmt.Printf("\rProgress: %d/%d tests completed (%d passed, %d failed)   ",
+			e.Completed, e.Total, e.Passed, e.Failed())
+	}
+}
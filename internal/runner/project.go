@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Project types, told apart by the files their tooling keeps in the root
+const (
+	ProjectAuto        = "auto"         // Detect from the files present
+	ProjectDzil        = "dzil"         // Dist::Zilla: dist.ini
+	ProjectMinil       = "minil"        // Minilla: minil.toml
+	ProjectModuleBuild = "module-build" // Module::Build: Build.PL
+	ProjectMakeMaker   = "makemaker"    // ExtUtils::MakeMaker: Makefile.PL
+	ProjectCpanfile    = "cpanfile"     // Just a cpanfile listing dependencies
+	ProjectPlain       = "plain"        // None of the above
+)
+
+// ValidProjectTypes lists the accepted project types
+var ValidProjectTypes = []string{ProjectAuto, ProjectDzil, ProjectMinil, ProjectModuleBuild, ProjectMakeMaker, ProjectCpanfile, ProjectPlain}
+
+// projectMarkers maps the file marking each project type, in detection
+// order: Minilla also generates a Build.PL, and most projects with a
+// builder also have a cpanfile
+var projectMarkers = []struct{ file, projectType string }{
+	{"dist.ini", ProjectDzil},
+	{"minil.toml", ProjectMinil},
+	{"Build.PL", ProjectModuleBuild},
+	{"Makefile.PL", ProjectMakeMaker},
+	{"cpanfile", ProjectCpanfile},
+}
+
+// DetectProjectType returns the type of the project in root
+func DetectProjectType(root string) string {
+	for _, m := range projectMarkers {
+		if info, err := os.Stat(filepath.Join(root, m.file)); err == nil && !info.IsDir() {
+			return m.projectType
+		}
+	}
+	return ProjectPlain
+}
+
+// ProjectLayout is where a project type keeps its sources and what its
+// tooling builds that tests and coverage should skip
+type ProjectLayout struct {
+	SourceDirs []string // Directories to measure
+	Ignore     []string // Gitignore-style patterns for build output
+}
+
+// LayoutFor returns the layout of a projectType project in root. Sources
+// are in lib for every type; what differs is the build output: blib for
+// MakeMaker and Module::Build, .build and the built Name-Version/
+// directory for Dist::Zilla, and .build for Minilla. Copies of lib and t
+// in those would otherwise be tested and reported twice.
+func LayoutFor(root, projectType string) ProjectLayout {
+	layout := ProjectLayout{SourceDirs: []string{"lib"}}
+	switch projectType {
+	case ProjectDzil:
+		layout.Ignore = append(layout.Ignore, ".build/")
+		if name := distName(filepath.Join(root, "dist.ini")); name != "" {
+			layout.Ignore = append(layout.Ignore, name+"-*/")
+		}
+	case ProjectMinil:
+		layout.Ignore = append(layout.Ignore, ".build/")
+	case ProjectModuleBuild, ProjectMakeMaker:
+		layout.Ignore = append(layout.Ignore, "blib/")
+	}
+	return layout
+}
+
+// distNameRe matches dist.ini's top-level name = line
+var distNameRe = regexp.MustCompile(`^name\s*=\s*(\S+)`)
+
+// distName reads the distribution name from a dist.ini, or "" without one
+func distName(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			return "" // the name comes before the first plugin section
+		}
+		if m := distNameRe.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
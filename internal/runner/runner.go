@@ -2,11 +2,16 @@ package runner
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,34 +19,111 @@ import (
 
 // TestResult holds the result of running a single test
 type TestResult struct {
-	File      string
-	Passed    bool
-	Error     string
-	Output    string
-	Duration  time.Duration
-	CoverDir  string // The isolated coverage directory used for this test
+	File     string
+	Passed   bool
+	Error    string
+	Output   string
+	Duration time.Duration
+	CoverDir string // The isolated coverage directory used for this test
+	Attempts int    // Number of times the test was run (more than 1 when retried)
+	Warnings string // Everything the test wrote to stderr, kept even when it passed
+
+	// CoverageOnlyFailure is set on a failed test that passed when rerun
+	// without Devel::Cover, so the failure comes from the instrumentation
+	CoverageOnlyFailure bool
+
+	// CoverageToolingError is set on a test that failed under Devel::Cover
+	// with Devel::Cover frames in its stderr, so the instrumentation died
+	// rather than the test (see coverToolingError)
+	CoverageToolingError bool
 }
 
+// PassedOnRetry reports whether the test failed at first but passed on a retry
+func (t TestResult) PassedOnRetry() bool {
+	return t.Passed && t.Attempts > 1
+}
+
+// Supported test harnesses
+const (
+	HarnessPerl  = "perl"  // Run each test directly with perl (default)
+	HarnessProve = "prove" // Run each test through prove
+)
+
+// DefaultCriteria are the Devel::Cover coverage criteria collected by default
+// ("path" is accepted by Devel::Cover but never recorded, so it isn't offered)
+var DefaultCriteria = []string{"statement", "branch", "condition", "subroutine"}
+
+// Devel::Cover database formats for Runner.DBFormat
+const (
+	DBFormatJSON     = "json"
+	DBFormatStorable = "storable"
+	DBFormatSereal   = "sereal"
+)
+
+// ValidDBFormats lists the accepted values for Runner.DBFormat
+var ValidDBFormats = []string{DBFormatJSON, DBFormatStorable, DBFormatSereal}
+
+// dbFormatModules maps each format to its Devel::Cover::DB::IO module
+var dbFormatModules = map[string]string{
+	DBFormatJSON:     "JSON",
+	DBFormatStorable: "Storable",
+	DBFormatSereal:   "Sereal",
+}
+
+// ValidCriteria are the criteria that can be requested
+var ValidCriteria = []string{"statement", "branch", "condition", "subroutine", "pod", "time"}
+
+// testDirIgnore is the -ignore regex for the test directory, dropped when
+// TestLib covers test helpers
+const testDirIgnore = `^t/`
+
+// DefaultCoverIgnore are the Devel::Cover -ignore regexes that keep test
+// files out of coverage
+var DefaultCoverIgnore = []string{testDirIgnore, `\.t$`}
+
 // Runner runs Perl tests with optional coverage
 type Runner struct {
-	IncludePaths []string
-	CoverDir     string
-	Jobs         int
-	Verbose      bool
-	SourceDirs   []string
-	NoSelect     bool
-	JSONMerge    bool   // Use JSON format for coverage data (enables pure Go merging)
-	PerlPath     string // Path to perl executable
-	ShowOutput   bool   // Show test output during execution
+	IncludePaths    []string
+	CoverDir        string
+	Jobs            int
+	SourceDirs      []string
+	NoSelect        bool
+	JSONMerge       bool           // Use JSON format for coverage data (enables pure Go merging)
+	DBFormat        string         // Format tests write their coverage in, one of ValidDBFormats ("" leaves Devel::Cover's default)
+	PerlPath        string         // Path to perl executable
+	ShowOutput      bool           // Show test output during execution
+	Retries         int            // Number of times to retry a failing test before marking it failed
+	Harness         string         // Test harness: HarnessPerl (default) or HarnessProve
+	Criteria        []string       // Devel::Cover coverage criteria (default: DefaultCriteria)
+	Order           string         // Dispatch order (see Order* constants)
+	Seed            int64          // Seed for OrderRandom
+	Cache           *Cache         // Results from previous runs (used by OrderFailedFirst)
+	LocalLib        string         // local::lib root whose lib/perl5 is added to @INC ("" detects ./local)
+	NoAutoInc       bool           // Don't add lib or local/lib/perl5 to @INC automatically
+	Env             []string       // Extra KEY=VALUE environment variables for tests
+	SelectMap       *SelectMap     // Modules to -select for mapped tests, instead of guessing from the filename
+	Root            string         // Project directory tests run in and relative paths resolve against (default: working directory)
+	CoverIgnore     []string       // Extra Devel::Cover -ignore regexes
+	CoverSelect     []string       // Extra Devel::Cover -select regexes, added to every test's options
+	NoDefaultIgnore bool           // Leave DefaultCoverIgnore out of the Devel::Cover options
+	TestLib         string         // Test helper directory to cover despite the ^t/ -ignore ("" keeps tests out of coverage)
+	SerialGroup     *regexp.Regexp // Tests whose paths share a first capture group run one after another on one worker
+	MaxMemoryMB     int            // Hold back new tests while running ones use more memory than this (0: no limit; needs /proc)
+	BailPercent     float64        // Stop once more than this percentage of finished tests died inside Devel::Cover (0: never)
+	Logger          *slog.Logger   // Diagnostics such as the -select and -ignore options chosen per test (default: discarded)
+
+	// OnProgress, if set, receives an event whenever a test starts or
+	// finishes. Calls are serialized. When nil, a progress line is printed
+	// every 10 tests.
+	OnProgress func(ProgressEvent)
 }
 
 // New creates a new Runner
-func New(includePaths []string, coverDir string, jobs int, verbose bool, sourceDirs []string, noSelect bool, jsonMerge bool, perlPath string, showOutput bool) *Runner {
+func New(includePaths []string, coverDir string, jobs int, sourceDirs []string, noSelect bool, jsonMerge bool, perlPath string, showOutput bool) *Runner {
 	return &Runner{
 		IncludePaths: includePaths,
 		CoverDir:     coverDir,
 		Jobs:         jobs,
-		Verbose:      verbose,
 		SourceDirs:   sourceDirs,
 		NoSelect:     noSelect,
 		JSONMerge:    jsonMerge,
@@ -50,125 +132,390 @@ func New(includePaths []string, coverDir string, jobs int, verbose bool, sourceD
 	}
 }
 
-// CheckDevelCover verifies that Devel::Cover is installed
-func CheckDevelCover(perlPath string) error {
+// CoverVersion is a Devel::Cover version such as 1.40 (or 1.39_01 for a
+// development release)
+type CoverVersion struct {
+	Raw   string
+	Major int
+	Minor int
+}
+
+func (v CoverVersion) String() string {
+	return v.Raw
+}
+
+// AtLeast reports whether v is major.minor or later, for gating features
+// that need a minimum Devel::Cover
+func (v CoverVersion) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+// ParseCoverVersion parses a Devel::Cover $VERSION string
+func ParseCoverVersion(s string) (CoverVersion, error) {
+	v := CoverVersion{Raw: s}
+	majorStr, minorStr, _ := strings.Cut(s, ".")
+	minorStr, _, _ = strings.Cut(minorStr, "_")
+	var err error
+	if v.Major, err = strconv.Atoi(majorStr); err != nil {
+		return v, fmt.Errorf("invalid Devel::Cover version %q", s)
+	}
+	if minorStr != "" {
+		if v.Minor, err = strconv.Atoi(minorStr); err != nil {
+			return v, fmt.Errorf("invalid Devel::Cover version %q", s)
+		}
+	}
+	return v, nil
+}
+
+var (
+	coverVersionsMu sync.Mutex
+	coverVersions   = make(map[string]CoverVersion) // perl path -> checked version
+)
+
+// CheckDevelCover verifies that Devel::Cover is installed for perlPath and
+// returns its version. The result is cached for the life of the process, so
+// only the first call per perl spawns it.
+func CheckDevelCover(perlPath string) (CoverVersion, error) {
+	coverVersionsMu.Lock()
+	defer coverVersionsMu.Unlock()
+	if v, ok := coverVersions[perlPath]; ok {
+		return v, nil
+	}
+
 	// Use -silent,1 to suppress verbose output and -ignore with pattern to ignore -e files
 	// The pattern ^\\-e$ matches the literal string "-e" that Devel::Cover sees
 	cmd := exec.Command(perlPath, "-MDevel::Cover=-silent,1,-ignore,^\\-e$", "-e", "print $Devel::Cover::VERSION")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("Devel::Cover is not installed. Install with: cpan Devel::Cover\nError: %s", string(output))
+		return CoverVersion{}, fmt.Errorf("Devel::Cover is not installed. Install with: cpan Devel::Cover\nError: %s", string(output))
+	}
+	v, err := ParseCoverVersion(strings.TrimSpace(string(output)))
+	if err != nil {
+		return CoverVersion{}, err
+	}
+	coverVersions[perlPath] = v
+	return v, nil
+}
+
+// CheckDBFormat verifies that the Devel::Cover installed for perlPath, at
+// version, can write format: its Devel::Cover::DB::IO module, and for
+// Sereal the Sereal modules, must load
+func CheckDBFormat(perlPath string, version CoverVersion, format string) error {
+	module, ok := dbFormatModules[format]
+	if !ok {
+		return fmt.Errorf("unknown database format %q (valid: %s)", format, strings.Join(ValidDBFormats, ", "))
+	}
+	cmd := exec.Command(perlPath, "-MDevel::Cover::DB::IO::"+module, "-e", "1")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Devel::Cover %s can't write %s databases: Devel::Cover::DB::IO::%s doesn't load\nError: %s",
+			version, format, module, strings.TrimSpace(string(output)))
 	}
-	fmt.Printf("Using Devel::Cover version %s\n", strings.TrimSpace(string(output)))
 	return nil
 }
 
+// ErrCoverageBroken is returned by RunTests when it stops early because
+// more than BailPercent of the finished tests died inside Devel::Cover
+var ErrCoverageBroken = errors.New("coverage instrumentation appears broken")
+
+// bailMinFinished is how many tests must finish before BailPercent is
+// checked, so one early tooling error doesn't stop a large run
+const bailMinFinished = 5
+
 // RunTests runs all test files with coverage
 // Each test file gets its own isolated coverage directory to avoid conflicts
-// when multiple tests exercise the same source files
-func (r *Runner) RunTests(testFiles []string) []TestResult {
+// when multiple tests exercise the same source files.
+// With BailPercent set, it stops dispatching once too many tests have died
+// inside Devel::Cover and returns the finished tests' results with an error
+// wrapping ErrCoverageBroken.
+func (r *Runner) RunTests(testFiles []string) ([]TestResult, error) {
+	bail := newToolingBail(r.BailPercent)
+	results := r.runParallel(testFiles, bail, func(i int, gate *memoryGate) TestResult {
+		// Each test gets an isolated coverage directory
+		return r.runWithRetries(testFiles[i], i, gate)
+	})
+	if !bail.stopped() {
+		return results, nil
+	}
+
+	// Tests never dispatched have no result
+	var finished []TestResult
+	var example string
+	for _, result := range results {
+		if result.File == "" {
+			continue
+		}
+		finished = append(finished, result)
+		if result.CoverageToolingError && example == "" {
+			example = result.File
+		}
+	}
+	return finished, fmt.Errorf("%w: %d of %d finished test(s) died inside Devel::Cover (more than %g%%, e.g. %s); the remaining %d were not run",
+		ErrCoverageBroken, bail.tooling, bail.finished, bail.percent, example, len(testFiles)-len(finished))
+}
+
+// toolingBail trips once more than percent of the finished tests, and at
+// least bailMinFinished of them, died inside Devel::Cover. A nil bail never
+// trips.
+type toolingBail struct {
+	percent float64
+
+	mu       sync.Mutex
+	finished int
+	tooling  int
+	tripped  bool
+}
+
+// newToolingBail returns the bail for percent, or nil when it is 0
+func newToolingBail(percent float64) *toolingBail {
+	if percent <= 0 {
+		return nil
+	}
+	return &toolingBail{percent: percent}
+}
+
+// record counts a finished test
+func (b *toolingBail) record(result TestResult) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.finished++
+	if result.CoverageToolingError {
+		b.tooling++
+	}
+	if b.finished >= bailMinFinished && float64(b.tooling)*100 > b.percent*float64(b.finished) {
+		b.tripped = true
+	}
+}
+
+// stopped reports whether the bail has tripped
+func (b *toolingBail) stopped() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tripped
+}
+
+// runParallel runs run(i) for every test across r.Jobs workers in dispatch
+// order, publishing start and finish events as tests progress. run gets
+// this call's memory gate to track the test's process with. Once bail
+// trips, the tests not yet started are skipped and keep a zero result.
+func (r *Runner) runParallel(testFiles []string, bail *toolingBail, run func(i int, gate *memoryGate) TestResult) []TestResult {
 	results := make([]TestResult, len(testFiles))
-	total := len(testFiles)
 
-	// Create a channel for jobs
-	jobs := make(chan int, len(testFiles))
-	for i := range testFiles {
-		jobs <- i
+	// Create a channel for jobs; each job is a serial group run by one worker
+	units := r.dispatchGroups(testFiles)
+	jobs := make(chan []int, len(units))
+	for _, unit := range units {
+		jobs <- unit
 	}
 	close(jobs)
 
-	// Track progress
-	var completed int
-	var passed int
+	p := newProgress(len(testFiles), r.OnProgress)
+
+	gate := r.newMemoryGate()
 
 	// Run tests in parallel
 	var wg sync.WaitGroup
-	var mu sync.Mutex
-
 	for w := 0; w < r.Jobs; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for i := range jobs {
-				// Each test gets an isolated coverage directory
-				isolatedCoverDir := fmt.Sprintf("%s_%d", r.CoverDir, i)
-				result := r.runSingleTest(testFiles[i], true, isolatedCoverDir)
-				mu.Lock()
-				results[i] = result
-				completed++
-				current := completed
-				if result.Passed {
-					passed++
-				}
-				currentPassed := passed
-				// Print progress every 10 tests or for the last one
-				if current%10 == 0 || int(current) == total {
-					fmt.Printf("\rProgress: %d/%d tests completed (%d passed, %d failed)   ",
-						current, total, currentPassed, current-currentPassed)
+			for unit := range jobs {
+				for _, i := range unit {
+					if bail.stopped() {
+						continue
+					}
+					if gate.wait() {
+						r.log().Debug("held back for memory", "test", testFiles[i], "max_mb", r.MaxMemoryMB)
+					}
+					p.start(testFiles[i])
+					result := run(i, gate)
+					results[i] = result
+					p.finish(result)
+					gate.done()
+					bail.record(result)
 				}
-				mu.Unlock()
 			}
 		}()
 	}
 
 	wg.Wait()
-	fmt.Println() // Newline after progress
+	if r.OnProgress == nil {
+		fmt.Println() // Newline after progress
+	}
 	return results
 }
 
-// RunTestsWithoutCoverage runs tests without Devel::Cover
-func (r *Runner) RunTestsWithoutCoverage(testFiles []string) []TestResult {
-	results := make([]TestResult, len(testFiles))
-	total := len(testFiles)
+// runWithRetries runs a test with coverage, retrying up to r.Retries times on failure.
+// Coverage from failed attempts is discarded so only the final attempt is merged.
+func (r *Runner) runWithRetries(testFile string, index int, gate *memoryGate) TestResult {
+	result := r.runIsolated(testFile, index, gate)
+	result.Attempts = 1
+	for attempt := 2; !result.Passed && attempt <= r.Retries+1; attempt++ {
+		r.log().Debug("retry", "test", testFile, "attempt", attempt, "max", r.Retries+1)
+		r.discardCoverDir(result)
+		result = r.runIsolated(testFile, index, gate)
+		result.Attempts = attempt
+	}
+	return result
+}
 
-	jobs := make(chan int, len(testFiles))
-	for i := range testFiles {
-		jobs <- i
+// discardCoverDir removes the coverage directory of a failed attempt. The
+// next attempt's result replaces this one, so a directory left behind is
+// never merged, but it is reported rather than leaked silently.
+func (r *Runner) discardCoverDir(result TestResult) {
+	if result.CoverDir == "" {
+		return
 	}
-	close(jobs)
+	if err := os.RemoveAll(result.CoverDir); err != nil {
+		r.log().Warn("failed to remove coverage from a failed attempt", "test", result.File, "dir", result.CoverDir, "err", err)
+	}
+}
 
-	// Track progress
-	var completed int
-	var passed int
+// runIsolated runs a test with coverage in a freshly created coverage directory
+func (r *Runner) runIsolated(testFile string, index int, gate *memoryGate) TestResult {
+	coverDir, err := r.newIsolatedCoverDir(index)
+	if err != nil {
+		return TestResult{
+			File:  testFile,
+			Error: fmt.Sprintf("failed to create coverage directory: %v", err),
+		}
+	}
+	return r.runSingleTest(testFile, true, coverDir, gate)
+}
 
-	var wg sync.WaitGroup
-	var mu sync.Mutex
+// newIsolatedCoverDir creates a unique coverage directory next to r.CoverDir.
+// The random suffix keeps concurrent perlcov invocations sharing a cover dir
+// from colliding; callers find the directory on TestResult.CoverDir.
+func (r *Runner) newIsolatedCoverDir(index int) (string, error) {
+	coverDir := r.CoverDir
+	if r.Root != "" && !filepath.IsAbs(coverDir) {
+		coverDir = filepath.Join(r.Root, coverDir)
+	}
+	parent := filepath.Dir(coverDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(parent, fmt.Sprintf("%s_%d_", filepath.Base(coverDir), index))
+}
 
-	for w := 0; w < r.Jobs; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for i := range jobs {
-				// No coverage directory needed when running without coverage
-				result := r.runSingleTest(testFiles[i], false, "")
-				mu.Lock()
-				results[i] = result
-				completed++
-				current := completed
-				if result.Passed {
-					passed++
-				}
-				currentPassed := passed
-				// Print progress every 10 tests or for the last one
-				if current%10 == 0 || int(current) == total {
-					fmt.Printf("\rProgress: %d/%d tests completed (%d passed, %d failed)   ",
-						current, total, currentPassed, current-currentPassed)
-				}
-				mu.Unlock()
-			}
-		}()
+// discardLogger drops everything, for runners without a Logger
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// log returns Logger, or a logger that discards everything
+func (r *Runner) log() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
 	}
+	return discardLogger
+}
 
-	wg.Wait()
-	fmt.Println() // Newline after progress
-	return results
+// root returns the project directory: Root, or the working directory
+func (r *Runner) root() string {
+	if r.Root != "" {
+		return r.Root
+	}
+	cwd, _ := os.Getwd()
+	return cwd
 }
 
-func (r *Runner) runSingleTest(testFile string, withCoverage bool, coverDir string) TestResult {
-	start := time.Now()
+// RemoveCoverDirs deletes the isolated coverage directories created for
+// results, leaving any other directories alone
+func RemoveCoverDirs(results []TestResult) {
+	for _, result := range results {
+		if result.CoverDir != "" {
+			os.RemoveAll(result.CoverDir) // Ignore errors
+		}
+	}
+}
 
+// SlowestTests returns the n longest-running of results, slowest first,
+// with ties in path order. n <= 0 returns them all.
+func SlowestTests(results []TestResult, n int) []TestResult {
+	sorted := append([]TestResult(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Duration != sorted[j].Duration {
+			return sorted[i].Duration > sorted[j].Duration
+		}
+		return sorted[i].File < sorted[j].File
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// RunTestsWithoutCoverage runs tests without Devel::Cover
+func (r *Runner) RunTestsWithoutCoverage(testFiles []string) []TestResult {
+	return r.runParallel(testFiles, nil, func(i int, gate *memoryGate) TestResult {
+		// No coverage directory needed when running without coverage
+		return r.runSingleTest(testFiles[i], false, "", gate)
+	})
+}
+
+// DryRun writes the command each test would run to w, one line per test in
+// dispatch order, without running anything. Environment variables the test
+// would get on top of ours (HARNESS_PERL_SWITCHES under prove, --env) are
+// written as a prefix so a line can be pasted into a shell as is.
+func (r *Runner) DryRun(testFiles []string, withCoverage bool, w io.Writer) error {
+	for _, i := range r.dispatchOrder(testFiles) {
+		cmd, _ := r.testCommand(testFiles[i], withCoverage, "")
+		if _, err := fmt.Fprintln(w, commandLine(cmd)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandLine renders cmd as a shell command line, prefixed with the
+// environment entries that differ from ours
+func commandLine(cmd *exec.Cmd) string {
+	inherited := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		inherited[kv] = true
+	}
+
+	var words []string
+	for _, kv := range cmd.Env {
+		if inherited[kv] {
+			continue
+		}
+		// Only the value is quoted, or the shell wouldn't see an assignment
+		key, value, _ := strings.Cut(kv, "=")
+		words = append(words, key+"="+shellQuote(value))
+	}
+	for _, arg := range cmd.Args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote single-quotes s unless it only contains characters no POSIX
+// shell treats specially
+func shellQuote(s string) string {
+	safe := s != ""
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_./,:=+@%", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// testCommand builds the command that runs testFile, under Devel::Cover
+// writing to coverDir (r.CoverDir when empty) if withCoverage is set, and
+// returns it with the absolute coverage directory
+func (r *Runner) testCommand(testFile string, withCoverage bool, coverDir string) (*exec.Cmd, string) {
 	// Get absolute paths for everything
-	cwd, _ := os.Getwd()
+	cwd := r.root()
 	absCoverDir := coverDir
 	if absCoverDir == "" {
 		absCoverDir = r.CoverDir
@@ -182,29 +529,40 @@ func (r *Runner) runSingleTest(testFile string, withCoverage bool, coverDir stri
 		absTestFile = filepath.Join(cwd, absTestFile)
 	}
 
-	args := []string{}
-
-	// Add include paths (convert to absolute)
-	for _, inc := range r.IncludePaths {
-		absInc := inc
-		if !filepath.IsAbs(absInc) {
-			absInc = filepath.Join(cwd, absInc)
-		}
-		args = append(args, "-I", absInc)
-	}
-
-	// Always add lib to include path if it exists
-	libPath := filepath.Join(cwd, "lib")
-	if _, err := os.Stat(libPath); err == nil {
-		args = append(args, "-I", libPath)
-	}
+	incArgs := r.includeArgs(cwd)
 
+	var coverOpts string
 	if withCoverage {
 		// Build Devel::Cover options with absolute path
-		coverOpts := fmt.Sprintf("-db,%s,-silent,1,-ignore,^t/,-ignore,\\.t$", absCoverDir)
+		coverOpts = fmt.Sprintf("-db,%s,-silent,1", absCoverDir)
+
+		// Keep test files, and anything else asked for, out of coverage
+		var ignores []string
+		if !r.NoDefaultIgnore {
+			for _, re := range DefaultCoverIgnore {
+				if re != testDirIgnore || r.TestLib == "" {
+					ignores = append(ignores, re)
+				}
+			}
+		}
+		for _, re := range append(ignores, r.CoverIgnore...) {
+			coverOpts += ",-ignore," + re
+		}
 
-		// Add source directories to coverage (as absolute paths)
-		for _, src := range r.SourceDirs {
+		// Restrict collection to the requested criteria
+		criteria := r.Criteria
+		if len(criteria) == 0 {
+			criteria = DefaultCriteria
+		}
+		coverOpts += ",-coverage," + strings.Join(criteria, ",")
+
+		// Add source directories, and any test helpers, to coverage (as
+		// absolute paths)
+		sourceDirs := r.SourceDirs
+		if r.TestLib != "" {
+			sourceDirs = append(append([]string{}, sourceDirs...), r.TestLib)
+		}
+		for _, src := range sourceDirs {
 			absSrc := src
 			if !filepath.IsAbs(absSrc) {
 				absSrc = filepath.Join(cwd, absSrc)
@@ -214,31 +572,66 @@ func (r *Runner) runSingleTest(testFile string, withCoverage bool, coverDir stri
 
 		// Try to derive module name from test filename for targeted coverage
 		// Skip this optimization if NoSelect is enabled (for benchmarking)
-		if !r.NoSelect {
-			if moduleName := extractModuleFromTestFile(testFile); moduleName != "" {
-				// Convert Module::Name to Module/Name.pm for file path matching
-				moduleFile := strings.ReplaceAll(moduleName, "::", "/") + ".pm"
-				// Check if module exists in lib or source directories
-				if moduleExists(moduleFile, cwd, r.SourceDirs) {
-					// Use -ignore to exclude lib/ files, then -select to include just
-					// the target module. The order matters: -ignore must come before
-					// -select for Devel::Cover to properly filter.
-					modulePattern := strings.TrimSuffix(moduleFile, ".pm")
-					coverOpts += fmt.Sprintf(",-ignore,lib/,-select,%s", modulePattern)
-					if r.Verbose {
-						fmt.Printf("  [select] %s -> %s\n", testFile, moduleName)
-					}
-				}
+		if modules, ok := r.SelectMap.Modules(testFile); ok && !r.NoSelect {
+			// Mapped tests select each listed module, however they're named
+			coverOpts += ",-ignore,lib/"
+			for _, module := range modules {
+				coverOpts += ",-select," + strings.ReplaceAll(module, "::", "/")
+			}
+			r.log().Debug("select", "test", testFile, "modules", strings.Join(modules, ","), "source", "select map")
+		} else if !r.NoSelect {
+			if moduleName, moduleFile := selectModule(testFile, cwd, r.SourceDirs); moduleName != "" {
+				// Use -ignore to exclude lib/ files, then -select to include just
+				// the target module. The order matters: -ignore must come before
+				// -select for Devel::Cover to properly filter.
+				modulePattern := strings.TrimSuffix(moduleFile, ".pm")
+				coverOpts += fmt.Sprintf(",-ignore,lib/,-select,%s", modulePattern)
+				r.log().Debug("select", "test", testFile, "modules", moduleName, "source", "filename")
 			}
 		}
 
-		args = append(args, "-MDevel::Cover="+coverOpts)
-	}
+		// Explicit selections apply to every test, on top of the module
+		// above. Test helpers are selected too, as the module's -ignore,lib/
+		// would otherwise catch t/lib.
+		selects := r.CoverSelect
+		if r.TestLib != "" {
+			selects = append([]string{testLibSelect(r.TestLib, cwd)}, selects...)
+		}
+		for _, re := range selects {
+			coverOpts += ",-select," + re
+		}
 
-	args = append(args, absTestFile)
+		r.log().Debug("cover options", "test", testFile, "options", coverOpts)
+	}
 
-	cmd := exec.Command(r.PerlPath, args...)
+	var cmd *exec.Cmd
+	switch r.Harness {
+	case HarnessProve:
+		// Run through prove so harness-level setup (.proverc, TAP::Harness
+		// plugins) applies. Devel::Cover is loaded via HARNESS_PERL_SWITCHES
+		// rather than on the command line, and -v echoes the raw TAP so
+		// containsTAPFailure can still inspect it.
+		args := append([]string{"-S", "prove", "-v"}, incArgs...)
+		args = append(args, absTestFile)
+		cmd = exec.Command(r.PerlPath, args...)
+		cmd.Env = r.testEnv(coverOpts)
+	default:
+		args := append([]string{}, incArgs...)
+		if coverOpts != "" {
+			args = append(args, "-MDevel::Cover="+coverOpts)
+		}
+		args = append(args, absTestFile)
+		cmd = exec.Command(r.PerlPath, args...)
+		cmd.Env = r.testEnv("")
+	}
 	cmd.Dir = cwd
+	return cmd, absCoverDir
+}
+
+// runSingleTest runs one test, tracking its process in gate (nil for none)
+func (r *Runner) runSingleTest(testFile string, withCoverage bool, coverDir string, gate *memoryGate) TestResult {
+	start := time.Now()
+	cmd, absCoverDir := r.testCommand(testFile, withCoverage, coverDir)
 
 	var stdout, stderr bytes.Buffer
 	if r.ShowOutput {
@@ -250,13 +643,19 @@ func (r *Runner) runSingleTest(testFile string, withCoverage bool, coverDir stri
 		cmd.Stderr = &stderr
 	}
 
-	err := cmd.Run()
+	err := cmd.Start()
+	if err == nil {
+		gate.track(cmd.Process.Pid)
+		err = cmd.Wait()
+		gate.untrack(cmd.Process.Pid)
+	}
 	duration := time.Since(start)
 
 	result := TestResult{
 		File:     testFile,
 		Duration: duration,
 		Output:   stdout.String(),
+		Warnings: stderr.String(),
 	}
 
 	// Record the coverage directory used for this test
@@ -267,7 +666,11 @@ func (r *Runner) runSingleTest(testFile string, withCoverage bool, coverDir stri
 	if err != nil {
 		result.Passed = false
 		result.Error = stderr.String()
-		if result.Error == "" {
+		if summary := tapFailureSummary(stdout.String()); summary != "" {
+			// The summary covers the failures with diagnostics, not why
+			// the test exited, e.g. a later die, so stderr follows it
+			result.Error = strings.TrimRight(summary+"\n"+result.Error, "\n")
+		} else if result.Error == "" {
 			result.Error = stdout.String()
 		}
 	} else {
@@ -275,8 +678,21 @@ func (r *Runner) runSingleTest(testFile string, withCoverage bool, coverDir stri
 		result.Passed = !containsTAPFailure(stdout.String())
 		if !result.Passed {
 			result.Error = stdout.String()
+			if summary := tapFailureSummary(stdout.String()); summary != "" {
+				result.Error = summary
+			}
+		} else if r.Harness != HarnessProve {
+			// A test can exit 0 having run fewer tests than planned; prove
+			// checks the plan itself and exits non-zero
+			if msg := tapPlanMismatch(stdout.String()); msg != "" {
+				result.Passed = false
+				result.Error = msg + "\n" + stdout.String()
+			}
 		}
 	}
+	if withCoverage && !result.Passed {
+		result.CoverageToolingError = coverToolingError(stderr.String())
+	}
 
 	return result
 }
@@ -318,6 +734,156 @@ func extractModuleFromTestFile(testFile string) string {
 	return moduleName
 }
 
+// moduleCandidates returns the modules testFile may be testing, best guess
+// first: the module named by the filename, then that name qualified by the
+// module-like directories above it, for nested layouts such as
+// t/App/Model/User.t -> App::Model::User
+func moduleCandidates(testFile string) []string {
+	name := extractModuleFromTestFile(testFile)
+	if name == "" {
+		return nil
+	}
+	candidates := []string{name}
+
+	// Collect directories upwards until one can't be part of a package name
+	// (t/, unit/, ...)
+	var parts []string
+	for dir := filepath.Dir(testFile); isPackageSegment(filepath.Base(dir)); dir = filepath.Dir(dir) {
+		parts = append([]string{filepath.Base(dir)}, parts...)
+	}
+	if len(parts) > 0 {
+		candidates = append(candidates, strings.Join(parts, "::")+"::"+name)
+	}
+	return candidates
+}
+
+// isPackageSegment reports whether s looks like one part of a Perl package
+// name: an identifier starting with an uppercase letter
+func isPackageSegment(s string) bool {
+	if s == "" || s[0] < 'A' || s[0] > 'Z' {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// selectModule returns the first of testFile's module candidates whose
+// source exists, with its path relative to the source directory, or empty
+// strings if none does
+func selectModule(testFile, cwd string, sourceDirs []string) (string, string) {
+	for _, moduleName := range moduleCandidates(testFile) {
+		// Convert Module::Name to Module/Name.pm for file path matching
+		moduleFile := strings.ReplaceAll(moduleName, "::", "/") + ".pm"
+		if moduleExists(moduleFile, cwd, sourceDirs) {
+			return moduleName, moduleFile
+		}
+	}
+	return "", ""
+}
+
+// testEnv returns the environment for a test process: ours, including
+// PERL5LIB, without DEVEL_COVER_OPTIONS (which would override the options
+// we pass to Devel::Cover) or Devel::Cover switches in
+// HARNESS_PERL_SWITCHES, with DEVEL_COVER_DB_FORMAT set for DBFormat,
+// followed by r.Env. For prove, harnessCoverOpts loads Devel::Cover from
+// HARNESS_PERL_SWITCHES with the options in DEVEL_COVER_OPTIONS: the
+// harness splits its switches on whitespace, which would break any path
+// in the options that contains a space.
+func (r *Runner) testEnv(harnessCoverOpts string) []string {
+	var env, switches []string
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		switch key {
+		case "DEVEL_COVER_OPTIONS":
+			continue
+		case "DEVEL_COVER_DB_FORMAT":
+			if r.DBFormat != "" {
+				continue
+			}
+		case "HARNESS_PERL_SWITCHES":
+			for _, sw := range strings.Fields(value) {
+				if !strings.HasPrefix(sw, "-MDevel::Cover") {
+					switches = append(switches, sw)
+				}
+			}
+			continue
+		}
+		env = append(env, kv)
+	}
+	if harnessCoverOpts != "" {
+		switches = append(switches, "-MDevel::Cover")
+		env = append(env, "DEVEL_COVER_OPTIONS="+harnessCoverOpts)
+	}
+	if len(switches) > 0 {
+		env = append(env, "HARNESS_PERL_SWITCHES="+strings.Join(switches, " "))
+	}
+	if r.DBFormat != "" {
+		env = append(env, "DEVEL_COVER_DB_FORMAT="+dbFormatModules[r.DBFormat])
+	}
+	// Later entries win, so --env can override anything above
+	return append(env, r.Env...)
+}
+
+// testLibSelect returns the -select regex for the files under dir, which
+// Devel::Cover names relative to cwd when they're inside it
+func testLibSelect(dir, cwd string) string {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cwd, dir)
+	}
+	if rel, err := filepath.Rel(cwd, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		dir = rel
+	}
+	return "^" + regexp.QuoteMeta(filepath.ToSlash(dir)) + "/"
+}
+
+// includeArgs returns the -I arguments for a test run from cwd: the
+// explicit include paths, then lib, blib/arch and blib/lib (what
+// MakeMaker and Module::Build build, including XS objects) and
+// local/lib/perl5 (Carton and local::lib's default) when they exist, unless
+// NoAutoInc is set. blib comes after lib so modules still load, and are
+// covered, from their sources. An explicit LocalLib replaces the local/
+// lookup and is always added.
+func (r *Runner) includeArgs(cwd string) []string {
+	var incArgs []string
+	abs := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(cwd, path)
+	}
+
+	// Add include paths (convert to absolute)
+	for _, inc := range r.IncludePaths {
+		incArgs = append(incArgs, "-I", abs(inc))
+	}
+
+	// Add lib, and what a build put in blib, to include path if they exist
+	if !r.NoAutoInc {
+		for _, dir := range []string{"lib", filepath.Join("blib", "arch"), filepath.Join("blib", "lib")} {
+			path := filepath.Join(cwd, dir)
+			if _, err := os.Stat(path); err == nil {
+				incArgs = append(incArgs, "-I", path)
+			}
+		}
+	}
+
+	// Add installed dependencies
+	if r.LocalLib != "" {
+		incArgs = append(incArgs, "-I", filepath.Join(abs(r.LocalLib), "lib", "perl5"))
+	} else if !r.NoAutoInc {
+		localPath := filepath.Join(cwd, "local", "lib", "perl5")
+		if _, err := os.Stat(localPath); err == nil {
+			incArgs = append(incArgs, "-I", localPath)
+		}
+	}
+
+	return incArgs
+}
+
 // moduleExists checks if a module file exists in cwd, lib, or any of the source directories
 func moduleExists(moduleFile, cwd string, sourceDirs []string) bool {
 	// Check in cwd first
@@ -348,21 +914,82 @@ func moduleExists(moduleFile, cwd string, sourceDirs []string) bool {
 	return false
 }
 
-// containsTAPFailure checks if the output contains TAP failure indicators
-func containsTAPFailure(output string) bool {
-	lines := strings.Split(output, "\n")
+var (
+	tapPlanRe = regexp.MustCompile(`^1\.\.(\d+)`)
+	tapTestRe = regexp.MustCompile(`^(?:not )?ok(?:\s|$)`)
+
+	// coverFrameRe matches where Devel::Cover shows up in a perl error: a
+	// die or warn location in one of its files, a Carp frame calling one
+	// of its subs, or its XS library failing to load
+	coverFrameRe = regexp.MustCompile(`Devel/Cover(?:/[\w/]+)?\.(?:pm|so|bundle|dll)\b|\bDevel::Cover(?:::\w+)+\(`)
+
+	// coverLoadRe matches perl failing to load a module, which is fatal
+	// wherever it shows up in stderr
+	coverLoadRe = regexp.MustCompile(`Can't (?:locate|load) |Compilation failed|BEGIN failed`)
+)
+
+// coverToolingError reports whether a failed test's stderr shows
+// Devel::Cover itself dying, e.g. on a perl it doesn't support, rather
+// than a failure of the test: Devel::Cover failing to load, or the message
+// the test died with, the last one, coming from Devel::Cover. Its warnings
+// earlier on, and its own banner and notices ("Devel::Cover: ..."), which
+// its END block prints after the die message, don't count.
+func coverToolingError(stderr string) bool {
+	lines := strings.Split(stderr, "\n")
 	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		// Check for "not ok" without "# TODO" or "# SKIP"
-		if strings.HasPrefix(line, "not ok") {
-			if !strings.Contains(line, "# TODO") && !strings.Contains(line, "# SKIP") {
-				return true
-			}
+		if coverLoadRe.MatchString(line) && coverFrameRe.MatchString(line) {
+			return true
 		}
-		// Check for Bail out
-		if strings.HasPrefix(line, "Bail out!") {
+	}
+
+	end := len(lines)
+	for end > 0 && (strings.TrimSpace(lines[end-1]) == "" || strings.HasPrefix(lines[end-1], "Devel::Cover: ")) {
+		end--
+	}
+	// Carp follows the message with its stack, one indented frame a line
+	start := end - 1
+	for start > 0 && strings.HasPrefix(lines[start], "\t") {
+		start--
+	}
+	return start >= 0 && coverFrameRe.MatchString(strings.Join(lines[start:end], "\n"))
+}
+
+// tapPlanMismatch compares the number of top-level test lines in output
+// with its plan and describes any difference. Output without a plan, or
+// with the skip-all plan 1..0 and no tests, passes. Indented lines belong
+// to subtests and are left to their parent's summary line.
+func tapPlanMismatch(output string) string {
+	planned, ran := -1, 0
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := tapPlanRe.FindStringSubmatch(line); m != nil && planned < 0 {
+			planned, _ = strconv.Atoi(m[1])
+			continue
+		}
+		if tapTestRe.MatchString(line) {
+			ran++
+		}
+	}
+	if planned < 0 || ran == planned {
+		return ""
+	}
+	return fmt.Sprintf("Planned %d tests but ran %d", planned, ran)
+}
+
+// containsTAPFailure checks if the output contains TAP failure indicators.
+// TAP13 YAML diagnostic blocks are skipped, so text quoted in them can't
+// pass for a test line.
+func containsTAPFailure(output string) bool {
+	for _, line := range parseTAPLines(output) {
+		if isTAPFailure(line.text) || strings.HasPrefix(line.text, "Bail out!") {
 			return true
 		}
 	}
 	return false
 }
+
+// isTAPFailure reports whether a trimmed TAP line is a "not ok" without
+// "# TODO" or "# SKIP"
+func isTAPFailure(line string) bool {
+	return strings.HasPrefix(line, "not ok") && !strings.Contains(line, "# TODO") && !strings.Contains(line, "# SKIP")
+}
@@ -1,6 +1,21 @@
 package runner
 
-import "testing"
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestExtractModuleFromTestFile(t *testing.T) {
 	tests := []struct {
@@ -95,6 +110,59 @@ func TestExtractModuleFromTestFile(t *testing.T) {
 	}
 }
 
+func TestModuleCandidates(t *testing.T) {
+	tests := []struct {
+		testFile string
+		want     []string
+	}{
+		{"t/Foo-Bar.t", []string{"Foo::Bar"}},
+		{"t/App/Model/User.t", []string{"User", "App::Model::User"}},
+		{"t/App/Model/User_roles.t", []string{"User", "App::Model::User"}},
+		{"t/App/User-Roles.t", []string{"User::Roles", "App::User::Roles"}},
+		{"t/unit/App/Model/User.t", []string{"User", "App::Model::User"}},
+		{"/home/User/project/t/App/Model/User.t", []string{"User", "App::Model::User"}},
+		{"App/Model/User.t", []string{"User", "App::Model::User"}},
+		{"t/My-Dir/User.t", []string{"User"}},
+		{"t/App/Model/00-load.t", nil},
+		{"t/App/basic.t", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testFile, func(t *testing.T) {
+			got := moduleCandidates(tt.testFile)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("moduleCandidates(%q) = %v, want %v", tt.testFile, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectModule(t *testing.T) {
+	cwd := t.TempDir()
+	for _, file := range []string{"lib/Flat.pm", "lib/App/Model/User.pm", "lib/App/Model/Flat.pm"} {
+		path := filepath.Join(cwd, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		testFile, wantModule, wantFile string
+	}{
+		{"t/App/Model/User.t", "App::Model::User", "App/Model/User.pm"},
+		{"t/App/Model/Flat.t", "Flat", "Flat.pm"}, // the filename still wins
+		{"t/App/Model/Missing.t", "", ""},
+	}
+	for _, tt := range tests {
+		module, file := selectModule(tt.testFile, cwd, nil)
+		if module != tt.wantModule || file != tt.wantFile {
+			t.Errorf("selectModule(%q) = %q, %q, want %q, %q", tt.testFile, module, file, tt.wantModule, tt.wantFile)
+		}
+	}
+}
+
 func TestContainsTAPFailure(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -136,11 +204,26 @@ func TestContainsTAPFailure(t *testing.T) {
 			output:   "1..0\n",
 			expected: false,
 		},
+		{
+			name:     "prove verbose output with failure",
+			output:   "t/a.t .. \n1..2\nok 1\nnot ok 2 - bad\nFailed 1/2 subtests \n\nResult: FAIL\n",
+			expected: true,
+		},
+		{
+			name:     "prove verbose output all passing",
+			output:   "t/a.t .. \n1..1\nok 1\nok\nAll tests successful.\nResult: PASS\n",
+			expected: false,
+		},
 		{
 			name:     "not ok in middle of line is not failure",
 			output:   "# this is not ok to do\nok 1 - test\n",
 			expected: false,
 		},
+		{
+			name:     "not ok inside YAML diagnostics is not failure",
+			output:   "TAP version 13\nok 1 - test\n  ---\n  message: |\n    not ok here\n  ...\n1..1\n",
+			expected: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -153,8 +236,213 @@ func TestContainsTAPFailure(t *testing.T) {
 	}
 }
 
+func TestTAPFailureSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name: "TAP13 diagnostics",
+			output: "TAP version 13\n1..3\nok 1 - loads\nnot ok 2 - adds numbers\n  ---\n" +
+				"  message: 'expected 4, got 5'\n  severity: fail\n  at:\n    file: t/math.t\n    line: 12\n" +
+				"  data:\n    got: 5\n  ...\nnot ok 3 - later # TODO not yet\n  ---\n  message: pending\n  ...\n",
+			want: "not ok 2 - adds numbers\n  message: expected 4, got 5\n  severity: fail\n  at: t/math.t line 12",
+		},
+		{
+			name: "block scalars and subtests",
+			output: "TAP version 13\n    not ok 1 - inner\n      ---\n      message: |\n        first line\n        second line\n" +
+				"      at: \"t/sub.t line 3\"\n      ...\n    1..1\nnot ok 1 - outer\n1..1\r\n",
+			want: "not ok 1 - inner\n  message: first line\n    second line\n  at: t/sub.t line 3\nnot ok 1 - outer",
+		},
+		{
+			name:   "folded message",
+			output: "not ok 1\n  ---\n  message: >\n    too\n    long\n  ...\n",
+			want:   "not ok 1\n  message: too long",
+		},
+		{
+			name:   "TAP12 without diagnostics",
+			output: "1..2\nok 1\nnot ok 2 - bad\n#   Failed test 'bad'\n",
+			want:   "",
+		},
+		{
+			name:   "unterminated block",
+			output: "not ok 1\n  ---\n  message: cut off\n",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tapFailureSummary(tt.output); got != tt.want {
+				t.Errorf("tapFailureSummary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTAPPlanMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"matching plan", "1..3\nok 1\nok 2\nnot ok 3 # TODO later\n", ""},
+		{"under-run", "1..10\nok 1\nok 2\nok 3\nok 4\nok 5\nok 6\nok 7\n", "Planned 10 tests but ran 7"},
+		{"over-run", "1..1\nok 1\nok 2\n", "Planned 1 tests but ran 2"},
+		{"plan at end", "ok 1\nok 2\n1..2\n", ""},
+		{"skip all", "1..0 # SKIP no database\n", ""},
+		{"no plan", "ok 1\nok 2\n", ""},
+		{"empty output", "", ""},
+		{"subtests not counted", "1..2\n    # Subtest: inner\n    ok 1\n    ok 2\n    1..2\nok 1 - inner\nok 2\n", ""},
+		{"comments and diagnostics ignored", "1..1\n# okay so far\nokay\nok 1\n", ""},
+		{"windows line endings", "1..2\r\nok 1\r\nok 2\r\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tapPlanMismatch(tt.output); got != tt.want {
+				t.Errorf("tapPlanMismatch(%q) = %q, want %q", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoverToolingError(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   bool
+	}{
+		{"die in Devel::Cover", "Can't call method \"file\" on an undefined value at /usr/lib/perl5/Devel/Cover.pm line 1061.\n", true},
+		{"die in a Devel::Cover submodule", "Bad op at /opt/lib/Devel/Cover/DB/Structure.pm line 88.\n", true},
+		{"Carp frame", "oops at lib/Foo.pm line 3.\n\tDevel::Cover::report(\"x\") called at -e line 1\n", true},
+		{"XS fails to load", "Can't load '/opt/auto/Devel/Cover/Cover.so' for module Devel::Cover\n", true},
+		{"Devel::Cover missing", "Can't locate Devel/Cover.pm in @INC (you may need to install the Devel::Cover module)\n", true},
+		{"die followed by notices", "Bad op at /opt/lib/Devel/Cover.pm line 88.\nDevel::Cover: Writing coverage database to cover_db/runs/1\n", true},
+		{"compilation failure", "Attempt to reload Devel/Cover.pm aborted.\nCompilation failed in require at /opt/lib/Devel/Cover/Util.pm line 3.\nBEGIN failed--compilation aborted at -e line 1.\n", true},
+		{"test failure", "#   Failed test 'adds'\n#   at t/add.t line 5.\n", false},
+		{"warning before a test failure", "Use of uninitialized value in addition (+) at /opt/lib/Devel/Cover.pm line 300.\n#   Failed test 'adds'\n#   at t/add.t line 5.\n# Looks like you failed 1 test of 1.\n", false},
+		{"warning before a die", "Deep recursion at /opt/lib/Devel/Cover.pm line 12.\nCan't call method \"x\" on undef at lib/Foo.pm line 7.\n", false},
+		{"Devel::Cover notice", "Devel::Cover: Deleting old coverage for changed file lib/Foo.pm\n", false},
+		{"similar module", "died at /opt/lib/Devel/Coverage.pm line 2.\n", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := coverToolingError(tt.stderr); got != tt.want {
+				t.Errorf("coverToolingError(%q) = %v, want %v", tt.stderr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSingleTestCoverToolingError(t *testing.T) {
+	dir := t.TempDir()
+	fakePerl := filepath.Join(dir, "fake-perl")
+	script := "#!/bin/sh\necho 'panic at /opt/lib/Devel/Cover.pm line 42.' >&2\nexit 255\n"
+	if err := os.WriteFile(fakePerl, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake perl: %v", err)
+	}
+
+	r := New(nil, filepath.Join(dir, "cover_db"), 1, nil, true, false, fakePerl, false)
+	if result := r.runSingleTest("t/anything.t", true, "", nil); result.Passed || !result.CoverageToolingError {
+		t.Errorf("with coverage: Passed = %v, CoverageToolingError = %v, want a tooling error", result.Passed, result.CoverageToolingError)
+	}
+	// Without Devel::Cover loaded the same output is the test's own failure
+	if result := r.runSingleTest("t/anything.t", false, "", nil); result.CoverageToolingError {
+		t.Error("without coverage: CoverageToolingError = true, want false")
+	}
+}
+
+func TestRunTestsBail(t *testing.T) {
+	dir := t.TempDir()
+	fakePerl := filepath.Join(dir, "fake-perl")
+	script := "#!/bin/sh\necho 'panic at /opt/lib/Devel/Cover.pm line 42.' >&2\nexit 255\n"
+	if err := os.WriteFile(fakePerl, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake perl: %v", err)
+	}
+	files := make([]string, 12)
+	for i := range files {
+		files[i] = fmt.Sprintf("t/%02d.t", i)
+	}
+
+	r := New(nil, filepath.Join(dir, "cover_db"), 1, nil, true, false, fakePerl, false)
+	r.OnProgress = func(ProgressEvent) {}
+	results, err := r.RunTests(files)
+	if err != nil || len(results) != len(files) {
+		t.Fatalf("without BailPercent: %d results, error %v; want every test run", len(results), err)
+	}
+	RemoveCoverDirs(results)
+
+	r.BailPercent = 50
+	results, err = r.RunTests(files)
+	defer RemoveCoverDirs(results)
+	if !errors.Is(err, ErrCoverageBroken) {
+		t.Fatalf("RunTests() error = %v, want ErrCoverageBroken", err)
+	}
+	if len(results) != bailMinFinished {
+		t.Errorf("got %d results, want the %d finished before bailing", len(results), bailMinFinished)
+	}
+	if !strings.Contains(err.Error(), "the remaining 7 were not run") {
+		t.Errorf("error %q should count the tests not run", err)
+	}
+}
+
+func TestToolingBail(t *testing.T) {
+	b := newToolingBail(0)
+	b.record(TestResult{CoverageToolingError: true})
+	if b.stopped() {
+		t.Error("nil bail stopped")
+	}
+
+	b = newToolingBail(25)
+	for i := 0; i < 8; i++ {
+		b.record(TestResult{CoverageToolingError: i >= 6})
+	}
+	if b.stopped() {
+		t.Error("2 of 8 (25%) stopped a 25% bail, want more than 25% needed")
+	}
+	b.record(TestResult{CoverageToolingError: true})
+	if !b.stopped() {
+		t.Error("3 of 9 did not stop a 25% bail")
+	}
+
+	// Too few finished tests to judge
+	b = newToolingBail(10)
+	for i := 0; i < bailMinFinished-1; i++ {
+		b.record(TestResult{CoverageToolingError: true})
+	}
+	if b.stopped() {
+		t.Errorf("stopped after %d tests, want at least %d", bailMinFinished-1, bailMinFinished)
+	}
+}
+
+func TestSlowestTests(t *testing.T) {
+	results := []TestResult{
+		{File: "t/fast.t", Duration: 100 * time.Millisecond},
+		{File: "t/slow.t", Duration: 3 * time.Second},
+		{File: "t/b.t", Duration: time.Second},
+		{File: "t/a.t", Duration: time.Second},
+	}
+
+	var got []string
+	for _, r := range SlowestTests(results, 3) {
+		got = append(got, r.File)
+	}
+	// Ties go in path order
+	want := []string{"t/slow.t", "t/a.t", "t/b.t"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SlowestTests(3) = %v, want %v", got, want)
+	}
+	if all := SlowestTests(results, 10); len(all) != 4 || all[3].File != "t/fast.t" {
+		t.Errorf("SlowestTests(10) = %v, want all four, fastest last", all)
+	}
+	if results[0].File != "t/fast.t" {
+		t.Errorf("SlowestTests reordered its input: %v", results)
+	}
+}
+
 func TestNewRunner(t *testing.T) {
-	r := New([]string{"/path/to/lib"}, "/cover/dir", 4, true, []string{"lib", "src"}, true, false, "/usr/bin/perl", true)
+	r := New([]string{"/path/to/lib"}, "/cover/dir", 4, []string{"lib", "src"}, true, false, "/usr/bin/perl", true)
 
 	if len(r.IncludePaths) != 1 || r.IncludePaths[0] != "/path/to/lib" {
 		t.Errorf("IncludePaths = %v, want [/path/to/lib]", r.IncludePaths)
@@ -165,9 +453,6 @@ func TestNewRunner(t *testing.T) {
 	if r.Jobs != 4 {
 		t.Errorf("Jobs = %d, want 4", r.Jobs)
 	}
-	if !r.Verbose {
-		t.Error("Verbose = false, want true")
-	}
 	if len(r.SourceDirs) != 2 {
 		t.Errorf("SourceDirs = %v, want [lib src]", r.SourceDirs)
 	}
@@ -181,3 +466,1029 @@ func TestNewRunner(t *testing.T) {
 		t.Error("ShowOutput = false, want true")
 	}
 }
+
+func TestRunSingleTestUsesPerlPath(t *testing.T) {
+	// A fake "perl" that ignores its arguments and emits passing TAP proves
+	// the runner invokes the configured interpreter rather than perl from PATH
+	dir := t.TempDir()
+	fakePerl := filepath.Join(dir, "fake-perl")
+	script := "#!/bin/sh\necho '1..1'\necho 'ok 1 - from fake perl'\n"
+	if err := os.WriteFile(fakePerl, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake perl: %v", err)
+	}
+
+	r := New(nil, filepath.Join(dir, "cover_db"), 1, nil, true, false, fakePerl, false)
+	result := r.runSingleTest("t/anything.t", false, "", nil)
+
+	if !result.Passed {
+		t.Fatalf("Passed = false, want true (error: %s)", result.Error)
+	}
+	if !strings.Contains(result.Output, "from fake perl") {
+		t.Errorf("Output = %q, want output from the configured perl", result.Output)
+	}
+}
+
+func TestRunSingleTestKeepsWarnings(t *testing.T) {
+	dir := t.TempDir()
+	fakePerl := filepath.Join(dir, "fake-perl")
+	script := "#!/bin/sh\necho '1..1'\necho 'ok 1'\necho 'Use of uninitialized value' >&2\n"
+	if err := os.WriteFile(fakePerl, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake perl: %v", err)
+	}
+
+	r := New(nil, filepath.Join(dir, "cover_db"), 1, nil, true, false, fakePerl, false)
+	result := r.runSingleTest("t/anything.t", false, "", nil)
+
+	if !result.Passed {
+		t.Fatalf("Passed = false, want true (error: %s)", result.Error)
+	}
+	if result.Warnings != "Use of uninitialized value\n" {
+		t.Errorf("Warnings = %q, want the test's stderr", result.Warnings)
+	}
+}
+
+func TestRunWithRetriesKeepsFinalCoverDir(t *testing.T) {
+	// A fake "perl" that fails on its first run and passes on its second
+	dir := t.TempDir()
+	fakePerl := filepath.Join(dir, "fake-perl")
+	counter := filepath.Join(dir, "runs")
+	script := "#!/bin/sh\necho 1..1\nif [ -e " + counter + " ]; then echo ok 1; else touch " + counter + "; echo 'not ok 1'; fi\n"
+	if err := os.WriteFile(fakePerl, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake perl: %v", err)
+	}
+
+	r := New(nil, filepath.Join(dir, "cover_db"), 1, nil, true, false, fakePerl, false)
+	r.Retries = 2
+	result := r.runWithRetries("t/flaky.t", 0, nil)
+
+	if !result.Passed || result.Attempts != 2 {
+		t.Fatalf("Passed = %v, Attempts = %d, want true, 2 (error: %s)", result.Passed, result.Attempts, result.Error)
+	}
+	if _, err := os.Stat(result.CoverDir); err != nil {
+		t.Errorf("final attempt's CoverDir %s: %v", result.CoverDir, err)
+	}
+	dirs, err := filepath.Glob(filepath.Join(dir, "cover_db_0_*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0] != result.CoverDir {
+		t.Errorf("coverage directories = %v, want only the final attempt's %s", dirs, result.CoverDir)
+	}
+}
+
+func TestDispatchOrder(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "b-small.t")
+	large := filepath.Join(dir, "a-large.t")
+	medium := filepath.Join(dir, "c-medium.t")
+	os.WriteFile(small, []byte("1"), 0644)
+	os.WriteFile(large, []byte("1234567890"), 0644)
+	os.WriteFile(medium, []byte("12345"), 0644)
+	files := []string{small, large, medium}
+
+	tests := []struct {
+		name     string
+		runner   *Runner
+		expected []int
+	}{
+		{"default keeps discovery order", &Runner{}, []int{0, 1, 2}},
+		{"alpha", &Runner{Order: OrderAlpha}, []int{1, 0, 2}},
+		{"size largest first", &Runner{Order: OrderSize}, []int{1, 2, 0}},
+		{
+			"failed-first",
+			&Runner{Order: OrderFailedFirst, Cache: &Cache{Tests: map[string]CachedResult{
+				medium: {Passed: false},
+				small:  {Passed: true},
+			}}},
+			[]int{2, 0, 1},
+		},
+		{"failed-first without cache", &Runner{Order: OrderFailedFirst}, []int{0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.runner.dispatchOrder(files)
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Fatalf("dispatchOrder() = %v, want %v", got, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchOrderRandomIsReproducible(t *testing.T) {
+	files := []string{"a.t", "b.t", "c.t", "d.t", "e.t", "f.t"}
+	first := (&Runner{Order: OrderRandom, Seed: 42}).dispatchOrder(files)
+	second := (&Runner{Order: OrderRandom, Seed: 42}).dispatchOrder(files)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("same seed gave different orders: %v vs %v", first, second)
+		}
+	}
+}
+
+func TestDispatchOrderLongestFirst(t *testing.T) {
+	files := []string{"fast.t", "slow.t", "new.t", "medium.t"}
+	r := &Runner{Cache: &Cache{Tests: map[string]CachedResult{
+		"fast.t":   {Passed: true, Duration: 1},
+		"slow.t":   {Passed: true, Duration: 30},
+		"medium.t": {Passed: true, Duration: 5},
+	}}}
+
+	// new.t has no cached duration and is assumed to take the median (5s),
+	// ahead of medium.t which is known to take that long
+	got := r.dispatchOrder(files)
+	expected := []int{1, 2, 3, 0}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("dispatchOrder() = %v, want %v", got, expected)
+		}
+	}
+}
+
+func TestDispatchGroups(t *testing.T) {
+	files := []string{"t/db/a.t", "t/unit.t", "t/api/x.t", "t/db/b.t", "t/api/y.t"}
+	r := &Runner{SerialGroup: regexp.MustCompile(`^t/(db|api)/`)}
+
+	got := fmt.Sprint(r.dispatchGroups(files))
+	if want := "[[0 3] [1] [2 4]]"; got != want {
+		t.Errorf("dispatchGroups() = %s, want %s", got, want)
+	}
+
+	// Without a capture group the whole match is the key
+	r.SerialGroup = regexp.MustCompile(`^t/db/`)
+	if got, want := fmt.Sprint(r.dispatchGroups(files)), "[[0 3] [1] [2] [4]]"; got != want {
+		t.Errorf("dispatchGroups() = %s, want %s", got, want)
+	}
+}
+
+func TestRunParallelSerialGroup(t *testing.T) {
+	files := []string{"t/db/a.t", "t/db/b.t", "t/db/c.t", "t/x.t", "t/y.t"}
+	r := &Runner{Jobs: 4, SerialGroup: regexp.MustCompile(`^t/(db)/`), OnProgress: func(ProgressEvent) {}}
+
+	var mu sync.Mutex
+	running := 0
+	r.runParallel(files, nil, func(i int, gate *memoryGate) TestResult {
+		if strings.HasPrefix(files[i], "t/db/") {
+			mu.Lock()
+			running++
+			if running > 1 {
+				t.Errorf("%s ran alongside another t/db test", files[i])
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}
+		return TestResult{File: files[i], Passed: true}
+	})
+}
+
+func TestNewIsolatedCoverDirIsUnique(t *testing.T) {
+	r := &Runner{CoverDir: filepath.Join(t.TempDir(), "cover_db")}
+
+	first, err := r.newIsolatedCoverDir(0)
+	if err != nil {
+		t.Fatalf("newIsolatedCoverDir() error: %v", err)
+	}
+	second, err := r.newIsolatedCoverDir(0)
+	if err != nil {
+		t.Fatalf("newIsolatedCoverDir() error: %v", err)
+	}
+	if first == second {
+		t.Fatalf("two runs of the same test share %s", first)
+	}
+	if !strings.HasPrefix(filepath.Base(first), "cover_db_0_") {
+		t.Errorf("dir %s should be named cover_db_0_<suffix>", first)
+	}
+
+	other := r.CoverDir + "_other"
+	if err := os.Mkdir(other, 0755); err != nil {
+		t.Fatal(err)
+	}
+	RemoveCoverDirs([]TestResult{{CoverDir: first}, {CoverDir: second}, {}})
+	for _, dir := range []string{first, second} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", dir)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated dir %s was removed", other)
+	}
+}
+
+func TestRunParallelPublishesProgress(t *testing.T) {
+	var events []ProgressEvent
+	r := &Runner{Jobs: 2, OnProgress: func(e ProgressEvent) {
+		events = append(events, e)
+	}}
+	files := []string{"t/a.t", "t/b.t", "t/c.t"}
+
+	results := r.runParallel(files, nil, func(i int, gate *memoryGate) TestResult {
+		return TestResult{File: files[i], Passed: i != 1}
+	})
+
+	if len(results) != 3 || results[1].File != "t/b.t" || results[1].Passed {
+		t.Fatalf("results = %+v, want results in input order", results)
+	}
+	if len(events) != 6 {
+		t.Fatalf("got %d events, want a start and finish per test", len(events))
+	}
+	last := events[len(events)-1]
+	if last.Started || last.Completed != 3 || last.Passed != 2 || last.Failed() != 1 || len(last.Running) != 0 {
+		t.Errorf("last event = %+v, want 3 completed, 2 passed, none running", last)
+	}
+	for _, e := range events {
+		if e.Started && !contains(e.Running, e.File) {
+			t.Errorf("start event for %s missing it from Running %v", e.File, e.Running)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestShard(t *testing.T) {
+	files := []string{"t/e.t", "t/a.t", "t/d.t", "t/b.t", "t/c.t"}
+
+	// Round-robin by sorted path without timings
+	if got := strings.Join(Shard(files, 1, 2, nil), ","); got != "t/b.t,t/d.t" {
+		t.Errorf("Shard(1/2) = %s, want t/b.t,t/d.t", got)
+	}
+
+	// Every test lands in exactly one shard
+	timings := &Cache{Tests: map[string]CachedResult{
+		"t/a.t": {Duration: 10},
+		"t/b.t": {Duration: 6},
+		"t/c.t": {Duration: 5},
+		"t/d.t": {Duration: 1},
+	}}
+	seen := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		for _, tf := range Shard(files, i, 2, timings) {
+			seen[tf]++
+		}
+	}
+	for _, tf := range files {
+		if seen[tf] != 1 {
+			t.Errorf("%s assigned to %d shards, want 1", tf, seen[tf])
+		}
+	}
+
+	// t/e.t is unknown and assumed to take the median (6): 10+5 vs 6+6+1
+	if got := strings.Join(Shard(files, 0, 2, timings), ","); got != "t/a.t,t/c.t" {
+		t.Errorf("Shard(0/2) with timings = %s, want t/a.t,t/c.t", got)
+	}
+}
+
+func TestIncludeArgs(t *testing.T) {
+	cwd := t.TempDir()
+	for _, dir := range []string{"lib", filepath.Join("blib", "lib"), filepath.Join("local", "lib", "perl5")} {
+		if err := os.MkdirAll(filepath.Join(cwd, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lib := filepath.Join(cwd, "lib")
+	blib := filepath.Join(cwd, "blib", "lib")
+	local := filepath.Join(cwd, "local", "lib", "perl5")
+
+	tests := []struct {
+		name string
+		r    Runner
+		want []string
+	}{
+		{"auto", Runner{IncludePaths: []string{"t/lib"}}, []string{"-I", filepath.Join(cwd, "t/lib"), "-I", lib, "-I", blib, "-I", local}},
+		{"local-lib", Runner{LocalLib: "vendor"}, []string{"-I", lib, "-I", blib, "-I", filepath.Join(cwd, "vendor", "lib", "perl5")}},
+		{"no-auto-inc", Runner{IncludePaths: []string{"/opt/lib"}, NoAutoInc: true}, []string{"-I", "/opt/lib"}},
+		{"no-auto-inc keeps local-lib", Runner{LocalLib: "/opt/deps", NoAutoInc: true}, []string{"-I", "/opt/deps/lib/perl5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.r.includeArgs(cwd)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("includeArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTestEnv(t *testing.T) {
+	t.Setenv("DEVEL_COVER_OPTIONS", "-db,/tmp/elsewhere")
+	t.Setenv("HARNESS_PERL_SWITCHES", "-w -MDevel::Cover=-db,/tmp/elsewhere")
+	t.Setenv("PERL5LIB", "/opt/perl5")
+
+	r := &Runner{Env: []string{"TZ=UTC"}}
+	env := r.testEnv("-db,cover_db")
+
+	got := make(map[string]string)
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		got[key] = value
+	}
+	if got["DEVEL_COVER_OPTIONS"] != "-db,cover_db" {
+		t.Errorf("DEVEL_COVER_OPTIONS = %q, want only our options", got["DEVEL_COVER_OPTIONS"])
+	}
+	if got["HARNESS_PERL_SWITCHES"] != "-w -MDevel::Cover" {
+		t.Errorf("HARNESS_PERL_SWITCHES = %q, want -w plus our switch", got["HARNESS_PERL_SWITCHES"])
+	}
+	if got["PERL5LIB"] != "/opt/perl5" {
+		t.Errorf("PERL5LIB = %q, want /opt/perl5", got["PERL5LIB"])
+	}
+	if env[len(env)-1] != "TZ=UTC" {
+		t.Errorf("--env values should come last, got %v", env[len(env)-1])
+	}
+}
+
+func TestTestEnvDBFormat(t *testing.T) {
+	t.Setenv("DEVEL_COVER_DB_FORMAT", "Sereal")
+
+	formats := func(env []string) []string {
+		var values []string
+		for _, kv := range env {
+			if value, ok := strings.CutPrefix(kv, "DEVEL_COVER_DB_FORMAT="); ok {
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+	if got := formats((&Runner{DBFormat: DBFormatJSON}).testEnv("")); !reflect.DeepEqual(got, []string{"JSON"}) {
+		t.Errorf("DEVEL_COVER_DB_FORMAT = %v, want only JSON", got)
+	}
+	// Without DBFormat, the caller's choice passes through
+	if got := formats((&Runner{}).testEnv("")); !reflect.DeepEqual(got, []string{"Sereal"}) {
+		t.Errorf("DEVEL_COVER_DB_FORMAT = %v, want Sereal", got)
+	}
+}
+
+func TestCheckDBFormat(t *testing.T) {
+	// A fake perl that loads only the Storable module
+	fakePerl := filepath.Join(t.TempDir(), "fake-perl")
+	script := "#!/bin/sh\ncase \"$1\" in *::Storable) exit 0;; esac\necho \"Can't locate module\"\nexit 2\n"
+	if err := os.WriteFile(fakePerl, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake perl: %v", err)
+	}
+	version := CoverVersion{Raw: "1.40", Major: 1, Minor: 40}
+
+	if err := CheckDBFormat(fakePerl, version, DBFormatStorable); err != nil {
+		t.Errorf("CheckDBFormat(storable) error: %v", err)
+	}
+	err := CheckDBFormat(fakePerl, version, DBFormatSereal)
+	if err == nil || !strings.Contains(err.Error(), "Devel::Cover 1.40 can't write sereal") {
+		t.Errorf("CheckDBFormat(sereal) error = %v, want one naming the version and format", err)
+	}
+	if err := CheckDBFormat(fakePerl, version, "yaml"); err == nil {
+		t.Error("CheckDBFormat(yaml) = nil, want an error")
+	}
+}
+
+func TestParseCoverVersion(t *testing.T) {
+	tests := []struct {
+		in           string
+		major, minor int
+		wantErr      bool
+	}{
+		{"1.40", 1, 40, false},
+		{"1.39_01", 1, 39, false},
+		{"0.79", 0, 79, false},
+		{"2", 2, 0, false},
+		{"", 0, 0, true},
+		{"v1.x", 0, 0, true},
+	}
+	for _, tt := range tests {
+		v, err := ParseCoverVersion(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCoverVersion(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (v.Major != tt.major || v.Minor != tt.minor || v.String() != tt.in) {
+			t.Errorf("ParseCoverVersion(%q) = %+v, want %d.%d", tt.in, v, tt.major, tt.minor)
+		}
+	}
+
+	v := CoverVersion{Raw: "1.40", Major: 1, Minor: 40}
+	if !v.AtLeast(1, 40) || !v.AtLeast(0, 99) || v.AtLeast(1, 41) || v.AtLeast(2, 0) {
+		t.Errorf("AtLeast() comparisons wrong for %s", v)
+	}
+}
+
+func TestCheckDevelCoverCaches(t *testing.T) {
+	// A fake perl that prints a version and counts its invocations
+	dir := t.TempDir()
+	fakePerl := filepath.Join(dir, "fake-perl")
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho x >> " + calls + "\nprintf 1.40\n"
+	if err := os.WriteFile(fakePerl, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake perl: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		v, err := CheckDevelCover(fakePerl)
+		if err != nil || v.Raw != "1.40" {
+			t.Fatalf("CheckDevelCover() = %v, %v, want 1.40", v, err)
+		}
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Errorf("perl was run %d times, want 1", n)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	r := New([]string{"/opt/lib"}, "/tmp/cover_db", 1, nil, true, false, "perl", false)
+	r.NoAutoInc = true
+	r.Env = []string{"TZ=UTC"}
+
+	var buf bytes.Buffer
+	if err := r.DryRun([]string{"/t/a.t", "/t/b.t"}, true, &buf); err != nil {
+		t.Fatalf("DryRun() error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("DryRun() wrote %d lines, want one per test:\n%s", len(lines), buf.String())
+	}
+	want := `TZ=UTC perl -I /opt/lib '-MDevel::Cover=-db,/tmp/cover_db,-silent,1,-ignore,^t/,-ignore,\.t$,-coverage,statement,branch,condition,subroutine' /t/a.t`
+	if lines[0] != want {
+		t.Errorf("line 0 = %q, want %q", lines[0], want)
+	}
+	if !strings.HasSuffix(lines[1], " /t/b.t") {
+		t.Errorf("line 1 = %q, want the command for /t/b.t", lines[1])
+	}
+
+	// Under prove, Devel::Cover goes in through HARNESS_PERL_SWITCHES,
+	// its options through DEVEL_COVER_OPTIONS
+	r.Harness = HarnessProve
+	buf.Reset()
+	if err := r.DryRun([]string{"/t/a.t"}, true, &buf); err != nil {
+		t.Fatalf("DryRun() error: %v", err)
+	}
+	got := strings.TrimSuffix(buf.String(), "\n")
+	if !strings.HasPrefix(got, "DEVEL_COVER_OPTIONS='-db,/tmp/cover_db,") || !strings.HasSuffix(got, " HARNESS_PERL_SWITCHES=-MDevel::Cover TZ=UTC perl -S prove -v -I /opt/lib /t/a.t") {
+		t.Errorf("prove DryRun() = %q", got)
+	}
+}
+
+func TestRunSingleTestProveCoverDirWithSpace(t *testing.T) {
+	if _, err := exec.LookPath("prove"); err != nil {
+		t.Skip("prove not installed")
+	}
+	// A stand-in Devel::Cover records the options it was loaded with
+	dir := t.TempDir()
+	stub := filepath.Join(dir, "stub", "Devel", "Cover.pm")
+	if err := os.MkdirAll(filepath.Dir(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	module := `package Devel::Cover;
+sub import {
+	shift;
+	my @o = (@_, split ",", $ENV{DEVEL_COVER_OPTIONS} || "");
+	open my $fh, ">", $ENV{COVER_OPTIONS_FILE} or die $!;
+	print $fh join("\n", @o);
+}
+1;
+`
+	if err := os.WriteFile(stub, []byte(module), 0644); err != nil {
+		t.Fatal(err)
+	}
+	test := filepath.Join(dir, "a.t")
+	if err := os.WriteFile(test, []byte("print \"1..1\\nok 1\\n\";\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	coverDir := filepath.Join(dir, "my project", "cover_db")
+	optionsFile := filepath.Join(dir, "options")
+	r := New([]string{filepath.Join(dir, "stub")}, coverDir, 1, nil, true, false, "perl", false)
+	r.Harness = HarnessProve
+	r.NoAutoInc = true
+	r.Env = []string{"COVER_OPTIONS_FILE=" + optionsFile}
+	result := r.runSingleTest(test, true, coverDir, nil)
+	if !result.Passed {
+		t.Fatalf("Passed = false, want true (error: %s)", result.Error)
+	}
+
+	data, err := os.ReadFile(optionsFile)
+	if err != nil {
+		t.Fatalf("Devel::Cover was not loaded: %v", err)
+	}
+	options := strings.Split(string(data), "\n")
+	if len(options) < 2 || options[0] != "-db" || options[1] != coverDir {
+		t.Errorf("Devel::Cover options = %q, want -db %s first", options, coverDir)
+	}
+}
+
+func TestTestCommandRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := New([]string{"inc"}, "cover_db", 1, []string{"lib"}, true, false, "perl", false)
+	r.Root = root
+
+	cmd, coverDir := r.testCommand("t/a.t", true, "")
+	if cmd.Dir != root {
+		t.Errorf("cmd.Dir = %q, want %q", cmd.Dir, root)
+	}
+	if coverDir != filepath.Join(root, "cover_db") {
+		t.Errorf("cover dir = %q, want it under the root", coverDir)
+	}
+	args := strings.Join(cmd.Args, " ")
+	for _, want := range []string{
+		"-I " + filepath.Join(root, "inc"),
+		"-I " + filepath.Join(root, "lib") + " ",
+		",+inc," + filepath.Join(root, "lib"),
+		filepath.Join(root, "t/a.t"),
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q lack %q", args, want)
+		}
+	}
+
+	dir, err := r.newIsolatedCoverDir(0)
+	if err != nil {
+		t.Fatalf("newIsolatedCoverDir() error: %v", err)
+	}
+	if filepath.Dir(dir) != root {
+		t.Errorf("isolated cover dir %q is not next to %s/cover_db", dir, root)
+	}
+}
+
+func TestTestCommandLogsSelect(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "lib", "App"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "lib", "App", "User.pm"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	r := New(nil, "cover_db", 1, []string{"lib"}, false, false, "perl", false)
+	r.Root = root
+	r.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	r.testCommand("t/App/User.t", true, "")
+	for _, want := range []string{
+		`msg=select test=t/App/User.t modules=App::User source=filename`,
+		`msg="cover options" test=t/App/User.t options=`,
+		`-ignore,lib/,-select,App/User`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs %q lack %q", logs.String(), want)
+		}
+	}
+}
+
+func TestTestCommandCoverIgnore(t *testing.T) {
+	r := New(nil, "/cover_db", 1, nil, true, false, "perl", false)
+	r.CoverIgnore = []string{`^tests/`}
+	r.CoverSelect = []string{`^script/`}
+
+	cmd, _ := r.testCommand("tests/a.t", true, "")
+	want := `-MDevel::Cover=-db,/cover_db,-silent,1,-ignore,^t/,-ignore,\.t$,-ignore,^tests/,`
+	if !strings.Contains(cmd.Args[1], want) || !strings.HasSuffix(cmd.Args[1], ",-select,^script/") {
+		t.Errorf("cover switch = %q, want the default and extra ignores and the extra select", cmd.Args[1])
+	}
+
+	r.NoDefaultIgnore = true
+	cmd, _ = r.testCommand("tests/a.t", true, "")
+	if !strings.HasPrefix(cmd.Args[1], "-MDevel::Cover=-db,/cover_db,-silent,1,-ignore,^tests/,-coverage,") {
+		t.Errorf("cover switch = %q, want only the extra ignore", cmd.Args[1])
+	}
+}
+
+func TestTestCommandIncludeTests(t *testing.T) {
+	r := New(nil, "/cover_db", 1, []string{"lib"}, true, false, "perl", false)
+	r.Root = "/proj"
+	r.TestLib = "t/lib"
+
+	cmd, _ := r.testCommand("t/a.t", true, "")
+	want := "-MDevel::Cover=-db,/cover_db,-silent,1,-ignore,\\.t$,-coverage,statement,branch,condition,subroutine,+inc,/proj/lib,+inc,/proj/t/lib,-select,^t/lib/"
+	if cmd.Args[1] != want {
+		t.Errorf("cover switch = %q, want %q", cmd.Args[1], want)
+	}
+
+	if got := testLibSelect("/elsewhere/helpers", "/proj"); got != "^/elsewhere/helpers/" {
+		t.Errorf("testLibSelect() outside the project = %q", got)
+	}
+	if got := testLibSelect("t/lib.d", "/proj"); got != `^t/lib\.d/` {
+		t.Errorf("testLibSelect() = %q, want the directory quoted", got)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"/t/a.t":    "/t/a.t",
+		"":          "''",
+		"a b":       "'a b'",
+		"it's":      `'it'\''s'`,
+		"^t/,\\.t$": `'^t/,\.t$'`,
+	}
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadSelectMap(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "select-map")
+	content := "# integration tests\n" +
+		"t/integration/checkout.t  App::Cart App::Order\n" +
+		"\n" +
+		"t/api/**  App::API\n" +
+		"t/**  App::Never\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadSelectMap(path, dir)
+	if err != nil {
+		t.Fatalf("LoadSelectMap() error: %v", err)
+	}
+	tests := []struct {
+		testFile string
+		want     []string
+		ok       bool
+	}{
+		{"t/integration/checkout.t", []string{"App::Cart", "App::Order"}, true},
+		{filepath.Join(dir, "t/api/v1/users.t"), []string{"App::API"}, true},
+		{"t/other.t", []string{"App::Never"}, true},
+		{"xt/author.t", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := m.Modules(tt.testFile)
+		if ok != tt.ok || strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Modules(%q) = %v, %v, want %v, %v", tt.testFile, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	for _, bad := range []string{"t/a.t\n", "t/a.t lowercase::name\n", "!t/a.t App\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSelectMap(path, dir); err == nil || !strings.Contains(err.Error(), ":1:") {
+			t.Errorf("LoadSelectMap(%q) error = %v, want a line-numbered error", bad, err)
+		}
+	}
+}
+
+func TestDryRunSelectMap(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "select-map")
+	if err := os.WriteFile(path, []byte("t/Flow.t App::Cart App::Order\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadSelectMap(path, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(nil, "/tmp/cover_db", 1, nil, false, false, "perl", false)
+	r.NoAutoInc = true
+	r.SelectMap = m
+
+	var buf bytes.Buffer
+	if err := r.DryRun([]string{"t/Flow.t"}, true, &buf); err != nil {
+		t.Fatalf("DryRun() error: %v", err)
+	}
+	if !strings.Contains(buf.String(), ",-ignore,lib/,-select,App/Cart,-select,App/Order'") {
+		t.Errorf("DryRun() = %q, want both mapped modules selected", buf.String())
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	results := []TestResult{
+		{File: "t/api/users.t", Passed: true, Duration: 1500 * time.Millisecond, Output: "1..1\nok 1\n", Warnings: "deprecated\n"},
+		{File: "t/dump.t", Passed: false, Duration: 250 * time.Millisecond, Output: "not ok 1\n",
+			Error: "\n$VAR1 = { 'a' => '<b>' & 1 };\x1b[0m\n"},
+	}
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var buf bytes.Buffer
+	if err := WriteJUnit(results, started, &buf); err != nil {
+		t.Fatalf("WriteJUnit() error: %v", err)
+	}
+
+	var got junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid XML: %v\n%s", err, buf.String())
+	}
+	if len(got.Suites) != 1 {
+		t.Fatalf("got %d suites, want 1", len(got.Suites))
+	}
+	suite := got.Suites[0]
+	if suite.Tests != 2 || suite.Failures != 1 || suite.Time != "1.750" || suite.Timestamp != "2026-01-02T03:04:05Z" {
+		t.Errorf("suite = tests %d, failures %d, time %s, timestamp %s", suite.Tests, suite.Failures, suite.Time, suite.Timestamp)
+	}
+
+	passed := suite.Cases[0]
+	if passed.Name != "t/api/users.t" || passed.ClassName != "t.api.users" || passed.Time != "1.500" || passed.Failure != nil {
+		t.Errorf("passing case = %+v", passed)
+	}
+	if passed.SystemOut != "1..1\nok 1\n" || passed.SystemErr != "deprecated\n" {
+		t.Errorf("passing case output = %q, %q", passed.SystemOut, passed.SystemErr)
+	}
+
+	failed := suite.Cases[1]
+	// The escape byte can't appear in XML and is replaced
+	if failed.Failure == nil || failed.Failure.Message != "$VAR1 = { 'a' => '<b>' & 1 };\uFFFD[0m" {
+		t.Fatalf("failing case failure = %+v", failed.Failure)
+	}
+	if !strings.Contains(failed.Failure.Text, "'<b>' & 1") {
+		t.Errorf("failure text = %q, want the error round-tripped", failed.Failure.Text)
+	}
+}
+
+func TestDetectProjectType(t *testing.T) {
+	tests := []struct {
+		files []string
+		want  string
+	}{
+		{nil, ProjectPlain},
+		{[]string{"cpanfile"}, ProjectCpanfile},
+		{[]string{"Makefile.PL", "cpanfile"}, ProjectMakeMaker},
+		{[]string{"Build.PL", "Makefile.PL"}, ProjectModuleBuild},
+		{[]string{"minil.toml", "Build.PL", "cpanfile"}, ProjectMinil},
+		{[]string{"dist.ini", "cpanfile"}, ProjectDzil},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		for _, f := range tt.files {
+			if err := os.WriteFile(filepath.Join(root, f), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := DetectProjectType(root); got != tt.want {
+			t.Errorf("DetectProjectType(%v) = %s, want %s", tt.files, got, tt.want)
+		}
+	}
+
+	// A directory named like a marker doesn't count
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dist.ini"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := DetectProjectType(root); got != ProjectPlain {
+		t.Errorf("DetectProjectType() = %s with a dist.ini directory, want plain", got)
+	}
+}
+
+func TestLayoutFor(t *testing.T) {
+	root := t.TempDir()
+	ini := "; generated\nname    = Foo-Bar\nversion = 1.0\n\n[@Basic]\nname = Not-This\n"
+	if err := os.WriteFile(filepath.Join(root, "dist.ini"), []byte(ini), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		ProjectDzil:        {".build/", "Foo-Bar-*/"},
+		ProjectMinil:       {".build/"},
+		ProjectModuleBuild: {"blib/"},
+		ProjectMakeMaker:   {"blib/"},
+		ProjectCpanfile:    nil,
+		ProjectPlain:       nil,
+	}
+	for projectType, want := range tests {
+		layout := LayoutFor(root, projectType)
+		if !reflect.DeepEqual(layout.Ignore, want) {
+			t.Errorf("LayoutFor(%s).Ignore = %q, want %q", projectType, layout.Ignore, want)
+		}
+		if !reflect.DeepEqual(layout.SourceDirs, []string{"lib"}) {
+			t.Errorf("LayoutFor(%s).SourceDirs = %q, want lib", projectType, layout.SourceDirs)
+		}
+	}
+
+	// Without a name, only .build is skipped
+	if got := LayoutFor(t.TempDir(), ProjectDzil).Ignore; !reflect.DeepEqual(got, []string{".build/"}) {
+		t.Errorf("LayoutFor(dzil) without dist.ini = %q", got)
+	}
+}
+
+func TestMemoryGate(t *testing.T) {
+	var mu sync.Mutex
+	usage := map[int]int64{}
+	setUsage := func(pid int, rss int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		usage[pid] = rss
+	}
+	g := &memoryGate{
+		limit: 100,
+		rss: func(pids []int) map[int]int64 {
+			mu.Lock()
+			defer mu.Unlock()
+			out := make(map[int]int64)
+			for _, pid := range pids {
+				out[pid] = usage[pid]
+			}
+			return out
+		},
+		poll:   time.Millisecond,
+		settle: time.Hour,
+		pids:   make(map[int]time.Time),
+	}
+
+	// The first test always runs
+	if g.wait() {
+		t.Fatal("wait() held back the first test")
+	}
+
+	// Until its process starts, and then settles, it can't be measured
+	if g.admit() {
+		t.Fatal("admit() let a test through before the running one started")
+	}
+	g.track(1)
+	setUsage(1, 30)
+	if g.admit() {
+		t.Fatal("admit() let a test through before the running one settled")
+	}
+	g.settle = 0
+	if !g.admit() {
+		t.Fatal("admit() held back a test under the limit")
+	}
+	g.track(2)
+
+	// Each running test now counts as at least the 30 seen, so 2 + 30 + 70
+	// is over the limit
+	setUsage(2, 2)
+	setUsage(1, 70)
+	started := make(chan bool)
+	go func() { started <- g.wait() }()
+	select {
+	case <-started:
+		t.Fatal("wait() let a test start over the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// A test finishing frees its memory; the other counts as the 70 peak
+	g.untrack(1)
+	g.done()
+	if held := <-started; !held {
+		t.Error("wait() = false, want true after holding the test back")
+	}
+	g.track(3)
+
+	// One test runs however much it used
+	g.untrack(2)
+	g.untrack(3)
+	g.done()
+	g.done()
+	if g.wait() {
+		t.Error("wait() held back the only test")
+	}
+
+	var nilGate *memoryGate
+	if nilGate.wait() {
+		t.Error("nil gate held back a test")
+	}
+}
+
+func TestTreeRSS(t *testing.T) {
+	if !MemoryThrottleSupported() {
+		t.Skip("no /proc")
+	}
+	self := os.Getpid()
+	if got := treeRSS([]int{self})[self]; got <= 0 {
+		t.Errorf("treeRSS(self) = %d, want the process's resident memory", got)
+	}
+	if parentPID(self) != os.Getppid() {
+		t.Errorf("parentPID(self) = %d, want %d", parentPID(self), os.Getppid())
+	}
+	if len(treeRSS(nil)) != 0 {
+		t.Error("treeRSS(nil) is not empty")
+	}
+}
+
+func TestRunParallelMaxMemory(t *testing.T) {
+	if !MemoryThrottleSupported() {
+		t.Skip("no /proc")
+	}
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("perl not found")
+	}
+	dir := t.TempDir()
+	var tests []string
+	for _, name := range []string{"a.t", "b.t", "c.t", "d.t"} {
+		path := filepath.Join(dir, name)
+		script := "my $pad = 'x' x 4_000_000; select undef, undef, undef, 0.05; print qq{1..1\nok 1\n};\n"
+		if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tests = append(tests, path)
+	}
+
+	// Each perl takes well over 1 MB, so tests run one at a time
+	r := New(nil, filepath.Join(dir, "cover_db"), 4, nil, true, false, "perl", false)
+	r.MaxMemoryMB = 1
+	peak := 0
+	r.OnProgress = func(e ProgressEvent) {
+		if len(e.Running) > peak {
+			peak = len(e.Running)
+		}
+	}
+	results := r.RunTestsWithoutCoverage(tests)
+	for _, res := range results {
+		if !res.Passed {
+			t.Errorf("%s failed: %s", res.File, res.Error)
+		}
+	}
+	if peak != 1 {
+		t.Errorf("%d tests ran at once under a 1 MB budget, want them held back", peak)
+	}
+}
+
+func TestTestsForChanges(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "select-map")
+	if err := os.WriteFile(path, []byte("t/integration/checkout.t  App::Order\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	selectMap, err := LoadSelectMap(path, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []string{
+		"t/App-Cart.t",
+		"t/App-Cart_discounts.t",
+		"t/App/Model/User.t",
+		"t/App-Order.t",
+		"t/integration/checkout.t",
+		"t/00-load.t",
+	}
+
+	cases := []struct {
+		name         string
+		changed      []string
+		wantSelected []string
+		wantUnmapped []string
+	}{
+		{
+			name:         "modules and tests",
+			changed:      []string{"lib/App/Cart.pm", "lib/App/Model/User.pm", "t/00-load.t", "README.md"},
+			wantSelected: []string{"t/App-Cart.t", "t/App-Cart_discounts.t", "t/App/Model/User.t", "t/00-load.t"},
+		},
+		{
+			name:         "select map",
+			changed:      []string{"lib/App/Order.pm"},
+			wantSelected: []string{"t/App-Order.t", "t/integration/checkout.t"},
+		},
+		{
+			name:         "untraceable changes",
+			changed:      []string{"lib/App/Cart.pm", "t/lib/Helper.pm", "lib/App/Untested.pm", "script/run.pl"},
+			wantSelected: []string{"t/App-Cart.t", "t/App-Cart_discounts.t"},
+			wantUnmapped: []string{"lib/App/Untested.pm", "script/run.pl", "t/lib/Helper.pm"},
+		},
+		{
+			name:    "nothing relevant",
+			changed: []string{"Changes", "docs/guide.md"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			selected, unmapped := TestsForChanges(tests, tc.changed, []string{"lib"}, selectMap)
+			if !reflect.DeepEqual(selected, tc.wantSelected) {
+				t.Errorf("selected = %v, want %v", selected, tc.wantSelected)
+			}
+			if !reflect.DeepEqual(unmapped, tc.wantUnmapped) {
+				t.Errorf("unmapped = %v, want %v", unmapped, tc.wantUnmapped)
+			}
+		})
+	}
+}
+
+func TestRunTestTAP13Diagnostics(t *testing.T) {
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("perl not found")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "yaml.t")
+	script := "print qq{TAP version 13\n1..1\nnot ok 1 - sums\n  ---\n  message: got 5\n  severity: fail\n  ...\n};\n" +
+		"print STDERR qq{Can't locate Missing.pm in \\@INC\n};\nexit 2;\n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(nil, filepath.Join(dir, "cover_db"), 1, nil, true, false, "perl", false)
+	r.OnProgress = func(ProgressEvent) {}
+	results := r.RunTestsWithoutCoverage([]string{path})
+	if len(results) != 1 || results[0].Passed {
+		t.Fatalf("results = %+v, want one failure", results)
+	}
+	want := "not ok 1 - sums\n  message: got 5\n  severity: fail\nCan't locate Missing.pm in @INC"
+	if results[0].Error != want {
+		t.Errorf("Error = %q, want %q, the summary followed by why the test died", results[0].Error, want)
+	}
+	if !strings.Contains(results[0].Warnings, "Can't locate Missing.pm") {
+		t.Errorf("Warnings = %q, want the test's stderr kept", results[0].Warnings)
+	}
+}
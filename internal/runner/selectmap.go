@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/user/perlcov/internal/ignore"
+)
+
+// selectEntry maps the tests matching one pattern to their modules
+type selectEntry struct {
+	match   *ignore.Matcher
+	modules []string
+}
+
+// SelectMap lists the modules to -select for tests the filename heuristic
+// can't handle, such as integration tests exercising several modules
+type SelectMap struct {
+	entries []selectEntry
+}
+
+// LoadSelectMap reads a select map file. Each line holds a test file
+// pattern (.perlcovignore syntax, relative to root) followed by the
+// modules to select for matching tests, separated by whitespace:
+//
+//	t/integration/checkout.t  App::Cart App::Order
+//	t/api/**                  App::API App::Model::User
+//
+// Blank lines and lines starting with # are skipped. The first matching
+// pattern wins.
+func LoadSelectMap(path, root string) (*SelectMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &SelectMap{}
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := m.add(root, fields[0], fields[1:]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// add appends an entry mapping tests matching pattern to modules
+func (m *SelectMap) add(root, pattern string, modules []string) error {
+	if strings.HasPrefix(pattern, "!") {
+		return fmt.Errorf("negated pattern %q is not supported", pattern)
+	}
+	if len(modules) == 0 {
+		return fmt.Errorf("pattern %q has no modules", pattern)
+	}
+	for _, module := range modules {
+		for _, part := range strings.Split(module, "::") {
+			if !isPackageSegment(part) {
+				return fmt.Errorf("invalid module name %q", module)
+			}
+		}
+	}
+
+	match := ignore.New(root)
+	if err := match.Add(pattern); err != nil {
+		return err
+	}
+	m.entries = append(m.entries, selectEntry{match: match, modules: modules})
+	return nil
+}
+
+// Modules returns the modules mapped to testFile, and whether it matched
+// any pattern
+func (m *SelectMap) Modules(testFile string) ([]string, bool) {
+	if m == nil {
+		return nil, false
+	}
+	for _, e := range m.entries {
+		if e.match.Match(testFile) {
+			return e.modules, true
+		}
+	}
+	return nil, false
+}
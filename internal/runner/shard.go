@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"sort"
+)
+
+// Shard returns the tests that shard index (0-based) of total should run.
+// The partition only depends on the file list and timings, so machines
+// agree on the split only if they pass the same timings: a shared file,
+// never each machine's own cache. With durations in timings, tests are
+// packed longest-first onto the least loaded shard to balance wall time;
+// with nil timings, sorted paths are dealt round-robin. The result is sorted.
+func Shard(testFiles []string, index, total int, timings *Cache) []string {
+	sorted := append([]string{}, testFiles...)
+	sort.Strings(sorted)
+
+	var shard []string
+	durations, _, ok := timings.estimateDurations(sorted)
+	if !ok {
+		for i, tf := range sorted {
+			if i%total == index {
+				shard = append(shard, tf)
+			}
+		}
+		return shard
+	}
+
+	order := make([]int, len(sorted))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return durations[order[a]] > durations[order[b]]
+	})
+
+	loads := make([]float64, total)
+	for _, i := range order {
+		target := 0
+		for s := 1; s < total; s++ {
+			if loads[s] < loads[target] {
+				target = s
+			}
+		}
+		loads[target] += durations[i]
+		if target == index {
+			shard = append(shard, sorted[i])
+		}
+	}
+	sort.Strings(shard)
+	return shard
+}
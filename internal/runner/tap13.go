@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tapLine is a line of TAP output outside YAML diagnostic blocks, trimmed,
+// with the fields of the TAP version 13 block that follows it, if any
+type tapLine struct {
+	text string
+	diag map[string]string
+}
+
+// parseTAPLines splits output into lines, folding each YAML diagnostic
+// block (an indented "---" up to "...") into the test line before it.
+// TAP12 output has no blocks, so every line comes back as is.
+func parseTAPLines(output string) []tapLine {
+	raw := strings.Split(output, "\n")
+	var lines []tapLine
+	for i := 0; i < len(raw); i++ {
+		line := strings.TrimRight(raw[i], "\r")
+		text := strings.TrimSpace(line)
+		if text == "---" && len(lines) > 0 && tapTestRe.MatchString(lines[len(lines)-1].text) {
+			end := i + 1
+			for end < len(raw) && strings.TrimSpace(raw[end]) != "..." {
+				end++
+			}
+			if end < len(raw) {
+				lines[len(lines)-1].diag = parseYAMLMap(raw[i+1 : end])
+				i = end
+				continue
+			}
+		}
+		lines = append(lines, tapLine{text: text})
+	}
+	return lines
+}
+
+// parseYAMLMap reads the subset of YAML that TAP producers write for
+// diagnostics: "key: value" pairs with plain or quoted scalars, | and >
+// block scalars, and nested maps, which are flattened to one line (see
+// flattenYAMLMap). Anything else is skipped.
+func parseYAMLMap(lines []string) map[string]string {
+	fields := make(map[string]string)
+	indent := -1
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		lineIndent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent < 0 {
+			indent = lineIndent
+		}
+		if lineIndent != indent {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		// Block scalars and nested maps take the more indented lines after
+		end := i + 1
+		for end < len(lines) && (strings.TrimSpace(lines[end]) == "" || yamlIndent(lines[end]) > indent) {
+			end++
+		}
+		nested := lines[i+1 : end]
+		switch {
+		case strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"):
+			sep := "\n"
+			if value[0] == '>' {
+				sep = " "
+			}
+			fields[key] = yamlBlockScalar(nested, sep)
+			i = end - 1
+		case value == "" && len(nested) > 0:
+			fields[key] = flattenYAMLMap(key, parseYAMLMap(nested))
+			i = end - 1
+		default:
+			fields[key] = yamlScalar(value)
+		}
+	}
+	return fields
+}
+
+// yamlIndent returns the number of leading spaces on line
+func yamlIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// yamlBlockScalar joins a block scalar's lines with sep, less their common
+// indentation
+func yamlBlockScalar(lines []string, sep string) string {
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" && (indent < 0 || yamlIndent(line) < indent) {
+			indent = yamlIndent(line)
+		}
+	}
+	var parts []string
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if len(line) >= indent && indent >= 0 {
+			line = line[indent:]
+		}
+		parts = append(parts, strings.TrimRight(line, " "))
+	}
+	return strings.TrimSpace(strings.Join(parts, sep))
+}
+
+// yamlScalar unquotes a single- or double-quoted scalar
+func yamlScalar(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+	}
+	return value
+}
+
+// flattenYAMLMap renders a nested map on one line. A location, such as
+// at: {file: t/math.t, line: 12}, reads like perl's "t/math.t line 12";
+// other maps list their fields in key order.
+func flattenYAMLMap(key string, fields map[string]string) string {
+	if file, ok := fields["file"]; ok {
+		if line, ok := fields["line"]; ok {
+			return file + " line " + line
+		}
+		return file
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + ": " + fields[k]
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tapDiagnosticFields are the YAML diagnostic fields shown for a failure,
+// in order
+var tapDiagnosticFields = []string{"message", "severity", "at"}
+
+// tapFailureSummary describes output's failing tests with their YAML
+// diagnostics, for TestResult.Error:
+//
+//	not ok 2 - adds numbers
+//	  message: expected 4, got 5
+//	  severity: fail
+//	  at: t/math.t line 12
+//
+// It returns "" when no failure has diagnostics, as in TAP12 output, so
+// callers keep the raw output instead.
+func tapFailureSummary(output string) string {
+	var b strings.Builder
+	found := false
+	for _, line := range parseTAPLines(output) {
+		if !isTAPFailure(line.text) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(line.text)
+		for _, field := range tapDiagnosticFields {
+			value, ok := line.diag[field]
+			if !ok || value == "" {
+				continue
+			}
+			found = true
+			b.WriteString("\n  " + field + ": " + strings.ReplaceAll(value, "\n", "\n    "))
+		}
+	}
+	if !found {
+		return ""
+	}
+	return b.String()
+}
@@ -0,0 +1,232 @@
+package perlcov
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/user/perlcov/internal/ignore"
+	"github.com/user/perlcov/internal/runner"
+)
+
+// loadIgnores builds the ignore matcher from .perlcovignore in root. The
+// extra patterns are appended after the file's, so they are additive and
+// cannot be re-included by a negation.
+func loadIgnores(root string, patterns []string) (*ignore.Matcher, error) {
+	m := ignore.New(root)
+	if err := m.Load(filepath.Join(root, ignore.FileName)); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ignore.FileName, err)
+	}
+	for _, p := range patterns {
+		if err := m.Add(p); err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// selectTests discovers the test files to run, narrowed to opts' shard
+func selectTests(opts Options, ignores *ignore.Matcher) ([]string, error) {
+	var globs []*regexp.Regexp
+	for _, glob := range opts.TestGlobs {
+		re, err := ignore.CompileGlob(filepath.ToSlash(glob))
+		if err != nil {
+			return nil, fmt.Errorf("invalid test glob: %w", err)
+		}
+		globs = append(globs, re)
+	}
+
+	// Discover test files
+	testFiles, err := discoverTests(opts.TestPaths, discoverOptions{
+		root:    opts.Root,
+		globs:   globs,
+		ignores: ignores,
+		filter:  opts.Filter,
+		exclude: opts.Exclude,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover tests: %w", err)
+	}
+
+	if len(testFiles) == 0 {
+		return nil, fmt.Errorf("no test files found")
+	}
+
+	if opts.Since != "" {
+		if testFiles, err = testsSince(opts, testFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.ShardTotal > 0 {
+		// Never balance by the local timing cache: each machine has its
+		// own, so the shards would disagree and overlap or drop tests
+		var timings *runner.Cache
+		if opts.ShardTimings != "" {
+			if timings, err = runner.ReadCache(opts.ShardTimings); err != nil {
+				return nil, fmt.Errorf("failed to read shard timings: %w", err)
+			}
+		}
+		all := len(testFiles)
+		testFiles = runner.Shard(testFiles, opts.ShardIndex, opts.ShardTotal, timings)
+		if len(testFiles) == 0 {
+			return nil, fmt.Errorf("no test files in shard %d/%d (%d test files total)", opts.ShardIndex, opts.ShardTotal, all)
+		}
+		opts.Logf("Shard %d/%d: running %d of %d test files\n", opts.ShardIndex, opts.ShardTotal, len(testFiles), all)
+	}
+	return testFiles, nil
+}
+
+// ErrNoAffectedTests is returned by RunCoverage and DryRun when Since is
+// set and none of the changes since it, e.g. only docs, affect a test
+var ErrNoAffectedTests = errors.New("no test files affected by the changes")
+
+// testsSince narrows testFiles to those affected by files changed since
+// opts.Since. When a changed Perl file can't be traced to its tests, every
+// test runs, with a warning naming the file.
+func testsSince(opts Options, testFiles []string) ([]string, error) {
+	changed, err := changedFiles(opts.Root, opts.Since)
+	if err != nil {
+		return nil, err
+	}
+	selected, unmapped := runner.TestsForChanges(testFiles, changed, opts.SourceDirs, opts.SelectMap)
+	if len(unmapped) > 0 {
+		opts.Logger.Warn("changed files don't map to test files; running all tests",
+			"since", opts.Since, "files", strings.Join(unmapped, ","))
+		return testFiles, nil
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w since %s (%d files changed)", ErrNoAffectedTests, opts.Since, len(changed))
+	}
+	opts.Logf("Changed since %s: running %d of %d test files\n", opts.Since, len(selected), len(testFiles))
+	return selected, nil
+}
+
+// changedFiles lists the files under root that differ between the working
+// tree and ref, and the untracked files git doesn't ignore, relative to
+// root
+func changedFiles(root, ref string) ([]string, error) {
+	diff, err := gitFiles(root, "diff", "--name-only", "--relative", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitFiles(root, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	return append(diff, untracked...), nil
+}
+
+// gitFiles runs git with args in dir and returns the paths it lists, one
+// per line
+func gitFiles(dir string, args ...string) ([]string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			files = append(files, filepath.FromSlash(line))
+		}
+	}
+	return files, nil
+}
+
+// discoverOptions controls which test files discoverTests returns
+type discoverOptions struct {
+	root    string           // directory relative paths are under
+	globs   []*regexp.Regexp // test file globs, matched relative to each directory
+	ignores *ignore.Matcher
+	filter  *regexp.Regexp // keep only matching paths (nil keeps all)
+	exclude *regexp.Regexp // drop matching paths (nil drops none)
+}
+
+// discoverTests returns the test files in paths, keeping relative paths
+// relative to opts.root as the runner resolves them
+func discoverTests(paths []string, opts discoverOptions) ([]string, error) {
+	var testFiles []string
+	ignores := opts.ignores
+
+	for _, p := range paths {
+		full := p
+		if !filepath.IsAbs(p) && opts.root != "" {
+			full = filepath.Join(opts.root, p)
+		}
+		info, err := os.Stat(full)
+		if err != nil {
+			return nil, fmt.Errorf("cannot access %s: %w", p, err)
+		}
+
+		if !info.IsDir() {
+			// A file named directly is a test whatever the globs say; they
+			// pick tests out of directories
+			if !ignores.Match(p) {
+				testFiles = append(testFiles, p)
+			}
+			continue
+		}
+
+		// It's a directory, find all matching test files recursively
+		err = filepath.Walk(full, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(full, path)
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(p, rel)
+			if info.IsDir() || ignores.Match(path) {
+				return nil
+			}
+			if matchesGlobs(filepath.ToSlash(rel), opts.globs) {
+				testFiles = append(testFiles, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return filterTests(testFiles, opts.filter, opts.exclude), nil
+}
+
+// matchesGlobs reports whether the slash-separated path matches any glob
+func matchesGlobs(path string, globs []*regexp.Regexp) bool {
+	for _, g := range globs {
+		if g.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTests keeps test files matching filter and drops those matching exclude
+func filterTests(testFiles []string, filter, exclude *regexp.Regexp) []string {
+	if filter == nil && exclude == nil {
+		return testFiles
+	}
+	var kept []string
+	for _, tf := range testFiles {
+		if filter != nil && !filter.MatchString(tf) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(tf) {
+			continue
+		}
+		kept = append(kept, tf)
+	}
+	return kept
+}
@@ -0,0 +1,659 @@
+// Package perlcov runs a Perl test suite under Devel::Cover and returns the
+// merged coverage report and the test results, for Go programs that embed
+// perlcov and render their own output. The perlcov command is a thin
+// wrapper that prints what RunCoverage returns.
+package perlcov
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/user/perlcov/internal/coverage"
+	"github.com/user/perlcov/internal/ignore"
+	"github.com/user/perlcov/internal/runner"
+)
+
+// Report is a coverage report: per-file coverage and the summary
+type Report = coverage.Report
+
+// TestResult is the outcome of running one test file
+type TestResult = runner.TestResult
+
+// ProgressEvent reports a test starting or finishing
+type ProgressEvent = runner.ProgressEvent
+
+// SelectMap maps test files to the modules to -select for them
+type SelectMap = runner.SelectMap
+
+// DefaultTestGlob selects test files when Options.TestGlobs is empty
+const DefaultTestGlob = "**/*.t"
+
+// Harnesses for Options.Harness
+const (
+	HarnessPerl  = runner.HarnessPerl  // Run each test with perl (default)
+	HarnessProve = runner.HarnessProve // Run each test through prove, which honors .proverc
+)
+
+// Path styles for Options.PathStyle
+const (
+	PathRel = coverage.PathRel // Relative to Root where possible (default)
+	PathAbs = coverage.PathAbs // Absolute
+)
+
+// DefaultCriteria are the criteria collected when Options.Criteria is empty
+var DefaultCriteria = append([]string{}, runner.DefaultCriteria...)
+
+// ValidDBFormats lists the accepted Options.DBFormat values
+var ValidDBFormats = append([]string{}, runner.ValidDBFormats...)
+
+// NormalizationConfig is a parsed Options.Normalize value
+type NormalizationConfig = coverage.NormalizationConfig
+
+// ParseNormalizationModes parses a comma-separated list of normalization
+// modes, as Options.Normalize takes: conditions-to-branches,
+// subroutines-to-statements, sonarqube or simple
+func ParseNormalizationModes(modes string) (*NormalizationConfig, error) {
+	return coverage.ParseNormalizationModes(modes)
+}
+
+// PathMapping rewrites report paths under From to the same path under To,
+// as Options.PathMappings takes
+type PathMapping = coverage.PathMapping
+
+// ParsePathMapping parses a mapping written as from=to, e.g. "blib/lib=lib"
+func ParsePathMapping(s string) (PathMapping, error) {
+	return coverage.ParsePathMapping(s)
+}
+
+// gcovPath is the gcov executable used for XS coverage
+const gcovPath = "gcov"
+
+// Options configures RunCoverage. The zero value runs every .t file under
+// t with coverage of lib, like perlcov without arguments. Relative paths
+// resolve against Root.
+type Options struct {
+	// Which tests to run
+	TestPaths    []string       // Test files and directories to search (default: t)
+	TestGlobs    []string       // Globs test files in the TestPaths directories must match, relative to each (default: DefaultTestGlob); files named in TestPaths always run
+	Ignore       []string       // Gitignore-style patterns added to .perlcovignore's, for tests and coverage
+	Filter       *regexp.Regexp // Only run tests whose path matches
+	Exclude      *regexp.Regexp // Skip tests whose path matches
+	ShardIndex   int            // Shard to run (0-based) when ShardTotal is set
+	ShardTotal   int            // Number of shards the tests are split into (0 runs them all)
+	ShardTimings string         // Timing cache file shared by every shard to balance the split by duration (default: round-robin by sorted path)
+	Since        string         // Only run tests affected by files changed since this git ref (changed tests and the tests of changed sources; see ErrNoAffectedTests)
+
+	// How to run them
+	Root             string         // Project directory tests run in and relative paths resolve against (default: working directory)
+	IncludePaths     []string       // Extra -I paths
+	SourceDirs       []string       // Source directories to measure (default: lib)
+	LocalLib         string         // local::lib root whose lib/perl5 is added to @INC ("" detects ./local)
+	NoAutoInc        bool           // Don't add lib or local/lib/perl5 to @INC automatically
+	Env              []string       // Extra KEY=VALUE environment variables for tests
+	PerlPath         string         // Perl executable (default: perl)
+	Jobs             int            // Parallel test jobs (default: number of CPUs)
+	Harness          string         // HarnessPerl (default) or HarnessProve
+	Retries          int            // Times to retry a failing test before marking it failed
+	Order            string         // Dispatch order: "", "alpha", "size", "random" or "failed-first"
+	Seed             int64          // Seed for the random order
+	SerialGroup      *regexp.Regexp // Tests whose paths share a first capture group run one after another
+	MaxMemoryMB      int            // Hold back new tests while running ones use more memory than this (0: no limit; Linux only)
+	BailPercent      float64        // Stop running tests once more than this percentage of finished ones died inside Devel::Cover (0: never)
+	NoTimingCache    bool           // Don't read or write the timing cache used for ordering
+	ShowOutput       bool           // Stream test output to stdout while tests run
+	RerunFailed      bool           // Rerun failed tests without Devel::Cover to mark coverage-only failures
+	NoCover          bool           // Run tests without coverage; RunCoverage then returns a nil report
+	NoRun            bool           // Don't run tests; report on Imports (and MergedDB with Accumulate)
+	SkipVersionCheck bool           // Don't check that Devel::Cover is installed first
+
+	// What Devel::Cover collects
+	CoverDir        string     // Coverage database (default: cover_db); the per-test databases are created next to it, named after it
+	MergedDB        string     // Where the merged database is written instead of CoverDir, e.g. a CI artifact path (default: CoverDir)
+	Accumulate      bool       // Merge into the existing database instead of starting afresh
+	Imports         []string   // Coverage databases from elsewhere to merge in
+	ImportArchives  []string   // .tar.gz or .zip artifacts holding coverage databases to merge in
+	Criteria        []string   // Criteria to collect, limiting the report to them (default: DefaultCriteria)
+	NoSelect        bool       // Don't -select the module each test's filename names
+	SelectMap       *SelectMap // Modules to -select for mapped tests (see LoadSelectMap)
+	CoverIgnore     []string   // Extra Devel::Cover -ignore regexes
+	CoverSelect     []string   // Extra Devel::Cover -select regexes
+	NoDefaultIgnore bool       // Don't pass the built-in -ignore regexes for test files
+	TestLib         string     // Test helper directory to cover despite the built-in ^t/ -ignore
+	JSONMerge       bool       // Export coverage as JSON and merge it in Go
+	DBFormat        string     // Format tests write coverage in, one of ValidDBFormats ("" for Devel::Cover's default)
+	XSCoverage      bool       // Add gcov's C coverage of XS code
+	XSDir           string     // Directory searched for .gcda files and XS sources
+	PerTestFile     string     // Write per-test coverage attribution here
+
+	// How the report is shaped
+	SummaryOnly     bool             // Parse counts only, without per-line data, for just the totals
+	ExcludeMarker   *regexp.Regexp   // Leave out source files whose head matches
+	Uncoverable     *regexp.Regexp   // Leave lines matching out of statement coverage
+	UncoverableFile string           // Devel::Cover .uncoverable file of points to leave out
+	IgnoreSubs      []*regexp.Regexp // Leave subroutines whose name matches out of subroutine coverage
+	PathStyle       string           // PathRel (default) or PathAbs
+	PathMappings    []PathMapping    // Rewrite report paths, e.g. blib/lib to lib
+	CountEmpty      bool             // Count files without statements as fully covered
+	Normalize       string           // Comma-separated normalization modes (see ParseNormalizationModes)
+
+	// Logger receives diagnostics such as the -select options chosen per
+	// test (default: discarded)
+	Logger *slog.Logger
+	// Logf receives the notes the perlcov command prints while running,
+	// such as "Found 12 test files" (default: discarded)
+	Logf func(format string, args ...interface{})
+	// OnProgress receives an event whenever a test starts or finishes
+	OnProgress func(ProgressEvent)
+	// OnMergeProgress receives the number of isolated coverage directories
+	// merged so far, about every 10% of them and for the last one
+	OnMergeProgress func(done, total int)
+	// AfterTests, if set, is called once the tests have run, before their
+	// isolated coverage databases are merged and failures are rerun. A
+	// returned error stops the run.
+	AfterTests func(results []TestResult) error
+}
+
+// discardLogger drops everything, for Options without a Logger
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// withDefaults fills in the defaults documented on Options and resolves
+// the paths tests and the report don't already resolve against Root
+func (opts Options) withDefaults() Options {
+	if opts.Root == "" {
+		if cwd, err := os.Getwd(); err == nil {
+			opts.Root = cwd
+		}
+	}
+	if len(opts.TestPaths) == 0 {
+		opts.TestPaths = []string{"t"}
+	}
+	if len(opts.TestGlobs) == 0 {
+		opts.TestGlobs = []string{DefaultTestGlob}
+	}
+	if len(opts.SourceDirs) == 0 {
+		opts.SourceDirs = []string{"lib"}
+	}
+	if opts.CoverDir == "" {
+		opts.CoverDir = "cover_db"
+	}
+	if opts.MergedDB == "" {
+		opts.MergedDB = opts.CoverDir
+	}
+	opts.CoverDir = opts.path(opts.CoverDir)
+	opts.MergedDB = opts.path(opts.MergedDB)
+	opts.Imports = opts.paths(opts.Imports)
+	opts.ImportArchives = opts.paths(opts.ImportArchives)
+	opts.UncoverableFile = opts.path(opts.UncoverableFile)
+	opts.XSDir = opts.path(opts.XSDir)
+	opts.PerTestFile = opts.path(opts.PerTestFile)
+	opts.ShardTimings = opts.path(opts.ShardTimings)
+	if opts.PerlPath == "" {
+		opts.PerlPath = "perl"
+	}
+	if opts.Jobs <= 0 {
+		opts.Jobs = runtime.NumCPU()
+	}
+	if opts.PathStyle == "" {
+		opts.PathStyle = coverage.PathRel
+	}
+	if opts.Logger == nil {
+		opts.Logger = discardLogger
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+	if opts.OnProgress == nil {
+		opts.OnProgress = func(ProgressEvent) {}
+	}
+	if opts.OnMergeProgress == nil {
+		opts.OnMergeProgress = func(int, int) {}
+	}
+	return opts
+}
+
+// path resolves p against Root, leaving empty and absolute paths alone
+func (opts Options) path(p string) string {
+	if p == "" || filepath.IsAbs(p) || opts.Root == "" {
+		return p
+	}
+	return filepath.Join(opts.Root, p)
+}
+
+// paths resolves each of ps against Root, into a new slice
+func (opts Options) paths(ps []string) []string {
+	if ps == nil {
+		return nil
+	}
+	resolved := make([]string, len(ps))
+	for i, p := range ps {
+		resolved[i] = opts.path(p)
+	}
+	return resolved
+}
+
+// LoadSelectMap reads a select map file (see SelectMap) for tests run in
+// root, resolving path and the map's patterns against it (the working
+// directory when empty)
+func LoadSelectMap(path, root string) (*SelectMap, error) {
+	opts := Options{Root: root}.withDefaults()
+	return runner.LoadSelectMap(opts.path(path), opts.Root)
+}
+
+// RunCoverage runs the tests with coverage, merges what each collected into
+// MergedDB and returns the report with the results in discovery order. A
+// failing test is not an error; check TestResult.Passed. The report is nil
+// with NoCover and the results are empty with NoRun.
+func RunCoverage(opts Options) (*Report, []TestResult, error) {
+	opts = opts.withDefaults()
+
+	var normalize *coverage.NormalizationConfig
+	if opts.Normalize != "" {
+		var err error
+		if normalize, err = coverage.ParseNormalizationModes(opts.Normalize); err != nil {
+			return nil, nil, fmt.Errorf("invalid normalization: %w", err)
+		}
+	}
+	if !opts.NoCover && !opts.NoRun && !opts.SkipVersionCheck {
+		version, err := runner.CheckDevelCover(opts.PerlPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.Logf("Using Devel::Cover version %s\n", version)
+		if opts.DBFormat != "" {
+			if err := runner.CheckDBFormat(opts.PerlPath, version, opts.DBFormat); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if opts.XSCoverage {
+		if _, err := exec.LookPath(gcovPath); err != nil {
+			return nil, nil, fmt.Errorf("XS coverage requires gcov: %w", err)
+		}
+	}
+
+	ignores, err := loadIgnores(opts.Root, opts.Ignore)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Unpack archived databases up front, so a bad artifact fails the run
+	// before the tests do. The imports are copied so appending to them
+	// can't write into the caller's slice.
+	imports := append([]string{}, opts.Imports...)
+	for _, archive := range opts.ImportArchives {
+		dir, cleanup, err := coverage.ExtractCoverageArchive(archive)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid import archive: %w", err)
+		}
+		defer cleanup()
+		imports = append(imports, dir)
+	}
+
+	var results []TestResult
+	if opts.NoRun {
+		if err := resetCoverDir(opts); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		results, err = runTests(opts, ignores)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.NoCover {
+		return nil, results, nil
+	}
+
+	// Merge coverage databases produced elsewhere, e.g. by other CI jobs
+	if len(imports) > 0 {
+		if err := coverage.ImportCoverageDBs(imports, opts.MergedDB); err != nil {
+			return nil, nil, fmt.Errorf("failed to import coverage: %w", err)
+		}
+		opts.Logf("Imported %d coverage database(s)\n", len(imports))
+	}
+
+	report, err := buildReport(opts, ignores, normalize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return report, results, nil
+}
+
+// DryRun writes the command each selected test would run with to w, one
+// line per test in dispatch order, without running anything
+func DryRun(opts Options, w io.Writer) error {
+	opts = opts.withDefaults()
+	ignores, err := loadIgnores(opts.Root, opts.Ignore)
+	if err != nil {
+		return err
+	}
+	testFiles, err := selectTests(opts, ignores)
+	if err != nil {
+		return err
+	}
+	cache := loadCache(opts)
+	if opts.Order == runner.OrderRandom {
+		opts.Logf("Random order seed: %d\n", opts.Seed)
+	}
+	return newRunner(opts, cache).DryRun(testFiles, !opts.NoCover, w)
+}
+
+// UntestedFiles lists the source files under SourceDirs that report has no
+// coverage for, because no test loaded them, leaving out ignored and
+// ExcludeMarker files
+func UntestedFiles(report *Report, opts Options) ([]string, error) {
+	opts = opts.withDefaults()
+	ignores, err := loadIgnores(opts.Root, opts.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	return coverage.UntestedFiles(report, opts.SourceDirs, func(path string) bool {
+		if ignores.Match(path) {
+			return true
+		}
+		if opts.ExcludeMarker != nil {
+			marked, _ := coverage.HeadMatches(report.SourcePath(path), opts.ExcludeMarker)
+			return marked
+		}
+		return false
+	})
+}
+
+// newRunner creates a test runner configured from opts
+func newRunner(opts Options, cache *runner.Cache) *runner.Runner {
+	r := runner.New(opts.IncludePaths, opts.CoverDir, opts.Jobs, opts.SourceDirs, opts.NoSelect, opts.JSONMerge, opts.PerlPath, opts.ShowOutput)
+	r.Retries = opts.Retries
+	r.Harness = opts.Harness
+	r.Criteria = opts.Criteria
+	r.Order = opts.Order
+	r.Seed = opts.Seed
+	r.SerialGroup = opts.SerialGroup
+	r.MaxMemoryMB = opts.MaxMemoryMB
+	r.BailPercent = opts.BailPercent
+	r.DBFormat = opts.DBFormat
+	r.OnProgress = opts.OnProgress
+	r.Cache = cache
+	r.LocalLib = opts.LocalLib
+	r.NoAutoInc = opts.NoAutoInc
+	r.Env = opts.Env
+	r.SelectMap = opts.SelectMap
+	r.Root = opts.Root
+	r.CoverIgnore = opts.CoverIgnore
+	r.CoverSelect = opts.CoverSelect
+	r.NoDefaultIgnore = opts.NoDefaultIgnore
+	r.TestLib = opts.TestLib
+	r.Logger = opts.Logger
+	return r
+}
+
+// loadCache reads the timing cache unless NoTimingCache is set
+func loadCache(opts Options) *runner.Cache {
+	if opts.NoTimingCache {
+		return nil
+	}
+	return runner.LoadCache(opts.path(runner.CacheFile))
+}
+
+// runTests discovers and runs the tests, merging their coverage into
+// MergedDB, and reruns failures without Devel::Cover if asked to
+func runTests(opts Options, ignores *ignore.Matcher) ([]TestResult, error) {
+	testFiles, err := selectTests(opts, ignores)
+	if err != nil {
+		return nil, err
+	}
+	cache := loadCache(opts)
+
+	opts.Logf("Found %d test files\n", len(testFiles))
+	if opts.NoCover {
+		opts.Logf("Coverage collection disabled (--no-cover)\n")
+	}
+
+	// Clean previous coverage data - skip if NoCover
+	if !opts.NoCover {
+		if err := resetCoverDir(opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.XSCoverage {
+		if err := resetGcda(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// Run tests
+	r := newRunner(opts, cache)
+	if opts.Order == runner.OrderRandom {
+		opts.Logf("Random order seed: %d\n", opts.Seed)
+	}
+
+	var results []TestResult
+	if opts.NoCover {
+		// Run tests without coverage
+		results = r.RunTestsWithoutCoverage(testFiles)
+	} else {
+		// Run tests with coverage (each test gets its own isolated coverage directory)
+		results, err = r.RunTests(testFiles)
+
+		// Merging normally removes the isolated dirs; this catches early returns.
+		// Only dirs created by this run are touched, never other invocations'.
+		defer runner.RemoveCoverDirs(results)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.PerTestFile != "" {
+			if err := writePerTest(opts, results, ignores); err != nil {
+				return nil, fmt.Errorf("failed to write per-test report: %w", err)
+			}
+			opts.Logf("Per-test coverage written: %s\n", opts.PerTestFile)
+		}
+	}
+
+	// Remember durations and outcomes for scheduling the next run
+	if r.Cache != nil {
+		r.Cache.Update(results)
+		if err := r.Cache.Save(opts.path(runner.CacheFile)); err != nil {
+			opts.Logger.Debug("failed to write timing cache", "file", opts.path(runner.CacheFile), "err", err)
+		}
+	}
+
+	// Let the caller see each test's isolated coverage before merging removes it
+	if opts.AfterTests != nil {
+		if err := opts.AfterTests(results); err != nil {
+			return nil, err
+		}
+	}
+
+	// Merge isolated coverage directories into the final cover_db
+	var isolatedDirs []string
+	for _, result := range results {
+		if result.CoverDir != "" {
+			isolatedDirs = append(isolatedDirs, result.CoverDir)
+		}
+	}
+	if len(isolatedDirs) > 0 {
+		opts.Logger.Info("merging coverage directories", "count", len(isolatedDirs), "into", opts.MergedDB)
+		start := time.Now()
+		err := coverage.MergeCoverageDBs(isolatedDirs, opts.MergedDB, opts.Jobs, opts.OnMergeProgress)
+		if errors.Is(err, coverage.ErrMergeCleanup) {
+			opts.Logger.Warn("merged coverage directories left behind", "err", err)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to merge coverage directories: %w", err)
+		}
+		opts.Logger.Debug("merged coverage directories", "elapsed", time.Since(start).Round(time.Millisecond))
+	}
+
+	// Rerun failures without Devel::Cover to tell genuine failures from
+	// ones the instrumentation causes; there's nothing to tell without it
+	if failed := failedTests(results); len(failed) > 0 && opts.RerunFailed && !opts.NoCover {
+		reconcileReruns(results, r.RunTestsWithoutCoverage(failed))
+	}
+
+	return results, nil
+}
+
+// buildReport parses MergedDB and shapes the report as opts asks
+func buildReport(opts Options, ignores *ignore.Matcher, normalize *coverage.NormalizationConfig) (*Report, error) {
+	start := time.Now()
+	parse := coverage.ParseCoverageDB
+	if opts.SummaryOnly {
+		parse = coverage.ParseCoverageSummary
+	}
+	report, err := parse(opts.MergedDB, opts.Root, opts.JSONMerge, opts.PerlPath, opts.Jobs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse coverage: %w", err)
+	}
+	opts.Logger.Debug("merged run files", "dir", opts.MergedDB, "runs", report.RunFiles,
+		"skipped", len(report.Skipped), "conflicts", len(report.Conflicts), "json_merge", opts.JSONMerge, "elapsed", time.Since(start).Round(time.Millisecond))
+	// Map built copies to their sources before the ignores, which may
+	// drop build output such as blib/
+	report.MapPaths(opts.PathMappings)
+
+	if opts.XSCoverage {
+		files, err := coverage.CollectGcov(opts.XSDir, gcovPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect XS coverage: %w", err)
+		}
+		report.AddFiles(files)
+		opts.Logf("Added C coverage of %d XS source file(s)\n", len(files))
+	}
+	if opts.Criteria != nil {
+		report.SetCriteria(opts.Criteria)
+	}
+
+	// Drop ignored source files so they don't count toward the summary
+	report.RemoveFiles(ignores.Match)
+	if opts.ExcludeMarker != nil {
+		marked, errs := coverage.MarkedFiles(report, opts.ExcludeMarker)
+		for _, err := range errs {
+			opts.Logger.Debug("kept file that could not be checked for the exclude marker", "err", err)
+		}
+		report.RemoveFiles(func(path string) bool { return marked[path] })
+	}
+	if opts.Uncoverable != nil {
+		dropped, errs := report.ExcludeUncoverable(opts.Uncoverable)
+		for _, err := range errs {
+			opts.Logger.Debug("could not check for uncoverable lines", "err", err)
+		}
+		if dropped > 0 {
+			opts.Logger.Info("left uncoverable lines out of statement coverage", "lines", dropped)
+		}
+	}
+	if opts.UncoverableFile != "" {
+		entries, err := coverage.ReadUncoverableFile(opts.UncoverableFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read uncoverable file: %w", err)
+		}
+		dropped, errs := report.ExcludeUncoverableEntries(entries)
+		for _, err := range errs {
+			opts.Logger.Debug("could not check for uncoverable points", "err", err)
+		}
+		opts.Logger.Info("left uncoverable points out of coverage", "file", opts.UncoverableFile,
+			"entries", len(entries), "points", dropped)
+	}
+	if len(opts.IgnoreSubs) > 0 {
+		dropped := report.ExcludeSubs(opts.IgnoreSubs)
+		opts.Logger.Info("left ignored subroutines out of subroutine coverage", "subs", dropped)
+	}
+	if opts.PathStyle != coverage.PathRel {
+		report.SetPathStyle(opts.PathStyle)
+	}
+	if opts.CountEmpty {
+		report.SetCountEmptyFiles(true)
+	}
+	if normalize != nil {
+		report.Normalize(normalize)
+	}
+	return report, nil
+}
+
+// writePerTest parses each test's isolated coverage directory on its own
+// and writes the resulting test -> source file attribution matrix
+func writePerTest(opts Options, results []TestResult, ignores *ignore.Matcher) error {
+	attribution := coverage.NewTestAttribution()
+	for _, result := range results {
+		if result.CoverDir == "" {
+			continue
+		}
+		report, err := coverage.ParseCoverageDB(result.CoverDir, opts.Root, opts.JSONMerge, opts.PerlPath, opts.Jobs)
+		if err != nil {
+			opts.Logger.Debug("no per-test coverage", "test", result.File, "err", err)
+			continue
+		}
+		report.MapPaths(opts.PathMappings)
+		report.RemoveFiles(ignores.Match)
+		attribution.Add(result.File, report)
+	}
+
+	f, err := os.Create(opts.PerTestFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return coverage.WritePerTestJSON(attribution, f)
+}
+
+// resetCoverDir clears the merged database before a run. With
+// Accumulate the database is kept and new runs are merged in after it.
+func resetCoverDir(opts Options) error {
+	if opts.Accumulate {
+		if _, err := os.Stat(opts.MergedDB); err == nil {
+			opts.Logf("Accumulating into existing %s\n", opts.MergedDB)
+		}
+		return nil
+	}
+	if err := os.RemoveAll(opts.MergedDB); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to clean coverage directory: %w", err)
+	}
+	return nil
+}
+
+// resetGcda removes the XS coverage counters left by previous runs, which
+// would otherwise be added to this run's, unless Accumulate is set
+func resetGcda(opts Options) error {
+	if opts.Accumulate {
+		return nil
+	}
+	removed, err := coverage.RemoveGcda(opts.XSDir)
+	if err != nil {
+		return fmt.Errorf("failed to clean XS coverage counters: %w", err)
+	}
+	if removed > 0 {
+		opts.Logger.Info("removed XS coverage counters", "files", removed, "dir", opts.XSDir)
+	}
+	return nil
+}
+
+// failedTests returns the files of the tests that failed
+func failedTests(results []TestResult) []string {
+	var failed []string
+	for _, r := range results {
+		if !r.Passed {
+			failed = append(failed, r.File)
+		}
+	}
+	return failed
+}
+
+// reconcileReruns marks each failed result whose rerun without
+// Devel::Cover passed as a coverage-only failure. Only failed tests are
+// rerun, so reruns of tests that passed are ignored.
+func reconcileReruns(results []TestResult, rerun []TestResult) {
+	passedWithout := make(map[string]bool)
+	for _, r := range rerun {
+		passedWithout[r.File] = r.Passed
+	}
+	for i := range results {
+		if !results[i].Passed && passedWithout[results[i].File] {
+			results[i].CoverageOnlyFailure = true
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package perlcov
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeProject creates a project with a passing and a failing test
+func writeProject(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string]string{
+		"t/pass.t":      "print \"1..1\\nok 1\\n\";\n",
+		"t/sub/fail.t":  "print \"1..1\\nnot ok 1\\n\"; exit 1;\n",
+		"t/README":      "not a test\n",
+		"lib/My/Mod.pm": "package My::Mod; 1;\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestRunCoverageNoCover(t *testing.T) {
+	root := writeProject(t)
+	var afterTests []TestResult
+	var notes []string
+	report, results, err := RunCoverage(Options{
+		Root:          root,
+		TestPaths:     []string{filepath.Join(root, "t")},
+		NoCover:       true,
+		NoTimingCache: true,
+		Logf:          func(format string, args ...interface{}) { notes = append(notes, format) },
+		AfterTests: func(results []TestResult) error {
+			afterTests = results
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunCoverage() error: %v", err)
+	}
+	if report != nil {
+		t.Errorf("report = %v, want nil without coverage", report)
+	}
+	if len(results) != 2 || len(afterTests) != 2 {
+		t.Fatalf("got %d results (%d in AfterTests), want 2", len(results), len(afterTests))
+	}
+	if !results[0].Passed || !strings.HasSuffix(results[0].File, "pass.t") {
+		t.Errorf("results[0] = %+v, want pass.t passing", results[0])
+	}
+	if results[1].Passed || !strings.HasSuffix(results[1].File, "fail.t") {
+		t.Errorf("results[1] = %+v, want fail.t failing", results[1])
+	}
+	if len(notes) == 0 || notes[0] != "Found %d test files\n" {
+		t.Errorf("notes = %q, want Found first", notes)
+	}
+}
+
+func TestRunCoverageNoTests(t *testing.T) {
+	root := writeProject(t)
+	_, _, err := RunCoverage(Options{
+		TestPaths:     []string{filepath.Join(root, "t")},
+		TestGlobs:     []string{"**/*.test"},
+		NoCover:       true,
+		NoTimingCache: true,
+	})
+	if err == nil || err.Error() != "no test files found" {
+		t.Errorf("RunCoverage() error = %v, want no test files found", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	root := writeProject(t)
+	var buf bytes.Buffer
+	err := DryRun(Options{
+		Root:          root,
+		TestPaths:     []string{filepath.Join(root, "t")},
+		Ignore:        []string{"sub/"},
+		NoTimingCache: true,
+	}, &buf)
+	if err != nil {
+		t.Fatalf("DryRun() error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "-MDevel::Cover=") || !strings.HasSuffix(lines[0], "pass.t") {
+		t.Errorf("DryRun() wrote %q, want one covered command for pass.t", buf.String())
+	}
+}
+
+func TestDryRunExplicitFile(t *testing.T) {
+	root := writeProject(t)
+	var buf bytes.Buffer
+	err := DryRun(Options{
+		Root:          root,
+		TestPaths:     []string{filepath.Join("t", "sub", "fail.t"), "t"},
+		TestGlobs:     []string{"*.t"},
+		NoCover:       true,
+		NoTimingCache: true,
+	}, &buf)
+	if err != nil {
+		t.Fatalf("DryRun() error: %v", err)
+	}
+	// The glob only reaches t/pass.t in the directory; the named file
+	// runs regardless
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], filepath.Join("t", "sub", "fail.t")) || !strings.HasSuffix(lines[1], "pass.t") {
+		t.Errorf("DryRun() wrote %q, want fail.t and pass.t", buf.String())
+	}
+}
+
+func TestDryRunShardsIgnoreLocalTimingCache(t *testing.T) {
+	// One machine has a warm timing cache, the other none; the local
+	// caches must not change the split
+	timings := `{"tests": {"t/a.t": {"duration": 1}, "t/b.t": {"duration": 1}, "t/c.t": {"duration": 1}, "t/d.t": {"duration": 10}}}`
+	roots := make([]string, 2)
+	for i := range roots {
+		roots[i] = t.TempDir()
+		for _, name := range []string{"a", "b", "c", "d"} {
+			path := filepath.Join(roots[i], "t", name+".t")
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("print \"1..1\\nok 1\\n\";\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(roots[0], ".perlcov-timings.json"), []byte(timings), 0644); err != nil {
+		t.Fatal(err)
+	}
+	shared := filepath.Join(t.TempDir(), "timings.json")
+	if err := os.WriteFile(shared, []byte(timings), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, shardTimings := range []string{"", shared} {
+		seen := make(map[string]int)
+		var shards []string
+		for i, root := range roots {
+			var buf bytes.Buffer
+			err := DryRun(Options{
+				Root:         root,
+				NoCover:      true,
+				ShardIndex:   i,
+				ShardTotal:   2,
+				ShardTimings: shardTimings,
+			}, &buf)
+			if err != nil {
+				t.Fatalf("DryRun(shard %d/2, timings %q) error: %v", i, shardTimings, err)
+			}
+			var shard []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				name := filepath.Base(line)
+				seen[name]++
+				shard = append(shard, name)
+			}
+			shards = append(shards, strings.Join(shard, ","))
+		}
+		for _, name := range []string{"a.t", "b.t", "c.t", "d.t"} {
+			if seen[name] != 1 {
+				t.Errorf("timings %q: %s ran in %d shards, want 1 (shards: %q)", shardTimings, name, seen[name], shards)
+			}
+		}
+		// Shared timings put the slow test on a shard of its own
+		if shardTimings != "" && shards[0] != "d.t" {
+			t.Errorf("shard 0/2 with shared timings = %s, want d.t", shards[0])
+		}
+	}
+}
+
+func TestRunCoverageMergedDB(t *testing.T) {
+	dir := t.TempDir()
+	imported := filepath.Join(dir, "shard0")
+	runDir := filepath.Join(imported, "runs", "1")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run := `{"runs": {"1": {"count": {"lib/A.pm": {"statement": [1, 0]}}}}}`
+	if err := os.WriteFile(filepath.Join(runDir, "cover.14"), []byte(run), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	coverDir := filepath.Join(dir, "scratch", "cover_db")
+	merged := filepath.Join(dir, "artifacts", "cover_db")
+	report, _, err := RunCoverage(Options{
+		NoRun:            true,
+		Imports:          []string{imported},
+		CoverDir:         coverDir,
+		MergedDB:         merged,
+		JSONMerge:        true,
+		SkipVersionCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("RunCoverage() error: %v", err)
+	}
+	if fc := report.Files["lib/A.pm"]; fc == nil || fc.Statements.Covered != 1 || fc.Statements.Total != 2 {
+		t.Errorf("report files = %v, want lib/A.pm at 1/2", report.Files)
+	}
+	if _, err := os.Stat(filepath.Join(merged, "runs")); err != nil {
+		t.Errorf("merged database not written: %v", err)
+	}
+	if _, err := os.Stat(coverDir); !os.IsNotExist(err) {
+		t.Errorf("%s was created, want only the merged database", coverDir)
+	}
+}
+
+func TestRunCoverageRootRelativePaths(t *testing.T) {
+	root := t.TempDir()
+	runDir := filepath.Join(root, "shard0", "runs", "1")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run := `{"runs": {"1": {"count": {"lib/A.pm": {"statement": [1, 0]}, "lib/B.pm": {"statement": [1]}}}}}`
+	if err := os.WriteFile(filepath.Join(runDir, "cover.14"), []byte(run), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".perlcovignore"), []byte("lib/B.pm\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every relative path resolves against Root, not the working directory
+	report, _, err := RunCoverage(Options{
+		Root:             root,
+		NoRun:            true,
+		Imports:          []string{"shard0"},
+		MergedDB:         "out/cover_db",
+		JSONMerge:        true,
+		SkipVersionCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("RunCoverage() error: %v", err)
+	}
+	if len(report.Files) != 1 || report.Files["lib/A.pm"] == nil {
+		t.Errorf("report files = %v, want only lib/A.pm", report.Files)
+	}
+	if report.Root != root {
+		t.Errorf("report.Root = %q, want %q", report.Root, root)
+	}
+	if _, err := os.Stat(filepath.Join(root, "out", "cover_db", "runs")); err != nil {
+		t.Errorf("merged database not written under Root: %v", err)
+	}
+}
+
+func TestRunCoverageRootTestPaths(t *testing.T) {
+	root := writeProject(t)
+	_, results, err := RunCoverage(Options{
+		Root:          root,
+		NoCover:       true,
+		NoTimingCache: true,
+	})
+	if err != nil {
+		t.Fatalf("RunCoverage() error: %v", err)
+	}
+	var files []string
+	for _, r := range results {
+		files = append(files, r.File)
+	}
+	want := []string{filepath.Join("t", "pass.t"), filepath.Join("t", "sub", "fail.t")}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("ran %v, want %v relative to Root", files, want)
+	}
+}
+
+func TestDryRunSince(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	root := writeProject(t)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	opts := Options{Root: root, Since: "HEAD", NoTimingCache: true}
+
+	// A docs-only change affects no test
+	if err := os.WriteFile(filepath.Join(root, "README"), []byte("docs\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "README")
+	if err := DryRun(opts, io.Discard); !errors.Is(err, ErrNoAffectedTests) {
+		t.Errorf("DryRun() error = %v, want ErrNoAffectedTests", err)
+	}
+
+	// A new test is selected before it is added to git
+	if err := os.WriteFile(filepath.Join(root, "t", "new.t"), []byte("print \"1..0\\n\";\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := DryRun(opts, &buf); err != nil {
+		t.Fatalf("DryRun() error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], filepath.Join("t", "new.t")) {
+		t.Errorf("DryRun() wrote %q, want the untracked t/new.t only", buf.String())
+	}
+}
+
+func TestReconcileReruns(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []TestResult
+		rerun   []TestResult
+		want    []bool // CoverageOnlyFailure per result
+	}{
+		{
+			name:    "failure passing without coverage",
+			results: []TestResult{{File: "t/a.t"}, {File: "t/b.t", Passed: true}},
+			rerun:   []TestResult{{File: "t/a.t", Passed: true}},
+			want:    []bool{true, false},
+		},
+		{
+			name:    "failure failing again",
+			results: []TestResult{{File: "t/a.t"}},
+			rerun:   []TestResult{{File: "t/a.t"}},
+			want:    []bool{false},
+		},
+		{
+			name:    "rerun of a passing test is ignored",
+			results: []TestResult{{File: "t/a.t", Passed: true}},
+			rerun:   []TestResult{{File: "t/a.t", Passed: true}},
+			want:    []bool{false},
+		},
+		{
+			name:    "failure without a rerun",
+			results: []TestResult{{File: "t/a.t"}, {File: "t/b.t"}},
+			rerun:   []TestResult{{File: "t/b.t", Passed: true}},
+			want:    []bool{false, true},
+		},
+		{
+			name:    "no reruns",
+			results: []TestResult{{File: "t/a.t"}},
+			want:    []bool{false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passed := make([]bool, len(tt.results))
+			for i, r := range tt.results {
+				passed[i] = r.Passed
+			}
+			reconcileReruns(tt.results, tt.rerun)
+			for i, r := range tt.results {
+				if r.CoverageOnlyFailure != tt.want[i] {
+					t.Errorf("%s: CoverageOnlyFailure = %v, want %v", r.File, r.CoverageOnlyFailure, tt.want[i])
+				}
+				// The result stays a failure; the exit code decides
+				if r.Passed != passed[i] {
+					t.Errorf("%s: Passed = %v, want it unchanged", r.File, r.Passed)
+				}
+			}
+		})
+	}
+}
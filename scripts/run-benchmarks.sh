@@ -61,6 +61,8 @@ benchmark "perlcov -j $JOBS (Storable)" $PERLCOV -j $JOBS
 
 benchmark "perlcov -j $JOBS --json-merge (Storable)" $PERLCOV -j $JOBS --json-merge
 
+benchmark "perlcov -j $JOBS --statements-only (Storable)" $PERLCOV -j $JOBS --statements-only
+
 echo "=========================================="
 echo "Phase 2: JSON::PP (pure Perl)"
 echo "(Slowest - pure Perl JSON)"